@@ -0,0 +1,131 @@
+package accesslog
+
+import (
+	"io"
+	"lb/logger"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record represents a single proxied connection
+type Record struct {
+	Client    string
+	Service   string
+	Replica   string
+	Index     int
+	Start     time.Time
+	Duration  time.Duration
+	BytesUp   int64
+	BytesDown int64
+	Reason    string
+	Method    string
+	Uri       string
+}
+
+// Predefined termination reasons of a proxied connection
+const (
+	ReasonClientClose  = "client_close"
+	ReasonBackendClose = "backend_close"
+	ReasonBackendReset = "backend_reset"
+	ReasonTimeout      = "timeout"
+	ReasonDialError    = "dial_error"
+	ReasonNoReplica    = "no_replica"
+	ReasonBadRequest   = "bad_request"
+)
+
+var (
+	lock    sync.Mutex
+	output  io.Writer
+	format  = logger.FormatJSON
+	enabled = false
+)
+
+// InitFromEnv parses environment variables and sets up the access log
+// - ACCESS_LOG: "stdout", "stderr" or a file path, access log is disabled when not set
+// - ACCESS_LOG_FORMAT: "json" or "logfmt" (defaults json)
+// - ACCESS_LOG_MAX_SIZE: max size of the file in MB before rotating it (defaults 100)
+// - ACCESS_LOG_MAX_BACKUPS: the number of rotated files to keep (defaults 5)
+func InitFromEnv() error {
+	target := os.Getenv("ACCESS_LOG")
+	if len(target) == 0 {
+		return nil
+	}
+
+	recordFormat, err := logger.ParseFormat(os.Getenv("ACCESS_LOG_FORMAT"))
+	if err != nil {
+		return err
+	}
+	if recordFormat == logger.FormatText {
+		recordFormat = logger.FormatJSON
+	}
+
+	maxSize := 100
+	if val, err := strconv.Atoi(os.Getenv("ACCESS_LOG_MAX_SIZE")); err == nil && val >= 0 {
+		maxSize = val
+	}
+
+	maxBackups := 5
+	if val, err := strconv.Atoi(os.Getenv("ACCESS_LOG_MAX_BACKUPS")); err == nil && val >= 0 {
+		maxBackups = val
+	}
+
+	// Open the output of the access log
+	var w io.Writer
+	switch strings.ToLower(target) {
+	case "stdout":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	default:
+		w, err = newRotatingFile(target, int64(maxSize)*1024*1024, maxBackups)
+		if err != nil {
+			return err
+		}
+	}
+
+	lock.Lock()
+	output = w
+	format = recordFormat
+	enabled = true
+	lock.Unlock()
+
+	logger.Info("Access log enabled", logger.Fields{"output": target, "format": os.Getenv("ACCESS_LOG_FORMAT")})
+	return nil
+}
+
+// Write writes a single record to the access log, this is no-op when access log is disabled
+func Write(record Record) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !enabled {
+		return
+	}
+
+	fields := logger.Fields{
+		"start":       record.Start.Format(time.RFC3339Nano),
+		"client":      record.Client,
+		"service":     record.Service,
+		"replica":     record.Replica,
+		"index":       record.Index,
+		"duration_ms": float64(record.Duration.Microseconds()) / 1000,
+		"bytes_up":    record.BytesUp,
+		"bytes_down":  record.BytesDown,
+		"reason":      record.Reason,
+	}
+
+	if len(record.Method) != 0 {
+		fields["method"] = record.Method
+		fields["uri"] = record.Uri
+	}
+
+	line := logger.Encode(format, fields, []string{"start", "client", "service", "replica", "index",
+		"duration_ms", "bytes_up", "bytes_down", "reason", "method", "uri"})
+	_, err := output.Write(line)
+	if err != nil {
+		logger.Warn("Could not write access log", logger.Fields{"err": err})
+	}
+}
@@ -0,0 +1,210 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRotatingFile writes the lines one by one, and checks which lines each file ends up with
+func TestRotatingFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxSize    int64
+		maxBackups int
+		lines      []string
+		files      []string // The current file first, then path.1, path.2, ...
+	}{
+		{"fits", 10, 2, []string{"aaa\n", "bbb\n"}, []string{"aaa\nbbb\n"}},
+		{"rotates", 10, 2, []string{"aaa\n", "bbb\n", "ccc\n"}, []string{"ccc\n", "aaa\nbbb\n"}},
+		{"shifts backups", 4, 2, []string{"aaa\n", "bbb\n", "ccc\n"}, []string{"ccc\n", "bbb\n", "aaa\n"}},
+		{"drops oldest backup", 4, 1, []string{"aaa\n", "bbb\n", "ccc\n"}, []string{"ccc\n", "bbb\n"}},
+		{"no backups", 4, 0, []string{"aaa\n", "bbb\n"}, []string{"bbb\n"}},
+		{"no max size", 0, 2, []string{"aaa\n", "bbb\n", "ccc\n"}, []string{"aaa\nbbb\nccc\n"}},
+		{"line larger than max size", 2, 2, []string{"aaa\n"}, []string{"aaa\n"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "access.log")
+			r, err := newRotatingFile(path, test.maxSize, test.maxBackups)
+			if err != nil {
+				t.Fatalf("could not open: %v", err)
+			}
+			defer r.Close()
+
+			for _, line := range test.lines {
+				if _, err := r.Write([]byte(line)); err != nil {
+					t.Fatalf("could not write: %v", err)
+				}
+			}
+
+			for i := 0; i <= test.maxBackups+1; i++ {
+				name := path
+				if i != 0 {
+					name = fmt.Sprintf("%s.%d", path, i)
+				}
+
+				data, err := os.ReadFile(name)
+				if i >= len(test.files) {
+					if err == nil {
+						t.Errorf("%s = %q, want no such file", filepath.Base(name), data)
+					}
+				} else if string(data) != test.files[i] {
+					t.Errorf("%s = %q, want %q", filepath.Base(name), data, test.files[i])
+				}
+			}
+		})
+	}
+}
+
+// TestRotatingFileReopen checks the size of an existing file counts towards the max size
+func TestRotatingFileReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	if err := os.WriteFile(path, []byte("aaa\n"), 0644); err != nil {
+		t.Fatalf("could not write: %v", err)
+	}
+
+	r, err := newRotatingFile(path, 6, 1)
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+	defer r.Close()
+
+	if _, err := r.Write([]byte("bbb\n")); err != nil {
+		t.Fatalf("could not write: %v", err)
+	}
+	if data, _ := os.ReadFile(path + ".1"); string(data) != "aaa\n" {
+		t.Errorf("access.log.1 = %q, want the existing line", data)
+	}
+}
+
+// TestRotatingFileRotateFails checks the file keeps taking writes when it could not be rotated, and rotates once it can
+func TestRotatingFileRotateFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	r, err := newRotatingFile(path, 4, 1)
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+	defer r.Close()
+
+	// The current file cannot be moved onto a directory which is not empty
+	if err := os.MkdirAll(filepath.Join(path+".1", "blocker"), 0755); err != nil {
+		t.Fatalf("could not create directory: %v", err)
+	}
+
+	if _, err := r.Write([]byte("aaa\n")); err != nil {
+		t.Fatalf("could not write: %v", err)
+	}
+	if _, err := r.Write([]byte("bbb\n")); err == nil {
+		t.Fatal("rotated onto a directory")
+	}
+
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatalf("could not remove directory: %v", err)
+	}
+	if _, err := r.Write([]byte("ccc\n")); err != nil {
+		t.Fatalf("could not write after rotating failed: %v", err)
+	}
+
+	for name, want := range map[string]string{path: "ccc\n", path + ".1": "aaa\n"} {
+		if data, _ := os.ReadFile(name); string(data) != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(name), data, want)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	defer func() {
+		lock.Lock()
+		output, enabled = nil, false
+		lock.Unlock()
+	}()
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	record := Record{
+		Client: "10.0.0.9:5000", Service: "tcp/0.0.0.0:80", Replica: "tcp/10.0.0.1:80", Index: 1, Start: start,
+		Duration: 1500 * time.Microsecond, BytesUp: 10, BytesDown: 20, Reason: ReasonClientClose,
+	}
+	withRequest := record
+	withRequest.Method, withRequest.Uri = "GET", "/a b"
+
+	tests := []struct {
+		name   string
+		format string
+		record Record
+		want   string
+	}{
+		{"json", "json", record,
+			`{"start":"2024-01-02T03:04:05Z","client":"10.0.0.9:5000","service":"tcp/0.0.0.0:80","replica":"tcp/10.0.0.1:80",` +
+				`"index":1,"duration_ms":1.5,"bytes_up":10,"bytes_down":20,"reason":"client_close"}`},
+		{"text is written as json", "text", withRequest,
+			`{"start":"2024-01-02T03:04:05Z","client":"10.0.0.9:5000","service":"tcp/0.0.0.0:80","replica":"tcp/10.0.0.1:80",` +
+				`"index":1,"duration_ms":1.5,"bytes_up":10,"bytes_down":20,"reason":"client_close","method":"GET","uri":"/a b"}`},
+		{"logfmt", "logfmt", withRequest,
+			`start=2024-01-02T03:04:05Z client=10.0.0.9:5000 service=tcp/0.0.0.0:80 replica=tcp/10.0.0.1:80 index=1 ` +
+				`duration_ms=1.5 bytes_up=10 bytes_down=20 reason=client_close method=GET uri="/a b"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "access.log")
+			t.Setenv("ACCESS_LOG", path)
+			t.Setenv("ACCESS_LOG_FORMAT", test.format)
+			if err := InitFromEnv(); err != nil {
+				t.Fatalf("could not init: %v", err)
+			}
+			defer output.(*rotatingFile).Close()
+
+			Write(test.record)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("could not read: %v", err)
+			}
+			if got := strings.TrimSuffix(string(data), "\n"); got != test.want {
+				t.Errorf("record = %s, want %s", got, test.want)
+			}
+			if test.format != "logfmt" && !json.Valid(data) {
+				t.Errorf("record = %s, want valid JSON", data)
+			}
+		})
+	}
+}
+
+func TestInitFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		format  string
+		enabled bool
+		ok      bool
+	}{
+		{"disabled", "", "json", false, true},
+		{"stdout", "stdout", "", true, true},
+		{"unknown format", "stderr", "xml", false, false},
+		{"unwritable file", "/nonexistent/access.log", "json", false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lock.Lock()
+			output, enabled = nil, false
+			lock.Unlock()
+
+			t.Setenv("ACCESS_LOG", test.target)
+			t.Setenv("ACCESS_LOG_FORMAT", test.format)
+			err := InitFromEnv()
+			if (err == nil) != test.ok || enabled != test.enabled {
+				t.Errorf("InitFromEnv = %v, enabled %v, want enabled %v", err, enabled, test.enabled)
+			}
+		})
+	}
+
+	lock.Lock()
+	output, enabled = nil, false
+	lock.Unlock()
+}
@@ -0,0 +1,116 @@
+package accesslog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingFile represents a file which gets rotated when it reaches its max size
+// The current file is always at path, the rotated files are path.1, path.2, ... where path.1 is the newest one
+type rotatingFile struct {
+	lock       sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// newRotatingFile opens (or creates) the file at path for appending
+func newRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{
+		lock:       sync.Mutex{},
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// open opens the file at path, and keeps track of its current size
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		msg := fmt.Sprintf("could not open access log %s: %v", r.path, err)
+		return errors.New(msg)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		msg := fmt.Sprintf("could not stat access log %s: %v", r.path, err)
+		return errors.New(msg)
+	}
+
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// Write writes p into the file, rotating the file first if p does not fit anymore
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize && r.size > 0 {
+		err := r.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts all rotated files by one, moves the current file to path.1 and opens a new file
+// The oldest file is removed when there are more than maxBackups rotated files
+// The current file is only closed once the new one was opened, if anything fails the file at path is opened again,
+// so that later writes still have a file to go to
+func (r *rotatingFile) rotate() error {
+	current := r.file
+
+	// Remove the oldest one, then shift path.N-1 to path.N
+	_ = os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxBackups))
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+
+	var err error
+	if r.maxBackups > 0 {
+		err = os.Rename(r.path, fmt.Sprintf("%s.1", r.path))
+	} else {
+		err = os.Remove(r.path)
+	}
+	if err == nil {
+		err = r.open()
+	}
+	if err != nil {
+		msg := fmt.Sprintf("could not rotate access log %s: %v", r.path, err)
+		err = errors.New(msg)
+
+		// Keep writing to the current file wherever it is now, unless the file at path can be opened again
+		_ = r.open()
+	}
+
+	if r.file != current {
+		_ = current.Close()
+	}
+	return err
+}
+
+// Close closes the current file
+func (r *rotatingFile) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.file.Close()
+}
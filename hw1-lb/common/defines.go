@@ -1,14 +1,5 @@
 package common
 
-// Defne colored outputs
-var (
-	ColoredWarn        string
-	ColoredError       string
-	ColoredInfo        string
-	ColorCmdRegister   string
-	ColorCmdUnregister string
-)
-
 const (
 	TypeProtoTCP = 1
 	TypeProtoUDP = 2
@@ -20,3 +11,23 @@ const (
 	CmdTypeUnregister = 2
 	CmdTypeHello      = 3
 )
+
+// Predefined types of session affinity
+const (
+	AffinityNone   = 0
+	AffinityCookie = 1
+	AffinityHeader = 2
+)
+
+// Predefined types of schedulers
+const (
+	SchedRoundRobin            = 0
+	SchedConsistentHash        = 1
+	SchedBoundedConsistentHash = 2
+)
+
+// Predefined sides which closed a forwarded connection first
+const (
+	ClosedByClient  = 1
+	ClosedByReplica = 2
+)
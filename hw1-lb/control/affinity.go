@@ -0,0 +1,270 @@
+package control
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"lb/accesslog"
+	"lb/common"
+	"lb/logger"
+	"lb/misc"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// affinityConfig represents how a service pins HTTP clients to a single Replica
+type affinityConfig struct {
+	mode       uint8
+	cookieName string
+	headerName string
+}
+
+// envParseAffinity parses environment variables and retrieves session affinity configuration
+// - LB_AFFINITY_MODE: "none", "cookie" or "header" (defaults none)
+// - LB_AFFINITY_COOKIE: the cookie name to insert and read in cookie mode (defaults LB_AFFINITY)
+// - LB_AFFINITY_HEADER: the header to hash in header mode (defaults X-Session-Id)
+func envParseAffinity() affinityConfig {
+	config := affinityConfig{
+		mode:       common.AffinityNone,
+		cookieName: "LB_AFFINITY",
+		headerName: "X-Session-Id",
+	}
+
+	switch strings.ToLower(os.Getenv("LB_AFFINITY_MODE")) {
+	case "", "none":
+		config.mode = common.AffinityNone
+	case "cookie":
+		config.mode = common.AffinityCookie
+	case "header":
+		config.mode = common.AffinityHeader
+	default:
+		logger.Warn("Unknown $LB_AFFINITY_MODE, defaulting to none",
+			logger.Fields{"mode": os.Getenv("LB_AFFINITY_MODE")})
+	}
+
+	if cookieName := os.Getenv("LB_AFFINITY_COOKIE"); len(cookieName) != 0 {
+		config.cookieName = cookieName
+	}
+
+	if headerName := os.Getenv("LB_AFFINITY_HEADER"); len(headerName) != 0 {
+		config.headerName = headerName
+	}
+
+	return config
+}
+
+// affinityID returns an opaque identifier of the Replica which is safe to hand out in cookies
+// We do not want to leak the backend address to the clients, so just hash it
+func (r *Replica) affinityID() string {
+	h := fnv.New64a()
+	h.Write([]byte(r.GetInfo()))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// findAffinityReplica looks for the Replica the request was pinned to
+// If the request was not pinned, or the pinned Replica is gone, unhealthy or ejected, this will return nil
+func (s *service) findAffinityReplica(req *http.Request) *Replica {
+	replicas := s.getReplicas()
+
+	switch s.affinity.mode {
+	case common.AffinityCookie:
+		// The cookie holds the affinity ID of the replica which served this client
+		cookie, err := req.Cookie(s.affinity.cookieName)
+		if err != nil {
+			return nil
+		}
+
+		for _, r := range replicas {
+			if r.affinityID() == cookie.Value && r.isAvailable() {
+				return r
+			}
+		}
+		return nil
+	case common.AffinityHeader:
+		key := req.Header.Get(s.affinity.headerName)
+		if len(key) == 0 {
+			return nil
+		}
+
+		// Use rendezvous hashing, so that removing a replica only moves the clients of that replica
+		var target *Replica
+		var maxScore uint64
+		for _, r := range replicas {
+			if !r.isAvailable() {
+				continue
+			}
+
+			h := fnv.New64a()
+			h.Write([]byte(key))
+			h.Write([]byte(r.GetInfo()))
+			score := h.Sum64()
+			if target == nil || score > maxScore {
+				target = r
+				maxScore = score
+			}
+		}
+		return target
+	default:
+		return nil
+	}
+}
+
+// doHTTPLB load balances a HTTP connection, this is used for session affinity or hashing on HTTP keys
+// This reads the first request of the connection to pick the Replica, then the whole connection is pinned to it
+// When the pinned Replica is not available anymore, this falls back to the normal scheduling and re-pins the client
+func (s *service) doHTTPLB(srcConn net.Conn) {
+	defer srcConn.Close()
+
+	// Every exit path leaves a single access log record
+	record := accesslog.Record{Client: remoteAddr(srcConn), Service: s.spec(), Index: -1, Start: time.Now()}
+	defer func() {
+		record.Duration = time.Since(record.Start)
+		accesslog.Write(record)
+	}()
+
+	// Read the first request of the connection, so we can look at its cookies and headers
+	clientReader := bufio.NewReader(srcConn)
+	req, err := http.ReadRequest(clientReader)
+	if err != nil {
+		logger.Warn("Could not read HTTP request",
+			logger.Fields{"service": s.spec(), "client": remoteAddr(srcConn), "err": err})
+		record.Reason = accesslog.ReasonBadRequest
+		return
+	}
+	record.Method = req.Method
+	record.Uri = req.RequestURI
+
+	// Find the pinned replica, if there was none, just perform scheduling
+	targetReplica := s.findAffinityReplica(req)
+	pinned := targetReplica != nil
+	schedIndex := -1
+	if !pinned {
+		targetReplica, schedIndex = s.schedule(srcConn, req)
+		if targetReplica == nil {
+			logger.Warn("Service has no replica to forward to",
+				logger.Fields{"service": s.spec(), "client": remoteAddr(srcConn)})
+			record.Reason = accesslog.ReasonNoReplica
+			return
+		}
+	}
+
+	// Establish a connection to the target replica
+	targetProto := misc.ConvertProtoToString(targetReplica.proto)
+	targetConn, err := net.Dial(targetProto, targetReplica.GetInfo())
+	if err != nil {
+		s.reportFailure(targetReplica, "dial error")
+	}
+	if err != nil && pinned {
+		// The pinned replica is not reachable, fall back to the normal scheduling
+		logger.Warn("Pinned replica is not reachable, rescheduling", logger.Fields{
+			"service": s.spec(),
+			"client":  remoteAddr(srcConn),
+			"replica": targetReplica.spec(),
+			"err":     err,
+		})
+		pinned = false
+		targetReplica, schedIndex = s.schedule(srcConn, req)
+		if targetReplica == nil {
+			record.Reason = accesslog.ReasonNoReplica
+			return
+		}
+		targetConn, err = net.Dial(targetProto, targetReplica.GetInfo())
+		if err != nil {
+			s.reportFailure(targetReplica, "dial error")
+		}
+	}
+	record.Replica = targetReplica.spec()
+	record.Index = schedIndex
+	if err != nil {
+		logger.Warn("Forwarding connection failed", logger.Fields{
+			"service": s.spec(),
+			"client":  remoteAddr(srcConn),
+			"replica": targetReplica.spec(),
+			"index":   schedIndex,
+			"err":     err,
+		})
+		record.Reason = accesslog.ReasonDialError
+		return
+	}
+	defer targetConn.Close()
+
+	atomic.AddInt32(&targetReplica.activeConns, 1)
+	defer atomic.AddInt32(&targetReplica.activeConns, -1)
+
+	// For debugging purpose
+	logger.Info("Forwarding connection", logger.Fields{
+		"service": s.spec(),
+		"client":  remoteAddr(srcConn),
+		"replica": targetReplica.spec(),
+		"index":   schedIndex,
+		"pinned":  pinned,
+		"method":  req.Method,
+		"uri":     req.RequestURI,
+	})
+
+	// Send the first request to the replica
+	// Keep the User-Agent as is, since Go will add its own one if there was none
+	if _, ok := req.Header["User-Agent"]; !ok {
+		req.Header["User-Agent"] = []string{""}
+	}
+	upWriter := &countingWriter{writer: targetConn}
+	err = req.Write(upWriter)
+	record.BytesUp = upWriter.count
+	if err != nil {
+		logger.Warn("Could not write HTTP request to replica",
+			logger.Fields{"service": s.spec(), "replica": targetReplica.spec(), "err": err})
+		record.Reason = accesslog.ReasonBackendClose
+		return
+	}
+
+	// Read the first response, and insert the affinity cookie if the client was not pinned yet
+	targetReader := bufio.NewReader(targetConn)
+	resp, err := http.ReadResponse(targetReader, req)
+	if err != nil {
+		logger.Warn("Could not read HTTP response from replica",
+			logger.Fields{"service": s.spec(), "replica": targetReplica.spec(), "err": err})
+		s.reportFailure(targetReplica, "invalid response")
+		record.Reason = accesslog.ReasonBackendClose
+		return
+	}
+
+	if s.affinity.mode == common.AffinityCookie && !pinned {
+		cookie := http.Cookie{
+			Name:     s.affinity.cookieName,
+			Value:    targetReplica.affinityID(),
+			Path:     "/",
+			HttpOnly: true,
+		}
+		resp.Header.Add("Set-Cookie", cookie.String())
+	}
+
+	downWriter := &countingWriter{writer: srcConn}
+	err = resp.Write(downWriter)
+	resp.Body.Close()
+	record.BytesDown = downWriter.count
+	if err != nil || resp.Close {
+		s.reportSuccess(targetReplica)
+		if err != nil {
+			record.Reason = accesslog.ReasonClientClose
+		} else {
+			record.Reason = accesslog.ReasonBackendClose
+		}
+		return
+	}
+
+	// The rest of the connection is pinned, so just pass the bytes through
+	// Anything which was already buffered by the readers is sent first
+	result := pipeTraffic(srcConn, clientReader, targetConn, targetReader, s.idleTimeout)
+	record.BytesUp += result.bytesUp
+	record.BytesDown += result.bytesDown
+	record.Reason = result.reason()
+	if result.reset {
+		s.reportFailure(targetReplica, "connection reset")
+	} else {
+		s.reportSuccess(targetReplica)
+	}
+}
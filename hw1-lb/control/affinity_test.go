@@ -0,0 +1,178 @@
+package control
+
+import (
+	"bufio"
+	"fmt"
+	"lb/common"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestEnvParseAffinity(t *testing.T) {
+	tests := []struct {
+		mode   string
+		cookie string
+		header string
+		want   affinityConfig
+	}{
+		{"", "", "", affinityConfig{common.AffinityNone, "LB_AFFINITY", "X-Session-Id"}},
+		{"Cookie", "SID", "", affinityConfig{common.AffinityCookie, "SID", "X-Session-Id"}},
+		{"header", "", "X-User", affinityConfig{common.AffinityHeader, "LB_AFFINITY", "X-User"}},
+		{"sticky", "", "", affinityConfig{common.AffinityNone, "LB_AFFINITY", "X-Session-Id"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			t.Setenv("LB_AFFINITY_MODE", test.mode)
+			t.Setenv("LB_AFFINITY_COOKIE", test.cookie)
+			t.Setenv("LB_AFFINITY_HEADER", test.header)
+
+			if got := envParseAffinity(); got != test.want {
+				t.Errorf("envParseAffinity = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFindAffinityReplica(t *testing.T) {
+	replicas := []*Replica{{addr: "10.0.0.1", port: 80}, {addr: "10.0.0.2", port: 80}, {addr: "10.0.0.3", port: 80}}
+	s := &service{replicas: replicas}
+	pinnedID := replicas[1].affinityID()
+	unhealthy := func(r *Replica) { r.healthCheckFailure = 1 }
+	ejected := func(r *Replica) { r.ejectedUntil = time.Now().Add(time.Minute).UnixNano() }
+
+	tests := []struct {
+		name   string
+		mode   uint8
+		cookie string
+		header string
+		change func(r *Replica) // Applied to the pinned replica, nil to leave it available
+		want   int              // Index of the pinned replica, -1 if none
+	}{
+		{"no affinity", common.AffinityNone, pinnedID, "a", nil, -1},
+		{"cookie", common.AffinityCookie, pinnedID, "", nil, 1},
+		{"no cookie", common.AffinityCookie, "", "a", nil, -1},
+		{"cookie of unknown replica", common.AffinityCookie, "0123456789abcdef", "", nil, -1},
+		{"cookie of unhealthy replica", common.AffinityCookie, pinnedID, "", unhealthy, -1},
+		{"cookie of ejected replica", common.AffinityCookie, pinnedID, "", ejected, -1},
+		{"no header", common.AffinityHeader, pinnedID, "", nil, -1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, r := range replicas {
+				r.healthCheckFailure, r.ejectedUntil = 0, 0
+			}
+			if test.change != nil {
+				test.change(replicas[1])
+			}
+			s.affinity = affinityConfig{mode: test.mode, cookieName: "LB_AFFINITY", headerName: "X-Session-Id"}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if len(test.cookie) != 0 {
+				req.AddCookie(&http.Cookie{Name: "LB_AFFINITY", Value: test.cookie})
+			}
+			if len(test.header) != 0 {
+				req.Header.Set("X-Session-Id", test.header)
+			}
+
+			got := s.findAffinityReplica(req)
+			if test.want == -1 && got != nil {
+				t.Errorf("pinned to %s, want none", got.GetInfo())
+			} else if test.want != -1 && got != replicas[test.want] {
+				t.Errorf("pinned to %v, want %s", got, replicas[test.want].GetInfo())
+			}
+		})
+	}
+}
+
+// TestHeaderAffinity checks clients of the same header stay on a replica, and only the clients of a replica which
+// became unhealthy move to others
+func TestHeaderAffinity(t *testing.T) {
+	replicas := []*Replica{{addr: "10.0.0.1", port: 80}, {addr: "10.0.0.2", port: 80}, {addr: "10.0.0.3", port: 80}}
+	s := &service{replicas: replicas, affinity: affinityConfig{mode: common.AffinityHeader, headerName: "X-Session-Id"}}
+
+	pin := func(key string) *Replica {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Session-Id", key)
+		return s.findAffinityReplica(req)
+	}
+
+	pinned := make(map[string]*Replica)
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("session-%d", i)
+		pinned[key] = pin(key)
+		if again := pin(key); again != pinned[key] {
+			t.Fatalf("%s moved from %s to %s", key, pinned[key].GetInfo(), again.GetInfo())
+		}
+	}
+
+	replicas[0].healthCheckFailure = 1
+	for key, r := range pinned {
+		got := pin(key)
+		if got == replicas[0] {
+			t.Errorf("%s stayed on unhealthy replica", key)
+		} else if r != replicas[0] && got != r {
+			t.Errorf("%s moved from %s to %s", key, r.GetInfo(), got.GetInfo())
+		}
+	}
+}
+
+// TestCookieAffinity checks the affinity cookie is inserted only for clients which were not pinned yet
+func TestCookieAffinity(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	host, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	r := &Replica{addr: host, proto: common.TypeProtoTCP}
+	r.port, _ = strconv.Atoi(port)
+	s := &service{replicas: []*Replica{r}, affinity: affinityConfig{mode: common.AffinityCookie, cookieName: "LB_AFFINITY"}}
+	r.ownerService = s
+
+	tests := []struct {
+		name   string
+		cookie string
+		set    bool
+	}{
+		{"new client", "", true},
+		{"pinned client", r.affinityID(), false},
+		{"client of a replica which left", "0123456789abcdef", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, proxied := net.Pipe()
+			defer client.Close()
+			go s.doHTTPLB(proxied)
+
+			req, _ := http.NewRequest(http.MethodGet, "http://lb/", nil)
+			req.Close = true
+			if len(test.cookie) != 0 {
+				req.AddCookie(&http.Cookie{Name: "LB_AFFINITY", Value: test.cookie})
+			}
+			if err := req.Write(client); err != nil {
+				t.Fatalf("could not send request: %v", err)
+			}
+
+			resp, err := http.ReadResponse(bufio.NewReader(client), req)
+			if err != nil {
+				t.Fatalf("could not read response: %v", err)
+			}
+			resp.Body.Close()
+
+			cookies := resp.Cookies()
+			if set := len(cookies) != 0; set != test.set {
+				t.Fatalf("cookies = %v, want set %v", cookies, test.set)
+			}
+			if test.set && (cookies[0].Name != "LB_AFFINITY" || cookies[0].Value != r.affinityID()) {
+				t.Errorf("cookie = %v, want LB_AFFINITY=%s", cookies[0], r.affinityID())
+			}
+		})
+	}
+}
@@ -4,9 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"lb/common"
+	"lb/logger"
 	"lb/misc"
 	"lb/server"
-	"log"
 	"net"
 	"os"
 	"os/signal"
@@ -43,7 +43,7 @@ func New() *Handler {
 	addr, port := envParseAddress()
 	controlServer, err := server.New(addr, port, "tcp", "controller")
 	if err != nil {
-		log.Fatalf("%s Could not start control server: %v", common.ColoredError, err)
+		logger.Fatal("Could not start control server", logger.Fields{"err": err})
 		return nil
 	}
 
@@ -74,11 +74,11 @@ func (h *Handler) Stop() error {
 }
 
 func (h *Handler) setupSignalHandling() {
-	stopper := make(chan os.Signal)
+	stopper := make(chan os.Signal, 1)
 	signal.Notify(stopper, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 	go func() {
 		sig := <-stopper
-		log.Printf("%s Controller received signal: %v. Shutting down...", common.ColoredInfo, sig)
+		logger.Info("Controller received signal, shutting down", logger.Fields{"signal": sig.String()})
 		os.Exit(0)
 	}()
 }
@@ -90,20 +90,20 @@ func (h *Handler) tempHandler(conn net.Conn) {
 		// Read data from the connection
 		n, err := conn.Read(buffer)
 		if err != nil {
-			//log.Printf("%s Controller: Src=%s error reading: %v",
-			//	common.ColoredWarn, conn.RemoteAddr(), err)
+			logger.Debug("Controller could not read from connection",
+				logger.Fields{"client": remoteAddr(conn), "err": err})
 			return
 		}
 
 		// Print the received data
-		//log.Printf("%s Controller received %s [src=%s]",
-		//	common.ColoredInfo, buffer[:n], conn.RemoteAddr())
+		logger.Debug("Controller received payload",
+			logger.Fields{"client": remoteAddr(conn), "payload": string(buffer[:n])})
 
 		// Parse json from user's transmission
 		userPayload, err := jsonParser(buffer[:n])
 		if err != nil {
-			log.Printf("%s Controller could not parse JSON [src=%s]: %v",
-				common.ColoredWarn, conn.RemoteAddr(), err)
+			logger.Warn("Controller could not parse JSON",
+				logger.Fields{"client": remoteAddr(conn), "err": err})
 		}
 
 		// Parse command type
@@ -118,17 +118,17 @@ func (h *Handler) tempHandler(conn net.Conn) {
 			err := h.processUnregister(conn, userPayload)
 			returnResult(conn, err, commandType)
 		}
-		//log.Printf("%s Controller received command %d [src=%s]",
-		//	common.ColoredInfo, commandType, conn.RemoteAddr())
+		logger.Debug("Controller received command",
+			logger.Fields{"client": remoteAddr(conn), "cmd": commandType})
 	}
 }
 
 // processRegister processes a register command
 func (h *Handler) processRegister(conn net.Conn, mapData map[string]interface{}) error {
 	// Parse raw IP address from the remote Addr, simply retrieve the part before port binding
-	addrParts := strings.Split(conn.RemoteAddr().String(), ":")
+	addrParts := strings.Split(remoteAddr(conn), ":")
 	if len(addrParts) != 2 {
-		msg := fmt.Sprintf("could not parse remote address: %s", conn.RemoteAddr().String())
+		msg := fmt.Sprintf("could not parse remote address: %s", remoteAddr(conn))
 		return errors.New(msg)
 	}
 
@@ -144,12 +144,19 @@ func (h *Handler) processRegister(conn net.Conn, mapData map[string]interface{})
 	if targetService != nil {
 		if targetService.isLive {
 			// This means we are registering a new replica for the service
-			log.Printf("%s Controller: %s/%d is existing service, adding a new replica (total %d availble replicas)",
-				common.ColorCmdRegister, misc.ConvertProtoToString(protocol), port, len(targetService.getReplicas()))
+			logger.Info("Existing service, adding a new replica", logger.Fields{
+				"cmd":      "register",
+				"service":  fmt.Sprintf("%s/%d", misc.ConvertProtoToString(protocol), port),
+				"replica":  fmt.Sprintf("%s/%s:%d", misc.ConvertProtoToString(protocol), addrParts[0], port),
+				"replicas": len(targetService.getReplicas()),
+			})
 		} else {
 			// This means that the service has no replica, thus had its server terminated, we need to restart server
-			log.Printf("%s Controller: %s/%d is existing service, however had its server terminated, restarting server",
-				common.ColorCmdRegister, misc.ConvertProtoToString(protocol), port)
+			logger.Info("Existing service had its server terminated, restarting server", logger.Fields{
+				"cmd":     "register",
+				"service": fmt.Sprintf("%s/%d", misc.ConvertProtoToString(protocol), port),
+				"replica": fmt.Sprintf("%s/%s:%d", misc.ConvertProtoToString(protocol), addrParts[0], port),
+			})
 			targetService, err = h.restartService(port, protocol, targetService)
 			if err != nil {
 				return err
@@ -157,8 +164,11 @@ func (h *Handler) processRegister(conn net.Conn, mapData map[string]interface{})
 		}
 	} else {
 		// This means we are registering a new service
-		log.Printf("%s Controller: %s/%d is a new service",
-			common.ColorCmdRegister, misc.ConvertProtoToString(protocol), port)
+		logger.Info("New service", logger.Fields{
+			"cmd":     "register",
+			"service": fmt.Sprintf("%s/%d", misc.ConvertProtoToString(protocol), port),
+			"replica": fmt.Sprintf("%s/%s:%d", misc.ConvertProtoToString(protocol), addrParts[0], port),
+		})
 
 		// Create a new service
 		targetService, err = h.createNewService(port, protocol)
@@ -187,9 +197,9 @@ func (h *Handler) processRegister(conn net.Conn, mapData map[string]interface{})
 // processUnregister processes an unregister command
 func (h *Handler) processUnregister(conn net.Conn, mapData map[string]interface{}) error {
 	// Parse raw IP address from the remote Addr, simply retrieve the part before port binding
-	addrParts := strings.Split(conn.RemoteAddr().String(), ":")
+	addrParts := strings.Split(remoteAddr(conn), ":")
 	if len(addrParts) != 2 {
-		msg := fmt.Sprintf("could not parse remote address: %s", conn.RemoteAddr().String())
+		msg := fmt.Sprintf("could not parse remote address: %s", remoteAddr(conn))
 		return errors.New(msg)
 	}
 
@@ -235,9 +245,12 @@ func (h *Handler) processUnregister(conn net.Conn, mapData map[string]interface{
 				misc.ConvertProtoToString(protocol), addrParts[0], port)
 			return errors.New(msg)
 		} else {
-			log.Printf("%s Controller removed %s/%s:%d from service %s/%d (total %d availble replicas)",
-				common.ColorCmdUnregister, misc.ConvertProtoToString(protocol), addrParts[0], port,
-				misc.ConvertProtoToString(protocol), port, len(targetService.getReplicas()))
+			logger.Info("Removed replica from service", logger.Fields{
+				"cmd":      "unregister",
+				"service":  targetService.spec(),
+				"replica":  targetReplica.spec(),
+				"replicas": len(targetService.getReplicas()),
+			})
 			return nil
 		}
 	}
@@ -261,7 +274,7 @@ func (h *Handler) createNewService(port int, proto uint8) (*service, error) {
 	// Retrieve LB_LISTEN_ADDR as load balancer listen address
 	lbIPAddr := os.Getenv("LB_LISTEN_ADDR")
 	if len(lbIPAddr) == 0 {
-		log.Printf("%s $LB_LISTEN_ADDR not set, defaulting to 0.0.0.0", common.ColoredWarn)
+		logger.Warn("$LB_LISTEN_ADDR not set, defaulting to 0.0.0.0", nil)
 		lbIPAddr = "0.0.0.0"
 	}
 
@@ -271,8 +284,10 @@ func (h *Handler) createNewService(port int, proto uint8) (*service, error) {
 	// Start listening a new server
 	newServer, err := server.New(lbIPAddr, port, protoString, "")
 	if err != nil {
-		log.Printf("%s Controller failed to start a new service at %s/%s:%d: %v",
-			common.ColoredError, protoString, lbIPAddr, port, err)
+		logger.Error("Controller failed to start a new service", logger.Fields{
+			"service": fmt.Sprintf("%s/%s:%d", protoString, lbIPAddr, port),
+			"err":     err,
+		})
 		msg := fmt.Sprintf("failed to start server at %s/%s:%d: %v",
 			protoString, lbIPAddr, port, err)
 		return nil, errors.New(msg)
@@ -288,7 +303,12 @@ func (h *Handler) createNewService(port int, proto uint8) (*service, error) {
 		lock:               sync.Mutex{},
 		lastScheduledIndex: 0,
 		isLive:             true,
+		affinity:           envParseAffinity(),
+		scheduler:          envParseScheduler(),
+		outlier:            envParseOutlier(),
+		idleTimeout:        envParseIdleTimeout(),
 	}
+	newService.hashRing = newServiceHashRing(newService.scheduler)
 
 	// Set callback function for LB as doLB
 	go newServer.DoMainLoop(nil, newService.doLB)
@@ -308,7 +328,7 @@ func (h *Handler) restartService(port int, proto uint8, existingService *service
 	// Retrieve LB_LISTEN_ADDR as load balancer listen address
 	lbIPAddr := os.Getenv("LB_LISTEN_ADDR")
 	if len(lbIPAddr) == 0 {
-		log.Printf("%s $LB_LISTEN_ADDR not set, defaulting to 0.0.0.0", common.ColoredWarn)
+		logger.Warn("$LB_LISTEN_ADDR not set, defaulting to 0.0.0.0", nil)
 		lbIPAddr = "0.0.0.0"
 	}
 
@@ -318,8 +338,10 @@ func (h *Handler) restartService(port int, proto uint8, existingService *service
 	// Start listening a new server
 	newServer, err := server.New(lbIPAddr, port, protoString, "")
 	if err != nil {
-		log.Printf("%s Controller failed to start a new service at %s/%s:%d: %v",
-			common.ColoredError, protoString, lbIPAddr, port, err)
+		logger.Error("Controller failed to restart service", logger.Fields{
+			"service": fmt.Sprintf("%s/%s:%d", protoString, lbIPAddr, port),
+			"err":     err,
+		})
 		msg := fmt.Sprintf("failed to start server at %s/%s:%d: %v",
 			protoString, lbIPAddr, port, err)
 		return nil, errors.New(msg)
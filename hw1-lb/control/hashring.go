@@ -0,0 +1,112 @@
+package control
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"sync/atomic"
+)
+
+// ringPoint represents a single virtual node on the hash ring
+type ringPoint struct {
+	hash    uint64
+	replica *Replica
+}
+
+// hashRing represents a consistent hashing ring of Replicas with virtual nodes
+// When loadFactor is larger than 0, the ring works as consistent hashing with bounded loads, meaning that
+// a Replica will not be picked when it already has more than loadFactor times the average active connections
+// The ring itself is not thread safe, the owner service shall lock before using it
+type hashRing struct {
+	vnodes     int
+	loadFactor float64
+	points     []ringPoint
+	replicas   []*Replica
+}
+
+// newHashRing creates a new hashRing
+func newHashRing(vnodes int, loadFactor float64) *hashRing {
+	return &hashRing{
+		vnodes:     vnodes,
+		loadFactor: loadFactor,
+		points:     make([]ringPoint, 0),
+		replicas:   make([]*Replica, 0),
+	}
+}
+
+// hashKey hashes a string into the ring's key space
+// FNV is not that good at spreading similar strings, so the result is mixed with splitmix64 finalizer
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// rebuild places all virtual nodes of given Replicas on the ring
+// Since the points only depend on the Replica's address, adding or removing a Replica
+// only remaps the keys which were (or will be) owned by that Replica
+func (h *hashRing) rebuild(replicas []*Replica) {
+	points := make([]ringPoint, 0, len(replicas)*h.vnodes)
+	for _, r := range replicas {
+		for i := 0; i < h.vnodes; i++ {
+			points = append(points, ringPoint{
+				hash:    hashKey(fmt.Sprintf("%s#%d", r.GetInfo(), i)),
+				replica: r,
+			})
+		}
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].hash < points[j].hash
+	})
+
+	h.points = points
+	h.replicas = replicas
+}
+
+// lookup returns the Replica owning the given key, if the ring was empty this will return nil
+// This walks the ring clockwise from the key's hash, skipping unhealthy or ejected Replicas and
+// (with bounded loads) Replicas which already reached their capacity
+func (h *hashRing) lookup(key string) *Replica {
+	if len(h.points) == 0 {
+		return nil
+	}
+
+	// Calculate the capacity of a single replica, ceil(c * (total + 1) / n)
+	capacity := int32(math.MaxInt32)
+	if h.loadFactor > 0 {
+		var total int32
+		for _, r := range h.replicas {
+			total += atomic.LoadInt32(&r.activeConns)
+		}
+		capacity = int32(math.Ceil(h.loadFactor * float64(total+1) / float64(len(h.replicas))))
+	}
+
+	// Find the first point which is equal to or larger than the hash of the key
+	keyHash := hashKey(key)
+	start := sort.Search(len(h.points), func(i int) bool {
+		return h.points[i].hash >= keyHash
+	})
+
+	// Walk the ring, the first point is kept as the fallback when every replica was skipped
+	var fallback *Replica
+	for i := 0; i < len(h.points); i++ {
+		r := h.points[(start+i)%len(h.points)].replica
+		if fallback == nil {
+			fallback = r
+		}
+
+		if r.isAvailable() && atomic.LoadInt32(&r.activeConns) < capacity {
+			return r
+		}
+	}
+
+	return fallback
+}
@@ -0,0 +1,132 @@
+package control
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testReplicas returns healthy replicas listening on the addresses
+func testReplicas(addrs ...string) []*Replica {
+	replicas := make([]*Replica, 0, len(addrs))
+	for _, addr := range addrs {
+		replicas = append(replicas, &Replica{addr: addr, port: 80})
+	}
+	return replicas
+}
+
+func TestHashRingEmpty(t *testing.T) {
+	ring := newHashRing(100, 0)
+	if r := ring.lookup("key"); r != nil {
+		t.Errorf("lookup = %s, want nil on an empty ring", r.GetInfo())
+	}
+
+	ring.rebuild(testReplicas())
+	if r := ring.lookup("key"); r != nil {
+		t.Errorf("lookup = %s, want nil on an empty ring", r.GetInfo())
+	}
+}
+
+// TestHashRingRemap checks adding or removing a replica only moves the keys of that replica
+func TestHashRingRemap(t *testing.T) {
+	replicas := testReplicas("10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4")
+
+	tests := []struct {
+		name   string
+		before []*Replica
+		after  []*Replica
+		moved  *Replica // The only replica keys may move from or to
+	}{
+		{"removed", replicas, []*Replica{replicas[0], replicas[1], replicas[3]}, replicas[2]},
+		{"added", replicas[:3], replicas, replicas[3]},
+		{"reordered", replicas, []*Replica{replicas[3], replicas[1], replicas[0], replicas[2]}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := newHashRing(100, 0)
+			before.rebuild(test.before)
+			after := newHashRing(100, 0)
+			after.rebuild(test.after)
+
+			moved := 0
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("key-%d", i)
+				from, to := before.lookup(key), after.lookup(key)
+				if from == to {
+					continue
+				}
+
+				moved++
+				if from != test.moved && to != test.moved {
+					t.Errorf("%s moved from %s to %s", key, from.GetInfo(), to.GetInfo())
+				}
+			}
+			if test.moved != nil && moved == 0 {
+				t.Error("no key moved")
+			}
+		})
+	}
+}
+
+// TestHashRingSpread checks the virtual nodes spread the keys evenly enough among the replicas
+func TestHashRingSpread(t *testing.T) {
+	replicas := testReplicas("10.0.0.1", "10.0.0.2", "10.0.0.3")
+	ring := newHashRing(160, 0)
+	ring.rebuild(replicas)
+
+	keys := 3000
+	counts := make(map[*Replica]int)
+	for i := 0; i < keys; i++ {
+		counts[ring.lookup(fmt.Sprintf("10.1.%d.%d", i/256, i%256))]++
+	}
+
+	for _, r := range replicas {
+		if share := counts[r] * 100 / keys; share < 23 || share > 43 {
+			t.Errorf("%s got %d%% of the keys, want about a third", r.GetInfo(), share)
+		}
+	}
+}
+
+// TestHashRingSkip checks the ring walks past the replicas which cannot take the key
+func TestHashRingSkip(t *testing.T) {
+	unhealthy := func(r *Replica) { r.healthCheckFailure = 1 }
+	ejected := func(r *Replica) { r.ejectedUntil = time.Now().Add(time.Minute).UnixNano() }
+	busy := func(r *Replica) { r.activeConns = 10 }
+
+	tests := []struct {
+		name       string
+		loadFactor float64
+		change     func(r *Replica)
+		all        bool // Change every replica instead of the owner only
+		owner      bool // The key stays on its owner
+	}{
+		{"unhealthy", 0, unhealthy, false, false},
+		{"ejected", 0, ejected, false, false},
+		{"busy without bounded loads", 0, busy, false, true},
+		{"busy with bounded loads", 1.25, busy, false, false},
+		{"every replica unavailable falls back to the owner", 0, unhealthy, true, true},
+		{"evenly busy replicas stay within their capacity", 1.25, busy, true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			replicas := testReplicas("10.0.0.1", "10.0.0.2", "10.0.0.3")
+			ring := newHashRing(100, test.loadFactor)
+			ring.rebuild(replicas)
+
+			owner := ring.lookup("key")
+			if test.all {
+				for _, r := range replicas {
+					test.change(r)
+				}
+			} else {
+				test.change(owner)
+			}
+
+			if got := ring.lookup("key"); (got == owner) != test.owner {
+				t.Errorf("lookup = %s, owner %s, want owner %v", got.GetInfo(), owner.GetInfo(), test.owner)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package control
+
+import (
+	"lb/common"
+	"lb/logger"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// outlierConfig represents how a service passively detects and ejects misbehaving Replicas
+type outlierConfig struct {
+	consecutiveFailures int32
+	baseEjectionTime    time.Duration
+	maxEjectionTime     time.Duration
+	maxEjectionPercent  int
+	minSessionTime      time.Duration
+}
+
+// envParseOutlier parses environment variables and retrieves outlier detection configuration
+// - OUTLIER_CONSECUTIVE_FAILURES: failures in a row before ejecting a replica, 0 disables ejection (defaults 5)
+// - OUTLIER_BASE_EJECTION_TIME: seconds of the first ejection, doubled on every ejection (defaults 30)
+// - OUTLIER_MAX_EJECTION_TIME: max seconds of a single ejection (defaults 300)
+// - OUTLIER_MAX_EJECTION_PERCENT: max percentage of replicas ejected at once (defaults 50)
+// - OUTLIER_MIN_SESSION_MS: sessions closed by the replica without any reply within this are failures (defaults 10)
+func envParseOutlier() outlierConfig {
+	config := outlierConfig{
+		consecutiveFailures: 5,
+		baseEjectionTime:    30 * time.Second,
+		maxEjectionTime:     300 * time.Second,
+		maxEjectionPercent:  50,
+		minSessionTime:      10 * time.Millisecond,
+	}
+
+	if val, err := strconv.Atoi(os.Getenv("OUTLIER_CONSECUTIVE_FAILURES")); err == nil && val >= 0 {
+		config.consecutiveFailures = int32(val)
+	}
+
+	if val, err := strconv.Atoi(os.Getenv("OUTLIER_BASE_EJECTION_TIME")); err == nil && val > 0 {
+		config.baseEjectionTime = time.Duration(val) * time.Second
+	}
+
+	if val, err := strconv.Atoi(os.Getenv("OUTLIER_MAX_EJECTION_TIME")); err == nil && val > 0 {
+		config.maxEjectionTime = time.Duration(val) * time.Second
+	}
+
+	if val, err := strconv.Atoi(os.Getenv("OUTLIER_MAX_EJECTION_PERCENT")); err == nil && val >= 0 && val <= 100 {
+		config.maxEjectionPercent = val
+	}
+
+	if val, err := strconv.Atoi(os.Getenv("OUTLIER_MIN_SESSION_MS")); err == nil && val >= 0 {
+		config.minSessionTime = time.Duration(val) * time.Millisecond
+	}
+
+	return config
+}
+
+// isEjected returns if this replica is currently ejected from scheduling by outlier detection
+func (r *Replica) isEjected() bool {
+	return time.Now().UnixNano() < atomic.LoadInt64(&r.ejectedUntil)
+}
+
+// isAvailable returns if this replica can be scheduled, meaning it is healthy and not ejected
+func (r *Replica) isAvailable() bool {
+	return r.isHealthy() && !r.isEjected()
+}
+
+// reportForward looks at how a forwarded connection went, and reports the result to outlier detection
+// Connection resets and abnormally short sessions closed by the replica are regarded as failures
+func (s *service) reportForward(r *Replica, result forwardResult) {
+	if result.reset {
+		s.reportFailure(r, "connection reset")
+	} else if result.closedBy == common.ClosedByReplica && result.bytesDown == 0 &&
+		result.duration < s.outlier.minSessionTime {
+		s.reportFailure(r, "short session")
+	} else {
+		s.reportSuccess(r)
+	}
+}
+
+// reportSuccess resets the consecutive failures of the replica
+// When the replica stayed healthy as long as its last ejection, the ejection backoff is reset as well
+func (s *service) reportSuccess(r *Replica) {
+	atomic.StoreInt32(&r.consecutiveFailures, 0)
+
+	ejections := atomic.LoadInt32(&r.ejections)
+	if ejections == 0 {
+		return
+	}
+
+	lastEjection := s.ejectionTime(ejections)
+	if time.Now().UnixNano() > atomic.LoadInt64(&r.ejectedUntil)+lastEjection.Nanoseconds() {
+		atomic.StoreInt32(&r.ejections, 0)
+	}
+}
+
+// reportFailure counts a failure of the replica, and ejects the replica when it failed too many times in a row
+// The replica will not be ejected if it would make more than the max ejection percent of replicas ejected
+func (s *service) reportFailure(r *Replica, reason string) {
+	if s.outlier.consecutiveFailures == 0 {
+		return
+	}
+
+	failures := atomic.AddInt32(&r.consecutiveFailures, 1)
+	if failures < s.outlier.consecutiveFailures || r.isEjected() {
+		return
+	}
+
+	// Check the number of ejected replicas, this is a critical section so lock with mutex
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ejected := 0
+	for _, replica := range s.replicas {
+		if replica.isEjected() {
+			ejected++
+		}
+	}
+
+	if (ejected+1)*100 > s.outlier.maxEjectionPercent*len(s.replicas) {
+		logger.Warn("Replica failed too many times in a row, but max ejection percent was reached", logger.Fields{
+			"service":              s.spec(),
+			"replica":              r.spec(),
+			"failures":             failures,
+			"reason":               reason,
+			"max_ejection_percent": s.outlier.maxEjectionPercent,
+		})
+		return
+	}
+
+	// Eject the replica, the duration gets doubled on every ejection
+	ejections := atomic.AddInt32(&r.ejections, 1)
+	duration := s.ejectionTime(ejections)
+	atomic.StoreInt64(&r.ejectedUntil, time.Now().Add(duration).UnixNano())
+	atomic.StoreInt32(&r.consecutiveFailures, 0)
+
+	logger.Warn("Ejecting replica after too many failures in a row", logger.Fields{
+		"service":  s.spec(),
+		"replica":  r.spec(),
+		"failures": failures,
+		"reason":   reason,
+		"duration": duration,
+	})
+}
+
+// ejectionTime returns the duration of the n-th ejection, which is base * 2^(n-1) capped at max ejection time
+func (s *service) ejectionTime(ejections int32) time.Duration {
+	duration := s.outlier.baseEjectionTime
+	for i := int32(1); i < ejections && duration < s.outlier.maxEjectionTime; i++ {
+		duration *= 2
+	}
+
+	if duration > s.outlier.maxEjectionTime {
+		duration = s.outlier.maxEjectionTime
+	}
+	return duration
+}
@@ -0,0 +1,166 @@
+package control
+
+import (
+	"lb/common"
+	"testing"
+	"time"
+)
+
+// outlierService returns a service of the replicas, which detects outliers with the config
+func outlierService(config outlierConfig, replicas ...*Replica) *service {
+	s := &service{addr: "0.0.0.0", port: 80, proto: common.TypeProtoTCP, replicas: replicas, outlier: config}
+	for _, r := range replicas {
+		r.ownerService = s
+	}
+	return s
+}
+
+func TestEjectionTime(t *testing.T) {
+	s := outlierService(outlierConfig{baseEjectionTime: 30 * time.Second, maxEjectionTime: 300 * time.Second})
+
+	tests := []struct {
+		ejections int32
+		want      time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, 60 * time.Second},
+		{3, 120 * time.Second},
+		{4, 240 * time.Second},
+		{5, 300 * time.Second},
+		{40, 300 * time.Second},
+	}
+
+	for _, test := range tests {
+		if got := s.ejectionTime(test.ejections); got != test.want {
+			t.Errorf("ejectionTime(%d) = %v, want %v", test.ejections, got, test.want)
+		}
+	}
+}
+
+func TestReportForward(t *testing.T) {
+	config := outlierConfig{consecutiveFailures: 5, minSessionTime: 10 * time.Millisecond}
+
+	tests := []struct {
+		name    string
+		result  forwardResult
+		failure bool
+	}{
+		{"reset", forwardResult{reset: true, bytesDown: 100, duration: time.Second}, true},
+		{"short session closed by replica", forwardResult{closedBy: common.ClosedByReplica}, true},
+		{"short session closed by client", forwardResult{closedBy: common.ClosedByClient}, false},
+		{"short session with a reply", forwardResult{closedBy: common.ClosedByReplica, bytesDown: 1}, false},
+		{"long session closed by replica", forwardResult{closedBy: common.ClosedByReplica, duration: time.Second}, false},
+		{"timed out", forwardResult{timedOut: true, duration: time.Minute}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &Replica{addr: "10.0.0.1", port: 80, consecutiveFailures: 2}
+			s := outlierService(config, r)
+
+			s.reportForward(r, test.result)
+			want := int32(0)
+			if test.failure {
+				want = 3
+			}
+			if r.consecutiveFailures != want {
+				t.Errorf("consecutive failures = %d, want %d", r.consecutiveFailures, want)
+			}
+		})
+	}
+}
+
+// TestReportFailure checks replicas are ejected after failing in a row, unless too many replicas would be ejected
+func TestReportFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   outlierConfig
+		replicas int
+		failures int // Failures of every replica in order
+		ejected  int
+	}{
+		{"below consecutive failures", outlierConfig{consecutiveFailures: 3, maxEjectionPercent: 100}, 2, 2, 0},
+		{"consecutive failures", outlierConfig{consecutiveFailures: 3, maxEjectionPercent: 100}, 2, 3, 2},
+		{"disabled", outlierConfig{consecutiveFailures: 0, maxEjectionPercent: 100}, 2, 10, 0},
+		{"max ejection percent", outlierConfig{consecutiveFailures: 1, maxEjectionPercent: 50}, 4, 1, 2},
+		{"max ejection percent rounds down", outlierConfig{consecutiveFailures: 1, maxEjectionPercent: 50}, 3, 1, 1},
+		{"no ejection at all", outlierConfig{consecutiveFailures: 1, maxEjectionPercent: 0}, 3, 1, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.config.baseEjectionTime = time.Minute
+			test.config.maxEjectionTime = time.Hour
+
+			replicas := testReplicas("10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4")[:test.replicas]
+			s := outlierService(test.config, replicas...)
+			for _, r := range replicas {
+				for i := 0; i < test.failures; i++ {
+					s.reportFailure(r, "test")
+				}
+			}
+
+			ejected := 0
+			for _, r := range replicas {
+				if r.isEjected() {
+					ejected++
+					if r.isAvailable() {
+						t.Errorf("%s was ejected but still available", r.GetInfo())
+					}
+				}
+			}
+			if ejected != test.ejected {
+				t.Errorf("ejected %d replicas, want %d", ejected, test.ejected)
+			}
+		})
+	}
+}
+
+// TestEjectionBackoff checks every ejection of a replica which keeps failing lasts twice as long as the last one
+func TestEjectionBackoff(t *testing.T) {
+	r := &Replica{addr: "10.0.0.1", port: 80}
+	s := outlierService(outlierConfig{consecutiveFailures: 1, maxEjectionPercent: 100, baseEjectionTime: time.Minute,
+		maxEjectionTime: time.Hour}, r)
+
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute} {
+		// Let the last ejection end right away, so the replica can be ejected again
+		r.ejectedUntil = 0
+
+		before := time.Now()
+		s.reportFailure(r, "test")
+		after := time.Now()
+
+		until := time.Unix(0, r.ejectedUntil)
+		if until.Before(before.Add(want)) || until.After(after.Add(want)) {
+			t.Errorf("ejection %d lasts %v, want %v", r.ejections, until.Sub(before), want)
+		}
+	}
+}
+
+// TestReportSuccess checks the ejection backoff is only reset after the replica stayed healthy as long as its last ejection
+func TestReportSuccess(t *testing.T) {
+	tests := []struct {
+		name      string
+		ejectedAt time.Duration // When the last ejection ended, relative to now
+		ejections int32
+	}{
+		{"right after ejection", -time.Second, 2},
+		{"healthy as long as last ejection", -2*time.Minute - time.Second, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &Replica{addr: "10.0.0.1", port: 80, consecutiveFailures: 3, ejections: 2}
+			r.ejectedUntil = time.Now().Add(test.ejectedAt).UnixNano()
+			s := outlierService(outlierConfig{consecutiveFailures: 5, baseEjectionTime: time.Minute, maxEjectionTime: time.Hour}, r)
+
+			s.reportSuccess(r)
+			if r.consecutiveFailures != 0 {
+				t.Errorf("consecutive failures = %d, want 0", r.consecutiveFailures)
+			}
+			if r.ejections != test.ejections {
+				t.Errorf("ejections = %d, want %d", r.ejections, test.ejections)
+			}
+		})
+	}
+}
@@ -4,25 +4,30 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"lb/common"
+	"lb/logger"
 	"lb/misc"
-	"log"
 	"net"
 	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 // Replica represents a single replica for load balancing
 type Replica struct {
-	addr               string
-	port               int
-	proto              uint8
-	lastHealthCheck    time.Time
-	healthCheckConn    net.Conn
-	ownerService       *service
-	healthCheckStopper chan uint8
+	addr                string
+	port                int
+	proto               uint8
+	lastHealthCheck     time.Time
+	healthCheckConn     net.Conn
+	ownerService        *service
+	healthCheckStopper  chan uint8
+	healthCheckFailure  int32
+	activeConns         int32
+	ejectedUntil        int64
+	ejections           int32
+	consecutiveFailures int32
 }
 
 // StartHealthCheckRoutine starts loop for health check for given replica forever
@@ -65,29 +70,40 @@ func (r *Replica) StartHealthCheckRoutine() {
 		for {
 			select {
 			case <-r.healthCheckStopper:
-				log.Printf("%s Health check routine was terminated by force for %s/%s:%d",
-					common.ColoredInfo, misc.ConvertProtoToString(r.proto), r.addr, r.port)
+				logger.Info("Health check routine was terminated by force",
+					logger.Fields{"service": r.ownerService.spec(), "replica": r.spec()})
 				return
 			default:
 				err := performHealthCheck(r.healthCheckConn)
 				if err != nil {
 					// Health check failed, warn user
-					log.Printf("%s Health check failed for %s/%s:%d (%d/%d), last reported: %s",
-						common.ColoredWarn, misc.ConvertProtoToString(r.proto), r.addr, r.port, curFailure, maxFailure,
-						r.lastHealthCheck.String())
+					logger.Warn("Health check failed", logger.Fields{
+						"service":      r.ownerService.spec(),
+						"replica":      r.spec(),
+						"failures":     curFailure,
+						"max_failures": maxFailure,
+						"last_success": r.lastHealthCheck.String(),
+						"err":          err,
+					})
 					curFailure++
 				} else {
 					// Health check successfully finished, reset failure count and set last health check time
 					curFailure = 0
 					r.lastHealthCheck = time.Now()
-					//log.Printf("%s Health check finished for %s/%s:%d",
-					//	common.ColoredInfo, misc.ConvertProtoToString(r.proto), r.addr, r.port)
+					logger.Debug("Health check finished",
+						logger.Fields{"service": r.ownerService.spec(), "replica": r.spec()})
 				}
 
+				atomic.StoreInt32(&r.healthCheckFailure, int32(curFailure))
+
 				// Reached max health check failures
 				if curFailure >= maxFailure {
-					log.Printf("%s Max health check failure count reached for %s/%s:%d (%d/%d)",
-						common.ColoredError, misc.ConvertProtoToString(r.proto), r.addr, r.port, curFailure, maxFailure)
+					logger.Error("Max health check failure count reached", logger.Fields{
+						"service":      r.ownerService.spec(),
+						"replica":      r.spec(),
+						"failures":     curFailure,
+						"max_failures": maxFailure,
+					})
 					break healthCheckFor
 				}
 
@@ -99,13 +115,13 @@ func (r *Replica) StartHealthCheckRoutine() {
 		// The health check connection is messed up, close
 		err := closeConnectionWithTimeout(r.healthCheckConn, 3)
 		if err != nil {
-			log.Printf("%s Controller is unable to close socket connection to %s/%s:%d: %v",
-				common.ColoredWarn, misc.ConvertProtoToString(r.proto), r.addr, r.port, err)
+			logger.Warn("Controller is unable to close health check connection",
+				logger.Fields{"service": r.ownerService.spec(), "replica": r.spec(), "err": err})
 		}
 
 		// Remove this replica from the owner service
-		log.Printf("%s Removing replica %s/%s:%d from service due to reaching max health check retrial",
-			common.ColoredWarn, misc.ConvertProtoToString(r.proto), r.addr, r.port)
+		logger.Warn("Removing replica from service due to reaching max health check retrial",
+			logger.Fields{"service": r.ownerService.spec(), "replica": r.spec()})
 		r.ownerService.removeReplica(*r)
 	}()
 }
@@ -201,6 +217,16 @@ func (r *Replica) GetInfo() string {
 	return fmt.Sprintf("%s:%d", r.addr, r.port)
 }
 
+// spec returns a string describing the protocol and the listen address, ex) tcp/10.0.0.1:80
+func (r *Replica) spec() string {
+	return fmt.Sprintf("%s/%s:%d", misc.ConvertProtoToString(r.proto), r.addr, r.port)
+}
+
+// isHealthy returns if the last health check of this replica was successful
+func (r *Replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthCheckFailure) == 0
+}
+
 // StopHealthCheck stops health check routine
 // This will not remove the replica from the service automatically
 func (r *Replica) StopHealthCheck() {
@@ -0,0 +1,128 @@
+package control
+
+import (
+	"lb/common"
+	"lb/logger"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// schedulerConfig represents how a service picks a Replica for a new connection
+type schedulerConfig struct {
+	algorithm  uint8
+	hashKey    string
+	vnodes     int
+	loadFactor float64
+}
+
+// envParseScheduler parses environment variables and retrieves scheduler configuration
+// - LB_SCHEDULER: "round-robin", "consistent-hash" or "bounded-consistent-hash" (defaults round-robin)
+// - LB_HASH_KEY: "source-ip", "source-addr", "header:NAME" or "cookie:NAME" (defaults source-ip)
+// - LB_HASH_VNODES: the number of virtual nodes per replica on the hash ring (defaults 100)
+// - LB_HASH_LOAD_FACTOR: the max load of a replica compared to the average for bounded loads (defaults 1.25)
+func envParseScheduler() schedulerConfig {
+	config := schedulerConfig{
+		algorithm:  common.SchedRoundRobin,
+		hashKey:    "source-ip",
+		vnodes:     100,
+		loadFactor: 1.25,
+	}
+
+	switch strings.ToLower(os.Getenv("LB_SCHEDULER")) {
+	case "", "round-robin":
+		config.algorithm = common.SchedRoundRobin
+	case "consistent-hash":
+		config.algorithm = common.SchedConsistentHash
+	case "bounded-consistent-hash":
+		config.algorithm = common.SchedBoundedConsistentHash
+	default:
+		logger.Warn("Unknown $LB_SCHEDULER, defaulting to round-robin",
+			logger.Fields{"scheduler": os.Getenv("LB_SCHEDULER")})
+	}
+
+	if hashKey := os.Getenv("LB_HASH_KEY"); len(hashKey) != 0 {
+		config.hashKey = hashKey
+	}
+
+	if val, err := strconv.Atoi(os.Getenv("LB_HASH_VNODES")); err == nil && val > 0 {
+		config.vnodes = val
+	}
+
+	if val, err := strconv.ParseFloat(os.Getenv("LB_HASH_LOAD_FACTOR"), 64); err == nil && val >= 1 {
+		config.loadFactor = val
+	}
+
+	return config
+}
+
+// newServiceHashRing creates the hash ring for the service if its scheduler uses consistent hashing
+func newServiceHashRing(config schedulerConfig) *hashRing {
+	switch config.algorithm {
+	case common.SchedConsistentHash:
+		return newHashRing(config.vnodes, 0)
+	case common.SchedBoundedConsistentHash:
+		return newHashRing(config.vnodes, config.loadFactor)
+	default:
+		return nil
+	}
+}
+
+// needsHTTP returns if the service shall read the HTTP request before scheduling
+func (s *service) needsHTTP() bool {
+	if s.proto != common.TypeProtoTCP {
+		return false
+	}
+
+	return s.affinity.mode != common.AffinityNone ||
+		(s.hashRing != nil && (strings.HasPrefix(s.scheduler.hashKey, "header:") ||
+			strings.HasPrefix(s.scheduler.hashKey, "cookie:")))
+}
+
+// schedulingKey retrieves the key for consistent hashing from the connection and the HTTP request
+// The request can be nil for non HTTP services, if the configured key was not found, source IP is used
+func (s *service) schedulingKey(srcConn net.Conn, req *http.Request) string {
+	hashKey := s.scheduler.hashKey
+	if req != nil && strings.HasPrefix(hashKey, "header:") {
+		if val := req.Header.Get(strings.TrimPrefix(hashKey, "header:")); len(val) != 0 {
+			return val
+		}
+	} else if req != nil && strings.HasPrefix(hashKey, "cookie:") {
+		if cookie, err := req.Cookie(strings.TrimPrefix(hashKey, "cookie:")); err == nil {
+			return cookie.Value
+		}
+	} else if strings.Compare(hashKey, "source-addr") == 0 {
+		return remoteAddr(srcConn)
+	}
+
+	// Default to the source IP
+	host, _, err := net.SplitHostPort(remoteAddr(srcConn))
+	if err != nil {
+		return remoteAddr(srcConn)
+	}
+	return host
+}
+
+// schedule picks a Replica for the connection using the configured scheduler
+// This returns the picked Replica and its index, if there was no Replica, this will return nil
+func (s *service) schedule(srcConn net.Conn, req *http.Request) (*Replica, int) {
+	if s.hashRing == nil {
+		return s.scheduleNext()
+	}
+
+	key := s.schedulingKey(srcConn, req)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	target := s.hashRing.lookup(key)
+	for i, r := range s.replicas {
+		if r == target {
+			return r, i
+		}
+	}
+
+	return nil, -1
+}
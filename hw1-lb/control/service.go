@@ -2,13 +2,16 @@ package control
 
 import (
 	"fmt"
+	"lb/accesslog"
 	"lb/common"
+	"lb/logger"
 	"lb/misc"
 	"lb/server"
-	"log"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // service represents a single service exposed by load balancer
@@ -21,6 +24,11 @@ type service struct {
 	lock               sync.Mutex
 	lastScheduledIndex int
 	isLive             bool
+	affinity           affinityConfig
+	scheduler          schedulerConfig
+	hashRing           *hashRing
+	outlier            outlierConfig
+	idleTimeout        time.Duration
 }
 
 // isGivenSpec returns if given spec matches current service, if we are looking at address as well, use isExactGivenSpec
@@ -28,6 +36,11 @@ func (s *service) isGivenSpec(port int, proto uint8) bool {
 	return s.port == port && s.proto == proto
 }
 
+// spec returns a string describing the protocol and the listen address, ex) tcp/0.0.0.0:80
+func (s *service) spec() string {
+	return fmt.Sprintf("%s/%s:%d", misc.ConvertProtoToString(s.proto), s.addr, s.port)
+}
+
 // isExactGivenSpec returns if given spec matches current service
 func (s *service) isExactGivenSpec(address string, port int, proto uint8) bool {
 	return s.port == port && s.proto == proto && strings.Compare(s.addr, address) == 0
@@ -38,6 +51,9 @@ func (s *service) isExactGivenSpec(address string, port int, proto uint8) bool {
 func (s *service) addReplica(r *Replica) {
 	s.lock.Lock()
 	s.replicas = append(s.replicas, r)
+	if s.hashRing != nil {
+		s.hashRing.rebuild(s.replicas)
+	}
 	s.lock.Unlock()
 }
 
@@ -62,17 +78,18 @@ func (s *service) removeReplica(target Replica) bool {
 		}
 	}
 	s.replicas = updatedReplicas
+	if s.hashRing != nil {
+		s.hashRing.rebuild(s.replicas)
+	}
 	s.lock.Unlock()
 
 	// Check if this service shall be terminated or not
 	if s.shouldBeTerminated() {
-		log.Printf("%s Service %s/%s:%d has no more replica left, terminating server",
-			common.ColoredInfo, misc.ConvertProtoToString(s.proto), s.addr, s.port)
+		logger.Info("Service has no more replica left, terminating server", logger.Fields{"service": s.spec()})
 
 		err := s.terminateService()
 		if err != nil {
-			log.Printf("%s Service %s/%s:%d cannot terminate server: %v",
-				common.ColoredError, misc.ConvertProtoToString(s.proto), s.addr, s.port, err)
+			logger.Error("Service cannot terminate server", logger.Fields{"service": s.spec(), "err": err})
 		}
 
 		// Set current service as dead
@@ -105,44 +122,119 @@ func (s *service) terminateService() error {
 	return s.server.Close()
 }
 
-// doLB picks a replica and sends the traffic from conn to the target replica server
-// We can of course ignore race conditions and do a bit more dangerous connections, but
-// for stability, we are going to use mutex and avoid any possible race conditions
-// When autoTry was set on, the function will pick next replica when current replica fails
-func (s *service) doLB(srcConn net.Conn) {
+// scheduleNext picks the next Replica to send the traffic to, using simple round-robin algorithm
+// This returns the picked Replica and its index, if there was no Replica, this will return nil
+func (s *service) scheduleNext() (*Replica, int) {
 	// The replicas that are possible to be scheduled
 	replicas := s.getReplicas()
 	replicaLen := len(replicas)
+	if replicaLen == 0 {
+		return nil, -1
+	}
 
 	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	// Perform simple round-robin algorithm
 	// If this was the last element or exceeds it, send it to the beginning
 	// Golang supports short circuit evaluation, meaning that if we only had single replica for a single server
 	// This will fire up the first part of evaluation expression and set the if statement as true
 	// If we explicitly checked if our replica count was 0, there will be additional cost for evaluating
 	// whether the replica count was 0. So this will be effective and faster.
-	if s.lastScheduledIndex+1 == replicaLen || s.lastScheduledIndex+1 > replicaLen {
-		s.lastScheduledIndex = 0
-	} else {
-		s.lastScheduledIndex = s.lastScheduledIndex + 1
+	// Replicas which are unhealthy or ejected are skipped, unless every replica was unavailable
+	fallbackIndex := -1
+	for i := 0; i < replicaLen; i++ {
+		if s.lastScheduledIndex+1 == replicaLen || s.lastScheduledIndex+1 > replicaLen {
+			s.lastScheduledIndex = 0
+		} else {
+			s.lastScheduledIndex = s.lastScheduledIndex + 1
+		}
+
+		if replicas[s.lastScheduledIndex].isAvailable() {
+			return replicas[s.lastScheduledIndex], s.lastScheduledIndex
+		}
+
+		if fallbackIndex == -1 {
+			fallbackIndex = s.lastScheduledIndex
+		}
 	}
 
+	s.lastScheduledIndex = fallbackIndex
+	return replicas[fallbackIndex], fallbackIndex
+}
+
+// doLB picks a replica and sends the traffic from conn to the target replica server
+// We can of course ignore race conditions and do a bit more dangerous connections, but
+// for stability, we are going to use mutex and avoid any possible race conditions
+// When autoTry was set on, the function will pick next replica when current replica fails
+// If the service needs to look at HTTP requests for scheduling, the TCP connection will be handled by doHTTPLB
+func (s *service) doLB(srcConn net.Conn) {
+	if s.needsHTTP() {
+		s.doHTTPLB(srcConn)
+		return
+	}
+
+	// UDP services share a single connection, so only close TCP connections once done
+	if s.proto == common.TypeProtoTCP {
+		defer srcConn.Close()
+	}
+
+	// Every exit path leaves a single access log record
+	record := accesslog.Record{Client: remoteAddr(srcConn), Service: s.spec(), Index: -1, Start: time.Now()}
+	defer func() {
+		record.Duration = time.Since(record.Start)
+		accesslog.Write(record)
+	}()
+
 	// The target replica that was selected
-	targetReplica := replicas[s.lastScheduledIndex]
+	targetReplica, schedIndex := s.schedule(srcConn, nil)
+	if targetReplica == nil {
+		logger.Warn("Service has no replica to forward to",
+			logger.Fields{"service": s.spec(), "client": remoteAddr(srcConn)})
+		record.Reason = accesslog.ReasonNoReplica
+		return
+	}
 	targetAddr := fmt.Sprintf("%s:%d", targetReplica.addr, targetReplica.port)
 	targetProto := misc.ConvertProtoToString(targetReplica.proto)
-
-	schedIndex := s.lastScheduledIndex
-	s.lock.Unlock()
+	replicaLen := len(s.getReplicas())
+	record.Replica = targetReplica.spec()
+	record.Index = schedIndex
 
 	// For debugging purpose
-	log.Printf("%s Forwarding %s -> %s proto=%s / index=%d / total=%d",
-		common.ColoredInfo, srcConn.RemoteAddr(), targetAddr, targetProto, schedIndex, replicaLen)
+	logger.Info("Forwarding connection", logger.Fields{
+		"service":  s.spec(),
+		"client":   remoteAddr(srcConn),
+		"replica":  targetReplica.spec(),
+		"index":    schedIndex,
+		"replicas": replicaLen,
+	})
+
+	// UDP services share a single connection, so deadlines must not be set on it
+	idleTimeout := s.idleTimeout
+	if s.proto != common.TypeProtoTCP {
+		idleTimeout = 0
+	}
 
 	// Forward traffic from srcConn to targetAddr
-	err := forwardTraffic(srcConn, targetAddr, targetProto)
+	atomic.AddInt32(&targetReplica.activeConns, 1)
+	result, err := forwardTraffic(srcConn, targetAddr, targetProto, idleTimeout)
+	atomic.AddInt32(&targetReplica.activeConns, -1)
 	if err != nil {
-		log.Printf("%s Forwarding %s -> %s proto=%s / index=%d failed: %v ",
-			common.ColoredWarn, srcConn.RemoteAddr(), targetAddr, targetProto, schedIndex, err)
+		logger.Warn("Forwarding connection failed", logger.Fields{
+			"service": s.spec(),
+			"client":  remoteAddr(srcConn),
+			"replica": targetReplica.spec(),
+			"index":   schedIndex,
+			"err":     err,
+		})
+		s.reportFailure(targetReplica, "dial error")
+		record.Reason = accesslog.ReasonDialError
+		return
 	}
+	record.BytesUp = result.bytesUp
+	record.BytesDown = result.bytesDown
+	record.Reason = result.reason()
+
+	// Let outlier detection know how the connection went
+	s.reportForward(targetReplica, result)
 }
@@ -6,12 +6,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"lb/accesslog"
 	"lb/common"
-	"log"
+	"lb/logger"
 	"net"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -23,9 +27,8 @@ func envParseAddress() (string, int) {
 	envAddr := os.Getenv("LB_LISTEN_ADDR")
 	ipv4Addr, _, err := net.ParseCIDR(envAddr)
 	if err != nil {
-		log.Printf("%s Could not parse environment variable $LB_LISTEN_ADDR in IP address format: %v",
-			common.ColoredWarn, err)
-		log.Printf("%s Defaulting listen IP to 0.0.0.0", common.ColoredWarn)
+		logger.Warn("Could not parse $LB_LISTEN_ADDR in IP address format, defaulting to 0.0.0.0",
+			logger.Fields{"err": err})
 		ipv4Addr = net.IPv4(0, 0, 0, 0) // 0.0.0.0
 	}
 
@@ -33,14 +36,23 @@ func envParseAddress() (string, int) {
 	envPort := os.Getenv("LB_LISTEN_PORT")
 	portVal, err := strconv.Atoi(envPort)
 	if err != nil {
-		log.Printf("%s Could not parse environment variable $LB_LISTEN_PORT as integer: %v", common.ColoredWarn, err)
-		log.Printf("%s Defaulting back to 8080", common.ColoredWarn)
+		logger.Warn("Could not parse $LB_LISTEN_PORT as integer, defaulting to 8080",
+			logger.Fields{"err": err})
 		portVal = 8080
 	}
 
 	return ipv4Addr.String(), portVal
 }
 
+// envParseIdleTimeout parses environment variables and retrieves the idle timeout of proxied TCP connections
+// - LB_IDLE_TIMEOUT: seconds a proxied connection may stay idle before it is terminated, 0 disables it (defaults 0)
+func envParseIdleTimeout() time.Duration {
+	if val, err := strconv.Atoi(os.Getenv("LB_IDLE_TIMEOUT")); err == nil && val > 0 {
+		return time.Duration(val) * time.Second
+	}
+	return 0
+}
+
 // jsonParser decodes raw bytes into JSON object
 func jsonParser(payload []byte) (map[string]interface{}, error) {
 	var ret map[string]interface{}
@@ -121,22 +133,22 @@ func returnResult(conn net.Conn, err error, commandType uint8) {
 	}
 
 	if err != nil {
-		log.Printf("%s Controller could not %s replica [src=%s]: %v",
-			common.ColoredError, typeString, conn.RemoteAddr(), err)
+		logger.Error("Controller could not process command",
+			logger.Fields{"cmd": typeString, "client": remoteAddr(conn), "err": err})
 
 		// Registration failed, send acknowledgment with error message to the client
 		failureResponse := map[string]string{"ack": "failed", "msg": err.Error()}
 		failureResponseJSON, err := json.Marshal(failureResponse)
 		if err != nil {
-			log.Printf("%s Controller Error encoding failure response [src=%s]: %v\n",
-				common.ColoredError, conn.RemoteAddr(), err)
+			logger.Error("Controller could not encode failure response",
+				logger.Fields{"client": remoteAddr(conn), "err": err})
 			return
 		}
 
 		_, err = conn.Write(failureResponseJSON)
 		if err != nil {
-			log.Printf("%s Error writing failure response to client [src=%s]: %v\n",
-				common.ColoredError, conn.RemoteAddr(), err)
+			logger.Error("Controller could not write failure response",
+				logger.Fields{"client": remoteAddr(conn), "err": err})
 			return
 		}
 	} else {
@@ -144,15 +156,15 @@ func returnResult(conn net.Conn, err error, commandType uint8) {
 		successResponse := map[string]string{"ack": "successful"}
 		successResponseJSON, err := json.Marshal(successResponse)
 		if err != nil {
-			log.Printf("%s Error encoding success response [src=%s]: %v\n",
-				common.ColoredError, conn.RemoteAddr(), err)
+			logger.Error("Controller could not encode success response",
+				logger.Fields{"client": remoteAddr(conn), "err": err})
 			return
 		}
 
 		_, err = conn.Write(successResponseJSON)
 		if err != nil {
-			log.Printf("%s Error writing success response to client [src=%s]: %v\n",
-				common.ColoredError, conn.RemoteAddr(), err)
+			logger.Error("Controller could not write success response",
+				logger.Fields{"client": remoteAddr(conn), "err": err})
 			return
 		}
 	}
@@ -182,35 +194,203 @@ func closeConnectionWithTimeout(conn net.Conn, timeout time.Duration) error {
 	}
 }
 
+// forwardResult represents how a single forwarded connection went
+type forwardResult struct {
+	bytesUp   int64
+	bytesDown int64
+	duration  time.Duration
+	reset     bool
+	timedOut  bool
+	closedBy  uint8
+}
+
+// reason returns why the forwarded connection was terminated, used for access logs
+func (r forwardResult) reason() string {
+	if r.timedOut {
+		return accesslog.ReasonTimeout
+	} else if r.reset {
+		return accesslog.ReasonBackendReset
+	} else if r.closedBy == common.ClosedByReplica {
+		return accesslog.ReasonBackendClose
+	} else {
+		return accesslog.ReasonClientClose
+	}
+}
+
 // forwardTraffic forwards traffic from srcConn to target address
+// When idleTimeout is larger than 0, the connection is terminated once both directions stayed idle for that long
 // The code was generated by ChatGPT
-func forwardTraffic(srcConn net.Conn, targetAddr, targetProto string) error {
+func forwardTraffic(srcConn net.Conn, targetAddr, targetProto string, idleTimeout time.Duration) (forwardResult, error) {
+	start := time.Now()
+
 	// Establish a connection to the target server
 	targetConn, err := net.Dial(targetProto, targetAddr)
 	if err != nil {
-		return err
+		return forwardResult{duration: time.Since(start)}, err
 	}
 	defer targetConn.Close()
 
+	result := pipeTraffic(srcConn, srcConn, targetConn, targetConn, idleTimeout)
+	result.duration = time.Since(start)
+	return result, nil
+}
+
+// idleTracker keeps the time of the last traffic of a proxied connection in either direction
+type idleTracker struct {
+	lastActive int64 // Unix time in nanoseconds, accessed atomically
+	timeout    time.Duration
+}
+
+// newIdleTracker creates a new idleTracker, the connection counts as active when it is created
+func newIdleTracker(timeout time.Duration) *idleTracker {
+	tracker := &idleTracker{timeout: timeout}
+	tracker.touch()
+	return tracker
+}
+
+// touch marks the connection active now
+func (t *idleTracker) touch() {
+	atomic.StoreInt64(&t.lastActive, time.Now().UnixNano())
+}
+
+// deadline returns when the connection will have been idle for the timeout, unless traffic comes before
+func (t *idleTracker) deadline() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&t.lastActive)).Add(t.timeout)
+}
+
+// idleReader is an io.Reader which times out only once both directions of the connection stayed idle for the timeout
+type idleReader struct {
+	conn    net.Conn
+	reader  io.Reader
+	tracker *idleTracker
+}
+
+// Read reads from the underlying reader until the deadline of the connection, and marks the connection active
+// Reads which timed out while the other direction had traffic are retried until the moved deadline
+func (r idleReader) Read(p []byte) (int, error) {
+	for {
+		_ = r.conn.SetReadDeadline(r.tracker.deadline())
+		n, err := r.reader.Read(p)
+		if n > 0 {
+			r.tracker.touch()
+		} else if isTimeout(err) && time.Now().Before(r.tracker.deadline()) {
+			continue
+		}
+		return n, err
+	}
+}
+
+// errorReader is an io.Reader which keeps the last error its underlying reader returned
+// io.Copy reports errors of both reading and writing, so this tells the errors of the reading side apart
+type errorReader struct {
+	reader io.Reader
+	err    error
+}
+
+// Read reads from the underlying reader, and keeps the error other than io.EOF
+func (r *errorReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// isTimeout returns if the error was caused by a deadline
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// pipeTraffic copies traffic in both directions between srcConn and targetConn until both directions finish
+// The readers are given separately, so that the bytes which were already buffered by a bufio.Reader are not lost
+// When idleTimeout is larger than 0, the connection is terminated once both directions stayed idle for that long
+func pipeTraffic(srcConn net.Conn, srcReader io.Reader, targetConn net.Conn, targetReader io.Reader,
+	idleTimeout time.Duration) forwardResult {
+	var result forwardResult
+	var closeOnce sync.Once
+
+	if idleTimeout > 0 {
+		tracker := newIdleTracker(idleTimeout)
+		srcReader = idleReader{conn: srcConn, reader: srcReader, tracker: tracker}
+		targetReader = idleReader{conn: targetConn, reader: targetReader, tracker: tracker}
+	}
+
+	// Only errors reading from the target are failures of the replica, failing to write to the client is not
+	targetErrors := &errorReader{reader: targetReader}
+
+	// Both directions share the deadline, so they time out together, and are only combined once both finished
+	var upTimedOut, downTimedOut bool
+
 	// Use goroutines to forward traffic in both directions
+	var wg sync.WaitGroup
+	wg.Add(2)
 	go func() {
+		defer wg.Done()
 		// Copy data from srcConn to targetConn
-		_, err := io.Copy(targetConn, srcConn)
+		n, err := io.Copy(targetConn, srcReader)
 		if err != nil {
-			// Handle the error as needed
-			fmt.Println("Error copying data to targetConn:", err)
+			logger.Debug("Could not copy data to replica",
+				logger.Fields{"client": remoteAddr(srcConn), "replica": remoteAddr(targetConn), "err": err})
 		}
+		result.bytesUp = n
+		closeOnce.Do(func() { result.closedBy = common.ClosedByClient })
+
+		upTimedOut = isTimeout(err)
+
+		// The client finished sending, let the target know as well
+		closeWrite(targetConn)
 	}()
 
 	go func() {
+		defer wg.Done()
 		// Copy data from targetConn to srcConn
-		_, err := io.Copy(srcConn, targetConn)
+		n, err := io.Copy(srcConn, targetErrors)
 		if err != nil {
-			// Handle the error as needed
-			fmt.Println("Error copying data to srcConn:", err)
+			logger.Debug("Could not copy data to client",
+				logger.Fields{"client": remoteAddr(srcConn), "replica": remoteAddr(targetConn), "err": err})
 		}
+		result.bytesDown = n
+		closeOnce.Do(func() { result.closedBy = common.ClosedByReplica })
+
+		downTimedOut = isTimeout(err)
+
+		// The target finished sending, let the client know as well
+		closeWrite(srcConn)
 	}()
 
 	// Block until both goroutines complete
-	select {}
+	wg.Wait()
+	result.timedOut = upTimedOut || downTimedOut
+	result.reset = errors.Is(targetErrors.err, syscall.ECONNRESET)
+	return result
+}
+
+// remoteAddr returns the remote address of the connection as string
+// Connections such as listening UDP connections do not have remote addresses, so this returns empty string
+func remoteAddr(conn net.Conn) string {
+	if conn.RemoteAddr() == nil {
+		return ""
+	}
+	return conn.RemoteAddr().String()
+}
+
+// countingWriter is an io.Writer which counts the bytes written through it
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+// Write writes p to the underlying writer and counts the written bytes
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.count += int64(n)
+	return n, err
+}
+
+// closeWrite shuts down the writing side of a TCP connection, this is no-op for other connections
+func closeWrite(conn net.Conn) {
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.CloseWrite()
+	}
 }
@@ -0,0 +1,185 @@
+package control
+
+import (
+	"io"
+	"lb/accesslog"
+	"lb/common"
+	"net"
+	"testing"
+	"time"
+)
+
+// connPair returns both ends of a loopback TCP connection
+func connPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+
+	dialed, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	conn := <-accepted
+	if conn == nil {
+		t.Fatal("could not accept")
+	}
+	return dialed.(*net.TCPConn), conn.(*net.TCPConn)
+}
+
+// reset closes the connection with RST instead of FIN
+func reset(conn *net.TCPConn) {
+	_ = conn.SetLinger(0)
+	_ = conn.Close()
+}
+
+// pipe runs pipeTraffic between the proxy ends of the client and the target connections
+func pipe(t *testing.T, idleTimeout time.Duration, client func(conn *net.TCPConn), target func(conn *net.TCPConn)) forwardResult {
+	t.Helper()
+
+	clientConn, srcConn := connPair(t)
+	targetConn, replicaConn := connPair(t)
+	defer srcConn.Close()
+	defer targetConn.Close()
+
+	go client(clientConn)
+	go target(replicaConn)
+
+	done := make(chan forwardResult, 1)
+	go func() { done <- pipeTraffic(srcConn, srcConn, targetConn, targetConn, idleTimeout) }()
+
+	select {
+	case result := <-done:
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("pipeTraffic did not finish")
+		return forwardResult{}
+	}
+}
+
+func TestPipeTraffic(t *testing.T) {
+	tests := []struct {
+		name        string
+		idleTimeout time.Duration
+		client      func(conn *net.TCPConn)
+		target      func(conn *net.TCPConn)
+		reset       bool
+		timedOut    bool
+	}{
+		{
+			name: "closed normally",
+			client: func(conn *net.TCPConn) {
+				_, _ = conn.Write([]byte("ping"))
+				_ = conn.CloseWrite()
+				_, _ = io.Copy(io.Discard, conn)
+				_ = conn.Close()
+			},
+			target: func(conn *net.TCPConn) {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+			},
+		},
+		{
+			name: "target reset",
+			client: func(conn *net.TCPConn) {
+				_, _ = io.Copy(io.Discard, conn)
+				_ = conn.Close()
+			},
+			target: func(conn *net.TCPConn) {
+				_, _ = conn.Write([]byte("pong"))
+				time.Sleep(50 * time.Millisecond)
+				reset(conn)
+			},
+			reset: true,
+		},
+		{
+			// Failing to write to the client is the client's fault, so the replica must not be blamed
+			name: "client reset",
+			client: func(conn *net.TCPConn) {
+				reset(conn)
+			},
+			target: func(conn *net.TCPConn) {
+				go func() { _, _ = io.Copy(io.Discard, conn) }()
+				buffer := make([]byte, 1024)
+				for {
+					_, err := conn.Write(buffer)
+					if err != nil {
+						_ = conn.Close()
+						return
+					}
+				}
+			},
+			reset: false,
+		},
+		{
+			name:        "idle",
+			idleTimeout: 50 * time.Millisecond,
+			client: func(conn *net.TCPConn) {
+				_, _ = io.Copy(io.Discard, conn)
+				_ = conn.Close()
+			},
+			target: func(conn *net.TCPConn) {
+				_, _ = io.Copy(io.Discard, conn)
+				_ = conn.Close()
+			},
+			timedOut: true,
+		},
+		{
+			// The target never answers, but the client keeps sending for longer than the timeout
+			name:        "one direction active",
+			idleTimeout: 200 * time.Millisecond,
+			client: func(conn *net.TCPConn) {
+				for i := 0; i < 30; i++ {
+					_, _ = conn.Write([]byte("ping"))
+					time.Sleep(20 * time.Millisecond)
+				}
+				_ = conn.CloseWrite()
+				_, _ = io.Copy(io.Discard, conn)
+				_ = conn.Close()
+			},
+			target: func(conn *net.TCPConn) {
+				_, _ = io.Copy(io.Discard, conn)
+				_ = conn.Close()
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := pipe(t, test.idleTimeout, test.client, test.target)
+			if result.reset != test.reset {
+				t.Errorf("reset = %v, want %v", result.reset, test.reset)
+			}
+			if result.timedOut != test.timedOut {
+				t.Errorf("timedOut = %v, want %v", result.timedOut, test.timedOut)
+			}
+		})
+	}
+}
+
+func TestForwardResultReason(t *testing.T) {
+	tests := []struct {
+		result forwardResult
+		want   string
+	}{
+		{forwardResult{closedBy: common.ClosedByClient}, accesslog.ReasonClientClose},
+		{forwardResult{closedBy: common.ClosedByReplica}, accesslog.ReasonBackendClose},
+		{forwardResult{closedBy: common.ClosedByReplica, reset: true}, accesslog.ReasonBackendReset},
+		{forwardResult{closedBy: common.ClosedByClient, reset: true, timedOut: true}, accesslog.ReasonTimeout},
+	}
+
+	for _, test := range tests {
+		if got := test.result.reason(); got != test.want {
+			t.Errorf("reason of %+v = %s, want %s", test.result, got, test.want)
+		}
+	}
+}
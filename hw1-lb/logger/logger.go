@@ -0,0 +1,266 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fields represents the structured fields of a single log record
+type Fields map[string]interface{}
+
+// Predefined log levels
+const (
+	LevelDebug = 0
+	LevelInfo  = 1
+	LevelWarn  = 2
+	LevelError = 3
+)
+
+// Predefined output formats
+const (
+	FormatText   = 0
+	FormatJSON   = 1
+	FormatLogfmt = 2
+)
+
+var (
+	lock          sync.Mutex
+	output        io.Writer = os.Stderr
+	currentLevel            = LevelInfo
+	currentFormat           = FormatText
+	levelNames              = map[int]string{LevelDebug: "debug", LevelInfo: "info", LevelWarn: "warn", LevelError: "error"}
+	coloredLevels           = map[int]string{}
+)
+
+// Init sets the level and the output format of the logger
+// Levels are "debug", "info", "warn" and "error", formats are "text", "json" and "logfmt"
+func Init(levelName string, formatName string) error {
+	level, err := parseLevel(levelName)
+	if err != nil {
+		return err
+	}
+
+	format, err := ParseFormat(formatName)
+	if err != nil {
+		return err
+	}
+
+	lock.Lock()
+	currentLevel = level
+	currentFormat = format
+	coloredLevels = map[int]string{
+		LevelDebug: color.New(color.FgHiCyan).Sprint("[DEBUG]"),
+		LevelInfo:  color.New(color.FgHiGreen).Sprint("[INFO]"),
+		LevelWarn:  color.New(color.FgHiYellow).Sprint("[WARN]"),
+		LevelError: color.New(color.FgHiRed).Sprint("[ERROR]"),
+	}
+	lock.Unlock()
+
+	return nil
+}
+
+// parseLevel converts a level name into a level, empty name defaults to info
+func parseLevel(levelName string) (int, error) {
+	switch strings.ToLower(levelName) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, errors.New(fmt.Sprintf("unknown log level: %s", levelName))
+	}
+}
+
+// ParseFormat converts a format name into a format, empty name defaults to text
+func ParseFormat(formatName string) (int, error) {
+	switch strings.ToLower(formatName) {
+	case "", "text":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	case "logfmt":
+		return FormatLogfmt, nil
+	default:
+		return FormatText, errors.New(fmt.Sprintf("unknown log format: %s", formatName))
+	}
+}
+
+// Debug writes a debug record
+func Debug(msg string, fields Fields) {
+	write(LevelDebug, msg, fields)
+}
+
+// Info writes an info record
+func Info(msg string, fields Fields) {
+	write(LevelInfo, msg, fields)
+}
+
+// Warn writes a warning record
+func Warn(msg string, fields Fields) {
+	write(LevelWarn, msg, fields)
+}
+
+// Error writes an error record
+func Error(msg string, fields Fields) {
+	write(LevelError, msg, fields)
+}
+
+// Fatal writes an error record and exits the program
+func Fatal(msg string, fields Fields) {
+	write(LevelError, msg, fields)
+	os.Exit(1)
+}
+
+// write encodes a single record and writes it to the output
+func write(level int, msg string, fields Fields) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if level < currentLevel {
+		return
+	}
+
+	now := time.Now()
+	var line []byte
+	if currentFormat == FormatText {
+		// Keep the good old colored format for humans
+		levelName, ok := coloredLevels[level]
+		if !ok {
+			levelName = "[" + strings.ToUpper(levelNames[level]) + "]"
+		}
+
+		buf := bytes.Buffer{}
+		buf.WriteString(now.Format("2006/01/02 15:04:05 "))
+		buf.WriteString(levelName)
+		buf.WriteString(" ")
+		buf.WriteString(msg)
+		for _, key := range sortedKeys(fields) {
+			buf.WriteString(" ")
+			buf.WriteString(key)
+			buf.WriteString("=")
+			buf.WriteString(logfmtValue(fields[key]))
+		}
+		buf.WriteString("\n")
+		line = buf.Bytes()
+	} else {
+		// The time, level and message always come first
+		record := Fields{}
+		for key, value := range fields {
+			record[key] = value
+		}
+		record["time"] = now.Format(time.RFC3339Nano)
+		record["level"] = levelNames[level]
+		record["msg"] = msg
+		line = Encode(currentFormat, record, []string{"time", "level", "msg"})
+	}
+
+	_, _ = output.Write(line)
+}
+
+// Encode encodes a record as a single line in JSON or logfmt format, ending with a newline
+// The keys in order are written first, then the rest of the keys are written in alphabetical order
+// This is also meant for other structured outputs such as access logs
+func Encode(format int, record Fields, order []string) []byte {
+	// Figure out the order of the keys
+	keys := make([]string, 0, len(record))
+	seen := make(map[string]bool)
+	for _, key := range order {
+		if _, ok := record[key]; ok {
+			keys = append(keys, key)
+			seen[key] = true
+		}
+	}
+	for _, key := range sortedKeys(record) {
+		if !seen[key] {
+			keys = append(keys, key)
+		}
+	}
+
+	buf := bytes.Buffer{}
+	if format == FormatJSON {
+		buf.WriteString("{")
+		for i, key := range keys {
+			if i != 0 {
+				buf.WriteString(",")
+			}
+			keyJSON, _ := json.Marshal(key)
+			buf.Write(keyJSON)
+			buf.WriteString(":")
+			buf.Write(jsonValue(record[key]))
+		}
+		buf.WriteString("}\n")
+	} else {
+		for i, key := range keys {
+			if i != 0 {
+				buf.WriteString(" ")
+			}
+			buf.WriteString(key)
+			buf.WriteString("=")
+			buf.WriteString(logfmtValue(record[key]))
+		}
+		buf.WriteString("\n")
+	}
+
+	return buf.Bytes()
+}
+
+// sortedKeys returns the keys of fields in alphabetical order
+func sortedKeys(fields Fields) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// jsonValue encodes a single value as JSON, errors and durations are encoded as strings
+func jsonValue(value interface{}) []byte {
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case time.Duration:
+		value = v.String()
+	case fmt.Stringer:
+		value = v.String()
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprintf("%v", value))
+	}
+	return encoded
+}
+
+// logfmtValue encodes a single value in logfmt, the value gets quoted if it has spaces or quotes
+func logfmtValue(value interface{}) string {
+	var str string
+	switch v := value.(type) {
+	case string:
+		str = v
+	case error:
+		str = v.Error()
+	case nil:
+		str = ""
+	default:
+		str = fmt.Sprintf("%v", v)
+	}
+
+	if len(str) == 0 || strings.ContainsAny(str, " =\"\t\n") {
+		return fmt.Sprintf("%q", str)
+	}
+	return str
+}
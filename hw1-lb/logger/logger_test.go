@@ -0,0 +1,129 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		level int
+		ok    bool
+	}{
+		{"", LevelInfo, true},
+		{"debug", LevelDebug, true},
+		{"INFO", LevelInfo, true},
+		{"warning", LevelWarn, true},
+		{"error", LevelError, true},
+		{"verbose", LevelInfo, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			level, err := parseLevel(test.name)
+			if level != test.level || (err == nil) != test.ok {
+				t.Errorf("parseLevel = %d, %v, want %d", level, err, test.level)
+			}
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		format int
+		ok     bool
+	}{
+		{"", FormatText, true},
+		{"text", FormatText, true},
+		{"JSON", FormatJSON, true},
+		{"logfmt", FormatLogfmt, true},
+		{"xml", FormatText, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			format, err := ParseFormat(test.name)
+			if format != test.format || (err == nil) != test.ok {
+				t.Errorf("ParseFormat = %d, %v, want %d", format, err, test.format)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	record := Fields{
+		"msg":      "hello world",
+		"level":    "info",
+		"err":      errors.New("failed"),
+		"duration": 1500 * time.Millisecond,
+		"count":    3,
+		"empty":    "",
+		"quoted":   `a"b`,
+	}
+
+	tests := []struct {
+		name   string
+		format int
+		order  []string
+		want   string
+	}{
+		{"json", FormatJSON, []string{"level", "msg"},
+			`{"level":"info","msg":"hello world","count":3,"duration":"1.5s","empty":"","err":"failed","quoted":"a\"b"}`},
+		{"logfmt", FormatLogfmt, []string{"level", "msg"},
+			`level=info msg="hello world" count=3 duration=1.5s empty="" err=failed quoted="a\"b"`},
+		{"missing keys of the order are skipped", FormatLogfmt, []string{"time", "msg"},
+			`msg="hello world" count=3 duration=1.5s empty="" err=failed level=info quoted="a\"b"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := string(Encode(test.format, record, test.order)); got != test.want+"\n" {
+				t.Errorf("Encode = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+// TestWrite checks records below the level are dropped, and the rest are written in the format
+func TestWrite(t *testing.T) {
+	previous, level, format := output, currentLevel, currentFormat
+	defer func() { output, currentLevel, currentFormat = previous, level, format }()
+
+	tests := []struct {
+		level  string
+		format string
+		write  func(msg string, fields Fields)
+		want   string // Empty if the record is dropped
+	}{
+		{"info", "json", Debug, ""},
+		{"info", "json", Info, `"level":"info","msg":"hi","replica":"a"}`},
+		{"warn", "logfmt", Info, ""},
+		{"warn", "logfmt", Error, `level=error msg=hi replica=a`},
+		{"debug", "text", Debug, `[DEBUG]`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.level+" "+test.format, func(t *testing.T) {
+			if err := Init(test.level, test.format); err != nil {
+				t.Fatalf("could not init: %v", err)
+			}
+			buf := &bytes.Buffer{}
+			output = buf
+
+			test.write("hi", Fields{"replica": "a"})
+			got := buf.String()
+			if len(test.want) == 0 {
+				if len(got) != 0 {
+					t.Errorf("wrote %s, want dropped", got)
+				}
+			} else if !strings.Contains(got, test.want) || !strings.HasSuffix(got, "\n") {
+				t.Errorf("wrote %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -1,20 +1,38 @@
 package main
 
 import (
-	"lb/common"
+	"flag"
+	"lb/accesslog"
 	"lb/control"
+	"lb/logger"
 	"lb/misc"
-	"log"
+	"os"
 	"sync"
 )
 
 // The main entry of this program
 func main() {
+	// Parse log level and format, flags take precedence over $LOG_LEVEL and $LOG_FORMAT
+	logLevel := flag.String("log-level", os.Getenv("LOG_LEVEL"), "log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", os.Getenv("LOG_FORMAT"), "log format: text, json or logfmt")
+	flag.Parse()
+
 	// Print our load balancer's logo
 	misc.PrintLBLogo()
 
-	// Initialize colors
-	misc.InitColoredLogs()
+	// Initialize logger
+	err := logger.Init(*logLevel, *logFormat)
+	if err != nil {
+		logger.Fatal("Could not initialize logger", logger.Fields{"err": err})
+		return
+	}
+
+	// Set up the access log of proxied connections, this is disabled unless $ACCESS_LOG was set
+	err = accesslog.InitFromEnv()
+	if err != nil {
+		logger.Fatal("Could not initialize access log", logger.Fields{"err": err})
+		return
+	}
 
 	// Create a sync.WaitGroup for determining all goroutine's termiantion
 	var wg sync.WaitGroup
@@ -22,9 +40,9 @@ func main() {
 
 	// Start up our control server
 	controller := control.New()
-	err := controller.Run(&wg)
+	err = controller.Run(&wg)
 	if err != nil {
-		log.Fatalf("%s Could not run control server: %v", common.ColoredError, err)
+		logger.Fatal("Could not run control server", logger.Fields{"err": err})
 		return
 	}
 }
@@ -2,7 +2,6 @@ package misc
 
 import (
 	"fmt"
-	"github.com/fatih/color"
 	"lb/common"
 )
 
@@ -20,15 +19,6 @@ func PrintLBLogo() {
 	fmt.Println("               Simple Load Balancer - 32190984 Isu Kim")
 }
 
-// InitColoredLogs initializes colored log messages, WARN, ERROR, INFO
-func InitColoredLogs() {
-	common.ColoredWarn = color.New(color.FgHiYellow).Sprint("[WARN]")
-	common.ColoredError = color.New(color.FgHiRed).Sprint("[ERROR]")
-	common.ColoredInfo = color.New(color.FgHiGreen).Sprintf("[INFO]")
-	common.ColorCmdRegister = color.New(color.FgBlue).Sprintf("[REGISTER]")
-	common.ColorCmdUnregister = color.New(color.FgRed).Sprintf("[UNREGISTER]")
-}
-
 // AreMapsEqual checks if two maps are equal
 func AreMapsEqual(map1 map[string]string, map2 map[string]string) bool {
 	if len(map1) != len(map2) {
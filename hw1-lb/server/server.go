@@ -4,8 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"lb/common"
+	"lb/logger"
 	"lb/misc"
-	"log"
 	"net"
 	"os"
 	"os/signal"
@@ -101,8 +101,7 @@ func (s *Server) DoMainLoop(wg *sync.WaitGroup, handler ConnectionHandler) {
 		for {
 			select {
 			case <-s.stopper:
-				log.Printf("%s Server %s/%s:%d received interrupt",
-					common.ColoredInfo, misc.ConvertProtoToString(s.proto), s.address, s.port)
+				logger.Info("Server received interrupt", logger.Fields{"server": s.spec()})
 				return
 			default:
 				// Accept a new connection
@@ -115,16 +114,14 @@ func (s *Server) DoMainLoop(wg *sync.WaitGroup, handler ConnectionHandler) {
 					}
 
 					// Listener is closed or other non-temporary error
-					log.Printf("%s \"%s(%s/%s)\" Error accepting connection: %v",
-						common.ColoredWarn, s.alias, s.proto, s.address, err)
+					logger.Warn("Server could not accept connection",
+						logger.Fields{"server": s.spec(), "alias": s.alias, "err": err})
 					return
 				}
 
 				// Handle the connection in a new goroutine
-				/**
-				log.Printf("%s \"%s(%s/%s)\" Request from: %s",
-					common.ColoredInfo, s.alias, misc.ConvertProtoToString(s.proto), s.address, conn.RemoteAddr())
-				*/
+				logger.Debug("Server accepted connection",
+					logger.Fields{"server": s.spec(), "alias": s.alias, "client": conn.RemoteAddr().String()})
 				go handler(conn)
 			}
 		}
@@ -132,8 +129,7 @@ func (s *Server) DoMainLoop(wg *sync.WaitGroup, handler ConnectionHandler) {
 		for {
 			select {
 			case <-s.stopper:
-				log.Printf("%s Server %s/%s:%d received interrupt",
-					common.ColoredInfo, misc.ConvertProtoToString(s.proto), s.address, s.port)
+				logger.Info("Server received interrupt", logger.Fields{"server": s.spec()})
 				return
 			default:
 				if s.udpConn != nil {
@@ -153,3 +149,8 @@ func (s *Server) IsSpec(port int, proto uint8) bool {
 func (s *Server) GetInfo() string {
 	return fmt.Sprintf("%s:%d", s.address, s.port)
 }
+
+// spec returns a string describing the protocol and the listen address, ex) tcp/0.0.0.0:80
+func (s *Server) spec() string {
+	return fmt.Sprintf("%s/%s:%d", misc.ConvertProtoToString(s.proto), s.address, s.port)
+}
@@ -1,8 +1,11 @@
 #!/bin/bash
 
 if [ "$#" -lt 1 ]; then
-    echo "Usage: $0 start <no_replica> <sync_mode> | destroy | client"
+    echo "Usage: $0 start <no_replica> <sync_mode> | join | destroy | client"
     echo "Ex) start 3 remote-write"
+    echo "Ex) start 3 quorum"
+    echo "Ex) start 3 raft"
+    echo "Ex) join (adds one more replica to the running cluster, raise maxReplicas in config.json for local-write and quorum)"
     exit 1
 fi
 
@@ -16,8 +19,12 @@ if [ "$1" == "start" ]; then
       echo "Sync mode is local-write"
     elif [ "$sync_mode" == "remote-write" ]; then
       echo "Sync mode is remote-write"
+    elif [ "$sync_mode" == "quorum" ]; then
+      echo "Sync mode is quorum"
+    elif [ "$sync_mode" == "raft" ]; then
+      echo "Sync mode is raft"
     else
-      echo "Unknown sync mode: $sync_mode, available: local-write, remove-write"
+      echo "Unknown sync mode: $sync_mode, available: local-write, remove-write, quorum, raft"
       exit
     fi
 
@@ -43,7 +50,6 @@ if [ "$1" == "start" ]; then
     environment:
       - SEPH_DATA=/go/app/data
       - REPLICA_ID=replica-$i
-$(if [ "$i" -eq "1" ]; then echo "      - IS_REPLICA_0=\"TRUE\""; fi)
     container_name: replica-$i
     volumes:
       - ./compose/config:/go/app/config/
@@ -73,6 +79,33 @@ EOL
 
     docker-compose up -d --remove-orphans
     echo "Seph cluster created with $replicas replicas."
+elif [ "$1" == "join" ]; then
+    # New replicas are not in config.json, so they join the cluster through the replicas in it
+    i=$(grep -c "^  replica-" docker-compose.yml)
+    i=$((i + 1))
+    cat > ./compose/replica.yml <<EOL
+  replica-$i:
+    image: isukim/seph:latest
+    environment:
+      - SEPH_DATA=/go/app/data
+      - REPLICA_ID=replica-$i
+    container_name: replica-$i
+    volumes:
+      - ./compose/config:/go/app/config/
+      - ./compose/data${i}:/go/app/data/
+    command: ./seph ./config/config.json
+EOL
+
+    # Services must come before the client in docker-compose.yml
+    awk 'FNR == NR { block = block $0 "\n"; next } /^  seph-client:/ { printf "%s", block } { print }' \
+      ./compose/replica.yml docker-compose.yml > docker-compose.yml.tmp
+    mv docker-compose.yml.tmp docker-compose.yml
+    rm ./compose/replica.yml
+
+    rm -rf ./compose/data${i}
+    mkdir -p ./compose/data${i}
+    docker-compose up -d replica-$i
+    echo "replica-$i is joining the Seph cluster."
 elif [ "$1" == "destroy" ]; then
     docker-compose kill
     rm -rf ./compose/data*
@@ -0,0 +1,268 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"math/rand"
+	"net/http"
+	"os"
+	"seph/common"
+	"seph/ds"
+	"seph/logger"
+	"seph/metrics"
+	"seph/misc"
+	"strconv"
+	"time"
+)
+
+// Metrics of anti-entropy rounds
+const (
+	metricAntiEntropyRounds    = "seph_antientropy_rounds_total"
+	metricAntiEntropyDivergent = "seph_antientropy_divergent_notes"
+	metricAntiEntropyRepaired  = "seph_antientropy_repaired_total"
+	metricAntiEntropyErrors    = "seph_antientropy_errors_total"
+	metricTombstonesPurged     = "seph_tombstones_purged_total"
+)
+
+// divergence counts how the notes of this replica differ from the notes of a peer
+type divergence struct {
+	missing  int // The peer had notes which this replica did not have at all
+	outdated int // The peer had newer versions of notes
+	ahead    int // This replica had newer versions of notes, or notes the peer did not have
+	conflict int // Both had the same version number with different contents, written concurrently
+}
+
+// total returns the number of notes which differed
+func (d divergence) total() int {
+	return d.missing + d.outdated + d.ahead + d.conflict
+}
+
+// syncGetDigest is for [GET] /sync/digest API
+// This returns the versions of all notes in this replica, including the tombstones
+func (h *Handler) syncGetDigest(c *gin.Context) {
+	c.JSON(http.StatusOK, h.dsh.Digest())
+}
+
+// syncGetNote is for [GET] /sync/note/{0-9} API
+// This returns the note or its tombstone stored in this replica, or 404 if there was none
+func (h *Handler) syncGetNote(c *gin.Context) {
+	// Read ID param from API
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		errResponse := common.NoteErrorResponse{
+			Msg:    "wrong URI, ID was invalid",
+			Method: c.Request.Method,
+			Uri:    c.Request.RequestURI,
+			Body:   "",
+		}
+
+		c.JSON(http.StatusBadRequest, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceReplica).With("reply", errResponse))
+		return
+	}
+
+	err, note := h.dsh.ReadRaw(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "non existing ID"})
+		return
+	}
+
+	c.JSON(http.StatusOK, note)
+}
+
+// getMetrics is for [GET] /metrics API
+// This returns all metrics in Prometheus text format
+func (h *Handler) getMetrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4")
+	c.Status(http.StatusOK)
+	err := metrics.Write(c.Writer)
+	if err != nil {
+		logger.Warn("Error writing metrics", logger.Fields{"err": err})
+	}
+}
+
+// runAntiEntropy compares the notes of this replica against a random peer periodically,
+// and pulls every note the peer had a newer version of
+// Every replica pulls from its peers, so the replicas converge even when writes went missing
+// This function is blocking function
+func (h *Handler) runAntiEntropy() {
+	metrics.Register(metricAntiEntropyRounds, metrics.TypeCounter, "Number of anti-entropy rounds")
+	metrics.Register(metricAntiEntropyDivergent, metrics.TypeGauge, "Number of notes which differed from the peer in the last round")
+	metrics.Register(metricAntiEntropyRepaired, metrics.TypeCounter, "Number of notes pulled from peers by anti-entropy")
+	metrics.Register(metricAntiEntropyErrors, metrics.TypeCounter, "Number of failed anti-entropy rounds")
+	metrics.Register(metricTombstonesPurged, metrics.TypeCounter, "Number of purged tombstones of deleted notes")
+
+	if h.antiEntropyInterval <= 0 {
+		logger.Info("Anti-entropy is disabled", logger.Fields{})
+		return
+	}
+
+	logger.Info("Now starting anti-entropy",
+		logger.Fields{"interval": h.antiEntropyInterval, "tombstone_ttl": h.tombstoneTTL})
+	ticker := time.NewTicker(h.antiEntropyInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		peer := h.randomPeer()
+		if len(peer) != 0 {
+			err := h.antiEntropyRound(peer)
+			if err != nil {
+				metrics.Add(metricAntiEntropyErrors, 1)
+				logger.Warn("Anti-entropy round failed", logger.Fields{"peer": peer, "err": err})
+			}
+
+			// Members and primaries of the notes converge along with the notes themselves
+			err = h.pullMembership(peer)
+			if err != nil {
+				logger.Warn("Could not pull members from peer", logger.Fields{"peer": peer, "err": err})
+			}
+			if h.syncMode == misc.SyncLocalWrite {
+				err = h.pullPrimaries(peer)
+				if err != nil {
+					logger.Warn("Could not pull primaries from peer", logger.Fields{"peer": peer, "err": err})
+				}
+			}
+		}
+
+		// Tombstones only need to live until every replica learned about the deletion
+		// Replicas down for longer than that are not readmitted, see checkDowntime
+		h.markAlive()
+		purged := h.dsh.PurgeTombstones(time.Now().Add(-h.tombstoneTTL))
+		if purged != 0 {
+			metrics.Add(metricTombstonesPurged, float64(purged))
+			logger.Info("Purged tombstones of deleted notes", logger.Fields{"purged": purged})
+		}
+	}
+}
+
+// checkDowntime refuses to readmit this replica if it was down for longer than the tombstone TTL
+// Peers purged the tombstones of the notes deleted meanwhile, so the copies of those notes in this replica
+// would look like notes the peers were missing, and anti-entropy would bring them back
+// Tombstones are only purged by anti-entropy, and notes in memory are gone anyway after a restart
+func (h *Handler) checkDowntime() error {
+	if h.antiEntropyInterval <= 0 || h.storage == misc.StorageMemory {
+		return nil
+	}
+
+	dir := os.Getenv("SEPH_DATA")
+	err, lastAlive, ok := ds.LoadLastAlive(dir)
+	if err != nil {
+		return err
+	}
+	if downtime := time.Since(lastAlive); ok && downtime > h.tombstoneTTL {
+		msg := fmt.Sprintf("replica was down for %v, longer than the tombstone TTL %v, "+
+			"remove the data directory to rejoin with the notes of the peers", downtime.Round(time.Second), h.tombstoneTTL)
+		return errors.New(msg)
+	}
+
+	h.markAlive()
+	return nil
+}
+
+// markAlive saves the current time as the time this replica was seen alive last
+func (h *Handler) markAlive() {
+	if h.storage == misc.StorageMemory {
+		return
+	}
+
+	err := ds.SaveLastAlive(os.Getenv("SEPH_DATA"), time.Now())
+	if err != nil {
+		logger.Warn("Could not save the time this replica was alive", logger.Fields{"err": err})
+	}
+}
+
+// randomPeer returns a random replica other than this replica, or empty string if there was none
+func (h *Handler) randomPeer() string {
+	peers := h.peers()
+	if len(peers) == 0 {
+		return ""
+	}
+	return peers[rand.Intn(len(peers))]
+}
+
+// antiEntropyRound compares the digest of this replica against the peer, then pulls the notes the peer had newer
+func (h *Handler) antiEntropyRound(peer string) error {
+	metrics.Add(metricAntiEntropyRounds, 1)
+
+	err, peerDigest := h.client.FetchDigest(peer)
+	if err != nil {
+		return err
+	}
+
+	local := make(map[int]common.NoteDigest)
+	for _, digest := range h.dsh.Digest() {
+		local[digest.Id] = digest
+	}
+
+	// Find out which notes differ, only the notes the peer had newer are pulled
+	// The peer will pull the notes this replica had newer in its own round
+	var diff divergence
+	pulls := make([]int, 0)
+	for _, remote := range peerDigest {
+		mine, ok := local[remote.Id]
+		delete(local, remote.Id)
+
+		if !ok {
+			diff.missing++
+			pulls = append(pulls, remote.Id)
+		} else if remote.Version > mine.Version {
+			diff.outdated++
+			pulls = append(pulls, remote.Id)
+		} else if remote.Version < mine.Version {
+			diff.ahead++
+		} else if remote.Hash != mine.Hash {
+			// Either might have won the tie, WriteNoteIfNewer keeps the winner on both sides
+			diff.conflict++
+			pulls = append(pulls, remote.Id)
+		}
+	}
+	diff.ahead += len(local)
+	metrics.Set(metricAntiEntropyDivergent, float64(diff.total()))
+
+	fields := logger.Fields{
+		"peer":     peer,
+		"missing":  diff.missing,
+		"outdated": diff.outdated,
+		"ahead":    diff.ahead,
+		"conflict": diff.conflict,
+	}
+	if diff.total() == 0 {
+		logger.Debug("Replica is in sync with peer", fields)
+		return nil
+	}
+	logger.Info("Replica diverged from peer", fields)
+
+	// Pull the notes one by one, a single failure should not stop repairing the rest
+	repaired := 0
+	failed := 0
+	for _, id := range pulls {
+		err, note, found := h.client.FetchNote(peer, id)
+		if err == nil && !found {
+			err = fmt.Errorf("note %d was gone from %s", id, peer)
+		}
+		if err != nil {
+			logger.Warn("Could not pull note from peer", logger.Fields{"peer": peer, "note_id": id, "err": err})
+			failed++
+			continue
+		}
+
+		// The note might have been written meanwhile, then the newer one just wins
+		err, written := h.dsh.WriteNoteIfNewer(note)
+		if err != nil {
+			logger.Debug("Skipped pulled note", logger.Fields{"peer": peer, "note_id": id, "err": err})
+			continue
+		}
+		if written {
+			repaired++
+		}
+	}
+	metrics.Add(metricAntiEntropyRepaired, float64(repaired))
+
+	logger.Info("Repaired notes from peer", logger.Fields{"peer": peer, "repaired": repaired, "failed": failed})
+	if failed != 0 {
+		msg := fmt.Sprintf("could not pull %d out of %d notes", failed, len(pulls))
+		return errors.New(msg)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"seph/common"
+	"seph/ds"
+	"seph/logger"
+	"seph/misc"
+	"strconv"
+)
+
+// syncGetChanges is for [GET] /sync/changes?epoch={epoch}&since={0-9} API
+// This returns the changes in the replication log after the sequence number, deletions included
+// If the log does not have the changes anymore, this returns 410 and the peer should pull a snapshot instead
+func (h *Handler) syncGetChanges(c *gin.Context) {
+	since, err := strconv.ParseUint(c.Query("since"), 10, 64)
+	if err != nil {
+		errResponse := common.NoteErrorResponse{
+			Msg:    "wrong URI, since was invalid",
+			Method: c.Request.Method,
+			Uri:    c.Request.RequestURI,
+			Body:   "",
+		}
+
+		c.JSON(http.StatusBadRequest, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceReplica).With("reply", errResponse))
+		return
+	}
+
+	err, changes := h.dsh.Changes(c.Query("epoch"), since)
+	if errors.Is(err, ds.ErrChangesUnavailable) {
+		c.JSON(http.StatusGone, gin.H{"msg": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, changes)
+}
+
+// syncGetSnapshot is for [GET] /sync/snapshot API
+// This returns all notes including the tombstones, along with the position in the replication log as headers
+func (h *Handler) syncGetSnapshot(c *gin.Context) {
+	// The position must be taken first, so that no change after it is missed
+	epoch, seq := h.dsh.LogPosition()
+	c.Header(ds.HeaderEpoch, epoch)
+	c.Header(ds.HeaderSeq, strconv.FormatUint(seq, 10))
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	err := h.dsh.WriteSnapshot(c.Writer)
+	if err != nil {
+		logger.Warn("Error writing snapshot", logger.Fields{"err": err})
+	}
+}
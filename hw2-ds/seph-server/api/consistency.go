@@ -0,0 +1,275 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"seph/common"
+	"seph/ds"
+	"seph/logger"
+	"seph/misc"
+	"strconv"
+	"strings"
+)
+
+// Headers for picking the read consistency, and for the session token of the client
+// The consistency level can be picked by the consistency query parameter as well, which takes precedence
+const (
+	headerConsistency = "Seph-Consistency"
+	headerSession     = "Seph-Session"
+)
+
+// Read consistency levels
+// Strong reads the newest version from the replica which takes the writes of the note, or from the read quorum
+// Session reads locally unless this replica is older than what the client has already seen, then reads strongly
+// Eventual reads locally, which might be stale
+const (
+	consistencyStrong   = "strong"
+	consistencySession  = "session"
+	consistencyEventual = "eventual"
+)
+
+// sessionMaxNotes is the number of notes a session token remembers, the notes seen longest ago are forgotten first
+const sessionMaxNotes = 64
+
+// errUnknownConsistency is returned when the client asked for a consistency level which does not exist
+var errUnknownConsistency = errors.New("unknown consistency level, supported levels: strong, session or eventual")
+
+// readConsistency returns the consistency level the client asked for, or the default one of the sync mode
+func (h *Handler) readConsistency(c *gin.Context) (error, string) {
+	level := c.Query("consistency")
+	if len(level) == 0 {
+		level = c.GetHeader(headerConsistency)
+	}
+	level = strings.ToLower(strings.TrimSpace(level))
+
+	switch level {
+	case "":
+		// Quorum mode always read from the read quorum, the other modes from the local storage
+		if h.syncMode == misc.SyncQuorum {
+			return nil, consistencyStrong
+		}
+		return nil, consistencyEventual
+	case consistencyStrong, consistencySession, consistencyEventual:
+		return nil, level
+	}
+	return fmt.Errorf("%w: %s", errUnknownConsistency, level), ""
+}
+
+// sessionEntry is the version of a single note a client has seen
+type sessionEntry struct {
+	id      int
+	version int64
+}
+
+// session is the versions of the notes a client has seen, ordered from the one seen longest ago
+// This is sent as the session token, which is a comma separated list of id:version pairs, ex) 3:2,7:1
+type session []sessionEntry
+
+// parseSession parses the session token, malformed entries are ignored
+func parseSession(token string) session {
+	var s session
+	for _, field := range strings.Split(token, ",") {
+		parts := strings.SplitN(strings.TrimSpace(field), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		id, err := strconv.Atoi(parts[0])
+		if err != nil {
+			continue
+		}
+		version, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		s = s.with(id, version)
+	}
+	return s
+}
+
+// version returns the version of the note the client has seen, false if the client has not seen the note
+func (s session) version(id int) (int64, bool) {
+	for _, entry := range s {
+		if entry.id == id {
+			return entry.version, true
+		}
+	}
+	return 0, false
+}
+
+// with returns the session after the client saw the version of the note, older versions are never taken
+func (s session) with(id int, version int64) session {
+	next := make(session, 0, len(s)+1)
+	for _, entry := range s {
+		if entry.id != id {
+			next = append(next, entry)
+		} else if entry.version > version {
+			version = entry.version
+		}
+	}
+
+	next = append(next, sessionEntry{id: id, version: version})
+	if len(next) > sessionMaxNotes {
+		next = next[len(next)-sessionMaxNotes:]
+	}
+	return next
+}
+
+// String returns the session token
+func (s session) String() string {
+	fields := make([]string, 0, len(s))
+	for _, entry := range s {
+		fields = append(fields, fmt.Sprintf("%d:%d", entry.id, entry.version))
+	}
+	return strings.Join(fields, ",")
+}
+
+// setSessionHeader replies the session token of the client, after the client saw the version of the note
+func setSessionHeader(c *gin.Context, id int, version int64) {
+	c.Header(headerSession, parseSession(c.GetHeader(headerSession)).with(id, version).String())
+}
+
+// writer returns the replica which takes the writes of the note, or empty if there is none
+// In local-write mode this is the primary of the note, in remote-write mode the leader
+func (h *Handler) writer(id int) string {
+	switch h.syncMode {
+	case misc.SyncLocalWrite:
+		return h.dsh.Owner(id).Primary
+	case misc.SyncRemoteWrite:
+		_, leader := h.currentLeader()
+		return leader
+	}
+	return ""
+}
+
+// readLocal reads the note from the local storage, deleted notes are not found
+func (h *Handler) readLocal(id int) (error, common.Note) {
+	err, note := h.dsh.ReadSpecific(id)
+	if err != nil {
+		return errNoteNotFound, common.Note{}
+	}
+	return nil, note
+}
+
+// strongRead reads the newest version of the note from the replica which takes its writes
+// If there is no such replica or it was unreachable, the newest version among the read quorum is read instead
+func (h *Handler) strongRead(id int) (error, common.Note) {
+	writer := h.writer(id)
+	if len(writer) != 0 && isSelf(writer) {
+		return h.readLocal(id)
+	}
+
+	if len(writer) != 0 {
+		err, note, found := h.client.FetchNote(writer, id)
+		if err == nil {
+			if !found || note.Deleted {
+				return errNoteNotFound, common.Note{}
+			}
+			return nil, note
+		}
+		logger.Warn("Could not read note from its writer, reading from the read quorum instead",
+			logger.Fields{"note_id": id, "writer": writer, "err": err})
+	}
+
+	return h.quorumRead(id)
+}
+
+// strongReadPage reads a page of the newest versions of the notes
+// In remote-write mode the page is read from the leader, otherwise it is merged from the pages of the read quorum,
+// since each note has its own primary
+func (h *Handler) strongReadPage(query ds.NoteQuery) (error, ds.NotePage) {
+	if h.syncMode == misc.SyncRemoteWrite {
+		_, leader := h.currentLeader()
+		if len(leader) != 0 && isSelf(leader) {
+			return h.dsh.ReadPage(query)
+		}
+
+		if len(leader) != 0 {
+			err, notes, more := h.client.FetchPage(leader, pageQueryOf(query))
+			if err == nil {
+				return nil, ds.NotePage{Notes: notes, More: more}
+			}
+			logger.Warn("Could not read page from the leader, reading from the read quorum instead",
+				logger.Fields{"leader": leader, "err": err})
+		}
+	}
+
+	return h.quorumReadPage(query)
+}
+
+// caughtUp returns if this replica has every version of the notes the client has seen
+// Notes whose tombstones were purged are missing on every replica, so they count as caught up
+func (h *Handler) caughtUp(s session) bool {
+	for _, entry := range s {
+		err, note := h.dsh.ReadRaw(entry.id)
+		if errors.Is(err, ds.ErrNotFound) && h.dsh.Purged(entry.id) {
+			continue
+		} else if err != nil || note.Version < entry.version {
+			return false
+		}
+	}
+	return true
+}
+
+// readNote reads the note at the consistency level, and returns the level the note was served at
+// Session reads are served strongly when this replica has not caught up with the client yet
+// In raft mode only the leader serves reads, so every read is strong
+func (h *Handler) readNote(c *gin.Context, id int, level string) (error, string, common.Note) {
+	if h.syncMode == misc.SyncRaft {
+		err, note := h.readLocal(id)
+		return err, consistencyStrong, note
+	}
+
+	switch level {
+	case consistencySession:
+		s := parseSession(c.GetHeader(headerSession))
+		if version, seen := s.version(id); !seen || h.caughtUp(session{{id: id, version: version}}) {
+			err, note := h.readLocal(id)
+			return err, consistencySession, note
+		}
+		logger.Debug("Replica is behind the session, reading strongly", logger.Fields{"note_id": id})
+		fallthrough
+	case consistencyStrong:
+		err, note := h.strongRead(id)
+		return err, consistencyStrong, note
+	}
+
+	err, note := h.readLocal(id)
+	return err, consistencyEventual, note
+}
+
+// readNotes reads a page of the notes at the consistency level, and returns the level the notes were served at
+// Local pages are read from the storage as they are scanned, strong pages are read from the writer or the read quorum
+func (h *Handler) readNotes(c *gin.Context, level string, query ds.NoteQuery) (error, string, ds.NotePage) {
+	if h.syncMode == misc.SyncRaft {
+		err, page := h.dsh.ReadPage(query)
+		return err, consistencyStrong, page
+	}
+
+	switch level {
+	case consistencySession:
+		if h.caughtUp(parseSession(c.GetHeader(headerSession))) {
+			err, page := h.dsh.ReadPage(query)
+			return err, consistencySession, page
+		}
+		logger.Debug("Replica is behind the session, reading strongly", nil)
+		fallthrough
+	case consistencyStrong:
+		err, page := h.strongReadPage(query)
+		return err, consistencyStrong, page
+	}
+
+	err, page := h.dsh.ReadPage(query)
+	return err, consistencyEventual, page
+}
+
+// setDeletedSession replies the session token of the client after it deleted the note
+// The version of the tombstone is taken from the local storage, if this replica has it
+func (h *Handler) setDeletedSession(c *gin.Context, id int) {
+	err, note := h.dsh.ReadRaw(id)
+	if err != nil {
+		return
+	}
+	setSessionHeader(c, id, note.Version)
+}
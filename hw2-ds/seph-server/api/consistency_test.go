@@ -0,0 +1,117 @@
+package api
+
+import (
+	"fmt"
+	"seph/common"
+	"seph/ds"
+	"seph/misc"
+	"testing"
+	"time"
+)
+
+func TestParseSession(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"empty", "", ""},
+		{"single", "3:2", "3:2"},
+		{"several", "3:2,7:1", "3:2,7:1"},
+		{"spaces", " 3:2 , 7:1 ", "3:2,7:1"},
+		{"malformed entries are ignored", "3:2,x:1,7,8:y,:,9:1", "3:2,9:1"},
+		{"repeated note keeps the newest version", "3:5,7:1,3:2", "7:1,3:5"},
+		{"negative ID", "-1:2", "-1:2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := parseSession(test.token).String(); got != test.want {
+				t.Errorf("parseSession(%q) = %q, want %q", test.token, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSessionWith(t *testing.T) {
+	s := parseSession("3:2,7:1")
+
+	tests := []struct {
+		name    string
+		id      int
+		version int64
+		want    string
+	}{
+		{"new note", 9, 1, "3:2,7:1,9:1"},
+		{"newer version moves to the end", 3, 4, "7:1,3:4"},
+		{"older version is never taken", 3, 1, "7:1,3:2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := s.with(test.id, test.version).String(); got != test.want {
+				t.Errorf("with(%d, %d) = %q, want %q", test.id, test.version, got, test.want)
+			}
+		})
+	}
+
+	// The notes seen longest ago are forgotten first
+	var full session
+	for id := 0; id < sessionMaxNotes+5; id++ {
+		full = full.with(id, 1)
+	}
+	if len(full) != sessionMaxNotes || full[0].id != 5 {
+		t.Errorf("session kept %d notes from %d, want %d notes from 5", len(full), full[0].id, sessionMaxNotes)
+	}
+	if version, ok := full.version(5); !ok || version != 1 {
+		t.Errorf("version(5) = %d, %v, want 1", version, ok)
+	}
+	if _, ok := full.version(4); ok {
+		t.Error("version(4) was still kept")
+	}
+}
+
+func TestCaughtUp(t *testing.T) {
+	err, dsh := ds.New(misc.StorageMemory, t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("could not open storage: %v", err)
+	}
+	h := &Handler{dsh: dsh}
+
+	now := time.Now().UTC()
+	writes := []common.Note{
+		{Id: 1, Title: "a", Version: 2, LastModified: now},
+		{Id: 2, Version: 3, LastModified: now.Add(-time.Hour), Deleted: true},
+		{Id: 4, Title: "b", Version: 1, LastModified: now},
+	}
+	for _, note := range writes {
+		if err, _ := dsh.WriteNoteIfNewer(note); err != nil {
+			t.Fatalf("could not write note: %v", err)
+		}
+	}
+	if purged := dsh.PurgeTombstones(now.Add(-time.Minute)); purged != 1 {
+		t.Fatalf("purged %d tombstones, want 1", purged)
+	}
+
+	tests := []struct {
+		token    string
+		caughtUp bool
+	}{
+		{"", true},
+		{"1:2,4:1", true},
+		{"1:3", false},
+		{"2:3", true}, // Purged tombstone
+		{"0:1", true}, // Below the purge watermark, deleted long ago
+		{"3:1", false},
+		{"5:1", false},
+		{"2:3,5:1", false},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%q", test.token), func(t *testing.T) {
+			if got := h.caughtUp(parseSession(test.token)); got != test.caughtUp {
+				t.Errorf("caughtUp = %v, want %v", got, test.caughtUp)
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+package api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"seph/common"
+	"seph/logger"
+	"seph/misc"
+	"seph/replication"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers which tell other replicas about the leader
+const (
+	headerLeader = replication.HeaderLeader
+	headerTerm   = replication.HeaderTerm
+)
+
+// errNoLeader is returned when a write arrived while there was no leader to handle it
+var errNoLeader = errors.New("no leader elected yet")
+
+// errNotLeader is returned when this replica was asked to act as the leader, but it was not
+var errNotLeader = errors.New("this replica is not the leader")
+
+// election keeps the state of the leader election in remote-write mode
+// This is the bully algorithm: the alive replica which comes first in the members always becomes the leader
+// Every new election increases the term, so that replicas can tell the stale leaders from the current one
+type election struct {
+	lock      sync.Mutex
+	self      string
+	term      uint64
+	leader    string
+	lastHeard time.Time
+	electing  bool
+}
+
+// newElection creates the election state of this replica, nobody is the leader until the first election
+func newElection(self string) *election {
+	return &election{lock: sync.Mutex{}, self: self, lastHeard: time.Now()}
+}
+
+// current returns the current term and leader
+func (e *election) current() (uint64, string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.term, e.leader
+}
+
+// isLeader returns if this replica is the leader
+func (e *election) isLeader() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return len(e.self) != 0 && e.leader == e.self
+}
+
+// observe learns the leader of the term from another replica, terms older than the current one are ignored
+// This returns true if the leader was accepted
+func (e *election) observe(term uint64, leader string) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if term < e.term {
+		return false
+	}
+
+	if term > e.term || e.leader != leader {
+		if e.leader == e.self && leader != e.self {
+			logger.Warn("Stepping down, another replica leads a newer term",
+				logger.Fields{"term": term, "leader": leader})
+		} else {
+			logger.Info("Following new leader", logger.Fields{"term": term, "leader": leader})
+		}
+	}
+
+	e.term = term
+	e.leader = leader
+	e.lastHeard = time.Now()
+	return true
+}
+
+// initElection registers the election routes and starts the election loop, only remote-write mode elects a leader
+func (h *Handler) initElection() {
+	h.election = newElection(h.self())
+	if h.priority() < 0 {
+		logger.Warn("This replica is not a member yet, it will not become the leader until it joins", nil)
+	}
+
+	h.engine.GET("/election/leader", h.electionGetLeader)
+	h.engine.POST("/election/elect", h.electionElect)
+	h.engine.POST("/election/heartbeat", h.electionHeartbeat)
+}
+
+// electionGetLeader is for [GET] /election/leader API
+// This returns the current term and leader, the leader is empty while nobody was elected
+func (h *Handler) electionGetLeader(c *gin.Context) {
+	term, leader := h.election.current()
+	c.JSON(http.StatusOK, common.ElectionMessage{Term: term, Replica: leader})
+}
+
+// electionElect is for [POST] /election/elect API
+// A replica which comes later in the replicas is starting an election, so this replica answers that it is alive
+// and starts its own election to bully the candidate
+func (h *Handler) electionElect(c *gin.Context) {
+	var candidate common.ElectionMessage
+	err := c.ShouldBindJSON(&candidate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+		return
+	}
+
+	logger.Debug("Received election", logger.Fields{"term": candidate.Term, "candidate": candidate.Replica})
+	go h.startElection(candidate.Term)
+
+	term, leader := h.election.current()
+	c.JSON(http.StatusOK, common.ElectionMessage{Term: term, Replica: leader})
+}
+
+// electionHeartbeat is for [POST] /election/heartbeat API
+// Heartbeats of stale leaders are rejected with 409 and the current leader, so that they step down
+func (h *Handler) electionHeartbeat(c *gin.Context) {
+	var heartbeat common.ElectionMessage
+	err := c.ShouldBindJSON(&heartbeat)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+		return
+	}
+
+	accepted := h.election.observe(heartbeat.Term, heartbeat.Replica)
+	term, leader := h.election.current()
+	if !accepted {
+		c.JSON(http.StatusConflict, common.ElectionMessage{Term: term, Replica: leader})
+		return
+	}
+
+	// A replica which comes first in the replicas takes the leadership back
+	priority := h.priority()
+	if priority >= 0 && priority < h.replicaIndex(heartbeat.Replica) {
+		go h.startElection(heartbeat.Term)
+	}
+
+	c.JSON(http.StatusOK, common.ElectionMessage{Term: term, Replica: leader})
+}
+
+// runElection sends heartbeats while this replica is the leader, and starts an election when the leader went silent
+// This function is blocking function
+func (h *Handler) runElection() {
+	logger.Info("Now starting leader election",
+		logger.Fields{"heartbeat_interval": h.heartbeatInterval, "election_timeout": h.electionTimeout})
+	ticker := time.NewTicker(h.heartbeatInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if h.election.isLeader() {
+			h.sendHeartbeats()
+			continue
+		}
+
+		h.election.lock.Lock()
+		silent := time.Since(h.election.lastHeard) > h.electionTimeout
+		h.election.lock.Unlock()
+
+		if silent && h.priority() >= 0 {
+			_, leader := h.election.current()
+			logger.Warn("Leader went silent, starting election", logger.Fields{"leader": leader})
+			go h.startElection(0)
+		}
+	}
+}
+
+// startElection asks every replica which comes first in the replicas if it is alive
+// If none of them answered, this replica catches up with its peers and becomes the leader of a new term
+// Otherwise, one of them will become the leader and tell us by its heartbeats
+func (h *Handler) startElection(seenTerm uint64) {
+	// The storage might not be ready yet, then the election loop will start an election later
+	e := h.election
+	replicas := h.replicas()
+	priority := h.priority()
+	e.lock.Lock()
+	if e.electing || priority < 0 || h.dsh == nil {
+		e.lock.Unlock()
+		return
+	}
+	e.electing = true
+	if seenTerm > e.term {
+		e.term = seenTerm
+	}
+	e.term++
+	term := e.term
+	e.lock.Unlock()
+
+	defer func() {
+		e.lock.Lock()
+		e.electing = false
+		e.lock.Unlock()
+	}()
+
+	// Ask all replicas with higher priority at once, a single one alive is enough to back off
+	alive := make(chan bool, priority)
+	for _, replica := range replicas[:priority] {
+		go func(replica string) {
+			err, _ := h.client.SendElection(replica, "elect", common.ElectionMessage{Term: term, Replica: e.self})
+			alive <- err == nil
+		}(replica)
+	}
+	for i := 0; i < priority; i++ {
+		if <-alive {
+			logger.Debug("Replica with higher priority is alive, backing off", logger.Fields{"term": term})
+			e.lock.Lock()
+			e.lastHeard = time.Now()
+			e.lock.Unlock()
+			return
+		}
+	}
+
+	// The new leader must not miss the writes the old leader made
+	err := h.dsh.Init(h.peers())
+	if err != nil {
+		logger.Warn("Could not catch up with peers before leading", logger.Fields{"err": err})
+	}
+
+	e.lock.Lock()
+	if e.term != term {
+		// Someone else started a newer term meanwhile
+		e.lock.Unlock()
+		return
+	}
+	e.leader = e.self
+	e.lastHeard = time.Now()
+	e.lock.Unlock()
+
+	logger.Info("Became the leader", logger.Fields{"term": term})
+	h.sendHeartbeats()
+}
+
+// sendHeartbeats tells every peer that this replica is the leader
+// If a peer knew a newer term, this replica steps down
+func (h *Handler) sendHeartbeats() {
+	term, leader := h.election.current()
+	if leader != h.election.self {
+		return
+	}
+
+	for _, peer := range h.peers() {
+		go func(peer string) {
+			err, reply := h.client.SendElection(peer, "heartbeat", common.ElectionMessage{Term: term, Replica: leader})
+			if err != nil {
+				logger.Debug("Could not send heartbeat", logger.Fields{"peer": peer, "err": err})
+				return
+			}
+
+			if reply.Term > term {
+				h.election.observe(reply.Term, reply.Replica)
+			}
+		}(peer)
+	}
+}
+
+// replicaIndex returns the index of the replica in the members, or the number of members if it was unknown
+func (h *Handler) replicaIndex(replica string) int {
+	replicas := h.replicas()
+	for i, r := range replicas {
+		if r == replica {
+			return i
+		}
+	}
+	return len(replicas)
+}
+
+// priority returns the index of this replica in the members, lower wins elections
+// This returns -1 if this replica was not a member, then it never becomes the leader
+func (h *Handler) priority() int {
+	for i, replica := range h.replicas() {
+		if isSelf(replica) {
+			return i
+		}
+	}
+	return -1
+}
+
+// currentLeader returns the leader for forwarding writes to, or errNoLeader
+func (h *Handler) currentLeader() (error, string) {
+	_, leader := h.election.current()
+	if len(leader) == 0 {
+		return errNoLeader, ""
+	}
+	return nil, leader
+}
+
+// rejectNotLeader replies 421 along with the current leader, so that the sender retries against the leader
+// This returns true if the request was rejected, because this replica was not the leader
+func (h *Handler) rejectNotLeader(c *gin.Context) bool {
+	if h.election.isLeader() {
+		return false
+	}
+
+	term, leader := h.election.current()
+	c.Header(headerLeader, leader)
+	c.Header(headerTerm, strconv.FormatUint(term, 10))
+	c.JSON(http.StatusMisdirectedRequest, gin.H{"msg": errNotLeader.Error(), "leader": leader})
+	logger.Warn("Reply", requestFields(c, misc.SourceReplica).With("leader", leader))
+	return true
+}
+
+// rejectStaleLeader replies 421 if the request came from a leader of an older term
+// This returns true if the request was rejected
+func (h *Handler) rejectStaleLeader(c *gin.Context) bool {
+	term, err := strconv.ParseUint(c.GetHeader(headerTerm), 10, 64)
+	if err != nil {
+		return false // Requests without terms are not from leaders
+	}
+
+	if h.election.observe(term, c.GetHeader(headerLeader)) {
+		return false
+	}
+
+	currentTerm, leader := h.election.current()
+	c.Header(headerLeader, leader)
+	c.Header(headerTerm, strconv.FormatUint(currentTerm, 10))
+	c.JSON(http.StatusMisdirectedRequest, gin.H{"msg": "stale leader", "leader": leader})
+	logger.Warn("Reply", requestFields(c, misc.SourceReplica).With("term", term).With("current_term", currentTerm))
+	return true
+}
+
+// observeMisdirected learns the leader a replica told along with a 421 reply, and returns the leader to retry against
+func (h *Handler) observeMisdirected(leader replication.Leader) (error, string) {
+	if len(leader.Replica) == 0 {
+		return errNoLeader, ""
+	}
+
+	h.election.observe(leader.Term, leader.Replica)
+	return nil, leader.Replica
+}
+
+// asLeader returns the leader of the current term, which requests to backups are marked as sent by
+func (h *Handler) asLeader() replication.Leader {
+	term, leader := h.election.current()
+	return replication.Leader{Term: term, Replica: leader}
+}
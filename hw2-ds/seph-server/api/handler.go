@@ -3,48 +3,140 @@ package api
 import (
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"log"
+	"github.com/hashicorp/raft"
 	"os"
 	"seph/ds"
+	"seph/logger"
 	"seph/misc"
+	"seph/replication"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Handler represents a single API handler
 type Handler struct {
-	engine     *gin.Engine
-	addr       string
-	port       int
-	syncMode   int
-	dsh        *ds.Handler
-	replicas   []string
-	primaryMap map[int]string
+	engine      *gin.Engine
+	addr        string
+	port        int
+	syncMode    int
+	dsh         *ds.Handler
+	client      *replication.Client
+	seeds       []string
+	members     *memberList
+	maxReplicas int
+	writeQuorum int
+	readQuorum  int
+	storage     string
+
+	antiEntropyInterval time.Duration
+	tombstoneTTL        time.Duration
+
+	election          *election
+	notesLock         sync.Mutex
+	notesInFlight     map[int]*noteLock // Notes the leader is writing
+	heartbeatInterval time.Duration
+	electionTimeout   time.Duration
+
+	raft                  atomic.Pointer[raft.Raft] // Set once raft started, handlers may run before that
+	raftLock              sync.Mutex
+	raftPortOffset        int
+	raftSnapshotThreshold int
+
+	internalPortOffset int
+	pipeline           *pipeline
+
+	primaryLock   sync.Mutex
+	primaryPolicy string
+
+	health      *failureDetector
+	hintMaxAge  time.Duration
+	hintMaxSize int
+
+	idempotencyTTL time.Duration
+	keysLock       sync.Mutex
+	keysInFlight   map[string]bool
+
+	pageLimit    int
+	pageMaxLimit int
 }
 
-// New creates a new API handler
-func New(addr string, port int, sync string, replicas []string) *Handler {
+// New creates a new API handler from the config
+func New(addr string, config misc.Config) *Handler {
 	// Create a new gin engine and init API routes
-	engine := gin.Default()
+	// Requests are logged by our own logger, so that they are structured as well
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	engine.Use(requestLogger(), gin.Recovery())
 
 	// Parse sync type
 	var syncMode int
-	if strings.Contains(sync, "local-write") {
+	if strings.Contains(config.Sync, "local-write") {
 		syncMode = misc.SyncLocalWrite
-	} else if strings.Contains(sync, "remote-write") {
+	} else if strings.Contains(config.Sync, "remote-write") {
 		syncMode = misc.SyncRemoteWrite
+	} else if strings.Contains(config.Sync, "quorum") {
+		syncMode = misc.SyncQuorum
+	} else if strings.Contains(config.Sync, "raft") {
+		syncMode = misc.SyncRaft
 	}
 
 	// Create handler and init routes
+	replicaTimeout := time.Duration(config.ReplicaTimeout) * time.Millisecond
 	h := Handler{
-		engine:     engine,
-		addr:       addr,
-		port:       port,
-		syncMode:   syncMode,
-		dsh:        nil,
-		replicas:   replicas,
-		primaryMap: map[int]string{},
+		engine:      engine,
+		addr:        addr,
+		port:        config.ServicePort,
+		syncMode:    syncMode,
+		dsh:         nil,
+		client:      replication.New(replicaTimeout, config.ReplicaRetries, replicaBackoff, config.InternalPortOffset),
+		seeds:       config.Replicas,
+		maxReplicas: config.MaxReplicas,
+		writeQuorum: config.WriteQuorum,
+		readQuorum:  config.ReadQuorum,
+		storage:     config.Storage,
+
+		antiEntropyInterval: time.Duration(config.AntiEntropyInterval) * time.Second,
+		tombstoneTTL:        time.Duration(config.TombstoneTTL) * time.Second,
+
+		heartbeatInterval: time.Duration(config.HeartbeatInterval) * time.Millisecond,
+		electionTimeout:   time.Duration(config.ElectionTimeout) * time.Millisecond,
+		notesInFlight:     make(map[int]*noteLock),
+
+		raftPortOffset:        config.RaftPortOffset,
+		raftSnapshotThreshold: config.RaftSnapshotThreshold,
+
+		internalPortOffset: config.InternalPortOffset,
+
+		primaryPolicy: config.PrimaryPolicy,
+
+		hintMaxAge:  time.Duration(config.HintMaxAge) * time.Second,
+		hintMaxSize: config.HintMaxSize,
+
+		idempotencyTTL: time.Duration(config.IdempotencyTTL) * time.Second,
+		keysInFlight:   make(map[string]bool),
+
+		pageLimit:    config.PageLimit,
+		pageMaxLimit: config.PageMaxLimit,
+	}
+
+	// Only primaries send their writes to backups, negative batch sizes send every write on its own
+	if (syncMode == misc.SyncLocalWrite || syncMode == misc.SyncRemoteWrite) && config.ReplicationBatchSize > 0 {
+		h.pipeline = newPipeline(config.ReplicationBatchSize, config.ReplicationPipeline, h.backupBatch)
+	}
+
+	// Raft detects failures by itself
+	if syncMode != misc.SyncRaft {
+		h.health = newFailureDetector(time.Duration(config.HealthInterval)*time.Millisecond, config.PhiThreshold)
 	}
+
+	// Members might have changed since the config was written, so the members seen last take precedence
+	err, members := newMemberList(os.Getenv("SEPH_DATA"), config.Replicas)
+	if err != nil {
+		logger.Fatal("Could not load members", logger.Fields{"dir": os.Getenv("SEPH_DATA"), "err": err})
+	}
+	h.members = members
 	h.initRoutes()
 
 	return &h
@@ -53,29 +145,65 @@ func New(addr string, port int, sync string, replicas []string) *Handler {
 // initRoutes initializes all routes
 func (h *Handler) initRoutes() {
 	// All APIs for /note
-	h.engine.GET("/note", h.getNoteAll)
-	h.engine.GET("/note/:id", h.getNoteSpecific)
-	h.engine.POST("/note", h.postNote)
-	h.engine.PUT("/note/:id", h.putNoteSpecific)
-	h.engine.PATCH("/note/:id", h.patchNoteSpecific)
-	h.engine.DELETE("/note/:id", h.deleteNoteSpecific)
+	// In raft mode only the leader handles them, the other replicas forward them to the leader
+	// Retries of writes with the same idempotency key are answered by the result of the first write
+	notes := h.engine.Group("/note")
+	if h.syncMode == misc.SyncRaft {
+		notes.Use(h.raftRedirect())
+	}
+	notes.Use(h.idempotency())
+	notes.GET("", h.getNoteAll)
+	notes.GET("/:id", h.getNoteSpecific)
+	notes.POST("", h.postNote)
+	notes.PUT("/:id", h.putNoteSpecific)
+	notes.PATCH("/:id", h.patchNoteSpecific)
+	notes.DELETE("/:id", h.deleteNoteSpecific)
+
+	// All APIs for replicas comparing their notes, and for metrics
+	h.engine.GET("/sync/digest", h.syncGetDigest)
+	h.engine.GET("/sync/note/:id", h.syncGetNote)
+	h.engine.GET("/sync/changes", h.syncGetChanges)
+	h.engine.GET("/sync/snapshot", h.syncGetSnapshot)
+	h.engine.GET("/metrics", h.getMetrics)
+	h.engine.GET("/health", h.getHealth)
+
+	// All APIs for members of the cluster, Raft keeps its own members
+	if h.syncMode != misc.SyncRaft {
+		h.engine.GET("/members", h.membersGet)
+		h.engine.PUT("/members", h.membersPut)
+		h.engine.POST("/members", h.membersJoin)
+		h.engine.DELETE("/members/:id", h.membersLeave)
+	}
 
 	// Init routes accordingly
 	if h.syncMode == 1 { // local-write
+		h.engine.GET("/primary", h.localGetPrimaryAll)
 		h.engine.GET("/primary/:id", h.localGetPrimarySpecific)
+		h.engine.PUT("/primary/:id", h.localPutPrimarySpecific)
 		h.engine.POST("/backup", h.localUpdateBackup)
 		h.engine.PUT("/backup", h.localUpdateBackup)
 		h.engine.PATCH("/backup", h.localUpdateBackup)
 		h.engine.DELETE("/backup/:id", h.localDeleteBackup)
 	} else if h.syncMode == 2 {
-		h.engine.POST("/primary", h.remoteForwardPrimary)
-		h.engine.PUT("/primary", h.remoteForwardPrimary)
-		h.engine.PATCH("/primary", h.remoteForwardPrimary)
-		h.engine.DELETE("/primary/:id", h.remoteDeletePrimary)
+		primary := h.engine.Group("/primary", h.idempotency())
+		primary.POST("", h.remoteForwardPrimary)
+		primary.PUT("", h.remoteForwardPrimary)
+		primary.PATCH("/:id", h.remoteForwardPrimary)
+		primary.DELETE("/:id", h.remoteDeletePrimary)
 		h.engine.POST("/backup", h.remoteUpdateBackup)
 		h.engine.PUT("/backup", h.remoteUpdateBackup)
 		h.engine.PATCH("/backup", h.remoteUpdateBackup)
 		h.engine.DELETE("/backup/:id", h.remoteDeleteBackup)
+		h.initElection()
+	} else if h.syncMode == misc.SyncQuorum {
+		h.engine.GET("/quorum", h.quorumGetAll)
+		h.engine.GET("/quorum/:id", h.quorumGetSpecific)
+		h.engine.PUT("/quorum", h.quorumUpdate)
+	} else if h.syncMode == misc.SyncRaft {
+		h.engine.GET("/raft/status", h.raftGetStatus)
+		members := h.engine.Group("/raft/members", h.raftRedirect())
+		members.POST("", h.raftAddMember)
+		members.DELETE("/:id", h.raftRemoveMember)
 	} // I am just too lazy to consider edge cases :b
 }
 
@@ -83,35 +211,103 @@ func (h *Handler) initRoutes() {
 // This function is blocking function
 func (h *Handler) Run() error {
 	addr := fmt.Sprintf("%s:%d", h.addr, h.port)
-	log.Printf("Now starting API server in %s", addr)
+	logger.Info("Now starting API server", logger.Fields{"addr": addr})
 
 	// Fire up distributed storage handler
-	// We will do 5 times of init processes
+	// We will do 5 times of init processes, then start with the local notes only
 	go func() {
 		failCount := 0
-		h.dsh = ds.New(os.Getenv("SEPH_DATA"), h.replicas)
-		for {
-			err := h.dsh.Init()
+		err, dsh := ds.New(h.storage, os.Getenv("SEPH_DATA"), h.replicas())
+		if err != nil {
+			logger.Fatal("Could not open local storage",
+				logger.Fields{"storage": h.storage, "dir": os.Getenv("SEPH_DATA"), "err": err})
+		}
+
+		// Raft brings back replicas of any downtime by its own log, the others must not bring back purged deletions
+		if h.syncMode != misc.SyncRaft {
+			err = h.checkDowntime()
 			if err != nil {
-				if failCount < 5 {
-					failCount++
-					log.Printf("[WARN] Sync with replicas[0] failed: %v (%d/%d)", err, failCount, 5)
-					time.Sleep(1 * time.Second)
-					continue
-				} else {
-					log.Fatalf("[ERROR] Could not sync with replicas[0], max retry reached")
-				}
-			} else {
-				return
+				logger.Fatal("Could not readmit replica", logger.Fields{"dir": os.Getenv("SEPH_DATA"), "err": err})
 			}
 		}
+
+		// Replicas which are not in the config join the cluster first, they have no slot for allocating IDs until then
+		if _, ok := h.selfMember(); !ok && h.syncMode != misc.SyncRaft {
+			h.joinCluster()
+		}
+		h.dsh = dsh
+		h.dsh.SetHintLimits(h.hintMaxAge, h.hintMaxSize)
+		h.dsh.SetWriter(h.self())
+		h.dsh.SetClient(h.client)
+		h.setIDStripe()
+
+		// Raft brings every replica up to date by itself
+		if h.syncMode == misc.SyncRaft {
+			err = h.startRaft()
+			if err != nil {
+				logger.Fatal("Could not start raft", logger.Fields{"err": err})
+			}
+			go h.runRaftPurge()
+			return
+		}
+
+		// Peers replicate to this replica over the internal protocol once its notes are loaded
+		go h.runInternal()
+		h.initMembership()
+		for {
+			err := h.dsh.Init(h.peers())
+			if err != nil && failCount < 5 {
+				failCount++
+				logger.Warn("Catching up with peers failed",
+					logger.Fields{"failures": failCount, "max_failures": 5, "err": err})
+				time.Sleep(1 * time.Second)
+				continue
+			} else if err != nil {
+				// Anti-entropy will pull the missed writes once the peers are back
+				logger.Warn("Could not catch up with any of the peers, starting with local notes only", nil)
+			}
+
+			// Primaries of the notes might have moved while this replica was down
+			if h.syncMode == misc.SyncLocalWrite {
+				h.initPrimaries()
+			}
+
+			go h.runHealth()
+			go h.runAntiEntropy()
+			if h.syncMode == misc.SyncRemoteWrite {
+				go h.runElection()
+			}
+			if h.syncMode == misc.SyncRemoteWrite || h.syncMode == misc.SyncLocalWrite {
+				go h.runHintedHandoff()
+			}
+			return
+		}
 	}()
 
 	err := h.engine.Run(addr)
 	if err != nil {
-		log.Fatalf("Could not start API server in %s: %v", addr, err)
+		logger.Fatal("Could not start API server", logger.Fields{"addr": addr, "err": err})
 		return err
 	}
 
 	return nil
 }
+
+// setIDStripe decides which IDs this replica may allocate for new notes
+// In remote-write and raft mode only the leader allocates IDs, otherwise every replica allocates IDs at once,
+// so each replica takes every N-th ID starting from its slot, where N is the number of members the cluster can grow to
+func (h *Handler) setIDStripe() {
+	if h.syncMode == misc.SyncRemoteWrite || h.syncMode == misc.SyncRaft {
+		return
+	}
+
+	member, ok := h.selfMember()
+	if ok {
+		h.dsh.SetIDStripe(h.maxReplicas, member.Slot)
+		logger.Debug("Allocating IDs in stripe", logger.Fields{"stride": h.maxReplicas, "offset": member.Slot})
+		return
+	}
+
+	logger.Warn("Could not find $REPLICA_ID in members, new note IDs might collide with other replicas",
+		logger.Fields{"replica_id": os.Getenv("REPLICA_ID")})
+}
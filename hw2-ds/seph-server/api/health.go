@@ -0,0 +1,265 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"math"
+	"net/http"
+	"seph/common"
+	"seph/logger"
+	"seph/metrics"
+	"sort"
+	"sync"
+	"time"
+)
+
+// States of peers, as the failure detector sees them
+const (
+	peerUp      = "up"
+	peerSuspect = "suspect"
+	peerDown    = "down"
+)
+
+// Metrics of the failure detector
+const (
+	metricPeersUp          = "seph_peers_up"
+	metricPeersSuspect     = "seph_peers_suspect"
+	metricPeersDown        = "seph_peers_down"
+	metricPeerStateChanges = "seph_peer_state_changes_total"
+)
+
+// healthWindow is the number of intervals between heartbeats kept for each peer
+const healthWindow = 100
+
+// maxPhi caps the suspicion level, so that it stays a finite number in JSON
+const maxPhi = 100
+
+// errPeerDown is returned instead of sending a request to a peer the failure detector regards as down
+var errPeerDown = errors.New("peer is down")
+
+// peerHealth keeps the heartbeats of a single peer
+type peerHealth struct {
+	lastHeard time.Time
+	heard     bool      // False until the first heartbeat, lastHeard is when the peer became known till then
+	intervals []float64 // Milliseconds between the heartbeats while the peer was not down
+	state     string
+}
+
+// failureDetector is a phi accrual failure detector, every replica probes /health of its peers periodically
+// Phi tells how unlikely it is that the next heartbeat of a peer is still on its way, given the intervals so far
+// Peers are suspected once phi reached half of the threshold, and regarded as down once it reached the threshold
+type failureDetector struct {
+	lock      sync.Mutex
+	interval  time.Duration
+	threshold float64
+	peers     map[string]*peerHealth
+}
+
+// newFailureDetector creates a failure detector probing every interval
+// A probe which took longer than the interval counts as a missed heartbeat
+func newFailureDetector(interval time.Duration, threshold float64) *failureDetector {
+	return &failureDetector{
+		lock:      sync.Mutex{},
+		interval:  interval,
+		threshold: threshold,
+		peers:     make(map[string]*peerHealth),
+	}
+}
+
+// peer returns the heartbeats of the peer, peers never probed before start as heard now, the lock must be held
+func (d *failureDetector) peer(replica string) *peerHealth {
+	p, ok := d.peers[replica]
+	if !ok {
+		p = &peerHealth{lastHeard: time.Now(), intervals: make([]float64, 0, healthWindow), state: peerUp}
+		d.peers[replica] = p
+	}
+	return p
+}
+
+// heartbeat records that the peer answered at the given time
+func (d *failureDetector) heartbeat(replica string, at time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	// The silence of a down peer says nothing about its usual intervals
+	p := d.peer(replica)
+	if p.heard && p.state != peerDown {
+		p.intervals = append(p.intervals, float64(at.Sub(p.lastHeard).Milliseconds()))
+		if len(p.intervals) > healthWindow {
+			p.intervals = p.intervals[1:]
+		}
+	}
+	p.lastHeard = at
+	p.heard = true
+}
+
+// phi returns the suspicion level of the peer at the given time, the lock must be held
+// This uses the logistic approximation of the normal distribution, the same as Akka and Cassandra do
+func (d *failureDetector) phi(p *peerHealth, now time.Time) float64 {
+	mean := float64(d.interval.Milliseconds())
+	if len(p.intervals) != 0 {
+		sum := 0.0
+		for _, interval := range p.intervals {
+			sum += interval
+		}
+		mean = sum / float64(len(p.intervals))
+	}
+
+	variance := 0.0
+	for _, interval := range p.intervals {
+		variance += (interval - mean) * (interval - mean)
+	}
+	if len(p.intervals) != 0 {
+		variance /= float64(len(p.intervals))
+	}
+
+	// Probes on a quiet network arrive like clockwork, a tiny deviation must not make every delay look fatal
+	stdDev := math.Sqrt(variance)
+	if minStdDev := float64(d.interval.Milliseconds()) / 4; stdDev < minStdDev {
+		stdDev = minStdDev
+	}
+
+	elapsed := float64(now.Sub(p.lastHeard).Milliseconds())
+	y := (elapsed - mean) / stdDev
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+
+	var phi float64
+	if elapsed > mean {
+		phi = -math.Log10(e / (1 + e))
+	} else {
+		phi = -math.Log10(1 - 1/(1+e))
+	}
+
+	if math.IsInf(phi, 0) || math.IsNaN(phi) || phi > maxPhi {
+		return maxPhi
+	}
+	return phi
+}
+
+// update recomputes the states of the peers, and forgets the replicas which are not peers anymore
+func (d *failureDetector) update(replicas []string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := time.Now()
+	known := make(map[string]bool)
+	counts := map[string]int{peerUp: 0, peerSuspect: 0, peerDown: 0}
+	for _, replica := range replicas {
+		known[replica] = true
+		p := d.peer(replica)
+		phi := d.phi(p, now)
+
+		state := peerUp
+		if phi >= d.threshold {
+			state = peerDown
+		} else if phi >= d.threshold/2 {
+			state = peerSuspect
+		}
+		counts[state]++
+
+		if state != p.state {
+			fields := logger.Fields{"peer": replica, "old_state": p.state, "state": state, "phi": fmt.Sprintf("%.2f", phi)}
+			if state == peerUp {
+				logger.Info("Peer is up again", fields)
+			} else {
+				logger.Warn("Peer state changed", fields)
+			}
+			metrics.Add(metricPeerStateChanges, 1)
+			p.state = state
+		}
+	}
+
+	for replica := range d.peers {
+		if !known[replica] {
+			delete(d.peers, replica)
+		}
+	}
+
+	metrics.Set(metricPeersUp, float64(counts[peerUp]))
+	metrics.Set(metricPeersSuspect, float64(counts[peerSuspect]))
+	metrics.Set(metricPeersDown, float64(counts[peerDown]))
+}
+
+// state returns the state of the peer, peers which were never probed are regarded as up
+func (d *failureDetector) state(replica string) string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	p, ok := d.peers[replica]
+	if !ok {
+		return peerUp
+	}
+	return p.state
+}
+
+// status returns the health of all peers sorted by their addresses
+func (d *failureDetector) status() []common.PeerHealth {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := time.Now()
+	peers := make([]common.PeerHealth, 0, len(d.peers))
+	for replica, p := range d.peers {
+		peers = append(peers, common.PeerHealth{
+			Replica:   replica,
+			State:     p.state,
+			Phi:       math.Round(d.phi(p, now)*100) / 100,
+			LastHeard: p.lastHeard.UTC(),
+		})
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Replica < peers[j].Replica })
+	return peers
+}
+
+// peerDown returns if the failure detector regards the replica as down
+// Requests to down replicas fail right away instead of waiting for their timeouts
+func (h *Handler) peerDown(replica string) bool {
+	return h.health != nil && h.health.state(replica) == peerDown
+}
+
+// getHealth is for [GET] /health API
+// This replica is up as long as it answers, the reply shows how this replica sees its peers as well
+func (h *Handler) getHealth(c *gin.Context) {
+	response := common.HealthResponse{Replica: h.self(), State: peerUp, Peers: []common.PeerHealth{}}
+	if h.health != nil {
+		response.Peers = h.health.status()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// runHealth probes /health of every peer periodically, and updates the states of the peers
+// This function is blocking function
+func (h *Handler) runHealth() {
+	metrics.Register(metricPeersUp, metrics.TypeGauge, "Number of peers which are up")
+	metrics.Register(metricPeersSuspect, metrics.TypeGauge, "Number of peers which are suspected to be down")
+	metrics.Register(metricPeersDown, metrics.TypeGauge, "Number of peers which are down")
+	metrics.Register(metricPeerStateChanges, metrics.TypeCounter, "Number of times peers changed their states")
+
+	logger.Info("Now starting failure detector",
+		logger.Fields{"interval": h.health.interval, "phi_threshold": h.health.threshold})
+	ticker := time.NewTicker(h.health.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		peers := h.peers()
+
+		// Probe all peers at once, a hung peer must not delay the heartbeats of the others
+		var wg sync.WaitGroup
+		for _, peer := range peers {
+			wg.Add(1)
+			go func(peer string) {
+				defer wg.Done()
+				err := h.client.Probe(peer, h.health.interval)
+				if err != nil {
+					logger.Debug("Health probe failed", logger.Fields{"peer": peer, "err": err})
+					return
+				}
+				h.health.heartbeat(peer, time.Now())
+			}(peer)
+		}
+		wg.Wait()
+
+		h.health.update(peers)
+	}
+}
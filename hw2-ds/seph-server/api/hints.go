@@ -0,0 +1,151 @@
+package api
+
+import (
+	"errors"
+	"seph/common"
+	"seph/ds"
+	"seph/logger"
+	"seph/metrics"
+	"seph/misc"
+	"seph/replication"
+	"time"
+)
+
+// Metrics of hinted handoff
+const (
+	metricHintsPending  = "seph_hints_pending"
+	metricHintsBytes    = "seph_hints_bytes"
+	metricHintsStored   = "seph_hints_stored_total"
+	metricHintsReplayed = "seph_hints_replayed_total"
+	metricHintsDropped  = "seph_hints_dropped_total"
+)
+
+// shouldHint returns if the write to the replica must be kept as a hint instead of being sent
+// Writes to a replica with hints are hinted as well, so that the replica receives the writes in order
+func (h *Handler) shouldHint(replica string) bool {
+	return h.peerDown(replica) || h.dsh.HasHints(replica)
+}
+
+// hintWrite keeps the write meant for the replica, so that it is replayed once the replica is back
+// If the hint could not be kept, the replica only catches up by anti-entropy
+func (h *Handler) hintWrite(replica string, method string, note common.Note, owner *common.Ownership, write *common.IdempotentWrite) {
+	hint := common.Hint{Replica: replica, Method: method, Note: note, Owner: owner, Idempotency: write}
+	err := h.dsh.AddHint(hint)
+	if err != nil {
+		if errors.Is(err, ds.ErrHintsFull) {
+			metrics.Add(metricHintsDropped, 1)
+		}
+		logger.Warn("Could not keep hint for replica", logger.Fields{"replica": replica, "note_id": note.Id, "err": err})
+		return
+	}
+
+	metrics.Add(metricHintsStored, 1)
+	logger.Debug("Kept hint for replica", logger.Fields{"replica": replica, "method": method, "note_id": note.Id})
+}
+
+// replayHints sends the hints for the replica in order, and drops the ones the replica received
+// Replaying stops at the first hint the replica did not answer, the rest are tried again later
+func (h *Handler) replayHints(replica string) {
+	hints := h.dsh.Hints(replica)
+	if len(hints) == 0 {
+		return
+	}
+
+	// Only the leader sends writes to backups, the new leader brings the replica up to date instead
+	if h.syncMode == misc.SyncRemoteWrite && !h.election.isLeader() {
+		logger.Info("Dropping hints, this replica is not the leader anymore", logger.Fields{"replica": replica, "hints": len(hints)})
+		metrics.Add(metricHintsDropped, float64(len(hints)))
+		err := h.dsh.DropHints(replica, hints[len(hints)-1].Seq)
+		if err != nil {
+			logger.Warn("Could not drop hints", logger.Fields{"replica": replica, "err": err})
+		}
+		return
+	}
+
+	logger.Info("Replaying hints to replica", logger.Fields{"replica": replica, "hints": len(hints)})
+	var delivered uint64
+	replayed := 0
+	for _, hint := range hints {
+		ack := h.backup(replica, replication.Mutation{Method: hint.Method, Note: hint.Note, Owner: hint.Owner, Idempotency: hint.Idempotency})
+		if replication.IsUnreachable(ack.Err) {
+			logger.Warn("Replica did not take hint, trying again later", logger.Fields{"replica": replica, "seq": hint.Seq, "err": ack.Err})
+			break
+		}
+
+		// The replica already has a newer version, or a newer leader took over, so the hint is not needed anymore
+		if ack.Err != nil {
+			logger.Warn("Replica refused hint, dropping it",
+				logger.Fields{"replica": replica, "seq": hint.Seq, "note_id": hint.Note.Id, "err": ack.Err})
+			metrics.Add(metricHintsDropped, 1)
+		} else {
+			replayed++
+			if hint.Owner != nil && ack.Primary != *hint.Owner {
+				_, _, _ = h.dsh.SetOwner(ack.Primary)
+			}
+		}
+		delivered = hint.Seq
+	}
+
+	if delivered == 0 {
+		return
+	}
+	metrics.Add(metricHintsReplayed, float64(replayed))
+	err := h.dsh.DropHints(replica, delivered)
+	if err != nil {
+		logger.Warn("Could not drop replayed hints", logger.Fields{"replica": replica, "err": err})
+		return
+	}
+	logger.Info("Replayed hints to replica", logger.Fields{"replica": replica, "replayed": replayed})
+}
+
+// runHintedHandoff replays the hints for every replica which is up, as often as the peers are probed
+// Hints older than the age limit, and hints for replicas which left the cluster are dropped
+// This function is blocking function
+func (h *Handler) runHintedHandoff() {
+	metrics.Register(metricHintsPending, metrics.TypeGauge, "Number of writes kept for unavailable replicas")
+	metrics.Register(metricHintsBytes, metrics.TypeGauge, "Bytes of writes kept for unavailable replicas")
+	metrics.Register(metricHintsStored, metrics.TypeCounter, "Number of writes kept for unavailable replicas so far")
+	metrics.Register(metricHintsReplayed, metrics.TypeCounter, "Number of kept writes replayed to replicas")
+	metrics.Register(metricHintsDropped, metrics.TypeCounter, "Number of writes for unavailable replicas which were dropped")
+
+	logger.Info("Now starting hinted handoff",
+		logger.Fields{"interval": h.health.interval, "max_age": h.hintMaxAge, "max_size": h.hintMaxSize})
+	ticker := time.NewTicker(h.health.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err, expired := h.dsh.ExpireHints()
+		if err != nil {
+			logger.Warn("Could not expire hints", logger.Fields{"err": err})
+		} else if expired != 0 {
+			metrics.Add(metricHintsDropped, float64(expired))
+			logger.Warn("Dropped expired hints", logger.Fields{"expired": expired})
+		}
+
+		members := make(map[string]bool)
+		for _, peer := range h.peers() {
+			members[peer] = true
+		}
+
+		for _, replica := range h.dsh.HintedReplicas() {
+			if !members[replica] {
+				hints := h.dsh.Hints(replica)
+				logger.Info("Dropping hints for replica which left", logger.Fields{"replica": replica, "hints": len(hints)})
+				metrics.Add(metricHintsDropped, float64(len(hints)))
+				err = h.dsh.DropHints(replica, hints[len(hints)-1].Seq)
+				if err != nil {
+					logger.Warn("Could not drop hints", logger.Fields{"replica": replica, "err": err})
+				}
+				continue
+			}
+
+			if !h.peerDown(replica) {
+				h.replayHints(replica)
+			}
+		}
+
+		count, size := h.dsh.HintStats()
+		metrics.Set(metricHintsPending, float64(count))
+		metrics.Set(metricHintsBytes, float64(size))
+	}
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"seph/common"
+	"seph/logger"
+	"time"
+)
+
+// Headers of writes made with idempotency keys
+// Replicas forwarding a write send the fingerprint of the client's request along, since they forward another body
+const (
+	headerIdempotencyKey         = "Idempotency-Key"
+	headerIdempotencyFingerprint = "Seph-Idempotency-Fingerprint"
+	headerIdempotentReplayed     = "Idempotent-Replayed"
+)
+
+// idempotencyKeyMaxLength is the longest idempotency key accepted
+const idempotencyKeyMaxLength = 255
+
+// contextIdempotency is the key of the idempotency request in the context of a write
+const contextIdempotency = "idempotency"
+
+// idempotencyRequest is the idempotency key of a write along with the fingerprint of the request
+type idempotencyRequest struct {
+	key         string
+	fingerprint string
+	expires     time.Time
+}
+
+// idempotencyOf returns the idempotency request of the write, nil if the client made the write without a key
+func idempotencyOf(c *gin.Context) *idempotencyRequest {
+	value, ok := c.Get(contextIdempotency)
+	if !ok {
+		return nil
+	}
+	return value.(*idempotencyRequest)
+}
+
+// write returns the write made with the idempotency key, which is remembered by every replica applying it
+// This returns nil if there was no idempotency key
+func (r *idempotencyRequest) write(method string, note common.Note) *common.IdempotentWrite {
+	if r == nil {
+		return nil
+	}
+	return &common.IdempotentWrite{Key: r.key, Fingerprint: r.fingerprint, Method: method, Note: note, Expires: r.expires}
+}
+
+// setHeaders sets the headers of the idempotency key for forwarding the write to another replica
+func (r *idempotencyRequest) setHeaders(header http.Header) {
+	if r == nil {
+		return
+	}
+	header.Set(headerIdempotencyKey, r.key)
+	header.Set(headerIdempotencyFingerprint, r.fingerprint)
+}
+
+// fingerprint returns the fingerprint of the request, the body is read and put back for the handler
+func fingerprint(c *gin.Context) (error, string) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return err, ""
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	hash := sha256.New()
+	hash.Write([]byte(c.Request.Method + "\n" + c.Request.URL.Path + "\n"))
+	hash.Write(body)
+	return nil, hex.EncodeToString(hash.Sum(nil))
+}
+
+// idempotency answers retries of writes made with the same idempotency key by the result of the first write
+// Writes are remembered by every replica applying them, so retries are answered by any replica
+// Only succeeded writes are remembered, failed writes are written again on retries
+// A key reused for another request is rejected with 422, and a retry while the key is in progress with 409
+func (h *Handler) idempotency() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(headerIdempotencyKey)
+		if len(key) == 0 || c.Request.Method == http.MethodGet {
+			c.Next()
+			return
+		} else if len(key) > idempotencyKeyMaxLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"msg": "idempotency key was too long"})
+			return
+		}
+
+		// Forwarded writes keep the fingerprint of the client's request
+		err, digest := fingerprint(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+			return
+		}
+		if forwarded := c.GetHeader(headerIdempotencyFingerprint); len(forwarded) != 0 && len(c.GetHeader(headerForwarded)) != 0 {
+			digest = forwarded
+		}
+
+		if !h.claimKey(key) {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"msg": "a write with the same idempotency key is in progress"})
+			return
+		}
+		defer h.releaseKey(key)
+
+		write, ok := h.dsh.RecallWrite(key)
+		if ok && write.Fingerprint != digest {
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"msg": "idempotency key was used for another request"})
+			return
+		} else if ok {
+			logger.Info("Replaying write of idempotency key", logger.Fields{"key": key, "note_id": write.Note.Id})
+			replayWrite(c, write)
+			c.Abort()
+			return
+		}
+
+		c.Set(contextIdempotency, &idempotencyRequest{key: key, fingerprint: digest, expires: time.Now().Add(h.idempotencyTTL)})
+		c.Next()
+	}
+}
+
+// replayWrite replies the result of the write made with the idempotency key, as the first write replied it
+func replayWrite(c *gin.Context, write common.IdempotentWrite) {
+	c.Header(headerIdempotentReplayed, "true")
+	if write.Method == http.MethodDelete {
+		c.JSON(http.StatusOK, gin.H{"msg": "OK"})
+		return
+	}
+
+	setNoteHeaders(c, write.Note)
+	c.JSON(http.StatusOK, write.Note)
+}
+
+// claimKey marks the idempotency key as in progress, false if it already was
+func (h *Handler) claimKey(key string) bool {
+	h.keysLock.Lock()
+	defer h.keysLock.Unlock()
+
+	if h.keysInFlight[key] {
+		return false
+	}
+	h.keysInFlight[key] = true
+	return true
+}
+
+// releaseKey marks the idempotency key as no longer in progress
+func (h *Handler) releaseKey(key string) {
+	h.keysLock.Lock()
+	defer h.keysLock.Unlock()
+
+	delete(h.keysInFlight, key)
+}
+
+// rememberWrite remembers the write made with an idempotency key, nil writes are ignored
+// Failing to remember only means that a retry writes again, so the write itself still succeeds
+func (h *Handler) rememberWrite(write *common.IdempotentWrite) {
+	if write == nil {
+		return
+	}
+
+	err := h.dsh.RememberWrite(*write)
+	if err != nil {
+		logger.Warn("Could not remember idempotency key", logger.Fields{"key": write.Key, "note_id": write.Note.Id, "err": err})
+	}
+}
@@ -0,0 +1,285 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+	"seph/ds"
+	"seph/logger"
+	"seph/misc"
+	"seph/replication"
+	"seph/replication/replicationpb"
+)
+
+// internalBatchSize is the number of notes or digests sent in each message of a stream
+const internalBatchSize = 256
+
+// errWrongMode is returned for calls the sync mode of this replica does not serve
+var errWrongMode = status.Error(codes.Unimplemented, "not served in this sync mode")
+
+// internalServer serves the internal protocol replicas use for replicating notes to each other
+// This does the same as the replica endpoints of the public HTTP API, which are kept for compatibility
+type internalServer struct {
+	replicationpb.UnimplementedReplicationServer
+	h *Handler
+}
+
+// runInternal serves the internal protocol on the service port plus the internal port offset
+// This function is blocking function
+func (h *Handler) runInternal() {
+	addr := fmt.Sprintf("%s:%d", h.addr, h.port+h.internalPortOffset)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Fatal("Could not listen for internal protocol", logger.Fields{"addr": addr, "err": err})
+		return
+	}
+
+	server := grpc.NewServer(grpc.MaxRecvMsgSize(replication.MaxMessageSize), grpc.MaxSendMsgSize(replication.MaxMessageSize))
+	replicationpb.RegisterReplicationServer(server, &internalServer{h: h})
+
+	logger.Info("Now starting internal server", logger.Fields{"addr": addr})
+	err = server.Serve(listener)
+	if err != nil {
+		logger.Fatal("Could not serve internal protocol", logger.Fields{"addr": addr, "err": err})
+	}
+}
+
+// resultOf returns the result of applying a single note, for the error the local storage returned
+func resultOf(err error) replicationpb.Result {
+	if err == nil {
+		return replicationpb.Result_OK
+	} else if errors.Is(err, ds.ErrStaleVersion) {
+		return replicationpb.Result_STALE_VERSION
+	} else if errors.Is(err, ds.ErrNotFound) {
+		return replicationpb.Result_NOT_FOUND
+	} else if errors.Is(err, ds.ErrVersionConflict) {
+		return replicationpb.Result_VERSION_CONFLICT
+	}
+	return replicationpb.Result_FAILED
+}
+
+// Backup applies the writes of the primary in order, unless this replica already has newer versions
+// Writes from leaders of older terms are all rejected, along with the leader this replica knows
+// Primaries sent along with the writes are stored once the writes were applied, as SetPrimary does
+// Idempotency keys sent along are remembered unless the write failed, so that retries are answered by this replica
+func (s *internalServer) Backup(ctx context.Context, request *replicationpb.BackupRequest) (*replicationpb.BackupResponse, error) {
+	h := s.h
+	if h.syncMode != misc.SyncLocalWrite && h.syncMode != misc.SyncRemoteWrite {
+		return nil, errWrongMode
+	}
+
+	response := &replicationpb.BackupResponse{
+		Results: make([]replicationpb.Result, len(request.Mutations)),
+		Owners:  make([]*replicationpb.Ownership, len(request.Mutations)),
+	}
+	for i := range response.Owners {
+		response.Owners[i] = &replicationpb.Ownership{}
+	}
+
+	if h.syncMode == misc.SyncRemoteWrite && request.Leader != nil &&
+		!h.election.observe(request.Leader.Term, request.Leader.Replica) {
+		for i := range response.Results {
+			response.Results[i] = replicationpb.Result_STALE_LEADER
+		}
+
+		response.Leader = replication.EncodeLeader(h.asLeader())
+		logger.Warn("Rejected backup from stale leader", logger.Fields{"source": misc.SourceReplica,
+			"leader": request.Leader.Replica, "term": request.Leader.Term, "current_term": response.Leader.GetTerm()})
+		return response, nil
+	}
+
+	for i, mutation := range request.Mutations {
+		note := replication.DecodeNote(mutation.Note)
+
+		var err error
+		deleted := mutation.Kind == replicationpb.Mutation_DELETE
+		if deleted {
+			err = h.dsh.DeleteNote(note.Id)
+		} else {
+			err, _ = h.dsh.WriteNoteIfNewer(note)
+		}
+
+		response.Results[i] = resultOf(err)
+		if response.Results[i] == replicationpb.Result_FAILED {
+			logger.Warn("Could not apply backup", logger.Fields{"source": misc.SourceReplica, "note_id": note.Id, "err": err})
+		}
+
+		if response.Results[i] != replicationpb.Result_FAILED {
+			h.rememberWrite(replication.DecodeIdempotentWrite(mutation.Idempotency))
+		}
+
+		// Deleting a note this replica never had still moves the note
+		applied := err == nil || (deleted && errors.Is(err, ds.ErrNotFound))
+		if mutation.Owner == nil || h.syncMode != misc.SyncLocalWrite || !applied {
+			continue
+		}
+
+		err, _, current := h.storeOwner(replication.DecodeOwnership(mutation.Owner))
+		if err != nil {
+			response.Results[i] = replicationpb.Result_FAILED
+			continue
+		}
+		response.Owners[i] = replication.EncodeOwnership(current)
+	}
+	return response, nil
+}
+
+// SetPrimary stores the primary of the note in local-write mode, unless this replica knew a newer one
+func (s *internalServer) SetPrimary(ctx context.Context, request *replicationpb.SetPrimaryRequest) (*replicationpb.SetPrimaryResponse, error) {
+	if s.h.syncMode != misc.SyncLocalWrite {
+		return nil, errWrongMode
+	}
+
+	owner := replication.DecodeOwnership(request.Owner)
+	if len(owner.Primary) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "primary was empty")
+	}
+
+	err, accepted, current := s.h.storeOwner(owner)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &replicationpb.SetPrimaryResponse{Accepted: accepted, Current: replication.EncodeOwnership(current)}, nil
+}
+
+// FetchPrimaries returns the primaries of all notes this replica knows about in local-write mode
+func (s *internalServer) FetchPrimaries(ctx context.Context, request *replicationpb.FetchPrimariesRequest) (*replicationpb.FetchPrimariesResponse, error) {
+	if s.h.syncMode != misc.SyncLocalWrite {
+		return nil, errWrongMode
+	}
+
+	owners := s.h.dsh.Owners()
+	response := &replicationpb.FetchPrimariesResponse{Owners: make([]*replicationpb.Ownership, 0, len(owners))}
+	for _, owner := range owners {
+		response.Owners = append(response.Owners, replication.EncodeOwnership(owner))
+	}
+	return response, nil
+}
+
+// WriteNotes stores the notes in quorum mode, each only if it is newer than the one this replica has
+// Conditional writes are stored only if this replica has no version above their base, see ds.WriteNoteOnBase
+// Idempotency keys sent along are remembered once all notes were stored
+func (s *internalServer) WriteNotes(ctx context.Context, request *replicationpb.WriteNotesRequest) (*replicationpb.WriteNotesResponse, error) {
+	if s.h.syncMode != misc.SyncQuorum {
+		return nil, errWrongMode
+	}
+	if len(request.BaseVersions) != 0 && len(request.BaseVersions) != len(request.Notes) {
+		return nil, status.Error(codes.InvalidArgument, "base versions do not match the notes")
+	}
+
+	response := &replicationpb.WriteNotesResponse{Results: make([]replicationpb.Result, len(request.Notes))}
+	stored := true
+	for i, encoded := range request.Notes {
+		note := replication.DecodeNote(encoded)
+		var err error
+		if len(request.BaseVersions) != 0 {
+			err = s.h.dsh.WriteNoteOnBase(note, request.BaseVersions[i])
+		} else {
+			err, _ = s.h.dsh.WriteNoteIfNewer(note)
+		}
+		response.Results[i] = resultOf(err)
+		stored = stored && err == nil
+		logger.Debug("Applied quorum write", logger.Fields{"note_id": note.Id, "version": note.Version, "err": err})
+	}
+
+	if stored {
+		for _, write := range request.Idempotency {
+			s.h.rememberWrite(replication.DecodeIdempotentWrite(write))
+		}
+	}
+	return response, nil
+}
+
+// FetchNote returns the note or its tombstone stored in this replica
+func (s *internalServer) FetchNote(ctx context.Context, request *replicationpb.FetchNoteRequest) (*replicationpb.FetchNoteResponse, error) {
+	err, note := s.h.dsh.ReadRaw(int(request.Id))
+	if err != nil {
+		return &replicationpb.FetchNoteResponse{Found: false}, nil
+	}
+	return &replicationpb.FetchNoteResponse{Found: true, Note: replication.EncodeNote(note)}, nil
+}
+
+// FetchPage returns a single page of the notes in this replica
+func (s *internalServer) FetchPage(ctx context.Context, request *replicationpb.FetchPageRequest) (*replicationpb.FetchPageResponse, error) {
+	query := replication.PageQuery{
+		Sort:          request.Sort,
+		Descending:    request.Descending,
+		TitlePrefix:   request.TitlePrefix,
+		TitleContains: request.TitleContains,
+		Offset:        int(request.Offset),
+		Limit:         int(request.Limit),
+	}
+	if request.After != nil {
+		after := replication.DecodeNote(request.After)
+		query.After = &after
+	}
+
+	err, page := s.h.dsh.ReadPage(noteQueryOf(query))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response := &replicationpb.FetchPageResponse{Notes: make([]*replicationpb.Note, 0, len(page.Notes)), More: page.More}
+	for _, note := range page.Notes {
+		response.Notes = append(response.Notes, replication.EncodeNote(note))
+	}
+	return response, nil
+}
+
+// FetchNotes returns the notes or their tombstones stored in this replica, missing notes are left out
+func (s *internalServer) FetchNotes(ctx context.Context, request *replicationpb.FetchNotesRequest) (*replicationpb.FetchNotesResponse, error) {
+	response := &replicationpb.FetchNotesResponse{Notes: make([]*replicationpb.Note, 0, len(request.Ids))}
+	for _, id := range request.Ids {
+		err, note := s.h.dsh.ReadRaw(int(id))
+		if err == nil {
+			response.Notes = append(response.Notes, replication.EncodeNote(note))
+		}
+	}
+	return response, nil
+}
+
+// FetchDigest streams the versions of all notes in this replica, including the tombstones
+func (s *internalServer) FetchDigest(request *replicationpb.FetchDigestRequest, stream replicationpb.Replication_FetchDigestServer) error {
+	digest := s.h.dsh.Digest()
+	for start := 0; start < len(digest); start += internalBatchSize {
+		end := start + internalBatchSize
+		if end > len(digest) {
+			end = len(digest)
+		}
+
+		batch := &replicationpb.DigestBatch{Digests: make([]*replicationpb.Digest, 0, end-start)}
+		for _, entry := range digest[start:end] {
+			batch.Digests = append(batch.Digests, replication.EncodeDigest(entry))
+		}
+		err := stream.Send(batch)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Snapshot streams all notes in this replica, including the tombstones
+func (s *internalServer) Snapshot(request *replicationpb.SnapshotRequest, stream replicationpb.Replication_SnapshotServer) error {
+	notes := s.h.dsh.ReadAllRaw()
+	for start := 0; start < len(notes); start += internalBatchSize {
+		end := start + internalBatchSize
+		if end > len(notes) {
+			end = len(notes)
+		}
+
+		batch := &replicationpb.NoteBatch{Notes: make([]*replicationpb.Note, 0, end-start)}
+		for _, note := range notes[start:end] {
+			batch.Notes = append(batch.Notes, replication.EncodeNote(note))
+		}
+		err := stream.Send(batch)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,29 +1,44 @@
 package api
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"io"
-	"log"
 	"net/http"
-	"os"
 	"seph/common"
+	"seph/ds"
+	"seph/logger"
 	"seph/misc"
+	"seph/replication"
 	"strconv"
 	"strings"
 )
 
+// primaryRetries is how many times a replica tries to become the primary of a note
+// while other replicas keep taking the note at the same time
+const primaryRetries = 3
+
+// errPrimaryUnreachable is returned when the primary of a note did not answer
+var errPrimaryUnreachable = errors.New("primary of the note was unreachable")
+
+// errPrimaryContended is returned when other replicas kept taking the note while this replica tried to write it
+var errPrimaryContended = errors.New("could not become the primary of the note, other replicas kept taking it")
+
+// localGetPrimaryAll is for [GET] /primary API
+// This returns the primaries of all notes this replica knows about
+func (h *Handler) localGetPrimaryAll(c *gin.Context) {
+	c.JSON(http.StatusOK, h.dsh.Owners())
+}
+
 // localGetPrimarySpecific is for [GET] /primary/{0-9} API
+// This returns the primary of the note, the primary is empty if the note never had one
 func (h *Handler) localGetPrimarySpecific(c *gin.Context) {
 	// Read ID param from API
 	noteID := c.Param("id")
-	newPrimary := c.GetHeader("primary")
 
 	// ID was unable to be converted as an integer
-	note, err := strconv.Atoi(noteID)
+	id, err := strconv.Atoi(noteID)
 	if err != nil {
 		errResponse := common.NoteErrorResponse{
 			Msg:    "wrong URI, ID was invalid",
@@ -33,27 +48,66 @@ func (h *Handler) localGetPrimarySpecific(c *gin.Context) {
 		}
 
 		c.JSON(http.StatusBadRequest, errResponse)
-		log.Printf("%s [REPLY][%s] %s %v",
-			misc.ColoredReplica, c.Request.Method, c.Request.RequestURI, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceReplica).With("reply", errResponse))
 		return
 	}
 
-	// Check if the note already had a primary before
-	oldPrimary, ok := h.primaryMap[note]
+	c.JSON(http.StatusOK, h.dsh.Owner(id))
+}
 
-	// Just show user that we are moving primaries
-	if ok { // This means that there already was a primary before
-		log.Printf("%s [REQUEST] Move item to new primary %s->%s", misc.ColoredReplica, oldPrimary, newPrimary)
-	} else { // This means that this was a new note
-		log.Printf("%s [REQUEST] Move item to new primary %s", misc.ColoredReplica, newPrimary)
+// localPutPrimarySpecific is for [PUT] /primary/{0-9} API
+// Another replica tells about the new primary of the note, this is stored unless this replica knew a newer one
+// When this replica was the primary, it stops writing the note before replying, so that the new primary
+// can pull the latest version of the note
+// Rejected primaries are replied with 409 along with the primary this replica keeps
+func (h *Handler) localPutPrimarySpecific(c *gin.Context) {
+	var owner common.Ownership
+	err := c.ShouldBindJSON(&owner)
+	if err != nil || strconv.Itoa(owner.Id) != c.Param("id") || len(owner.Primary) == 0 {
+		errResponse := common.NoteErrorResponse{
+			Msg:    "wrong body, primary did not match the URI",
+			Method: c.Request.Method,
+			Uri:    c.Request.RequestURI,
+			Body:   fmt.Sprintf("%v", owner),
+		}
+
+		c.JSON(http.StatusBadRequest, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceReplica).With("reply", errResponse))
+		return
 	}
 
-	// Construct response
-	response := struct {
-		Msg string `json:"msg"`
-	}{Msg: "OK"}
-	c.JSON(http.StatusOK, response)
-	return
+	err, accepted, current := h.storeOwner(owner)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": err.Error()})
+		return
+	} else if !accepted {
+		c.JSON(http.StatusConflict, current)
+		logger.Warn("Reply", requestFields(c, misc.SourceReplica).With("reply", current))
+		return
+	}
+
+	c.JSON(http.StatusOK, current)
+}
+
+// storeOwner stores the primary another replica told about, unless this replica knew a newer one
+// This returns whether the primary was accepted, and the primary this replica keeps now
+func (h *Handler) storeOwner(owner common.Ownership) (error, bool, common.Ownership) {
+	// Writes of this replica hold the lock, so none of them is in progress once the note moved
+	h.primaryLock.Lock()
+	old := h.dsh.Owner(owner.Id)
+	err, accepted, current := h.dsh.SetOwner(owner)
+	h.primaryLock.Unlock()
+
+	if err != nil {
+		logger.Error("Unable to store primary of note", logger.Fields{"note_id": owner.Id, "err": err})
+		return err, false, common.Ownership{}
+	}
+
+	if accepted && old.Primary != current.Primary {
+		logger.Info("Move item to new primary", logger.Fields{"source": misc.SourceReplica, "note_id": owner.Id,
+			"old_primary": old.Primary, "primary": current.Primary, "epoch": current.Epoch})
+	}
+	return nil, accepted, current
 }
 
 // localUpdateBackup is for [POST/PUT/PATCH] /backup API
@@ -70,13 +124,12 @@ func (h *Handler) localUpdateBackup(c *gin.Context) {
 
 		// Return bad request, user sent us bad thing!
 		c.JSON(http.StatusBadRequest, errResponse)
-		log.Printf("%s [REPLY][%s] %s %v",
-			misc.ColoredReplica, c.Request.Method, c.Request.RequestURI, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceReplica).With("reply", errResponse))
 		return
 	}
 
-	// Perform remote write
-	err, newNote := h.performLocalWrite(c, reqNote)
+	// Apply the note as the primary wrote it, unless this replica already has a newer version
+	err, _ = h.dsh.WriteNoteIfNewer(reqNote)
 	if err != nil {
 		errResponse := common.NoteErrorResponse{
 			Msg:    err.Error(),
@@ -85,22 +138,24 @@ func (h *Handler) localUpdateBackup(c *gin.Context) {
 			Body:   fmt.Sprintf("%v", reqNote),
 		}
 
-		// Return bad request, user sent us bad thing!
-		c.JSON(http.StatusInternalServerError, errResponse)
-		log.Printf("%s [REPLY][%s] %s %v",
-			misc.ColoredReplica, c.Request.Method, c.Request.RequestURI, errResponse)
+		// Stale versions are conflicts, anything else is our fault
+		status := http.StatusInternalServerError
+		if errors.Is(err, ds.ErrStaleVersion) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceReplica).With("reply", errResponse))
 		return
 	}
 
 	// This replica's update was successful
-	c.JSON(http.StatusOK, newNote)
+	c.JSON(http.StatusOK, reqNote)
 	return
 }
 
 // localDeleteBackup is for [DELETE] /backup/{0-9} API
 func (h *Handler) localDeleteBackup(c *gin.Context) {
-	log.Printf("%s [REQUEST][%s] %s {} ",
-		misc.ColoredReplica, c.Request.Method, c.Request.RequestURI)
+	logger.Info("Request", requestFields(c, misc.SourceReplica))
 
 	// Read ID param from API
 	noteID := c.Param("id")
@@ -116,8 +171,7 @@ func (h *Handler) localDeleteBackup(c *gin.Context) {
 		}
 
 		c.JSON(http.StatusBadRequest, errResponse)
-		log.Printf("%s [REPLY][%s] %s %v",
-			misc.ColoredReplica, c.Request.Method, c.Request.RequestURI, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceReplica).With("reply", errResponse))
 		return
 	}
 
@@ -138,240 +192,57 @@ func (h *Handler) localDeleteBackup(c *gin.Context) {
 }
 
 // handleLocalWrite handles local write
-func (h *Handler) handleLocalWrite(c *gin.Context, note common.Note, primary string) (error, common.Note) {
-	// Check if the note already had a primary before
-	oldPrimary, ok := h.primaryMap[note.Id]
-	newPrimary := os.Getenv("REPLICA_ID")
-
-	// Just show user that we are moving primaries
-	if ok { // This means that there already was a primary before
-		log.Printf("%s [REQUEST] Move item to new primary %s->%s", misc.ColoredReplica, oldPrimary, newPrimary)
-	} else { // This means that this was a new note
-		log.Printf("%s [REQUEST] Move item to new primary %s", misc.ColoredReplica, newPrimary)
-	}
-
-	// If this was a POST request, create a new file and propagate
+// New notes start at this replica, other writes make this replica the primary of the note first
+// With the forward policy, writes to notes of other primaries are forwarded to the primary instead
+func (h *Handler) handleLocalWrite(c *gin.Context, note common.Note) (error, common.Note) {
+	// If this was a POST request, create a new note here and propagate
 	if strings.Contains(c.Request.Method, "POST") {
 		// Assign new ID for the new note
 		err, newID := h.dsh.AssignNewID()
 		if err != nil {
-			log.Printf("Unable to assign new ID for note: %v", err)
+			logger.Error("Unable to assign new ID for note", logger.Fields{"err": err})
 			return err, common.Note{}
 		}
 
-		// Update note and try creating the note
-		note.Id = newID
-		err = h.dsh.CreateNote(note)
+		// Nobody else allocates this ID, so this replica is the first primary of the note
+		owner := common.Ownership{Id: newID, Primary: h.self(), Epoch: 1}
+		err, _, _ = h.dsh.SetOwner(owner)
 		if err != nil {
-			log.Printf("Unable to create new note: %v", err)
+			logger.Error("Unable to store primary of note", logger.Fields{"note_id": newID, "err": err})
 			return err, common.Note{}
 		}
 
-		// Update primary map, this is the current primary
-		h.primaryMap[newID] = primary
-		c.JSON(http.StatusOK, note) // We just return write response
-
-		// Till here, only the new primary knows the current note's information
-		// Serialize the payload to JSON
-		payloadBytes, err := json.Marshal(note)
+		note.Id = newID
+		err, newNote := h.performLocalWrite(c, note)
 		if err != nil {
-			log.Println("Error marshaling JSON payload:", err)
 			return err, common.Note{}
 		}
 
-		// For all replicas, tell them to update
-		for _, replica := range h.replicas {
-			// If this was the primary, skip
-			if strings.Contains(replica, primary) {
-				continue
-			}
-
-			log.Printf("[DEBUG] propagating to %s", replica)
-
-			backupEndpoint := fmt.Sprintf("http://%s/backup", replica)                     // For backup
-			primaryUpdateEndpoint := fmt.Sprintf("http://%s/primary/%d", replica, note.Id) // For keeping track of primary
-
-			// Perform backup API
-			response, err := http.Post(backupEndpoint, "application/json", bytes.NewBuffer(payloadBytes))
-			if err != nil {
-				log.Printf("Error making POST request to replica %s: %v\n", backupEndpoint, err)
-				return err, common.Note{}
-			}
-
-			// Check if the replica got response correct
-			if response.StatusCode == http.StatusOK {
-				// Read the response body
-				body, err := io.ReadAll(response.Body)
-				if err != nil {
-					log.Printf("Error reading response body from replica %s: %v", backupEndpoint, err)
-					return err, common.Note{}
-				}
-
-				// Try unmarshalling the body into our note format
-				var newNote common.Note
-				err = json.Unmarshal(body, &newNote)
-				if err != nil {
-					log.Printf("Error unmarshalling response from replica %s: %v", backupEndpoint, err)
-					return err, common.Note{}
-				}
-			} else {
-				log.Printf("Non-OK response from replica %s: %v", backupEndpoint, err)
-				return err, common.Note{}
-			}
-			response.Body.Close()
-
-			// Perform primary API
-			request, err := http.NewRequest("GET", primaryUpdateEndpoint, nil)
-			if err != nil {
-				log.Printf("Error creating GET request to replica %s: %v", replica, err)
-				return err, common.Note{}
-			}
-
-			// Add primary value to header
-			request.Header.Add("primary", primary)
-
-			// perform GET request
-			client := &http.Client{}
-			response, err = client.Do(request)
-			if err != nil {
-				log.Printf("Error making GET request to replica %s: %v", replica, err)
-			}
-
-			if response.StatusCode == http.StatusOK {
-				// Read the response body
-				body, err := io.ReadAll(response.Body)
-				if err != nil {
-					log.Printf("Error reading response body from replica %s: %v", primaryUpdateEndpoint, err)
-					return err, common.Note{}
-				}
-
-				// Try unmarshalling the body into our note format
-				var newNote common.Note
-				err = json.Unmarshal(body, &newNote)
-				if err != nil {
-					log.Printf("Error unmarshalling response from replica %s: %v", primaryUpdateEndpoint, err)
-					return err, common.Note{}
-				}
-			} else {
-				log.Printf("Non-OK response from replica %s: %v", primaryUpdateEndpoint, err)
-				return err, common.Note{}
-			}
-			response.Body.Close()
-		}
-
-		// We successfully wrote, so return id and the note!
-		return nil, note
+		h.propagateLocal(c.Request.Method, newNote, owner, idempotencyOf(c).write(c.Request.Method, newNote))
+		return nil, newNote
 	} else if strings.Contains(c.Request.Method, "PUT") ||
 		strings.Contains(c.Request.Method, "PATCH") { // Forward PUT or PATCH
 
-		err, newNote := h.performLocalWrite(c, note)
-		if err != nil {
-			log.Printf("Unable to write local file: %v", err)
-			return err, common.Note{}
+		if h.shouldForward(c, note.Id) {
+			err, newNote := h.forwardLocalWrite(c, note)
+			if !errors.Is(err, errPrimaryUnreachable) {
+				return err, newNote
+			}
+			logger.Warn("Primary of the note was unreachable, writing here instead", logger.Fields{"note_id": note.Id})
 		}
 
-		// This replica's update was successful
-		c.JSON(http.StatusOK, newNote)
-
-		// Serialize the payload to JSON
-		payloadBytes, err := json.Marshal(note)
+		err, newNote, owner := h.writeAsPrimary(note.Id, func() (error, common.Note) {
+			return h.performLocalWrite(c, note)
+		})
 		if err != nil {
-			log.Println("Error marshaling JSON payload:", err)
+			logger.Error("Unable to write local file", logger.Fields{"note_id": note.Id, "err": err})
 			return err, common.Note{}
 		}
 
-		// For all replicas, tell them to update as well
-		for _, replica := range h.replicas {
-			// If this was the primary, skip
-			if strings.Contains(replica, primary) {
-				continue
-			}
-
-			log.Printf("[DEBUG] propagating to %s", replica)
-
-			backupEndpoint := fmt.Sprintf("http://%s/backup", replica)                     // For backup
-			primaryUpdateEndpoint := fmt.Sprintf("http://%s/primary/%d", replica, note.Id) // For keeping track of primary
-
-			// Perform backup API
-			// Create a new request accordingly
-			request, err := http.NewRequest(c.Request.Method, backupEndpoint, bytes.NewBuffer(payloadBytes))
-			if err != nil {
-				log.Printf("Error creating %s request: %v to primary\n", c.Request.Method, err)
-				return err, common.Note{}
-			}
-			request.Header.Set("Content-Type", "application/json")
-
-			// Perform the request
-			client := &http.Client{}
-			response, err := client.Do(request)
-			if err != nil {
-				log.Printf("Error making %s request to primary %s: %v\n", c.Request.Method, backupEndpoint, err)
-				return err, common.Note{}
-			}
-
-			// Check if the replica got response correct
-			if response.StatusCode == http.StatusOK {
-				// Read the response body
-				body, err := io.ReadAll(response.Body)
-				if err != nil {
-					log.Printf("Error reading response body from replica %s: %v", backupEndpoint, err)
-					return err, common.Note{}
-				}
-
-				// Try unmarshalling the body into our note format
-				var newNote common.Note
-				err = json.Unmarshal(body, &newNote)
-				if err != nil {
-					log.Printf("Error unmarshalling response from replica %s: %v", backupEndpoint, err)
-					return err, common.Note{}
-				}
-			} else {
-				log.Printf("Non-OK response from replica %s: %v", backupEndpoint, err)
-				return err, common.Note{}
-			}
-			response.Body.Close()
-
-			// Perform primary API
-			request, err = http.NewRequest("GET", primaryUpdateEndpoint, nil)
-			if err != nil {
-				log.Printf("Error creating GET request to replica %s: %v", replica, err)
-				return err, common.Note{}
-			}
-
-			// Add primary value to header
-			request.Header.Add("primary", primary)
-
-			// perform GET request
-			client = &http.Client{}
-			response, err = client.Do(request)
-			if err != nil {
-				log.Printf("Error making GET request to replica %s: %v", replica, err)
-			}
-
-			if response.StatusCode == http.StatusOK {
-				// Read the response body
-				body, err := io.ReadAll(response.Body)
-				if err != nil {
-					log.Printf("Error reading response body from replica %s: %v", primaryUpdateEndpoint, err)
-					return err, common.Note{}
-				}
-
-				// Try unmarshalling the body into our note format
-				var newNote common.Note
-				err = json.Unmarshal(body, &newNote)
-				if err != nil {
-					log.Printf("Error unmarshalling response from replica %s: %v", primaryUpdateEndpoint, err)
-					return err, common.Note{}
-				}
-			} else {
-				log.Printf("Non-OK response from replica %s: %v", primaryUpdateEndpoint, err)
-				return err, common.Note{}
-			}
-			response.Body.Close()
-		}
-		// We successfully wrote, so return id and the note!
-		return nil, note
-	} // I won't give a fuck on other cases
+		// Backups apply the note as this primary wrote it
+		h.propagateLocal(c.Request.Method, newNote, owner, idempotencyOf(c).write(c.Request.Method, newNote))
+		return nil, newNote
+	}
 
 	return errors.New("invalid method"), common.Note{}
 }
@@ -380,9 +251,10 @@ func (h *Handler) handleLocalWrite(c *gin.Context, note common.Note, primary str
 func (h *Handler) performLocalWrite(c *gin.Context, note common.Note) (error, common.Note) {
 	if strings.Contains(c.Request.Method, "POST") { // If this was POST, create new one
 		// Update note and try creating the note
+		h.touchNote(&note, 1)
 		err := h.dsh.CreateNote(note)
 		if err != nil {
-			log.Printf("Unable to create new note: %v", err)
+			logger.Error("Unable to create new note", logger.Fields{"note_id": note.Id, "err": err})
 			return err, common.Note{}
 		}
 
@@ -392,23 +264,27 @@ func (h *Handler) performLocalWrite(c *gin.Context, note common.Note) (error, co
 		// First, find original note
 		err, original := h.dsh.ReadSpecific(note.Id)
 		if err != nil {
-			log.Printf("Unable to find existing note with ID  %d: %v", note.Id)
+			logger.Warn("Unable to find existing note", logger.Fields{"note_id": note.Id, "err": err})
 			return err, common.Note{}
 		}
 
-		// Patch will only modify if the field was not empty
-		if len(note.Body) != 0 {
-			original.Body = note.Body
+		// The client might only want to write if nobody else modified the note
+		err = checkIfMatch(c.GetHeader("If-Match"), original)
+		if err != nil {
+			return err, common.Note{}
 		}
 
-		if len(note.Title) != 0 {
-			original.Title = note.Title
+		// Apply the patch to the newest version of the note
+		err, original = patchNote(c, original)
+		if err != nil {
+			return err, common.Note{}
 		}
 
-		// Try updating the note
+		// Try updating the note as the next version
+		h.touchNote(&original, original.Version+1)
 		err = h.dsh.UpdateNote(original)
 		if err != nil {
-			log.Printf("Unable to update existing note with ID %d: %v", note.Id, err)
+			logger.Error("Unable to update existing note", logger.Fields{"note_id": note.Id, "err": err})
 			return err, common.Note{}
 		}
 
@@ -417,7 +293,13 @@ func (h *Handler) performLocalWrite(c *gin.Context, note common.Note) (error, co
 		// First, find original note
 		err, original := h.dsh.ReadSpecific(note.Id)
 		if err != nil {
-			log.Printf("Unable to find existing note with ID  %d: %v", note.Id)
+			logger.Warn("Unable to find existing note", logger.Fields{"note_id": note.Id, "err": err})
+			return err, common.Note{}
+		}
+
+		// The client might only want to write if nobody else modified the note
+		err = checkIfMatch(c.GetHeader("If-Match"), original)
+		if err != nil {
 			return err, common.Note{}
 		}
 
@@ -425,10 +307,11 @@ func (h *Handler) performLocalWrite(c *gin.Context, note common.Note) (error, co
 		original.Body = note.Body
 		original.Title = note.Title
 
-		// Try updating the note
+		// Try updating the note as the next version
+		h.touchNote(&original, original.Version+1)
 		err = h.dsh.UpdateNote(original)
 		if err != nil {
-			log.Printf("Unable to update existing note with ID %d: %v", note.Id, err)
+			logger.Error("Unable to update existing note", logger.Fields{"note_id": note.Id, "err": err})
 			return err, common.Note{}
 		}
 
@@ -439,59 +322,273 @@ func (h *Handler) performLocalWrite(c *gin.Context, note common.Note) (error, co
 }
 
 // handleLocalDelete handles the delete operation
-func (h *Handler) handleLocalDelete(id int, primary string) error {
-	// Check if the note already had a primary before
-	oldPrimary, ok := h.primaryMap[id]
-	newPrimary := os.Getenv("REPLICA_ID")
-
-	// Just show user that we are moving primaries
-	if ok { // This means that there already was a primary before
-		log.Printf("%s [REQUEST] Move item to new primary %s->%s", misc.ColoredReplica, oldPrimary, newPrimary)
-	} else { // This means that this was a new note
-		log.Printf("%s [REQUEST] Move item to new primary %s", misc.ColoredReplica, newPrimary)
+// If ifMatch was not empty, the note is deleted only if its current version matches
+// Deletes which were forwarded by other replicas are never forwarded again
+func (h *Handler) handleLocalDelete(id int, ifMatch string, forwarded bool, idempotency *idempotencyRequest) error {
+	if !forwarded && h.primaryPolicy == misc.PrimaryForward {
+		owner := h.dsh.Owner(id)
+		if len(owner.Primary) != 0 && owner.Primary != h.self() {
+			err, status, _ := h.forwardToPrimary(owner.Primary, http.MethodDelete, fmt.Sprintf("/note/%d", id), nil, "", ifMatch, idempotency)
+			if err == nil && status == http.StatusOK {
+				return nil
+			} else if err == nil && status == http.StatusPreconditionFailed {
+				return errPreconditionFailed
+			} else if err == nil {
+				msg := fmt.Sprintf("non-ok response code %d from primary %s", status, owner.Primary)
+				return errors.New(msg)
+			}
+			logger.Warn("Primary of the note was unreachable, deleting here instead", logger.Fields{"note_id": id})
+		}
 	}
 
-	// First, delete the file from current replica
-	err := h.dsh.DeleteNote(id)
+	err, _, owner := h.writeAsPrimary(id, func() (error, common.Note) {
+		// The client might only want to delete if nobody else modified the note
+		if len(ifMatch) != 0 {
+			err, current := h.dsh.ReadSpecific(id)
+			if err != nil {
+				return err, common.Note{}
+			}
+
+			err = checkIfMatch(ifMatch, current)
+			if err != nil {
+				return err, common.Note{}
+			}
+		}
+
+		return h.dsh.DeleteNote(id), common.Note{}
+	})
 	if err != nil {
-		log.Printf("Error deleting note %d: %v", id, err)
+		logger.Error("Error deleting note", logger.Fields{"note_id": id, "err": err})
 		return err
 	}
 
-	// For all replicas, tell them to update as well
-	for _, replica := range h.replicas {
-		// If this was the primary, skip
-		if strings.Contains(replica, primary) {
-			continue
+	h.propagateLocal(http.MethodDelete, common.Note{Id: id}, owner, idempotency.write(http.MethodDelete, common.Note{Id: id}))
+	return nil
+}
+
+// shouldForward returns if the write to the note should be forwarded to its primary
+// Only the forward policy forwards writes, and only to primaries other than this replica
+func (h *Handler) shouldForward(c *gin.Context, id int) bool {
+	if h.primaryPolicy != misc.PrimaryForward || len(c.GetHeader(headerForwarded)) != 0 {
+		return false
+	}
+
+	owner := h.dsh.Owner(id)
+	return len(owner.Primary) != 0 && owner.Primary != h.self()
+}
+
+// forwardLocalWrite forwards the write to the primary of the note, and returns the note the primary wrote
+func (h *Handler) forwardLocalWrite(c *gin.Context, note common.Note) (error, common.Note) {
+	// Serialize the payload to JSON, patches are forwarded as they are
+	err, payloadBytes, contentType := forwardedWrite(c, note)
+	if err != nil {
+		logger.Error("Error marshaling JSON payload", logger.Fields{"err": err})
+		return err, common.Note{}
+	}
+
+	primary := h.dsh.Owner(note.Id).Primary
+	uri := fmt.Sprintf("/note/%d", note.Id)
+	err, status, body := h.forwardToPrimary(primary, c.Request.Method, uri, payloadBytes, contentType, c.GetHeader("If-Match"), idempotencyOf(c))
+	if err != nil {
+		return err, common.Note{}
+	}
+
+	// Check if the response status code is OK (200)
+	if status == http.StatusOK {
+		// Try unmarshalling the body into our note format
+		var newNote common.Note
+		err = json.Unmarshal(body, &newNote)
+		if err != nil {
+			logger.Error("Error unmarshalling response from primary", logger.Fields{"err": err})
+			return err, common.Note{}
 		}
 
-		// Perform delete request
-		var response *http.Response
-		endpoint := fmt.Sprintf("http://%s/backup/%d", replica, id)
+		// Yes this worked
+		return nil, newNote
+	} else if status == http.StatusPreconditionFailed {
+		return errPreconditionFailed, common.Note{}
+	} else if status == http.StatusUnprocessableEntity {
+		return forwardedPatchError(body), common.Note{}
+	} else {
+		logger.Error("Non-OK response from primary", logger.Fields{"primary": primary, "status": status})
+		return errors.New("non-ok response code"), common.Note{}
+	}
+}
+
+// forwardToPrimary sends the request to the primary as a client request marked as forwarded,
+// and returns the status code and the body of the reply
+// The idempotency key of the client is sent along, so that the primary remembers the write with it
+// If the primary did not answer, this returns errPrimaryUnreachable
+func (h *Handler) forwardToPrimary(primary string, method string, uri string, payload []byte, contentType string, ifMatch string, idempotency *idempotencyRequest) (error, int, []byte) {
+	// A down primary would only make the client wait for the timeout
+	if h.peerDown(primary) {
+		logger.Warn("Primary is down", logger.Fields{"primary": primary})
+		return fmt.Errorf("%w: %v", errPrimaryUnreachable, errPeerDown), 0, nil
+	}
+	logger.Info("Forward request to primary", logger.Fields{"source": misc.SourceReplica, "primary": primary})
+
+	header := http.Header{}
+	if len(contentType) != 0 {
+		header.Set("Content-Type", contentType)
+	}
+	header.Set("If-Match", ifMatch)
+	header.Set(headerForwarded, h.self())
+	idempotency.setHeaders(header)
+	err, reply := h.client.Forward(primary, method, uri, payload, header)
+	if err != nil {
+		logger.Warn("Error making request", logger.Fields{"method": method, "primary": primary, "err": err})
+		return fmt.Errorf("%w: %v", errPrimaryUnreachable, err), 0, nil
+	}
+
+	return nil, reply.Status, reply.Body
+}
 
-		// Create a new request accordingly
-		request, err := http.NewRequest("DELETE", endpoint, nil)
+// writeAsPrimary makes this replica the primary of the note, then performs the write as the primary
+// This returns the result of the write and the ownership the write was made under
+func (h *Handler) writeAsPrimary(id int, write func() (error, common.Note)) (error, common.Note, common.Ownership) {
+	for attempt := 0; attempt < primaryRetries; attempt++ {
+		err := h.acquirePrimary(id)
 		if err != nil {
-			log.Printf("Error creating DELETE request to replica: %v\n", err)
-			return err
+			return err, common.Note{}, common.Ownership{}
 		}
-		request.Header.Set("Content-Type", "application/json")
 
-		// Perform the request
-		client := http.Client{}
-		response, err = client.Do(request)
+		// Another replica might have taken the note meanwhile, then take it back
+		h.primaryLock.Lock()
+		owner := h.dsh.Owner(id)
+		if owner.Primary != h.self() {
+			h.primaryLock.Unlock()
+			continue
+		}
+
+		err, note := write()
+		h.primaryLock.Unlock()
+		return err, note, owner
+	}
+
+	return errPrimaryContended, common.Note{}, common.Ownership{}
+}
+
+// acquirePrimary moves the note from its current primary to this replica
+// The current primary is asked to give up the note first, then the latest version of the note is pulled from it
+// If the current primary was unreachable, this replica takes the note anyway and the old primary learns about it later
+func (h *Handler) acquirePrimary(id int) error {
+	self := h.self()
+	for attempt := 0; attempt < primaryRetries; attempt++ {
+		current := h.dsh.Owner(id)
+		if current.Primary == self {
+			return nil
+		}
+
+		claim := common.Ownership{Id: id, Primary: self, Epoch: current.Epoch + 1}
+		if len(current.Primary) != 0 {
+			err, accepted, reply := h.client.SetPrimary(current.Primary, claim)
+			if err != nil {
+				logger.Warn("Primary of the note was unreachable, taking over the note",
+					logger.Fields{"note_id": id, "old_primary": current.Primary, "err": err})
+			} else if !accepted {
+				// Another replica took the note first, so ask that one next time
+				_, _, _ = h.dsh.SetOwner(reply)
+				continue
+			} else {
+				h.pullLatest(current.Primary, id)
+			}
+		}
+
+		err, accepted, _ := h.dsh.SetOwner(claim)
 		if err != nil {
-			log.Printf("Error making DELETE request to replica %s: %v\n", endpoint, err)
 			return err
+		} else if !accepted {
+			continue
 		}
 
-		// Just check if code was OK
-		if response.StatusCode == http.StatusOK {
+		logger.Info("Move item to new primary", logger.Fields{"source": misc.SourceReplica, "note_id": id,
+			"old_primary": current.Primary, "primary": self, "epoch": claim.Epoch})
+		return nil
+	}
+
+	return errPrimaryContended
+}
+
+// pullLatest pulls the note from the old primary, which will not write the note anymore
+func (h *Handler) pullLatest(primary string, id int) {
+	err, note, found := h.client.FetchNote(primary, id)
+	if err != nil {
+		logger.Debug("Could not pull note from old primary", logger.Fields{"primary": primary, "note_id": id, "err": err})
+		return
+	} else if !found {
+		return
+	}
+
+	err, _ = h.dsh.WriteNoteIfNewer(note)
+	if err != nil && !errors.Is(err, ds.ErrStaleVersion) {
+		logger.Warn("Could not apply note from old primary", logger.Fields{"primary": primary, "note_id": id, "err": err})
+	}
+}
+
+// propagateLocal sends the write and the primary of the note to all other replicas
+// Writes to unreachable and down replicas are kept as hints, and replayed once the replicas are back
+// The idempotency key of the write is remembered by this replica and sent along, if the client made the write with one
+func (h *Handler) propagateLocal(method string, note common.Note, owner common.Ownership, write *common.IdempotentWrite) {
+	h.rememberWrite(write)
+	h.eachPeer(func(replica string) {
+		if h.shouldHint(replica) {
+			h.hintWrite(replica, method, note, &owner, write)
+			return
+		}
+		logger.Debug("Propagating to replica", logger.Fields{"replica": replica})
+
+		// Backups store the primary along with the write
+		ack := h.replicate(replica, replication.Mutation{Method: method, Note: note, Owner: &owner, Idempotency: write})
+		if replication.IsUnreachable(ack.Err) {
+			logger.Warn("Replica is unreachable, keeping hint", logger.Fields{"replica": replica, "note_id": note.Id, "err": ack.Err})
+			h.hintWrite(replica, method, note, &owner, write)
+			return
+		} else if ack.Err != nil {
+			logger.Warn("Could not propagate to replica", logger.Fields{"replica": replica, "note_id": note.Id, "err": ack.Err})
+			return
+		}
+
+		if ack.Primary != owner {
+			logger.Warn("Replica knows a newer primary of the note",
+				logger.Fields{"replica": replica, "note_id": note.Id, "primary": ack.Primary.Primary, "epoch": ack.Primary.Epoch})
+			_, _, _ = h.dsh.SetOwner(ack.Primary)
+		}
+	})
+}
+
+// initPrimaries pulls the primaries of all notes from the first healthy peer
+func (h *Handler) initPrimaries() {
+	for _, peer := range h.peers() {
+		err := h.pullPrimaries(peer)
+		if err != nil {
+			logger.Warn("Could not pull primaries from peer", logger.Fields{"peer": peer, "err": err})
 			continue
-		} else {
+		}
+		return
+	}
+}
+
+// pullPrimaries pulls the primaries of all notes from the peer, keeping the newer one of each note
+func (h *Handler) pullPrimaries(peer string) error {
+	err, owners := h.client.FetchPrimaries(peer)
+	if err != nil {
+		return err
+	}
+
+	updated := 0
+	for _, owner := range owners {
+		before := h.dsh.Owner(owner.Id)
+		err, accepted, _ := h.dsh.SetOwner(owner)
+		if err != nil {
 			return err
 		}
+		if accepted && before != owner {
+			updated++
+		}
 	}
 
+	if updated != 0 {
+		logger.Info("Pulled primaries from peer", logger.Fields{"peer": peer, "updated": updated})
+	}
 	return nil
 }
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"seph/logger"
+	"time"
+)
+
+// requestFields returns the log fields describing the request of given context
+// The source tells whether the request came from a client or from another replica
+func requestFields(c *gin.Context, source string) logger.Fields {
+	fields := logger.Fields{
+		"source": source,
+		"method": c.Request.Method,
+		"uri":    c.Request.RequestURI,
+		"client": c.ClientIP(),
+	}
+
+	if id := c.Param("id"); len(id) != 0 {
+		fields["note_id"] = id
+	}
+
+	return fields
+}
+
+// requestLogger is a gin middleware which writes a single debug record per finished request
+func requestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		logger.Debug("Finished request", logger.Fields{
+			"method":  c.Request.Method,
+			"uri":     c.Request.RequestURI,
+			"client":  c.ClientIP(),
+			"status":  c.Writer.Status(),
+			"latency": time.Since(start),
+		})
+	}
+}
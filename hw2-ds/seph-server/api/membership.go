@@ -0,0 +1,439 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net"
+	"net/http"
+	"os"
+	"seph/common"
+	"seph/ds"
+	"seph/logger"
+	"seph/misc"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// membershipRetries is how many times a join or leave is retried while other replicas keep changing the members
+const membershipRetries = 3
+
+// Errors of joins and leaves, see membershipErrorStatus for their status codes
+var (
+	errUnknownMember       = errors.New("unknown member")
+	errMemberConflict      = errors.New("another member has the same ID or address")
+	errClusterFull         = errors.New("cluster is full, raise maxReplicas in the config")
+	errLastMember          = errors.New("the last member cannot leave")
+	errMembershipContended = errors.New("could not change members, other replicas kept changing them")
+)
+
+// memberList keeps the members of the cluster as this replica knows them
+// The replicas in the config are only the initial members, joins and leaves change the members afterwards
+// Every change increases the version, so that replicas always keep the latest members
+type memberList struct {
+	lock    sync.RWMutex
+	dir     string
+	current common.Membership
+	change  sync.Mutex // Serializes the joins and leaves this replica makes
+}
+
+// newMemberList loads the members this replica saw last, or makes the replicas in the config the members
+func newMemberList(targetDir string, replicas []string) (error, *memberList) {
+	l := &memberList{lock: sync.RWMutex{}, dir: targetDir, change: sync.Mutex{}}
+
+	err, membership, ok := ds.LoadMembership(targetDir)
+	if err != nil {
+		return err, nil
+	} else if ok {
+		l.current = membership
+		return nil, l
+	}
+
+	l.current.Members = make([]common.Member, 0, len(replicas))
+	for i, replica := range replicas {
+		l.current.Members = append(l.current.Members, common.Member{Id: replica, Address: replica, Slot: i})
+	}
+	return nil, l
+}
+
+// get returns a copy of the members, so that the caller can change it freely
+func (l *memberList) get() common.Membership {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	return copyMembership(l.current)
+}
+
+// set stores the members if they supersede the current ones, and returns whether it did along with the current ones
+func (l *memberList) set(membership common.Membership) (error, bool, common.Membership) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if membershipKey(membership) == membershipKey(l.current) && membership.Version == l.current.Version {
+		return nil, true, copyMembership(l.current)
+	} else if !supersedesMembership(membership, l.current) {
+		return nil, false, copyMembership(l.current)
+	}
+
+	l.current = copyMembership(membership)
+	if len(l.dir) != 0 {
+		err := ds.SaveMembership(l.dir, l.current)
+		if err != nil {
+			return err, true, copyMembership(l.current)
+		}
+	}
+	return nil, true, copyMembership(l.current)
+}
+
+// copyMembership returns a deep copy of the members
+func copyMembership(membership common.Membership) common.Membership {
+	members := append([]common.Member(nil), membership.Members...)
+	return common.Membership{Version: membership.Version, Members: members}
+}
+
+// membershipKey returns the members as a string, used for telling two memberships of the same version apart
+func membershipKey(membership common.Membership) string {
+	keys := make([]string, 0, len(membership.Members))
+	for _, member := range membership.Members {
+		keys = append(keys, fmt.Sprintf("%s=%s/%d", member.Id, member.Address, member.Slot))
+	}
+	return strings.Join(keys, ",")
+}
+
+// supersedesMembership returns if the members a should replace the members b
+// Higher versions win, and ties are broken by the members themselves so that all replicas agree on the same members
+func supersedesMembership(a common.Membership, b common.Membership) bool {
+	if a.Version != b.Version {
+		return a.Version > b.Version
+	}
+	return membershipKey(a) < membershipKey(b)
+}
+
+// replicas returns the addresses of all members, in the order they joined
+func (h *Handler) replicas() []string {
+	membership := h.members.get()
+	replicas := make([]string, 0, len(membership.Members))
+	for _, member := range membership.Members {
+		replicas = append(replicas, member.Address)
+	}
+	return replicas
+}
+
+// selfMember returns this replica as a member, or false if this replica was not a member
+func (h *Handler) selfMember() (common.Member, bool) {
+	for _, member := range h.members.get().Members {
+		if member.Id == os.Getenv("REPLICA_ID") || isSelf(member.Address) {
+			return member, true
+		}
+	}
+	return common.Member{}, false
+}
+
+// applyMembership stores the members unless this replica already knows newer ones
+// This returns whether the members were stored, and the members this replica keeps now
+func (h *Handler) applyMembership(membership common.Membership) (error, bool, common.Membership) {
+	old := h.members.get()
+	_, wasMember := h.selfMember()
+
+	err, accepted, current := h.members.set(membership)
+	if err != nil || !accepted || membershipKey(current) == membershipKey(old) {
+		return err, accepted, current
+	}
+
+	logger.Info("Members changed", logger.Fields{"version": current.Version, "replicas": strings.Join(h.replicas(), ",")})
+
+	// Members which left are no backups anymore, so their queues are dropped
+	if h.pipeline != nil {
+		for _, replica := range leftMembers(old, current) {
+			h.pipeline.remove(replica)
+		}
+	}
+
+	_, isMember := h.selfMember()
+	if wasMember && !isMember {
+		logger.Warn("This replica was removed from the cluster, it can be stopped now", nil)
+
+		// A removed replica must not lead the others anymore
+		if h.election != nil {
+			h.election.lock.Lock()
+			if h.election.leader == h.election.self {
+				h.election.leader = ""
+			}
+			h.election.lock.Unlock()
+		}
+	} else if !wasMember && isMember && h.dsh != nil {
+		h.setIDStripe()
+	}
+
+	return nil, true, current
+}
+
+// leftMembers returns the addresses of the members in old which are no longer in current
+func leftMembers(old common.Membership, current common.Membership) []string {
+	remaining := make(map[string]bool)
+	for _, member := range current.Members {
+		remaining[member.Address] = true
+	}
+
+	left := make([]string, 0)
+	for _, member := range old.Members {
+		if !remaining[member.Address] {
+			left = append(left, member.Address)
+		}
+	}
+	return left
+}
+
+// membersGet is for [GET] /members API
+// This returns the members of the cluster as this replica knows them
+func (h *Handler) membersGet(c *gin.Context) {
+	c.JSON(http.StatusOK, h.members.get())
+}
+
+// membersPut is for [PUT] /members API
+// Another replica tells about the new members, they are stored unless this replica knew newer ones
+// Rejected members are replied with 409 along with the members this replica keeps
+func (h *Handler) membersPut(c *gin.Context) {
+	var membership common.Membership
+	err := c.ShouldBindJSON(&membership)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+		return
+	}
+
+	err, accepted, current := h.applyMembership(membership)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": err.Error()})
+		logger.Error("Unable to store members", logger.Fields{"err": err})
+		return
+	} else if !accepted {
+		c.JSON(http.StatusConflict, current)
+		logger.Warn("Reply", requestFields(c, misc.SourceReplica).With("version", current.Version))
+		return
+	}
+
+	c.JSON(http.StatusOK, current)
+}
+
+// membersJoin is for [POST] /members API
+// This adds the replica as a member, the ID defaults to the address of the replica
+// Joining again with the same ID and address changes nothing, so replicas can retry their joins
+func (h *Handler) membersJoin(c *gin.Context) {
+	var member common.Member
+	err := c.ShouldBindJSON(&member)
+	if err != nil || len(member.Address) == 0 {
+		errResponse := common.NoteErrorResponse{
+			Msg:    "wrong body, address of the member was missing",
+			Method: c.Request.Method,
+			Uri:    c.Request.RequestURI,
+			Body:   "",
+		}
+
+		c.JSON(http.StatusBadRequest, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
+		return
+	}
+	if len(member.Id) == 0 {
+		member.Id = member.Address
+	}
+
+	err, membership := h.changeMembership(func(membership *common.Membership) (error, bool) {
+		return addMember(membership, member, h.maxReplicas)
+	})
+	if err != nil {
+		c.JSON(membershipErrorStatus(err), gin.H{"msg": err.Error()})
+		logger.Warn("Reply", requestFields(c, misc.SourceClient).With("err", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, membership)
+}
+
+// membersLeave is for [DELETE] /members/{id} API
+// This removes the replica from the members, the replica can be stopped once this returned
+func (h *Handler) membersLeave(c *gin.Context) {
+	id := c.Param("id")
+	err, membership := h.changeMembership(func(membership *common.Membership) (error, bool) {
+		return removeMember(membership, id)
+	})
+	if err != nil {
+		c.JSON(membershipErrorStatus(err), gin.H{"msg": err.Error()})
+		logger.Warn("Reply", requestFields(c, misc.SourceClient).With("err", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, membership)
+}
+
+// membershipErrorStatus returns the status code of the reply for the failed join or leave
+func membershipErrorStatus(err error) int {
+	if errors.Is(err, errUnknownMember) {
+		return http.StatusNotFound
+	} else if errors.Is(err, errMemberConflict) || errors.Is(err, errClusterFull) || errors.Is(err, errLastMember) {
+		return http.StatusConflict
+	} else if errors.Is(err, errMembershipContended) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// addMember adds the member with the lowest free slot, and returns whether the members changed
+func addMember(membership *common.Membership, member common.Member, maxReplicas int) (error, bool) {
+	used := make(map[int]bool)
+	for _, existing := range membership.Members {
+		if existing.Id == member.Id && existing.Address == member.Address {
+			return nil, false
+		} else if existing.Id == member.Id || existing.Address == member.Address {
+			return fmt.Errorf("%w: %s at %s", errMemberConflict, existing.Id, existing.Address), false
+		}
+		used[existing.Slot] = true
+	}
+
+	// Slots of the replicas which left are reused, their IDs were caught up by the joining replica
+	for slot := 0; slot < maxReplicas; slot++ {
+		if !used[slot] {
+			member.Slot = slot
+			membership.Members = append(membership.Members, member)
+			return nil, true
+		}
+	}
+
+	return errClusterFull, false
+}
+
+// removeMember removes the member of the ID or the address, and returns whether the members changed
+func removeMember(membership *common.Membership, id string) (error, bool) {
+	for i, member := range membership.Members {
+		if member.Id != id && member.Address != id {
+			continue
+		}
+
+		if len(membership.Members) == 1 {
+			return errLastMember, false
+		}
+		membership.Members = append(membership.Members[:i], membership.Members[i+1:]...)
+		return nil, true
+	}
+
+	return fmt.Errorf("%w: %s", errUnknownMember, id), false
+}
+
+// changeMembership changes the members, then tells the new members to every replica of the old and the new members
+// Replicas which did not answer learn the new members later, when they pull the members from their peers
+// If a replica knew newer members, the change is made again on top of those
+func (h *Handler) changeMembership(change func(membership *common.Membership) (error, bool)) (error, common.Membership) {
+	h.members.change.Lock()
+	defer h.members.change.Unlock()
+
+	for attempt := 0; attempt < membershipRetries; attempt++ {
+		current := h.members.get()
+		next := copyMembership(current)
+		err, changed := change(&next)
+		if err != nil || !changed {
+			return err, current
+		}
+		next.Version = current.Version + 1
+
+		err, accepted, _ := h.applyMembership(next)
+		if err != nil {
+			return err, current
+		} else if !accepted {
+			continue
+		}
+
+		// Removed replicas are told as well, so that they learn they were removed
+		targets := make(map[string]bool)
+		for _, member := range append(current.Members, next.Members...) {
+			if !isSelf(member.Address) {
+				targets[member.Address] = true
+			}
+		}
+
+		conflict := false
+		for replica := range targets {
+			err, accepted, theirs := h.client.SetMembers(replica, next)
+			if err != nil {
+				logger.Warn("Could not tell members to replica", logger.Fields{"replica": replica, "err": err})
+				continue
+			} else if !accepted {
+				logger.Info("Replica knows newer members, changing members again",
+					logger.Fields{"replica": replica, "version": theirs.Version})
+				_, _, _ = h.applyMembership(theirs)
+				conflict = true
+				break
+			}
+		}
+
+		if !conflict {
+			return nil, next
+		}
+	}
+
+	return errMembershipContended, h.members.get()
+}
+
+// pullMembership pulls the members from the peer, keeping them if they were newer
+func (h *Handler) pullMembership(peer string) error {
+	err, membership := h.client.FetchMembers(peer)
+	if err != nil {
+		return err
+	}
+
+	err, _, _ = h.applyMembership(membership)
+	return err
+}
+
+// initMembership pulls the members from the first healthy peer, they might have changed while this replica was down
+func (h *Handler) initMembership() {
+	for _, peer := range h.peers() {
+		err := h.pullMembership(peer)
+		if err != nil {
+			logger.Warn("Could not pull members from peer", logger.Fields{"peer": peer, "err": err})
+			continue
+		}
+		return
+	}
+}
+
+// joinCluster asks the replicas in the config to add this replica as a member, until one of them did
+// The ID of this replica is $REPLICA_ID, and the address is what other replicas reach this replica at
+// This function is blocking function
+func (h *Handler) joinCluster() {
+	self := common.Member{Id: os.Getenv("REPLICA_ID"), Address: h.self()}
+	logger.Info("This replica is not a member yet, joining the cluster", logger.Fields{"id": self.Id, "address": self.Address})
+
+	for {
+		for _, seed := range h.seeds {
+			if isSelf(seed) {
+				continue
+			}
+
+			err, membership := h.client.Join(seed, self)
+			if err != nil {
+				logger.Warn("Could not join the cluster through replica", logger.Fields{"replica": seed, "err": err})
+				continue
+			}
+			_, _, _ = h.applyMembership(membership)
+
+			if _, ok := h.selfMember(); ok {
+				logger.Info("Joined the cluster", logger.Fields{"replica": seed, "version": membership.Version})
+				return
+			}
+		}
+
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// selfAddress returns the address other replicas reach this replica at, when it was not in the members
+// $REPLICA_ID is used as it is if it had a port, otherwise the service port is added
+func (h *Handler) selfAddress() string {
+	replicaID := os.Getenv("REPLICA_ID")
+	_, _, err := net.SplitHostPort(replicaID)
+	if err == nil {
+		return replicaID
+	}
+	return net.JoinHostPort(replicaID, strconv.Itoa(h.port))
+}
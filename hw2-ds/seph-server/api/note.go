@@ -6,30 +6,74 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"io"
-	"log"
 	"net/http"
-	"os"
 	"seph/common"
+	"seph/logger"
 	"seph/misc"
 	"strconv"
 )
 
 // getNoteAll is for [GET] /note API
 func (h *Handler) getNoteAll(c *gin.Context) {
-	log.Printf("%s [REQUEST][%s] %s {}",
-		misc.ColoredClient, c.Request.Method, c.Request.RequestURI)
+	logger.Info("Request", requestFields(c, misc.SourceClient))
 
-	// Read all notes from local storage, send them
-	notes := h.dsh.ReadAll()
-	c.JSON(http.StatusOK, notes)
-	log.Printf("%s [REPLY][%s] %s %v",
-		misc.ColoredClient, c.Request.Method, c.Request.RequestURI, notes)
+	// Find out how consistent the notes should be
+	err, level := h.readConsistency(c)
+	if err != nil {
+		errResponse := common.NoteErrorResponse{
+			Msg:    err.Error(),
+			Method: c.Request.Method,
+			Uri:    c.Request.RequestURI,
+			Body:   "",
+		}
+
+		c.JSON(http.StatusBadRequest, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
+		return
+	}
+
+	// Find out which page of the notes the client wants
+	err, query := h.noteQuery(c)
+	if err != nil {
+		errResponse := common.NoteErrorResponse{
+			Msg:    err.Error(),
+			Method: c.Request.Method,
+			Uri:    c.Request.RequestURI,
+			Body:   "",
+		}
+
+		c.JSON(http.StatusBadRequest, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
+		return
+	}
+
+	// Read the page at that level, stale notes might be read from local storage unless the level was strong
+	err, level, page := h.readNotes(c, level, query)
+	if err != nil {
+		errResponse := common.NoteErrorResponse{
+			Msg:    err.Error(),
+			Method: c.Request.Method,
+			Uri:    c.Request.RequestURI,
+			Body:   "",
+		}
+
+		c.JSON(http.StatusInternalServerError, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
+		return
+	}
+
+	c.Header(headerConsistency, level)
+	if token := parseSession(c.GetHeader(headerSession)); len(token) != 0 {
+		c.Header(headerSession, token.String())
+	}
+	setPageHeaders(c, query, page)
+	c.JSON(http.StatusOK, page.Notes)
+	logger.Info("Reply", requestFields(c, misc.SourceClient).With("consistency", level).With("reply", page.Notes))
 }
 
 // getNoteSpecific is for [GET] /note/{0-9} API
 func (h *Handler) getNoteSpecific(c *gin.Context) {
-	log.Printf("%s [REQUEST][%s] %s {} ",
-		misc.ColoredClient, c.Request.Method, c.Request.RequestURI)
+	logger.Info("Request", requestFields(c, misc.SourceClient))
 
 	// Read ID param from API
 	noteID := c.Param("id")
@@ -45,14 +89,40 @@ func (h *Handler) getNoteSpecific(c *gin.Context) {
 		}
 
 		c.JSON(http.StatusBadRequest, errResponse)
-		log.Printf("%s [REPLY][%s] %s %v",
-			misc.ColoredClient, c.Request.Method, c.Request.RequestURI, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
 		return
 	}
 
-	// If ID was able to be converted, try reading it from local storage
-	err, notes := h.dsh.ReadSpecific(note)
+	// Find out how consistent the note should be
+	err, level := h.readConsistency(c)
 	if err != nil {
+		errResponse := common.NoteErrorResponse{
+			Msg:    err.Error(),
+			Method: c.Request.Method,
+			Uri:    c.Request.RequestURI,
+			Body:   "",
+		}
+
+		c.JSON(http.StatusBadRequest, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
+		return
+	}
+
+	// If ID was able to be converted, read it at that level
+	// Eventual reads come from local storage, strong ones from the replica taking the writes or the read quorum
+	err, level, notes := h.readNote(c, note, level)
+	if err != nil && !errors.Is(err, errNoteNotFound) {
+		errResponse := common.NoteErrorResponse{
+			Msg:    err.Error(),
+			Method: c.Request.Method,
+			Uri:    c.Request.RequestURI,
+			Body:   "",
+		}
+
+		c.JSON(http.StatusInternalServerError, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
+		return
+	} else if err != nil {
 		errResponse := common.NoteErrorResponse{
 			Msg:    "wrong URI, non existing ID",
 			Method: c.Request.Method,
@@ -61,26 +131,22 @@ func (h *Handler) getNoteSpecific(c *gin.Context) {
 		}
 
 		c.JSON(http.StatusBadRequest, errResponse)
-		log.Printf("%s [REPLY][%s] %s %v",
-			misc.ColoredClient, c.Request.Method, c.Request.RequestURI, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
 		return
 	}
 
 	// This worked, so return note information
+	c.Header(headerConsistency, level)
+	setNoteHeaders(c, notes)
 	c.JSON(http.StatusOK, notes)
-	log.Printf("%s [REPLY][%s] %s %v",
-		misc.ColoredClient, c.Request.Method, c.Request.RequestURI, notes)
+	logger.Info("Reply", requestFields(c, misc.SourceClient).With("consistency", level).With("reply", notes))
 }
 
 // postNote is for [POST] /note API
 func (h *Handler) postNote(c *gin.Context) {
-	msg := fmt.Sprintf("%s [REQUEST][%s] %s ",
-		misc.ColoredClient, c.Request.Method, c.Request.RequestURI)
-
 	// Try parsing body JSON
 	err, req := clientRequest(c)
 	if err != nil {
-		msg = msg + "{err}"
 		errResponse := common.NoteErrorResponse{
 			Msg:    err.Error(),
 			Method: c.Request.Method,
@@ -89,22 +155,19 @@ func (h *Handler) postNote(c *gin.Context) {
 		}
 
 		// Return bad request, user sent us bad thing!
-		log.Println(msg)
+		logger.Info("Request", requestFields(c, misc.SourceClient))
 		c.JSON(http.StatusBadRequest, errResponse)
-		log.Printf("%s [REPLY][%s] %s %v",
-			misc.ColoredClient, c.Request.Method, c.Request.RequestURI, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
 		return
-	} else {
-		msg = fmt.Sprintf("%s %v", msg, req)
 	}
 
 	// Print out the request information
-	log.Println(msg)
+	logger.Info("Request", requestFields(c, misc.SourceClient).With("body", req))
 
 	// Now the distributed storage part!
 	switch h.syncMode {
 	case misc.SyncLocalWrite:
-		err, result := h.handleLocalWrite(c, req, os.Getenv("REPLICA_ID"))
+		err, result := h.handleLocalWrite(c, req)
 		if err != nil {
 			errResponse := common.NoteErrorResponse{
 				Msg:    err.Error(),
@@ -112,15 +175,15 @@ func (h *Handler) postNote(c *gin.Context) {
 				Uri:    c.Request.RequestURI,
 				Body:   fmt.Sprintf("%v", req),
 			}
-			c.JSON(http.StatusInternalServerError, errResponse)
-			log.Printf("%s [REPLY][%s] %s %v",
-				misc.ColoredClient, c.Request.Method, c.Request.RequestURI, errResponse)
+			c.JSON(writeErrorStatus(err), errResponse)
+			logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
 			return
 		}
 
 		// Yes this worked
-		log.Printf("%s [REPLY][%s] %s %v",
-			misc.ColoredClient, c.Request.Method, c.Request.RequestURI, result)
+		setNoteHeaders(c, result)
+		c.JSON(http.StatusOK, result)
+		logger.Info("Reply", requestFields(c, misc.SourceClient).With("reply", result))
 		return
 	case misc.SyncRemoteWrite:
 		err, result := h.handleRemoteWrite(c, req)
@@ -131,29 +194,62 @@ func (h *Handler) postNote(c *gin.Context) {
 				Uri:    c.Request.RequestURI,
 				Body:   fmt.Sprintf("%v", req),
 			}
-			c.JSON(http.StatusInternalServerError, errResponse)
-			log.Printf("%s [REPLY][%s] %s %v",
-				misc.ColoredClient, c.Request.Method, c.Request.RequestURI, errResponse)
+			c.JSON(writeErrorStatus(err), errResponse)
+			logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
+			return
+		}
+
+		// Yes this worked
+		setNoteHeaders(c, result)
+		c.JSON(http.StatusOK, result)
+		logger.Info("Reply", requestFields(c, misc.SourceClient).With("reply", result))
+		return
+	case misc.SyncQuorum:
+		err, result := h.handleQuorumWrite(c, req)
+		if err != nil {
+			errResponse := common.NoteErrorResponse{
+				Msg:    err.Error(),
+				Method: c.Request.Method,
+				Uri:    c.Request.RequestURI,
+				Body:   fmt.Sprintf("%v", req),
+			}
+			c.JSON(writeErrorStatus(err), errResponse)
+			logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
+			return
+		}
+
+		// Yes this worked
+		setNoteHeaders(c, result)
+		c.JSON(http.StatusOK, result)
+		logger.Info("Reply", requestFields(c, misc.SourceClient).With("reply", result))
+		return
+	case misc.SyncRaft:
+		err, result := h.handleRaftWrite(c, req)
+		if err != nil {
+			errResponse := common.NoteErrorResponse{
+				Msg:    err.Error(),
+				Method: c.Request.Method,
+				Uri:    c.Request.RequestURI,
+				Body:   fmt.Sprintf("%v", req),
+			}
+			c.JSON(writeErrorStatus(err), errResponse)
+			logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
 			return
 		}
 
 		// Yes this worked
+		setNoteHeaders(c, result)
 		c.JSON(http.StatusOK, result)
-		log.Printf("%s [REPLY][%s] %s %v",
-			misc.ColoredClient, c.Request.Method, c.Request.RequestURI, result)
+		logger.Info("Reply", requestFields(c, misc.SourceClient).With("reply", result))
 		return
 	}
 }
 
 // putNoteSpecific is for [PUT] /note/{0-9} API
 func (h *Handler) putNoteSpecific(c *gin.Context) {
-	msg := fmt.Sprintf("%s [REQUEST][%s] %s ",
-		misc.ColoredClient, c.Request.Method, c.Request.RequestURI)
-
 	// Try parsing body JSON
 	err, req := clientRequest(c)
 	if err != nil {
-		msg = msg + "{err}"
 		errResponse := common.NoteErrorResponse{
 			Msg:    err.Error(),
 			Method: c.Request.Method,
@@ -162,18 +258,37 @@ func (h *Handler) putNoteSpecific(c *gin.Context) {
 		}
 
 		// Return bad request, user sent us bad thing!
+		logger.Info("Request", requestFields(c, misc.SourceClient))
 		c.JSON(http.StatusBadRequest, errResponse)
-	} else {
-		msg = fmt.Sprintf("%s %v", msg, req)
+		logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
+		return
 	}
 
 	// Print out the request information
-	log.Println(msg)
+	logger.Info("Request", requestFields(c, misc.SourceClient).With("body", req))
+
+	// ID was unable to be converted as an integer
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		errResponse := common.NoteErrorResponse{
+			Msg:    "wrong URI, ID was invalid",
+			Method: c.Request.Method,
+			Uri:    c.Request.RequestURI,
+			Body:   fmt.Sprintf("%v", req),
+		}
+
+		c.JSON(http.StatusBadRequest, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
+		return
+	}
+
+	// The ID in the URI takes precedence over the one in the body
+	req.Id = id
 
 	// Now the distributed storage part!
 	switch h.syncMode {
 	case misc.SyncLocalWrite:
-		err, result := h.handleLocalWrite(c, req, os.Getenv("REPLICA_ID"))
+		err, result := h.handleLocalWrite(c, req)
 		if err != nil {
 			errResponse := common.NoteErrorResponse{
 				Msg:    err.Error(),
@@ -181,15 +296,15 @@ func (h *Handler) putNoteSpecific(c *gin.Context) {
 				Uri:    c.Request.RequestURI,
 				Body:   fmt.Sprintf("%v", req),
 			}
-			c.JSON(http.StatusInternalServerError, errResponse)
-			log.Printf("%s [REPLY][%s] %s %v",
-				misc.ColoredClient, c.Request.Method, c.Request.RequestURI, errResponse)
+			c.JSON(writeErrorStatus(err), errResponse)
+			logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
 			return
 		}
 
 		// Yes this worked
-		log.Printf("%s [REPLY][%s] %s %v",
-			misc.ColoredClient, c.Request.Method, c.Request.RequestURI, result)
+		setNoteHeaders(c, result)
+		c.JSON(http.StatusOK, result)
+		logger.Info("Reply", requestFields(c, misc.SourceClient).With("reply", result))
 		return
 	case misc.SyncRemoteWrite:
 		err, result := h.handleRemoteWrite(c, req)
@@ -200,65 +315,121 @@ func (h *Handler) putNoteSpecific(c *gin.Context) {
 				Uri:    c.Request.RequestURI,
 				Body:   fmt.Sprintf("%v", req),
 			}
-			c.JSON(http.StatusInternalServerError, errResponse)
-			log.Printf("%s [REPLY][%s] %s %v",
-				misc.ColoredClient, c.Request.Method, c.Request.RequestURI, errResponse)
+			c.JSON(writeErrorStatus(err), errResponse)
+			logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
+			return
+		}
+
+		// Yes this worked
+		setNoteHeaders(c, result)
+		c.JSON(http.StatusOK, result)
+		logger.Info("Reply", requestFields(c, misc.SourceClient).With("reply", result))
+		return
+	case misc.SyncQuorum:
+		err, result := h.handleQuorumWrite(c, req)
+		if err != nil {
+			errResponse := common.NoteErrorResponse{
+				Msg:    err.Error(),
+				Method: c.Request.Method,
+				Uri:    c.Request.RequestURI,
+				Body:   fmt.Sprintf("%v", req),
+			}
+			c.JSON(writeErrorStatus(err), errResponse)
+			logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
+			return
+		}
+
+		// Yes this worked
+		setNoteHeaders(c, result)
+		c.JSON(http.StatusOK, result)
+		logger.Info("Reply", requestFields(c, misc.SourceClient).With("reply", result))
+		return
+	case misc.SyncRaft:
+		err, result := h.handleRaftWrite(c, req)
+		if err != nil {
+			errResponse := common.NoteErrorResponse{
+				Msg:    err.Error(),
+				Method: c.Request.Method,
+				Uri:    c.Request.RequestURI,
+				Body:   fmt.Sprintf("%v", req),
+			}
+			c.JSON(writeErrorStatus(err), errResponse)
+			logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
 			return
 		}
 
 		// Yes this worked
+		setNoteHeaders(c, result)
 		c.JSON(http.StatusOK, result)
-		log.Printf("%s [REPLY][%s] %s %v",
-			misc.ColoredClient, c.Request.Method, c.Request.RequestURI, result)
+		logger.Info("Reply", requestFields(c, misc.SourceClient).With("reply", result))
 		return
 	}
 }
 
 // patchNoteSpecific is for [PATCH] /note/{0-9} API
 func (h *Handler) patchNoteSpecific(c *gin.Context) {
-	msg := fmt.Sprintf("%s [REQUEST][%s] %s ",
-		misc.ColoredClient, c.Request.Method, c.Request.RequestURI)
+	// The ID in the URI decides which note is patched
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		errResponse := common.NoteErrorResponse{
+			Msg:    "wrong URI, ID was invalid",
+			Method: c.Request.Method,
+			Uri:    c.Request.RequestURI,
+			Body:   "",
+		}
 
-	// Try parsing body JSON
-	err, req := clientRequest(c)
+		logger.Info("Request", requestFields(c, misc.SourceClient))
+		c.JSON(http.StatusBadRequest, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
+		return
+	}
+
+	// Try parsing the patch, the replica writing the note applies it to the newest version of the note
+	err, patch := bindPatch(c)
 	if err != nil {
-		msg = msg + "{err}"
 		errResponse := common.NoteErrorResponse{
 			Msg:    err.Error(),
 			Method: c.Request.Method,
 			Uri:    c.Request.RequestURI,
-			Body:   "", // When json parsing failed, we regard body as empty
+			Body:   "", // When patch parsing failed, we regard body as empty
 		}
 
 		// Return bad request, user sent us bad thing!
-		c.JSON(http.StatusBadRequest, errResponse)
-	} else {
-		msg = fmt.Sprintf("%s %v", msg, req)
+		status := http.StatusBadRequest
+		if errors.Is(err, errUnsupportedPatch) {
+			status = http.StatusUnsupportedMediaType
+			c.Header("Accept-Patch", acceptPatch)
+		}
+		logger.Info("Request", requestFields(c, misc.SourceClient))
+		c.JSON(status, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
+		return
 	}
+	req := common.Note{Id: id}
 
 	// Print out the request information
-	log.Println(msg)
+	logger.Info("Request", requestFields(c, misc.SourceClient).With("body", patch.String()))
 
 	// Now the distributed storage part!
 	switch h.syncMode {
 	case misc.SyncLocalWrite:
-		err, result := h.handleLocalWrite(c, req, os.Getenv("REPLICA_ID"))
+		err, result := h.handleLocalWrite(c, req)
 		if err != nil {
 			errResponse := common.NoteErrorResponse{
 				Msg:    err.Error(),
 				Method: c.Request.Method,
 				Uri:    c.Request.RequestURI,
-				Body:   fmt.Sprintf("%v", req),
+				Body:   patch.String(),
 			}
-			c.JSON(http.StatusInternalServerError, errResponse)
-			log.Printf("%s [REPLY][%s] %s %v",
-				misc.ColoredClient, c.Request.Method, c.Request.RequestURI, errResponse)
+			c.JSON(writeErrorStatus(err), errResponse)
+			logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
 			return
 		}
 
 		// Yes this worked
-		log.Printf("%s [REPLY][%s] %s %v",
-			misc.ColoredClient, c.Request.Method, c.Request.RequestURI, result)
+		setNoteHeaders(c, result)
+		c.JSON(http.StatusOK, result)
+		logger.Info("Reply", requestFields(c, misc.SourceClient).With("reply", result))
 		return
 	case misc.SyncRemoteWrite:
 		err, result := h.handleRemoteWrite(c, req)
@@ -267,26 +438,62 @@ func (h *Handler) patchNoteSpecific(c *gin.Context) {
 				Msg:    err.Error(),
 				Method: c.Request.Method,
 				Uri:    c.Request.RequestURI,
-				Body:   fmt.Sprintf("%v", req),
+				Body:   patch.String(),
+			}
+			c.JSON(writeErrorStatus(err), errResponse)
+			logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
+			return
+		}
+
+		// Yes this worked
+		setNoteHeaders(c, result)
+		c.JSON(http.StatusOK, result)
+		logger.Info("Reply", requestFields(c, misc.SourceClient).With("reply", result))
+		return
+	case misc.SyncQuorum:
+		err, result := h.handleQuorumWrite(c, req)
+		if err != nil {
+			errResponse := common.NoteErrorResponse{
+				Msg:    err.Error(),
+				Method: c.Request.Method,
+				Uri:    c.Request.RequestURI,
+				Body:   patch.String(),
+			}
+			c.JSON(writeErrorStatus(err), errResponse)
+			logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
+			return
+		}
+
+		// Yes this worked
+		setNoteHeaders(c, result)
+		c.JSON(http.StatusOK, result)
+		logger.Info("Reply", requestFields(c, misc.SourceClient).With("reply", result))
+		return
+	case misc.SyncRaft:
+		err, result := h.handleRaftWrite(c, req)
+		if err != nil {
+			errResponse := common.NoteErrorResponse{
+				Msg:    err.Error(),
+				Method: c.Request.Method,
+				Uri:    c.Request.RequestURI,
+				Body:   patch.String(),
 			}
-			c.JSON(http.StatusInternalServerError, errResponse)
-			log.Printf("%s [REPLY][%s] %s %v",
-				misc.ColoredClient, c.Request.Method, c.Request.RequestURI, errResponse)
+			c.JSON(writeErrorStatus(err), errResponse)
+			logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
 			return
 		}
 
 		// Yes this worked
+		setNoteHeaders(c, result)
 		c.JSON(http.StatusOK, result)
-		log.Printf("%s [REPLY][%s] %s %v",
-			misc.ColoredClient, c.Request.Method, c.Request.RequestURI, result)
+		logger.Info("Reply", requestFields(c, misc.SourceClient).With("reply", result))
 		return
 	}
 }
 
 // deleteNoteSpecific is for [DELETE] /note/{0-9} API
 func (h *Handler) deleteNoteSpecific(c *gin.Context) {
-	log.Printf("%s [REQUEST][%s] %s {} ",
-		misc.ColoredClient, c.Request.Method, c.Request.RequestURI)
+	logger.Info("Request", requestFields(c, misc.SourceClient))
 
 	// Read ID param from API
 	noteID := c.Param("id")
@@ -302,8 +509,7 @@ func (h *Handler) deleteNoteSpecific(c *gin.Context) {
 		}
 
 		c.JSON(http.StatusBadRequest, errResponse)
-		log.Printf("%s [REPLY][%s] %s %v",
-			misc.ColoredClient, c.Request.Method, c.Request.RequestURI, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceClient).With("reply", errResponse))
 		return
 	}
 
@@ -311,31 +517,65 @@ func (h *Handler) deleteNoteSpecific(c *gin.Context) {
 	switch h.syncMode {
 	case misc.SyncRemoteWrite:
 		// Perform remote delete
-		err = h.handleRemoteDelete(id)
+		err = h.handleRemoteDelete(id, c.GetHeader("If-Match"), idempotencyOf(c))
+		response := struct {
+			Msg string `json:"msg"`
+		}{}
+		if err != nil {
+			response.Msg = "FAILED"
+			c.JSON(writeErrorStatus(err), response)
+			return
+		} else {
+			response.Msg = "OK"
+			h.setDeletedSession(c, id)
+			c.JSON(http.StatusOK, response)
+			return
+		}
+	case misc.SyncQuorum:
+		// Perform quorum delete
+		err = h.handleQuorumDelete(id, c.GetHeader("If-Match"), idempotencyOf(c))
+		response := struct {
+			Msg string `json:"msg"`
+		}{}
+		if err != nil {
+			response.Msg = "FAILED"
+			c.JSON(writeErrorStatus(err), response)
+			return
+		} else {
+			response.Msg = "OK"
+			h.setDeletedSession(c, id)
+			c.JSON(http.StatusOK, response)
+			return
+		}
+	case misc.SyncRaft:
+		// Perform raft delete
+		err = h.handleRaftDelete(id, c.GetHeader("If-Match"), idempotencyOf(c))
 		response := struct {
 			Msg string `json:"msg"`
 		}{}
 		if err != nil {
 			response.Msg = "FAILED"
-			c.JSON(http.StatusInternalServerError, response)
+			c.JSON(writeErrorStatus(err), response)
 			return
 		} else {
 			response.Msg = "OK"
+			h.setDeletedSession(c, id)
 			c.JSON(http.StatusOK, response)
 			return
 		}
 	case misc.SyncLocalWrite:
 		// Perform local delete
-		err = h.handleLocalDelete(id, os.Getenv("REPLICA_ID"))
+		err = h.handleLocalDelete(id, c.GetHeader("If-Match"), len(c.GetHeader(headerForwarded)) != 0, idempotencyOf(c))
 		response := struct {
 			Msg string `json:"msg"`
 		}{}
 		if err != nil {
 			response.Msg = "FAILED"
-			c.JSON(http.StatusInternalServerError, response)
+			c.JSON(writeErrorStatus(err), response)
 			return
 		} else {
 			response.Msg = "OK"
+			h.setDeletedSession(c, id)
 			c.JSON(http.StatusOK, response)
 			return
 		}
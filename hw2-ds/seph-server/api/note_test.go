@@ -0,0 +1,37 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"seph/ds"
+	"seph/misc"
+	"strings"
+	"testing"
+)
+
+// TestPutNoteInvalidID checks a PUT to a URI whose ID is not a number is rejected, instead of writing the ID of the body
+func TestPutNoteInvalidID(t *testing.T) {
+	for _, id := range []string{"abc", "1.5", ""} {
+		t.Run(id, func(t *testing.T) {
+			err, dsh := ds.New(misc.StorageMemory, t.TempDir(), nil)
+			if err != nil {
+				t.Fatalf("could not open handler: %v", err)
+			}
+			h := &Handler{dsh: dsh, syncMode: misc.SyncLocalWrite}
+
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodPut, "/note/"+id, strings.NewReader(`{"id":1,"title":"t","body":"b"}`))
+			c.Params = gin.Params{{Key: "id", Value: id}}
+
+			h.putNoteSpecific(c)
+			if recorder.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", recorder.Code, http.StatusBadRequest)
+			}
+			if err, _ := dsh.ReadRaw(1); err == nil {
+				t.Error("note of the body ID was written")
+			}
+		})
+	}
+}
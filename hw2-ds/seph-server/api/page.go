@@ -0,0 +1,186 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"seph/common"
+	"seph/ds"
+	"seph/replication"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// headerNextCursor is the header of the cursor of the next page, which is only set when there are more notes
+const headerNextCursor = "Seph-Next-Cursor"
+
+// Query parameters of GET /note
+// Pages continue from the cursor of the previous page, or skip offset notes, but not both
+// Sort is one of id, title or updated, and order is either asc or desc
+const (
+	queryLimit         = "limit"
+	queryOffset        = "offset"
+	queryCursor        = "cursor"
+	querySort          = "sort"
+	queryOrder         = "order"
+	queryTitlePrefix   = "titlePrefix"
+	queryTitleContains = "titleContains"
+)
+
+// errInvalidPage is returned when the client asked for a page which cannot exist
+var errInvalidPage = errors.New("invalid page")
+
+// pageCursor is the cursor of the next page as sent to the client, which is base64 encoded JSON
+// The sort order is kept along, so that the cursor is not used for another order by mistake
+type pageCursor struct {
+	Sort         string    `json:"sort"`
+	Descending   bool      `json:"desc,omitempty"`
+	Id           int       `json:"id"`
+	Title        string    `json:"title,omitempty"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// noteQuery returns the page of notes the client asked for
+// Limits larger than the largest page are lowered to it, and the default page is taken when there was no limit
+func (h *Handler) noteQuery(c *gin.Context) (error, ds.NoteQuery) {
+	query := ds.NoteQuery{
+		Sort:          strings.ToLower(c.DefaultQuery(querySort, ds.SortByID)),
+		TitlePrefix:   c.Query(queryTitlePrefix),
+		TitleContains: c.Query(queryTitleContains),
+		Limit:         h.pageLimit,
+	}
+
+	switch query.Sort {
+	case ds.SortByID, ds.SortByTitle, ds.SortByUpdated:
+	default:
+		return fmt.Errorf("%w: unknown sort %s, supported sorts: id, title or updated", errInvalidPage, query.Sort), ds.NoteQuery{}
+	}
+
+	switch order := strings.ToLower(c.DefaultQuery(queryOrder, "asc")); order {
+	case "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return fmt.Errorf("%w: unknown order %s, supported orders: asc or desc", errInvalidPage, order), ds.NoteQuery{}
+	}
+
+	if value, ok := c.GetQuery(queryLimit); ok {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return fmt.Errorf("%w: limit must be a positive number", errInvalidPage), ds.NoteQuery{}
+		}
+		query.Limit = limit
+	}
+	if query.Limit > h.pageMaxLimit {
+		query.Limit = h.pageMaxLimit
+	}
+
+	if value, ok := c.GetQuery(queryOffset); ok {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return fmt.Errorf("%w: offset must not be negative", errInvalidPage), ds.NoteQuery{}
+		}
+		query.Offset = offset
+	}
+
+	if token, ok := c.GetQuery(queryCursor); ok {
+		if query.Offset != 0 {
+			return fmt.Errorf("%w: offset cannot be used along with cursor", errInvalidPage), ds.NoteQuery{}
+		}
+
+		err, cursor := parseCursor(token, query)
+		if err != nil {
+			return err, ds.NoteQuery{}
+		}
+		query.After = &cursor
+	}
+
+	return nil, query
+}
+
+// pageQueryOf returns the query as sent to other replicas
+func pageQueryOf(query ds.NoteQuery) replication.PageQuery {
+	pageQuery := replication.PageQuery{
+		Sort:          query.Sort,
+		Descending:    query.Descending,
+		TitlePrefix:   query.TitlePrefix,
+		TitleContains: query.TitleContains,
+		Offset:        query.Offset,
+		Limit:         query.Limit,
+	}
+	if query.After != nil {
+		pageQuery.After = &common.Note{Id: query.After.Id, Title: query.After.Title, LastModified: query.After.LastModified}
+	}
+	return pageQuery
+}
+
+// noteQueryOf returns the query another replica sent
+func noteQueryOf(pageQuery replication.PageQuery) ds.NoteQuery {
+	query := ds.NoteQuery{
+		Sort:          pageQuery.Sort,
+		Descending:    pageQuery.Descending,
+		TitlePrefix:   pageQuery.TitlePrefix,
+		TitleContains: pageQuery.TitleContains,
+		Offset:        pageQuery.Offset,
+		Limit:         pageQuery.Limit,
+	}
+	if pageQuery.After != nil {
+		cursor := ds.CursorOf(*pageQuery.After)
+		query.After = &cursor
+	}
+	return query
+}
+
+// parseCursor parses the cursor the client sent, which must be of the same sort order as the query
+func parseCursor(token string, query ds.NoteQuery) (error, ds.NoteCursor) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return fmt.Errorf("%w: malformed cursor", errInvalidPage), ds.NoteCursor{}
+	}
+
+	var cursor pageCursor
+	err = json.Unmarshal(data, &cursor)
+	if err != nil {
+		return fmt.Errorf("%w: malformed cursor", errInvalidPage), ds.NoteCursor{}
+	} else if cursor.Sort != query.Sort || cursor.Descending != query.Descending {
+		return fmt.Errorf("%w: cursor was made for another sort order", errInvalidPage), ds.NoteCursor{}
+	}
+
+	return nil, ds.NoteCursor{Id: cursor.Id, Title: cursor.Title, LastModified: cursor.LastModified}
+}
+
+// cursorToken returns the cursor of the position in the sort order of the query, as sent to the client
+func cursorToken(query ds.NoteQuery, position ds.NoteCursor) string {
+	data, _ := json.Marshal(pageCursor{
+		Sort:         query.Sort,
+		Descending:   query.Descending,
+		Id:           position.Id,
+		Title:        position.Title,
+		LastModified: position.LastModified,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// setPageHeaders replies where the next page starts, if there were more notes after the page
+// The next page link keeps every other query parameter, and continues the way the client paginated, by cursor or by offset
+func setPageHeaders(c *gin.Context, query ds.NoteQuery, page ds.NotePage) {
+	if !page.More || len(page.Notes) == 0 {
+		return
+	}
+
+	token := cursorToken(query, ds.CursorOf(page.Notes[len(page.Notes)-1]))
+	c.Header(headerNextCursor, token)
+
+	values := c.Request.URL.Query()
+	values.Set(queryLimit, strconv.Itoa(query.Limit))
+	if query.After == nil && query.Offset != 0 {
+		values.Set(queryOffset, strconv.Itoa(query.Offset+len(page.Notes)))
+	} else {
+		values.Del(queryOffset)
+		values.Set(queryCursor, token)
+	}
+	c.Header("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", c.Request.URL.Path, values.Encode()))
+}
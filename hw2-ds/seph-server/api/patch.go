@@ -0,0 +1,315 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"mime"
+	"reflect"
+	"seph/common"
+	"strconv"
+	"strings"
+)
+
+// Content types of PATCH requests
+// Plain JSON bodies are regarded as merge patches, so existing clients sending the members to update keep working
+const (
+	contentTypeJSON       = "application/json"
+	contentTypeMergePatch = "application/merge-patch+json"
+	contentTypeJSONPatch  = "application/json-patch+json"
+)
+
+// acceptPatch is the Accept-Patch header, listing the content types PATCH requests may have
+const acceptPatch = contentTypeMergePatch + ", " + contentTypeJSONPatch
+
+// contextPatch is the key of the patch in the context of a PATCH request
+const contextPatch = "patch"
+
+// Errors of patches
+var (
+	errUnsupportedPatch = errors.New("unsupported patch content type, supported types: " + acceptPatch)
+	errInvalidPatch     = errors.New("invalid patch")
+	errPatchFailed      = errors.New("patch could not be applied")
+)
+
+// Members of the note patches may not modify, patches may only keep them as they are
+var readOnlyMembers = []string{"id", "version", "lastModified", "writer", "deleted"}
+
+// patchOperation is a single operation of a JSON Patch (RFC 6902)
+type patchOperation struct {
+	Op    string           `json:"op"`
+	Path  string           `json:"path"`
+	From  string           `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// notePatch is the patch of a PATCH request, either a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902)
+// The patch is applied to the newest version of the note by the replica writing it, and only the resulting note is replicated
+type notePatch struct {
+	contentType string
+	raw         []byte
+	merge       interface{}
+	operations  []patchOperation
+}
+
+// parsePatch reads the patch from the body of the request according to its content type
+func parsePatch(c *gin.Context) (error, *notePatch) {
+	contentType := contentTypeJSON
+	if header := c.GetHeader("Content-Type"); len(header) != 0 {
+		mediaType, _, err := mime.ParseMediaType(header)
+		if err != nil {
+			return fmt.Errorf("%w: %s", errUnsupportedPatch, header), nil
+		}
+		contentType = mediaType
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		msg := fmt.Sprintf("invalid request body: %v", err)
+		return errors.New(msg), nil
+	}
+
+	p := &notePatch{contentType: contentType, raw: body}
+	switch contentType {
+	case contentTypeJSON, contentTypeMergePatch:
+		err = json.Unmarshal(body, &p.merge)
+	case contentTypeJSONPatch:
+		err = json.Unmarshal(body, &p.operations)
+	default:
+		return fmt.Errorf("%w: %s", errUnsupportedPatch, contentType), nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidPatch, err), nil
+	}
+	return nil, p
+}
+
+// bindPatch reads the patch of the request, and keeps it in the context for the replica writing the note
+func bindPatch(c *gin.Context) (error, *notePatch) {
+	err, p := parsePatch(c)
+	if err != nil {
+		return err, nil
+	}
+
+	c.Set(contextPatch, p)
+	return nil, p
+}
+
+// patchNote applies the patch of the request to the note
+func patchNote(c *gin.Context, note common.Note) (error, common.Note) {
+	value, ok := c.Get(contextPatch)
+	if !ok {
+		return fmt.Errorf("%w: request had no patch", errInvalidPatch), common.Note{}
+	}
+	return value.(*notePatch).apply(note)
+}
+
+// forwardedWrite returns the body and the content type for forwarding the write to another replica
+// Patches are forwarded as the client sent them, so that the replica writing the note applies them to its newest version
+func forwardedWrite(c *gin.Context, note common.Note) (error, []byte, string) {
+	if value, ok := c.Get(contextPatch); ok {
+		p := value.(*notePatch)
+		return nil, p.raw, p.contentType
+	}
+
+	payload, err := json.Marshal(note)
+	return err, payload, contentTypeJSON
+}
+
+// forwardedPatchError returns the error of the patch the replica writing the note could not apply
+// The message of the reply is kept, so the client learns why the patch failed
+func forwardedPatchError(body []byte) error {
+	var reply common.NoteErrorResponse
+	if json.Unmarshal(body, &reply) != nil || !strings.HasPrefix(reply.Msg, errPatchFailed.Error()) {
+		return errPatchFailed
+	}
+	return fmt.Errorf("%w%s", errPatchFailed, strings.TrimPrefix(reply.Msg, errPatchFailed.Error()))
+}
+
+// String returns the patch as the client sent it
+func (p *notePatch) String() string {
+	return string(bytes.TrimSpace(p.raw))
+}
+
+// apply returns the note after applying the patch, only the title and the body of the note may be modified
+func (p *notePatch) apply(note common.Note) (error, common.Note) {
+	err, doc := noteDocument(note)
+	if err != nil {
+		return err, common.Note{}
+	}
+
+	var patched interface{}
+	if p.contentType == contentTypeJSONPatch {
+		err, patched = applyJSONPatch(doc, p.operations)
+	} else {
+		patched = mergePatch(doc, p.merge)
+	}
+	if err != nil {
+		return err, common.Note{}
+	}
+
+	return documentNote(note, doc, patched)
+}
+
+// noteDocument returns the note as the JSON document patches are applied to
+func noteDocument(note common.Note) (error, map[string]interface{}) {
+	data, err := json.Marshal(note)
+	if err != nil {
+		return err, nil
+	}
+
+	var doc map[string]interface{}
+	err = json.Unmarshal(data, &doc)
+	return err, doc
+}
+
+// documentNote returns the note the patched document describes
+// Patches modifying read-only members or adding unknown members are not applied
+func documentNote(note common.Note, original map[string]interface{}, patched interface{}) (error, common.Note) {
+	doc, ok := patched.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%w: note must stay an object", errPatchFailed), common.Note{}
+	}
+
+	for _, member := range readOnlyMembers {
+		if !reflect.DeepEqual(original[member], doc[member]) {
+			return fmt.Errorf("%w: %s is read-only", errPatchFailed, member), common.Note{}
+		}
+	}
+
+	fields := map[string]*string{"title": &note.Title, "body": &note.Body}
+	for member, value := range doc {
+		field, ok := fields[member]
+		if !ok {
+			if _, readOnly := original[member]; !readOnly {
+				return fmt.Errorf("%w: unknown member %s", errPatchFailed, member), common.Note{}
+			}
+			continue
+		}
+
+		text, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%w: %s must be a string", errPatchFailed, member), common.Note{}
+		}
+		*field = text
+	}
+
+	// Removed members are empty
+	for member, field := range fields {
+		if _, ok := doc[member]; !ok {
+			*field = ""
+		}
+	}
+	return nil, note
+}
+
+// mergePatch applies the JSON Merge Patch to the target as RFC 7396 describes
+// Members of the patch replace the members of the target, null removes them, and objects are merged recursively
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+
+	merged := make(map[string]interface{}, len(targetObject))
+	for member, value := range targetObject {
+		merged[member] = value
+	}
+	for member, value := range patchObject {
+		if value == nil {
+			delete(merged, member)
+		} else {
+			merged[member] = mergePatch(merged[member], value)
+		}
+	}
+	return merged
+}
+
+// applyJSONPatch applies the operations of the JSON Patch to the document in order as RFC 6902 describes
+// Operations are applied to a copy, so the document is left as it was if any operation fails
+// Notes are flat objects, so paths can only point at the members of the note
+func applyJSONPatch(doc map[string]interface{}, operations []patchOperation) (error, interface{}) {
+	patched := make(map[string]interface{}, len(doc))
+	for member, value := range doc {
+		patched[member] = value
+	}
+
+	for i, operation := range operations {
+		err := applyOperation(patched, operation)
+		if err != nil {
+			return fmt.Errorf("%w: operation %d (%s %s): %v", errPatchFailed, i, operation.Op, operation.Path, err), nil
+		}
+	}
+	return nil, patched
+}
+
+// applyOperation applies a single operation of a JSON Patch to the document
+func applyOperation(doc map[string]interface{}, operation patchOperation) error {
+	err, member := pointerMember(operation.Path)
+	if err != nil {
+		return err
+	}
+
+	switch operation.Op {
+	case "add", "replace", "test":
+		if operation.Value == nil {
+			return errors.New("value is missing")
+		}
+
+		var value interface{}
+		err = json.Unmarshal(*operation.Value, &value)
+		if err != nil {
+			return err
+		}
+
+		current, exists := doc[member]
+		if operation.Op != "add" && !exists {
+			return fmt.Errorf("%s does not exist", operation.Path)
+		} else if operation.Op == "test" && !reflect.DeepEqual(current, value) {
+			return errors.New("test failed")
+		} else if operation.Op != "test" {
+			doc[member] = value
+		}
+	case "remove":
+		if _, exists := doc[member]; !exists {
+			return fmt.Errorf("%s does not exist", operation.Path)
+		}
+		delete(doc, member)
+	case "move", "copy":
+		err, from := pointerMember(operation.From)
+		if err != nil {
+			return err
+		}
+
+		value, exists := doc[from]
+		if !exists {
+			return fmt.Errorf("%s does not exist", operation.From)
+		}
+		if operation.Op == "move" {
+			delete(doc, from)
+		}
+		doc[member] = value
+	default:
+		return fmt.Errorf("unknown op %s", strconv.Quote(operation.Op))
+	}
+	return nil
+}
+
+// pointerMember returns the member of the note the JSON Pointer (RFC 6901) points at
+func pointerMember(pointer string) (error, string) {
+	if !strings.HasPrefix(pointer, "/") || strings.Count(pointer, "/") != 1 {
+		return fmt.Errorf("path %s does not point at a member of the note", strconv.Quote(pointer)), ""
+	}
+
+	// ~1 must be decoded before ~0, so that ~01 stays ~1
+	member := strings.ReplaceAll(pointer[1:], "~1", "/")
+	return nil, strings.ReplaceAll(member, "~0", "~")
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"seph/common"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPatch parses the patch from a PATCH request of the content type and the body
+func testPatch(t *testing.T, contentType string, body string) (error, *notePatch) {
+	t.Helper()
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPatch, "/note/1", strings.NewReader(body))
+	if len(contentType) != 0 {
+		c.Request.Header.Set("Content-Type", contentType)
+	}
+	return parsePatch(c)
+}
+
+func TestParsePatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		err         error
+	}{
+		{"no content type is a merge patch", "", `{"title":"a"}`, nil},
+		{"plain JSON is a merge patch", "application/json; charset=utf-8", `{"title":"a"}`, nil},
+		{"merge patch", contentTypeMergePatch, `{"title":null}`, nil},
+		{"JSON patch", contentTypeJSONPatch, `[{"op":"remove","path":"/body"}]`, nil},
+		{"malformed merge patch", contentTypeMergePatch, `{"title":`, errInvalidPatch},
+		{"JSON patch must be a list", contentTypeJSONPatch, `{"op":"remove","path":"/body"}`, errInvalidPatch},
+		{"unsupported content type", "text/plain", `title=a`, errUnsupportedPatch},
+		{"malformed content type", "application/", `{}`, errUnsupportedPatch},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err, p := testPatch(t, test.contentType, test.body)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("parsePatch = %v, want %v", err, test.err)
+			}
+			if err == nil && p.String() != test.body {
+				t.Errorf("patch = %s, want %s as sent", p.String(), test.body)
+			}
+		})
+	}
+}
+
+// TestMergePatch checks the examples of RFC 7396
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		target string
+		patch  string
+		want   string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+
+	decode := func(data string) interface{} {
+		var v interface{}
+		if err := json.Unmarshal([]byte(data), &v); err != nil {
+			t.Fatalf("could not decode %s: %v", data, err)
+		}
+		return v
+	}
+
+	for _, test := range tests {
+		t.Run(test.target+" "+test.patch, func(t *testing.T) {
+			got := mergePatch(decode(test.target), decode(test.patch))
+			if want := decode(test.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("mergePatch = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestPatchApply(t *testing.T) {
+	note := common.Note{Id: 1, Title: "title", Body: "body", Version: 3, LastModified: time.Now().UTC(), Writer: "127.0.0.1:8001"}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		title       string
+		noteBody    string
+		err         error
+	}{
+		{"merge title", contentTypeMergePatch, `{"title":"new"}`, "new", "body", nil},
+		{"merge removes body", contentTypeMergePatch, `{"body":null}`, "title", "", nil},
+		{"merge keeps read-only member", contentTypeMergePatch, `{"id":1,"title":"new"}`, "new", "body", nil},
+		{"merge read-only member", contentTypeMergePatch, `{"version":9}`, "", "", errPatchFailed},
+		{"merge unknown member", contentTypeMergePatch, `{"color":"red"}`, "", "", errPatchFailed},
+		{"merge title not a string", contentTypeMergePatch, `{"title":1}`, "", "", errPatchFailed},
+		{"merge note not an object", contentTypeMergePatch, `"note"`, "", "", errPatchFailed},
+		{"replace", contentTypeJSONPatch, `[{"op":"replace","path":"/title","value":"new"}]`, "new", "body", nil},
+		{"test then replace", contentTypeJSONPatch,
+			`[{"op":"test","path":"/title","value":"title"},{"op":"replace","path":"/body","value":"new"}]`, "title", "new", nil},
+		{"failed test applies nothing", contentTypeJSONPatch,
+			`[{"op":"replace","path":"/body","value":"new"},{"op":"test","path":"/title","value":"other"}]`, "", "", errPatchFailed},
+		{"move", contentTypeJSONPatch, `[{"op":"move","from":"/body","path":"/title"}]`, "body", "", nil},
+		{"copy", contentTypeJSONPatch, `[{"op":"copy","from":"/title","path":"/body"}]`, "title", "title", nil},
+		{"remove", contentTypeJSONPatch, `[{"op":"remove","path":"/title"}]`, "", "body", nil},
+		{"remove twice", contentTypeJSONPatch, `[{"op":"remove","path":"/title"},{"op":"remove","path":"/title"}]`, "", "", errPatchFailed},
+		{"replace read-only member", contentTypeJSONPatch, `[{"op":"replace","path":"/deleted","value":true}]`, "", "", errPatchFailed},
+		{"add without value", contentTypeJSONPatch, `[{"op":"add","path":"/title"}]`, "", "", errPatchFailed},
+		{"unknown op", contentTypeJSONPatch, `[{"op":"swap","path":"/title"}]`, "", "", errPatchFailed},
+		{"nested path", contentTypeJSONPatch, `[{"op":"add","path":"/title/a","value":"x"}]`, "", "", errPatchFailed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err, p := testPatch(t, test.contentType, test.body)
+			if err != nil {
+				t.Fatalf("could not parse patch: %v", err)
+			}
+
+			err, patched := p.apply(note)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("apply = %v, want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+
+			want := note
+			want.Title, want.Body = test.title, test.noteBody
+			if patched != want {
+				t.Errorf("apply = %+v, want %+v", patched, want)
+			}
+		})
+	}
+}
+
+func TestPointerMember(t *testing.T) {
+	tests := []struct {
+		pointer string
+		member  string
+		ok      bool
+	}{
+		{"/title", "title", true},
+		{"/", "", true},
+		{"/a~1b", "a/b", true},
+		{"/a~0b", "a~b", true},
+		{"/a~01", "a~1", true},
+		{"title", "", false},
+		{"", "", false},
+		{"/a/b", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pointer, func(t *testing.T) {
+			err, member := pointerMember(test.pointer)
+			if (err == nil) != test.ok || member != test.member {
+				t.Errorf("pointerMember = %v, %q, want %q", err, member, test.member)
+			}
+		})
+	}
+}
+
+func TestForwardedPatchError(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"reason is kept", `{"msg":"patch could not be applied: title is read-only"}`, "patch could not be applied: title is read-only"},
+		{"other error", `{"msg":"note not found"}`, errPatchFailed.Error()},
+		{"malformed reply", `oops`, errPatchFailed.Error()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := forwardedPatchError([]byte(test.body))
+			if !errors.Is(err, errPatchFailed) || err.Error() != test.want {
+				t.Errorf("forwardedPatchError = %v, want %s", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"seph/metrics"
+	"seph/replication"
+	"sync"
+)
+
+// Metrics of batched replication
+const (
+	metricReplicationBatches = "seph_replication_batches_total"
+	metricReplicationWrites  = "seph_replication_batched_writes_total"
+)
+
+// errBackupLeft is the answer to writes still queued for a backup once it left the cluster
+var errBackupLeft = errors.New("backup left the cluster")
+
+// pendingWrite is a write waiting in the queue of a backup, done receives the answer of the backup
+type pendingWrite struct {
+	mutation replication.Mutation
+	done     chan replication.Ack
+}
+
+// backupQueue holds the writes waiting to be sent to a single backup
+type backupQueue struct {
+	lock     sync.Mutex
+	cond     *sync.Cond
+	replica  string
+	pending  []*pendingWrite
+	inFlight map[int]int // Number of batches in flight for each note
+	sending  int         // Number of batches in flight
+	closed   bool        // The backup left the cluster, its sender stops and writes are refused
+}
+
+// pipeline queues the writes to each backup and sends them in batches, every backup has its own queue and sender
+// Writes which arrive while batches are in flight are sent together in the next batch, so the batches grow with load
+// Up to depth batches are in flight to each backup at once, but a write waits while its note is in flight,
+// so each backup applies the writes to a note in order
+type pipeline struct {
+	lock      sync.Mutex
+	batchSize int
+	depth     int
+	send      func(replica string, mutations []replication.Mutation) (error, []replication.Ack)
+	queues    map[string]*backupQueue
+}
+
+// newPipeline creates a new pipeline, which sends batches of up to batchSize writes with send
+func newPipeline(batchSize int, depth int, send func(replica string, mutations []replication.Mutation) (error, []replication.Ack)) *pipeline {
+	metrics.Register(metricReplicationBatches, metrics.TypeCounter, "Number of batches of writes sent to backups")
+	metrics.Register(metricReplicationWrites, metrics.TypeCounter, "Number of writes sent to backups in batches")
+
+	return &pipeline{
+		lock:      sync.Mutex{},
+		batchSize: batchSize,
+		depth:     depth,
+		send:      send,
+		queues:    make(map[string]*backupQueue),
+	}
+}
+
+// queue returns the queue of the backup, the queue and its sender are created on the first write to the backup
+func (p *pipeline) queue(replica string) *backupQueue {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	q, ok := p.queues[replica]
+	if !ok {
+		q = &backupQueue{replica: replica, inFlight: make(map[int]int)}
+		q.cond = sync.NewCond(&q.lock)
+		p.queues[replica] = q
+		go p.run(q)
+	}
+	return q
+}
+
+// remove stops the sender of the backup and drops its queue, the writes still queued fail with errBackupLeft
+// Batches already in flight are still answered by the backup
+func (p *pipeline) remove(replica string) {
+	p.lock.Lock()
+	q, ok := p.queues[replica]
+	delete(p.queues, replica)
+	p.lock.Unlock()
+	if !ok {
+		return
+	}
+
+	q.lock.Lock()
+	q.closed = true
+	pending := q.pending
+	q.pending = nil
+	q.cond.Broadcast()
+	q.lock.Unlock()
+
+	for _, w := range pending {
+		w.done <- replication.Ack{Err: errBackupLeft}
+	}
+}
+
+// write queues the write to the backup, and waits until the backup answered
+func (p *pipeline) write(replica string, mutation replication.Mutation) replication.Ack {
+	return <-p.enqueue(replica, mutation)
+}
+
+// enqueue queues the write to the backup, the answer of the backup is sent to the returned channel
+// Writes queued one after another are applied by the backup in the same order
+func (p *pipeline) enqueue(replica string, mutation replication.Mutation) <-chan replication.Ack {
+	w := &pendingWrite{mutation: mutation, done: make(chan replication.Ack, 1)}
+
+	q := p.queue(replica)
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		w.done <- replication.Ack{Err: errBackupLeft}
+		return w.done
+	}
+	q.pending = append(q.pending, w)
+	q.cond.Signal()
+	return w.done
+}
+
+// next takes the next batch from the queue, waiting until a batch may be sent, the lock must be held
+// This returns nil once the queue was closed
+func (p *pipeline) next(q *backupQueue) []*pendingWrite {
+	for {
+		if q.closed {
+			return nil
+		}
+		if q.sending < p.depth && len(q.pending) != 0 {
+			batch := make([]*pendingWrite, 0, p.batchSize)
+			rest := make([]*pendingWrite, 0, len(q.pending))
+			blocked := make(map[int]bool) // Notes whose writes must wait, later writes to them wait as well
+			for _, w := range q.pending {
+				id := w.mutation.Note.Id
+				if len(batch) < p.batchSize && q.inFlight[id] == 0 && !blocked[id] {
+					batch = append(batch, w)
+				} else {
+					blocked[id] = true
+					rest = append(rest, w)
+				}
+			}
+
+			if len(batch) != 0 {
+				q.pending = rest
+				return batch
+			}
+		}
+		q.cond.Wait()
+	}
+}
+
+// run sends the batches of the queue until the backup left the cluster
+// This function is blocking function
+func (p *pipeline) run(q *backupQueue) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for {
+		batch := p.next(q)
+		if batch == nil {
+			return
+		}
+		for _, w := range batch {
+			q.inFlight[w.mutation.Note.Id]++
+		}
+		q.sending++
+		go p.flush(q, batch)
+	}
+}
+
+// flush sends the batch to the backup, and tells each write the answer of the backup
+// The whole batch fails unless the backup answered every write, since the answers could not be matched to the writes
+func (p *pipeline) flush(q *backupQueue, batch []*pendingWrite) {
+	mutations := make([]replication.Mutation, 0, len(batch))
+	for _, w := range batch {
+		mutations = append(mutations, w.mutation)
+	}
+
+	err, acks := p.send(q.replica, mutations)
+	if err == nil && len(acks) != len(batch) {
+		msg := fmt.Sprintf("backup %s answered %d out of %d writes", q.replica, len(acks), len(batch))
+		err = errors.New(msg)
+	}
+	metrics.Add(metricReplicationBatches, 1)
+	metrics.Add(metricReplicationWrites, float64(len(batch)))
+	for i, w := range batch {
+		if err != nil {
+			w.done <- replication.Ack{Err: err}
+		} else {
+			w.done <- acks[i]
+		}
+	}
+
+	q.lock.Lock()
+	for _, w := range batch {
+		id := w.mutation.Note.Id
+		q.inFlight[id]--
+		if q.inFlight[id] == 0 {
+			delete(q.inFlight, id)
+		}
+	}
+	q.sending--
+	q.cond.Signal()
+	q.lock.Unlock()
+}
+
+// replicate sends the write to the backup, through its queue unless batching is disabled
+func (h *Handler) replicate(replica string, mutation replication.Mutation) replication.Ack {
+	if h.pipeline == nil {
+		return h.backup(replica, mutation)
+	}
+	return h.pipeline.write(replica, mutation)
+}
+
+// queueReplicate queues the write to the backup, the answer of the backup is sent to the returned channel
+// When batching is disabled, the write is sent right away and this returns once the backup answered
+func (h *Handler) queueReplicate(replica string, mutation replication.Mutation) <-chan replication.Ack {
+	if h.pipeline == nil {
+		done := make(chan replication.Ack, 1)
+		done <- h.backup(replica, mutation)
+		return done
+	}
+	return h.pipeline.enqueue(replica, mutation)
+}
+
+// eachPeer runs fn for every peer and waits for all of them, at once when writes are batched, one after another otherwise
+func (h *Handler) eachPeer(fn func(replica string)) {
+	if h.pipeline == nil {
+		for _, peer := range h.peers() {
+			fn(peer)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, peer := range h.peers() {
+		wg.Add(1)
+		go func(replica string) {
+			defer wg.Done()
+			fn(replica)
+		}(peer)
+	}
+	wg.Wait()
+}
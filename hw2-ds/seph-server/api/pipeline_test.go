@@ -0,0 +1,229 @@
+package api
+
+import (
+	"errors"
+	"seph/common"
+	"seph/replication"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mutation returns a write of the note
+func mutation(id int) replication.Mutation {
+	return replication.Mutation{Method: "PUT", Note: common.Note{Id: id}}
+}
+
+// TestPipelineFlush checks each write gets the answer of the backup to it, and the whole batch fails otherwise
+func TestPipelineFlush(t *testing.T) {
+	errBackup := errors.New("backup failed")
+	errWrite := errors.New("write failed")
+
+	tests := []struct {
+		name string
+		send func(mutations []replication.Mutation) (error, []replication.Ack)
+		want []error
+	}{
+		{"answered", func(mutations []replication.Mutation) (error, []replication.Ack) {
+			acks := make([]replication.Ack, len(mutations))
+			acks[1].Err = errWrite
+			return nil, acks
+		}, []error{nil, errWrite}},
+		{"failed", func(mutations []replication.Mutation) (error, []replication.Ack) {
+			return errBackup, nil
+		}, []error{errBackup, errBackup}},
+		{"answered too few", func(mutations []replication.Mutation) (error, []replication.Ack) {
+			return nil, make([]replication.Ack, len(mutations)-1)
+		}, nil},
+		{"answered too many", func(mutations []replication.Mutation) (error, []replication.Ack) {
+			return nil, make([]replication.Ack, len(mutations)+1)
+		}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := newPipeline(10, 1, func(replica string, mutations []replication.Mutation) (error, []replication.Ack) {
+				return test.send(mutations)
+			})
+			batch := []*pendingWrite{
+				{mutation: mutation(1), done: make(chan replication.Ack, 1)},
+				{mutation: mutation(2), done: make(chan replication.Ack, 1)},
+			}
+			q := p.queue("127.0.0.1:8002")
+			q.lock.Lock()
+			q.inFlight[1], q.inFlight[2] = 1, 1
+			q.sending++
+			q.lock.Unlock()
+
+			p.flush(q, batch)
+			for i, w := range batch {
+				ack := <-w.done
+				if test.want == nil && ack.Err == nil {
+					t.Errorf("write %d succeeded, want the whole batch failed", i)
+				} else if test.want != nil && !errors.Is(ack.Err, test.want[i]) {
+					t.Errorf("write %d = %v, want %v", i, ack.Err, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestPipelineRemove checks the writes queued for a backup which left fail, while the batch in flight is still answered
+func TestPipelineRemove(t *testing.T) {
+	sent := make(chan bool)
+	release := make(chan bool)
+	p := newPipeline(10, 1, func(replica string, mutations []replication.Mutation) (error, []replication.Ack) {
+		sent <- true
+		<-release
+		return nil, make([]replication.Ack, len(mutations))
+	})
+
+	replica := "127.0.0.1:8002"
+	inFlight := make(chan replication.Ack, 1)
+	go func() { inFlight <- p.write(replica, mutation(1)) }()
+	<-sent
+
+	// The note is in flight, so the next write to it waits in the queue
+	queued := make(chan replication.Ack, 1)
+	go func() { queued <- p.write(replica, mutation(1)) }()
+	for {
+		q := p.queue(replica)
+		q.lock.Lock()
+		waiting := len(q.pending)
+		q.lock.Unlock()
+		if waiting != 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	p.remove(replica)
+	if ack := <-queued; !errors.Is(ack.Err, errBackupLeft) {
+		t.Errorf("queued write = %v, want %v", ack.Err, errBackupLeft)
+	}
+
+	close(release)
+	if ack := <-inFlight; ack.Err != nil {
+		t.Errorf("write in flight = %v, want answered", ack.Err)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.queues) != 0 {
+		t.Errorf("queues = %d, want removed", len(p.queues))
+	}
+}
+
+// TestPipelineOrder checks a backup gets the writes to a note in the order they were queued, even when its batches
+// are in flight at once, and a failed batch only fails the writes in it
+func TestPipelineOrder(t *testing.T) {
+	var lock sync.Mutex
+	versions := make(map[int][]int64) // Versions of each note in the order the backup got them
+	p := newPipeline(2, 4, func(replica string, mutations []replication.Mutation) (error, []replication.Ack) {
+		lock.Lock()
+		defer lock.Unlock()
+		failed := false
+		for _, m := range mutations {
+			versions[m.Note.Id] = append(versions[m.Note.Id], m.Note.Version)
+			failed = failed || m.Note.Version == 3
+		}
+		if failed {
+			return errors.New("backup failed"), nil
+		}
+		return nil, make([]replication.Ack, len(mutations))
+	})
+
+	var answers []<-chan replication.Ack
+	for version := int64(1); version <= 5; version++ {
+		for id := 1; id <= 3; id++ {
+			answers = append(answers, p.enqueue("127.0.0.1:8002", replication.Mutation{Method: "PUT",
+				Note: common.Note{Id: id, Version: version}}))
+		}
+	}
+
+	failed := 0
+	for _, answer := range answers {
+		if ack := <-answer; ack.Err != nil {
+			failed++
+		}
+	}
+	if failed == 0 || failed == len(answers) {
+		t.Errorf("%d out of %d writes failed, want only the batches of version 3", failed, len(answers))
+	}
+
+	for id, got := range versions {
+		for i := range got {
+			if got[i] != int64(i+1) {
+				t.Errorf("note %d got versions %v, want in order", id, got)
+				break
+			}
+		}
+	}
+}
+
+// TestLockNote checks the writes of the leader to a note wait for each other, but not for the writes to other notes
+func TestLockNote(t *testing.T) {
+	h := &Handler{notesInFlight: make(map[int]*noteLock)}
+	unlock := h.lockNote(1)
+
+	locked := make(chan func())
+	go func() { locked <- h.lockNote(2) }()
+	select {
+	case unlockOther := <-locked:
+		unlockOther()
+	case <-time.After(time.Second):
+		t.Fatal("writing another note waited for the locked note")
+	}
+
+	go func() { locked <- h.lockNote(1) }()
+	select {
+	case <-locked:
+		t.Fatal("writing the locked note did not wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	(<-locked)()
+
+	h.notesLock.Lock()
+	defer h.notesLock.Unlock()
+	if len(h.notesInFlight) != 0 {
+		t.Errorf("notes in flight = %d, want none once unlocked", len(h.notesInFlight))
+	}
+}
+
+func TestLeftMembers(t *testing.T) {
+	membership := func(addresses ...string) common.Membership {
+		m := common.Membership{}
+		for i, address := range addresses {
+			m.Members = append(m.Members, common.Member{Id: address, Address: address, Slot: i})
+		}
+		return m
+	}
+
+	tests := []struct {
+		name    string
+		old     common.Membership
+		current common.Membership
+		left    []string
+	}{
+		{"unchanged", membership("a", "b"), membership("a", "b"), nil},
+		{"joined", membership("a"), membership("a", "b"), nil},
+		{"left", membership("a", "b", "c"), membership("a", "c"), []string{"b"}},
+		{"replaced", membership("a", "b"), membership("a", "c"), []string{"b"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			left := leftMembers(test.old, test.current)
+			if len(left) != len(test.left) {
+				t.Fatalf("left = %v, want %v", left, test.left)
+			}
+			for i := range left {
+				if left[i] != test.left[i] {
+					t.Errorf("left = %v, want %v", left, test.left)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,485 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"seph/common"
+	"seph/ds"
+	"seph/logger"
+	"seph/misc"
+	"seph/replication"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// errNoteNotFound is returned when none of the replicas in the read quorum had the note
+var errNoteNotFound = errors.New("non existing ID")
+
+// quorumGetAll is for [GET] /quorum API
+// This returns all notes stored in this replica only, including the tombstones of deleted notes
+func (h *Handler) quorumGetAll(c *gin.Context) {
+	c.JSON(http.StatusOK, h.dsh.ReadAllRaw())
+}
+
+// quorumGetSpecific is for [GET] /quorum/{0-9} API
+// This returns the note or its tombstone stored in this replica only, or 404 if there was none
+func (h *Handler) quorumGetSpecific(c *gin.Context) {
+	// Read ID param from API
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		errResponse := common.NoteErrorResponse{
+			Msg:    "wrong URI, ID was invalid",
+			Method: c.Request.Method,
+			Uri:    c.Request.RequestURI,
+			Body:   "",
+		}
+
+		c.JSON(http.StatusBadRequest, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceReplica).With("reply", errResponse))
+		return
+	}
+
+	err, note := h.dsh.ReadRaw(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "non existing ID"})
+		return
+	}
+
+	c.JSON(http.StatusOK, note)
+}
+
+// quorumUpdate is for [PUT] /quorum API
+// The note is stored only if it is newer than the one this replica has, older notes are rejected as conflicts
+func (h *Handler) quorumUpdate(c *gin.Context) {
+	// Try parsing body JSON
+	err, reqNote := clientRequest(c)
+	if err != nil {
+		errResponse := common.NoteErrorResponse{
+			Msg:    err.Error(),
+			Method: c.Request.Method,
+			Uri:    c.Request.RequestURI,
+			Body:   "", // When json parsing failed, we regard body as empty
+		}
+
+		c.JSON(http.StatusBadRequest, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceReplica).With("reply", errResponse))
+		return
+	}
+
+	err, written := h.dsh.WriteNoteIfNewer(reqNote)
+	if err != nil {
+		errResponse := common.NoteErrorResponse{
+			Msg:    err.Error(),
+			Method: c.Request.Method,
+			Uri:    c.Request.RequestURI,
+			Body:   fmt.Sprintf("%v", reqNote),
+		}
+
+		status := http.StatusInternalServerError
+		if errors.Is(err, ds.ErrStaleVersion) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceReplica).With("reply", errResponse))
+		return
+	}
+
+	logger.Debug("Applied quorum write", logger.Fields{"note_id": reqNote.Id, "version": reqNote.Version, "written": written})
+	c.JSON(http.StatusOK, reqNote)
+}
+
+// handleQuorumWrite handles writes in quorum mode
+// The newest version of the note is read from the read quorum, then the new version is sent to all replicas
+// This succeeds once the write quorum of replicas acknowledged the new version on top of the version which was read
+func (h *Handler) handleQuorumWrite(c *gin.Context, note common.Note) (error, common.Note) {
+	var base int64
+	if strings.Contains(c.Request.Method, "POST") {
+		// Assign a new ID, every replica has its own stripe of IDs so they never collide
+		err, newID := h.dsh.AssignNewID()
+		if err != nil {
+			return err, common.Note{}
+		}
+
+		note.Id = newID
+		h.touchNote(&note, 1)
+	} else if strings.Contains(c.Request.Method, "PUT") || strings.Contains(c.Request.Method, "PATCH") {
+		err, original := h.quorumRead(note.Id)
+		if err != nil {
+			return err, common.Note{}
+		}
+
+		// The client might only want to write if nobody else modified the note
+		err = checkIfMatch(c.GetHeader("If-Match"), original)
+		if err != nil {
+			return err, common.Note{}
+		}
+
+		// Put will just overwrite, patch is applied to the newest version of the note
+		if strings.Contains(c.Request.Method, "PUT") {
+			original.Title = note.Title
+			original.Body = note.Body
+		} else {
+			err, original = patchNote(c, original)
+			if err != nil {
+				return err, common.Note{}
+			}
+		}
+
+		base = original.Version
+		note = original
+		h.touchNote(&note, base+1)
+	} else {
+		return errors.New("unknown method"), common.Note{}
+	}
+
+	err := h.quorumWriteNote(note, base, idempotencyOf(c).write(c.Request.Method, note))
+	if err != nil {
+		return err, common.Note{}
+	}
+
+	return nil, note
+}
+
+// quorumWriteNote sends the note made from the base version to all replicas,
+// this succeeds once the write quorum of replicas stored it
+// Replicas which already stored a version above the base reject the note, since another write got there first
+// If the write quorum was not reached because of them, this returns errPreconditionFailed
+// Every replica storing the note remembers the idempotency key of the write as well, if the client made it with one
+func (h *Handler) quorumWriteNote(note common.Note, base int64, write *common.IdempotentWrite) error {
+	var conflicts atomic.Int32
+	err, _ := h.fanOut(h.writeQuorumSize(), func(replica string) (error, interface{}) {
+		var err error
+		if isSelf(replica) {
+			err = h.dsh.WriteNoteOnBase(note, base)
+			if err == nil {
+				h.rememberWrite(write)
+			}
+		} else {
+			err = h.client.WriteNoteOnBase(replica, note, base, write)
+		}
+
+		if errors.Is(err, ds.ErrVersionConflict) || replication.StatusOf(err) == http.StatusPreconditionFailed {
+			conflicts.Add(1)
+		}
+		return err, nil
+	})
+	if err != nil {
+		logger.Error("Could not reach write quorum",
+			logger.Fields{"note_id": note.Id, "write_quorum": h.writeQuorumSize(), "conflicts": conflicts.Load(), "err": err})
+		if conflicts.Load() != 0 {
+			return fmt.Errorf("%w: %v", errPreconditionFailed, err)
+		}
+	}
+
+	return err
+}
+
+// handleQuorumDelete deletes the note by writing its tombstone to all replicas
+// This succeeds once the write quorum of replicas stored the tombstone
+// If ifMatch was not empty, the note is deleted only if the newest version in the read quorum matches
+// Deleting a note which does not exist is regarded as a success
+func (h *Handler) handleQuorumDelete(id int, ifMatch string, idempotency *idempotencyRequest) error {
+	err, current := h.quorumRead(id)
+	if errors.Is(err, errNoteNotFound) && len(ifMatch) == 0 {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	err = checkIfMatch(ifMatch, current)
+	if err != nil {
+		return err
+	}
+
+	tombstone := common.Note{Id: id, Deleted: true}
+	h.touchNote(&tombstone, current.Version+1)
+	return h.quorumWriteNote(tombstone, current.Version, idempotency.write(http.MethodDelete, tombstone))
+}
+
+// quorumReply is the note a single replica of the read quorum had, found is false if the replica did not have it
+type quorumReply struct {
+	replica string
+	note    common.Note
+	found   bool
+}
+
+// quorumRead reads the note from the read quorum of replicas, and returns the newest version among them
+// Replicas of the read quorum which had an older version, or the other version of a concurrent write, are repaired
+// If none of the replicas had the note, this returns errNoteNotFound
+func (h *Handler) quorumRead(id int) (error, common.Note) {
+	err, results := h.fanOut(h.readQuorumSize(), func(replica string) (error, interface{}) {
+		if isSelf(replica) {
+			err, note := h.dsh.ReadRaw(id)
+			// Missing notes are valid answers as well
+			return nil, quorumReply{replica: replica, note: note, found: err == nil}
+		}
+
+		err, note, found := h.client.FetchNote(replica, id)
+		if err != nil {
+			return err, nil
+		}
+		return nil, quorumReply{replica: replica, note: note, found: found}
+	})
+	if err != nil {
+		logger.Error("Could not reach read quorum", logger.Fields{"note_id": id, "read_quorum": h.readQuorumSize(), "err": err})
+		return err, common.Note{}
+	}
+
+	// Pick the newest version
+	found := false
+	var newest common.Note
+	for _, result := range results {
+		reply := result.(quorumReply)
+		if reply.found && (!found || ds.Newer(reply.note, newest)) {
+			newest = reply.note
+			found = true
+		}
+	}
+	if found {
+		h.readRepair(newest, results)
+	}
+
+	// The newest version might be the tombstone of a deleted note
+	if !found || newest.Deleted {
+		return errNoteNotFound, common.Note{}
+	}
+	return nil, newest
+}
+
+// readRepair writes the newest version of the note to the replicas of the read quorum which replied another one
+// Repairs are made in the background, so the read does not wait for them, and failed ones are left to anti-entropy
+func (h *Handler) readRepair(newest common.Note, results []interface{}) {
+	for _, result := range results {
+		reply := result.(quorumReply)
+		if reply.found && !ds.Newer(newest, reply.note) {
+			continue
+		}
+
+		logger.Debug("Repairing replica with newest version",
+			logger.Fields{"note_id": newest.Id, "replica": reply.replica, "version": newest.Version})
+		go func(replica string) {
+			var err error
+			if isSelf(replica) {
+				err, _ = h.dsh.WriteNoteIfNewer(newest)
+			} else {
+				err = h.client.WriteNote(replica, newest, nil)
+			}
+			if err != nil {
+				logger.Warn("Could not repair replica", logger.Fields{"note_id": newest.Id, "replica": replica, "err": err})
+			}
+		}(reply.replica)
+	}
+}
+
+// pageReply is the page a single replica of the read quorum replied, full if it might have had more notes
+type pageReply struct {
+	replica string
+	notes   []common.Note
+	full    bool
+}
+
+// quorumReadPage reads a page of notes from the read quorum of replicas, and returns the newest version of each note
+// Each replica replies its own first notes, as many as the page, the notes before it and one more, which are merged
+// Notes a replica left out of its page are checked against the replica, since it might have a newer version of them
+// which was deleted or moved elsewhere in the sort order, in which case the page is taken from all notes instead
+func (h *Handler) quorumReadPage(query ds.NoteQuery) (error, ds.NotePage) {
+	first := query
+	first.Offset = 0
+	first.Limit = query.Limit + 1
+	if query.After == nil {
+		first.Limit += query.Offset
+	}
+
+	err, results := h.fanOut(h.readQuorumSize(), func(replica string) (error, interface{}) {
+		if isSelf(replica) {
+			err, page := h.dsh.ReadPage(first)
+			return err, pageReply{replica: replica, notes: page.Notes, full: page.More}
+		}
+
+		err, notes, more := h.client.FetchPage(replica, pageQueryOf(first))
+		return err, pageReply{replica: replica, notes: notes, full: more}
+	})
+	if err != nil {
+		logger.Error("Could not reach read quorum", logger.Fields{"read_quorum": h.readQuorumSize(), "err": err})
+		return err, ds.NotePage{}
+	}
+
+	// Merge the pages, keeping the newest version of each note
+	newest := make(map[int]common.Note)
+	for _, result := range results {
+		for _, note := range result.(pageReply).notes {
+			if existing, ok := newest[note.Id]; !ok || ds.Newer(note, existing) {
+				newest[note.Id] = note
+			}
+		}
+	}
+
+	for _, result := range results {
+		reply := result.(pageReply)
+		if !h.pageCurrent(reply, newest) {
+			logger.Debug("Replica had other versions of the page, reading all notes instead", logger.Fields{"replica": reply.replica})
+			err, notes := h.quorumReadAll()
+			if err != nil {
+				return err, ds.NotePage{}
+			}
+			return nil, ds.PageNotes(notes, query)
+		}
+	}
+
+	notes := make([]common.Note, 0, len(newest))
+	for _, note := range newest {
+		notes = append(notes, note)
+	}
+	return nil, ds.PageNotes(notes, query)
+}
+
+// pageCurrent returns if the page of the replica agrees with the newest versions merged from the read quorum
+// Older versions only matter when the page was full, since they might have taken the place of notes after them
+func (h *Handler) pageCurrent(reply pageReply, newest map[int]common.Note) bool {
+	replied := make(map[int]bool, len(reply.notes))
+	for _, note := range reply.notes {
+		replied[note.Id] = true
+		if reply.full && ds.Newer(newest[note.Id], note) {
+			return false
+		}
+	}
+
+	missing := make([]int, 0)
+	for id := range newest {
+		if !replied[id] {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return true
+	}
+
+	var notes []common.Note
+	if isSelf(reply.replica) {
+		for _, id := range missing {
+			if err, note := h.dsh.ReadRaw(id); err == nil {
+				notes = append(notes, note)
+			}
+		}
+	} else {
+		var err error
+		err, notes = h.client.FetchNotes(reply.replica, missing)
+		if err != nil {
+			logger.Warn("Could not check page against replica", logger.Fields{"replica": reply.replica, "err": err})
+			return false
+		}
+	}
+
+	for _, note := range notes {
+		if ds.Newer(note, newest[note.Id]) {
+			return false
+		}
+	}
+	return true
+}
+
+// quorumReadAll reads all notes from the read quorum of replicas, and returns the newest version of each note
+// Notes whose newest version is a tombstone are left out
+func (h *Handler) quorumReadAll() (error, []common.Note) {
+	err, results := h.fanOut(h.readQuorumSize(), func(replica string) (error, interface{}) {
+		if isSelf(replica) {
+			return nil, h.dsh.ReadAllRaw()
+		}
+
+		err, notes := h.client.FetchAll(replica)
+		return err, notes
+	})
+	if err != nil {
+		logger.Error("Could not reach read quorum", logger.Fields{"read_quorum": h.readQuorumSize(), "err": err})
+		return err, nil
+	}
+
+	// Merge all notes, keeping the newest version of each
+	newest := make(map[int]common.Note)
+	for _, result := range results {
+		notes, _ := result.([]common.Note)
+		for _, note := range notes {
+			if existing, ok := newest[note.Id]; !ok || ds.Newer(note, existing) {
+				newest[note.Id] = note
+			}
+		}
+	}
+
+	allNotes := make([]common.Note, 0, len(newest))
+	for _, note := range newest {
+		if !note.Deleted {
+			allNotes = append(allNotes, note)
+		}
+	}
+	sort.Slice(allNotes, func(i, j int) bool { return allNotes[i].Id < allNotes[j].Id })
+
+	return nil, allNotes
+}
+
+// writeQuorumSize returns the number of replicas a write must succeed on, the majority of the members unless configured
+func (h *Handler) writeQuorumSize() int {
+	if h.writeQuorum != 0 {
+		return h.writeQuorum
+	}
+	return len(h.replicas())/2 + 1
+}
+
+// readQuorumSize returns the number of replicas a read must succeed on, the majority of the members unless configured
+func (h *Handler) readQuorumSize() int {
+	if h.readQuorum != 0 {
+		return h.readQuorum
+	}
+	return len(h.replicas())/2 + 1
+}
+
+// fanOut runs fn against all replicas in parallel, and returns as soon as need replicas succeeded
+// The results of the succeeded replicas are returned, the rest of the replicas keep going in the background
+// If it became impossible to get enough successes, this returns error
+func (h *Handler) fanOut(need int, fn func(replica string) (error, interface{})) (error, []interface{}) {
+	type reply struct {
+		replica string
+		err     error
+		result  interface{}
+	}
+
+	// The channel is buffered, so the late replies do not block the goroutines
+	replicas := h.replicas()
+	replies := make(chan reply, len(replicas))
+	for _, replica := range replicas {
+		go func(replica string) {
+			// Down replicas fail right away, so that the quorum is decided without waiting for them
+			if h.peerDown(replica) {
+				replies <- reply{replica: replica, err: errPeerDown}
+				return
+			}
+			err, result := fn(replica)
+			replies <- reply{replica: replica, err: err, result: result}
+		}(replica)
+	}
+
+	results := make([]interface{}, 0, need)
+	failures := 0
+	for i := 0; i < len(replicas); i++ {
+		r := <-replies
+		if r.err != nil {
+			logger.Warn("Replica failed during quorum operation", logger.Fields{"replica": r.replica, "err": r.err})
+			failures++
+			if len(replicas)-failures < need {
+				break
+			}
+			continue
+		}
+
+		results = append(results, r.result)
+		if len(results) >= need {
+			return nil, results
+		}
+	}
+
+	msg := fmt.Sprintf("only %d out of %d replicas succeeded, %d required", len(results), len(replicas), need)
+	return errors.New(msg), nil
+}
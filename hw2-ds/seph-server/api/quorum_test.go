@@ -0,0 +1,119 @@
+package api
+
+import (
+	"errors"
+	"google.golang.org/grpc"
+	"net"
+	"seph/common"
+	"seph/ds"
+	"seph/misc"
+	"seph/replication"
+	"seph/replication/replicationpb"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// quorumPeer is another replica of a quorum cluster, serving the internal protocol on its service port plus one
+type quorumPeer struct {
+	addr string
+	dsh  *ds.Handler
+}
+
+// startQuorumPeer starts a replica which stores the notes, and returns it with the address other replicas know it by
+func startQuorumPeer(t *testing.T, notes ...common.Note) quorumPeer {
+	t.Helper()
+
+	err, dsh := ds.New(misc.StorageMemory, t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("could not open handler: %v", err)
+	}
+	for _, note := range notes {
+		if err := dsh.WriteNote(note); err != nil {
+			t.Fatalf("could not write note: %v", err)
+		}
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	server := grpc.NewServer()
+	replicationpb.RegisterReplicationServer(server, &internalServer{h: &Handler{dsh: dsh, syncMode: misc.SyncQuorum}})
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	return quorumPeer{addr: net.JoinHostPort("127.0.0.1", strconv.Itoa(port-1)), dsh: dsh}
+}
+
+// downAddress returns the address of a replica which does not answer
+func downAddress(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(port-1))
+}
+
+// TestQuorumWriteNote checks a write made from an old version fails with a precondition failure once replicas which
+// stored a newer version keep it from the write quorum, while replicas which only fail keep it a plain failure
+func TestQuorumWriteNote(t *testing.T) {
+	now := time.Now().UTC()
+	old := common.Note{Id: 1, Title: "old", Version: 3, LastModified: now, Writer: "127.0.0.1:1"}
+	other := common.Note{Id: 1, Title: "other", Version: 4, LastModified: now, Writer: "127.0.0.1:2"}
+	note := common.Note{Id: 1, Title: "mine", Version: 4, LastModified: now.Add(time.Millisecond), Writer: "127.0.0.1:3"}
+
+	tests := []struct {
+		name         string
+		peers        []*common.Note // Note each peer stores, nil for a peer which is down
+		precondition bool
+		failed       bool
+	}{
+		{"every replica on the base", []*common.Note{&old, &old}, false, false},
+		{"one replica took another write", []*common.Note{&old, &other}, false, false},
+		{"quorum took another write", []*common.Note{&other, &other}, true, true},
+		{"one replica took another write and one is down", []*common.Note{&other, nil}, true, true},
+		{"peers are down", []*common.Note{nil, nil}, false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			self := downAddress(t)
+			t.Setenv("REPLICA_ID", self)
+			replicas := []string{self}
+			for _, stored := range test.peers {
+				if stored == nil {
+					replicas = append(replicas, downAddress(t))
+				} else {
+					replicas = append(replicas, startQuorumPeer(t, *stored).addr)
+				}
+			}
+
+			err, dsh := ds.New(misc.StorageMemory, t.TempDir(), nil)
+			if err != nil {
+				t.Fatalf("could not open handler: %v", err)
+			}
+			if err := dsh.WriteNote(old); err != nil {
+				t.Fatalf("could not write note: %v", err)
+			}
+			err, members := newMemberList(t.TempDir(), replicas)
+			if err != nil {
+				t.Fatalf("could not make members: %v", err)
+			}
+			h := &Handler{dsh: dsh, members: members, client: replication.New(time.Second, 0, time.Millisecond, 1)}
+
+			err = h.quorumWriteNote(note, old.Version, nil)
+			if failed := err != nil; failed != test.failed {
+				t.Fatalf("err = %v, want failed %v", err, test.failed)
+			}
+			if precondition := errors.Is(err, errPreconditionFailed); precondition != test.precondition {
+				t.Errorf("err = %v, want precondition failure %v", err, test.precondition)
+			}
+		})
+	}
+}
@@ -0,0 +1,441 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"seph/common"
+	"seph/ds"
+	"seph/logger"
+	"seph/metrics"
+	"seph/misc"
+	"seph/replication"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// headerForwarded marks requests a follower forwarded to the leader, so that they are never forwarded again
+const headerForwarded = replication.HeaderForwarded
+
+// raftTimeout is how long a single Raft operation may take, such as committing a command
+const raftTimeout = 5 * time.Second
+
+// startRaft starts the Raft node of this replica, keeping its log and snapshots in $SEPH_DATA
+// The ID of this replica in the Raft cluster is its address in the replicas
+// When there was no Raft state yet, the replicas in the config are bootstrapped as the initial cluster
+// A replica which is not in the config never bootstraps, it waits until the leader adds it as a member
+func (h *Handler) startRaft() error {
+	self := h.self()
+	dir := os.Getenv("SEPH_DATA")
+
+	config := raft.DefaultConfig()
+	config.LocalID = raft.ServerID(self)
+	config.SnapshotThreshold = uint64(h.raftSnapshotThreshold)
+	config.Logger = hclog.New(&hclog.LoggerOptions{Name: "raft", Level: hclog.Warn, Output: os.Stderr})
+
+	err, store := ds.OpenRaftStore(dir)
+	if err != nil {
+		return err
+	}
+
+	logs, err := raft.NewLogCache(512, store)
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := raft.NewFileSnapshotStore(dir, 2, os.Stderr)
+	if err != nil {
+		msg := fmt.Sprintf("could not open raft snapshots in %s: %v", path.Join(dir, "snapshots"), err)
+		return errors.New(msg)
+	}
+
+	// Other replicas dial the advertised address, so it must not be 0.0.0.0
+	advertise, err := net.ResolveTCPAddr("tcp", h.raftAddr(self))
+	if err != nil {
+		return err
+	}
+	bind := fmt.Sprintf("%s:%d", h.addr, advertise.Port)
+	transport, err := raft.NewTCPTransport(bind, advertise, 3, raftTimeout, os.Stderr)
+	if err != nil {
+		msg := fmt.Sprintf("could not listen for raft on %s: %v", bind, err)
+		return errors.New(msg)
+	}
+
+	r, err := raft.NewRaft(config, h.dsh.RaftFSM(), logs, store, snapshots, transport)
+	if err != nil {
+		return err
+	}
+	h.raft.Store(r)
+
+	hasState, err := raft.HasExistingState(logs, store, snapshots)
+	if err != nil {
+		return err
+	}
+	if !hasState && h.replicaIndex(self) < len(h.seeds) {
+		servers := make([]raft.Server, 0, len(h.seeds))
+		for _, replica := range h.seeds {
+			servers = append(servers, raft.Server{ID: raft.ServerID(replica), Address: raft.ServerAddress(h.raftAddr(replica))})
+		}
+
+		// Every replica in the config bootstraps the same cluster, which is safe
+		err = r.BootstrapCluster(raft.Configuration{Servers: servers}).Error()
+		if err != nil && !errors.Is(err, raft.ErrCantBootstrap) {
+			return err
+		}
+		logger.Info("Bootstrapped raft cluster", logger.Fields{"servers": len(servers)})
+	} else if !hasState {
+		logger.Info("Waiting to be added to the raft cluster", logger.Fields{"id": self, "address": h.raftAddr(self)})
+	}
+
+	logger.Info("Now starting raft", logger.Fields{"id": self, "address": h.raftAddr(self)})
+	return nil
+}
+
+// raftAddr returns the address Raft of the replica listens on, which is its service port plus the offset
+func (h *Handler) raftAddr(replica string) string {
+	host, port, err := net.SplitHostPort(replica)
+	if err != nil {
+		return replica
+	}
+
+	servicePort, err := strconv.Atoi(port)
+	if err != nil {
+		return replica
+	}
+	return net.JoinHostPort(host, strconv.Itoa(servicePort+h.raftPortOffset))
+}
+
+// raftRedirect is a gin middleware which lets only the leader handle the request
+// Followers forward the request to the leader and relay its reply, so that clients can talk to any replica
+// Reads on the leader make sure it is still the leader first, so that they never return stale notes
+func (h *Handler) raftRedirect() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r := h.raft.Load()
+		if r == nil {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"msg": "raft is not ready yet"})
+			return
+		}
+
+		if r.State() == raft.Leader {
+			if c.Request.Method == http.MethodGet {
+				err := r.VerifyLeader().Error()
+				if err != nil {
+					c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"msg": err.Error()})
+					return
+				}
+			}
+
+			c.Next()
+			return
+		}
+
+		// The leader changed while the request was forwarded, the client should just retry
+		_, leader := r.LeaderWithID()
+		if len(leader) == 0 || len(c.GetHeader(headerForwarded)) != 0 {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"msg": errNoLeader.Error()})
+			return
+		}
+
+		h.raftForward(c, string(leader))
+		c.Abort()
+	}
+}
+
+// raftForward forwards the request to the leader, and relays the reply to the client
+func (h *Handler) raftForward(c *gin.Context, leader string) {
+	logger.Debug("Forward request to leader", logger.Fields{"source": misc.SourceReplica, "leader": leader})
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+		return
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", c.GetHeader("Content-Type"))
+	header.Set("If-Match", c.GetHeader("If-Match"))
+	header.Set(headerConsistency, c.GetHeader(headerConsistency))
+	header.Set(headerSession, c.GetHeader(headerSession))
+	header.Set(headerIdempotencyKey, c.GetHeader(headerIdempotencyKey))
+	header.Set(headerForwarded, h.self())
+
+	err, reply := h.client.Forward(leader, c.Request.Method, c.Request.RequestURI, body, header)
+	if err != nil {
+		logger.Warn("Could not forward request to leader", logger.Fields{"leader": leader, "err": err})
+		c.JSON(http.StatusServiceUnavailable, gin.H{"msg": err.Error()})
+		return
+	}
+
+	for _, name := range []string{"ETag", "Last-Modified", "Accept-Patch", "Link", headerNextCursor, headerConsistency, headerSession, headerIdempotentReplayed} {
+		if value := reply.Header.Get(name); len(value) != 0 {
+			c.Header(name, value)
+		}
+	}
+	c.Data(reply.Status, reply.Header.Get("Content-Type"), reply.Body)
+}
+
+// handleRaftWrite handles writes in raft mode, this must be called only on the leader
+// Writes are serialized, so that every write reads the result of the previous one before it is committed
+// The note is committed once a majority of the replicas logged it, and applied to this replica before returning
+func (h *Handler) handleRaftWrite(c *gin.Context, note common.Note) (error, common.Note) {
+	h.raftLock.Lock()
+	defer h.raftLock.Unlock()
+
+	err := h.raftCatchUp()
+	if err != nil {
+		return err, common.Note{}
+	}
+
+	if strings.Contains(c.Request.Method, "POST") {
+		err, newID := h.dsh.AssignNewID()
+		if err != nil {
+			return err, common.Note{}
+		}
+
+		note.Id = newID
+		h.touchNote(&note, 1)
+	} else if strings.Contains(c.Request.Method, "PUT") || strings.Contains(c.Request.Method, "PATCH") {
+		err, original := h.dsh.ReadSpecific(note.Id)
+		if err != nil {
+			logger.Warn("Unable to find existing note", logger.Fields{"note_id": note.Id, "err": err})
+			return err, common.Note{}
+		}
+
+		// The client might only want to write if nobody else modified the note
+		err = checkIfMatch(c.GetHeader("If-Match"), original)
+		if err != nil {
+			return err, common.Note{}
+		}
+
+		// Put will just overwrite, patch is applied to the newest version of the note
+		if strings.Contains(c.Request.Method, "PUT") {
+			original.Title = note.Title
+			original.Body = note.Body
+		} else {
+			err, original = patchNote(c, original)
+			if err != nil {
+				return err, common.Note{}
+			}
+		}
+
+		note = original
+		h.touchNote(&note, original.Version+1)
+	} else {
+		return errors.New("unknown method"), common.Note{}
+	}
+
+	err = h.raftApply(note, idempotencyOf(c).write(c.Request.Method, note))
+	if err != nil {
+		return err, common.Note{}
+	}
+
+	return nil, note
+}
+
+// handleRaftDelete deletes the note by committing its tombstone, this must be called only on the leader
+// If ifMatch was not empty, the note is deleted only if its current version matches
+func (h *Handler) handleRaftDelete(id int, ifMatch string, idempotency *idempotencyRequest) error {
+	h.raftLock.Lock()
+	defer h.raftLock.Unlock()
+
+	err := h.raftCatchUp()
+	if err != nil {
+		return err
+	}
+
+	err, current := h.dsh.ReadSpecific(id)
+	if err != nil {
+		return err
+	}
+
+	err = checkIfMatch(ifMatch, current)
+	if err != nil {
+		return err
+	}
+
+	tombstone := common.Note{Id: id, Deleted: true}
+	h.touchNote(&tombstone, current.Version+1)
+	return h.raftApply(tombstone, idempotency.write(http.MethodDelete, tombstone))
+}
+
+// raftCatchUp waits until every committed command was applied to this replica
+// A new leader might not have applied the commands of the old leader yet, then it would write on top of stale notes
+func (h *Handler) raftCatchUp() error {
+	r := h.raft.Load()
+	if r.AppliedIndex() >= r.LastIndex() {
+		return nil
+	}
+
+	return r.Barrier(raftTimeout).Error()
+}
+
+// raftApply commits the note to the Raft log, and waits until it was applied to this replica
+// The idempotency key of the write is committed along, so that every replica remembers it once applied
+func (h *Handler) raftApply(note common.Note, write *common.IdempotentWrite) error {
+	err, command := ds.RaftCommand(note, write)
+	if err != nil {
+		return err
+	}
+
+	future := h.raft.Load().Apply(command, raftTimeout)
+	err = future.Error()
+	if err != nil {
+		logger.Error("Could not commit raft command", logger.Fields{"note_id": note.Id, "err": err})
+		return err
+	}
+
+	if err, ok := future.Response().(error); ok && err != nil {
+		return err
+	}
+	return nil
+}
+
+// runRaftPurge makes the leader purge the expired tombstones through the Raft log periodically
+// Anti-entropy does not run in raft mode, so tombstones are purged as often as its rounds would run
+// Every replica applies the same purge at the same point of the log, so the notes never diverge
+// This function is blocking function
+func (h *Handler) runRaftPurge() {
+	metrics.Register(metricTombstonesPurged, metrics.TypeCounter, "Number of purged tombstones of deleted notes")
+	if h.antiEntropyInterval <= 0 {
+		logger.Info("Purging tombstones is disabled", logger.Fields{})
+		return
+	}
+
+	ticker := time.NewTicker(h.antiEntropyInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if h.raft.Load().State() != raft.Leader {
+			continue
+		}
+
+		err, purged := h.raftPurge(time.Now().Add(-h.tombstoneTTL))
+		if err != nil {
+			logger.Warn("Could not purge tombstones", logger.Fields{"err": err})
+		} else if purged != 0 {
+			metrics.Add(metricTombstonesPurged, float64(purged))
+			logger.Info("Purged tombstones of deleted notes", logger.Fields{"purged": purged})
+		}
+	}
+}
+
+// raftPurge commits purging the tombstones deleted before the given time, and returns the number of purged ones
+// Nothing is committed when there was nothing to purge, so that idle clusters do not grow their logs
+func (h *Handler) raftPurge(before time.Time) (error, int) {
+	h.raftLock.Lock()
+	defer h.raftLock.Unlock()
+
+	err := h.raftCatchUp()
+	if err != nil {
+		return err, 0
+	}
+	if h.dsh.ExpiredTombstones(before) == 0 {
+		return nil, 0
+	}
+
+	err, command := ds.RaftPurgeCommand(before)
+	if err != nil {
+		return err, 0
+	}
+
+	future := h.raft.Load().Apply(command, raftTimeout)
+	err = future.Error()
+	if err != nil {
+		return err, 0
+	}
+
+	purged, _ := future.Response().(int)
+	return nil, purged
+}
+
+// raftGetStatus is for [GET] /raft/status API
+// This returns the state of the Raft cluster as this replica sees it
+func (h *Handler) raftGetStatus(c *gin.Context) {
+	r := h.raft.Load()
+	if r == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"msg": "raft is not ready yet"})
+		return
+	}
+
+	future := r.GetConfiguration()
+	err := future.Error()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": err.Error()})
+		return
+	}
+
+	members := make([]common.RaftMember, 0)
+	for _, server := range future.Configuration().Servers {
+		members = append(members, common.RaftMember{
+			Id:       string(server.ID),
+			Address:  string(server.Address),
+			Suffrage: server.Suffrage.String(),
+		})
+	}
+
+	_, leader := r.LeaderWithID()
+	c.JSON(http.StatusOK, common.RaftStatus{
+		State:        r.State().String(),
+		Leader:       string(leader),
+		Term:         r.Stats()["term"],
+		LastIndex:    r.LastIndex(),
+		AppliedIndex: r.AppliedIndex(),
+		Members:      members,
+	})
+}
+
+// raftAddMember is for [POST] /raft/members API
+// This adds the replica as a voting member, its Raft address defaults to its service port plus the offset
+func (h *Handler) raftAddMember(c *gin.Context) {
+	var member common.RaftMember
+	err := c.ShouldBindJSON(&member)
+	if err != nil || len(member.Id) == 0 {
+		errResponse := common.NoteErrorResponse{
+			Msg:    "wrong body, id of the member was missing",
+			Method: c.Request.Method,
+			Uri:    c.Request.RequestURI,
+			Body:   "",
+		}
+
+		c.JSON(http.StatusBadRequest, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceReplica).With("reply", errResponse))
+		return
+	}
+
+	if len(member.Address) == 0 {
+		member.Address = h.raftAddr(member.Id)
+	}
+
+	err = h.raft.Load().AddVoter(raft.ServerID(member.Id), raft.ServerAddress(member.Address), 0, raftTimeout).Error()
+	if err != nil {
+		logger.Error("Could not add raft member", logger.Fields{"id": member.Id, "address": member.Address, "err": err})
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": err.Error()})
+		return
+	}
+
+	logger.Info("Added raft member", logger.Fields{"id": member.Id, "address": member.Address})
+	c.JSON(http.StatusOK, member)
+}
+
+// raftRemoveMember is for [DELETE] /raft/members/{id} API
+// This removes the replica from the cluster, removing the leader itself makes the others elect a new one
+func (h *Handler) raftRemoveMember(c *gin.Context) {
+	id := c.Param("id")
+	err := h.raft.Load().RemoveServer(raft.ServerID(id), 0, raftTimeout).Error()
+	if err != nil {
+		logger.Error("Could not remove raft member", logger.Fields{"id": id, "err": err})
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": err.Error()})
+		return
+	}
+
+	logger.Info("Removed raft member", logger.Fields{"id": id})
+	c.JSON(http.StatusOK, gin.H{"msg": "OK"})
+}
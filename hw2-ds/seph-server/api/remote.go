@@ -1,185 +1,81 @@
 package api
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"io"
-	"log"
 	"net/http"
 	"seph/common"
+	"seph/ds"
+	"seph/logger"
 	"seph/misc"
+	"seph/replication"
 	"strconv"
 	"strings"
+	"sync"
 )
 
-// remoteForwardPrimary is for [POST/PUT/PATCH] /primary API
+// remoteForwardPrimary is for [POST/PUT] /primary and [PATCH] /primary/:id API
+// Only the leader accepts this, the other replicas reply 421 along with the current leader
 func (h *Handler) remoteForwardPrimary(c *gin.Context) {
-	// Try parsing body JSON
-	err, reqNote := clientRequest(c)
-	if err != nil {
-		errResponse := common.NoteErrorResponse{
-			Msg:    err.Error(),
-			Method: c.Request.Method,
-			Uri:    c.Request.RequestURI,
-			Body:   "", // When json parsing failed, we regard body as empty
-		}
-
-		// Return bad request, user sent us bad thing!
-		c.JSON(http.StatusBadRequest, errResponse)
-		log.Printf("%s [REPLY][%s] %s %v",
-			misc.ColoredReplica, c.Request.Method, c.Request.RequestURI, errResponse)
+	if h.rejectNotLeader(c) {
 		return
 	}
 
-	// Perform remote write
-
-		// Assign new ID for the new note
-		err, newID := h.dsh.AssignNewID()
-		if err != nil {
-			log.Printf("Unable to assign new ID for note: %v", err)
-			return
+	// Try parsing body JSON, or the patch along with the ID of the note for PATCH
+	var err error
+	var reqNote common.Note
+	if strings.Contains(c.Request.Method, "PATCH") {
+		reqNote.Id, err = strconv.Atoi(c.Param("id"))
+		if err == nil {
+			err, _ = bindPatch(c)
 		}
-
-		reqNote.Id = newID
-	err, newNote := h.performRemoteWrite(c, reqNote)
+	} else {
+		err, reqNote = clientRequest(c)
+	}
 	if err != nil {
 		errResponse := common.NoteErrorResponse{
 			Msg:    err.Error(),
 			Method: c.Request.Method,
 			Uri:    c.Request.RequestURI,
-			Body:   fmt.Sprintf("%v", reqNote),
+			Body:   "", // When json parsing failed, we regard body as empty
 		}
 
 		// Return bad request, user sent us bad thing!
-		c.JSON(http.StatusInternalServerError, errResponse)
-		log.Printf("%s [REPLY][%s] %s %v",
-			misc.ColoredReplica, c.Request.Method, c.Request.RequestURI, errResponse)
+		c.JSON(http.StatusBadRequest, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceReplica).With("reply", errResponse))
 		return
 	}
 
-	// Till here, only the primary knows that a note was written
-	// Serialize the payload to JSON
-	payloadBytes, err := json.Marshal(newNote)
+	// Perform remote write, and tell all replicas to update
+	err, newNote := h.leaderWrite(c, reqNote)
 	if err != nil {
 		errResponse := common.NoteErrorResponse{
 			Msg:    err.Error(),
 			Method: c.Request.Method,
 			Uri:    c.Request.RequestURI,
-			Body:   fmt.Sprintf("%v", newNote),
+			Body:   fmt.Sprintf("%v", reqNote),
 		}
 
-		// Return bad request, user sent us bad thing!
-		c.JSON(http.StatusInternalServerError, errResponse)
-		log.Printf("%s [REPLY][%s] %s %v",
-			misc.ColoredReplica, c.Request.Method, c.Request.RequestURI, errResponse)
+		c.JSON(writeErrorStatus(err), errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceReplica).With("reply", errResponse))
 		return
 	}
 
-	// Now primary shall tell all replicas to update
-	// For all replicas, update
-	for i, replica := range h.replicas {
-		if i == 0 { // Skip current replica
-			continue
-		}
-
-		// Perform request accordingly
-		var response *http.Response
-		endpoint := fmt.Sprintf("http://%s/backup", replica)
-		log.Printf("[DEBUG] propagating to %s", replica)
-		if strings.Contains(c.Request.Method, "POST") { // Forward POST
-			// Create POST Request
-			response, err = http.Post(endpoint, "application/json", bytes.NewBuffer(payloadBytes))
-			if err != nil {
-				log.Printf("Error making POST request to replica %s: %v\n", endpoint, err)
-				errResponse := common.NoteErrorResponse{
-					Msg:    err.Error(),
-					Method: c.Request.Method,
-					Uri:    c.Request.RequestURI,
-					Body:   fmt.Sprintf("%v", reqNote),
-				}
-
-				// Return bad request, user sent us bad thing!
-				c.JSON(http.StatusInternalServerError, errResponse)
-				log.Printf("%s [REPLY][%s] %s %v",
-					misc.ColoredReplica, c.Request.Method, c.Request.RequestURI, errResponse)
-				return
-			}
-		} else if strings.Contains(c.Request.Method, "PUT") ||
-			strings.Contains(c.Request.Method, "PATCH") { // Forward PUT or PATCH
-
-			// Create a new request accordingly
-			request, err := http.NewRequest(c.Request.Method, endpoint, bytes.NewBuffer(payloadBytes))
-			if err != nil {
-				log.Printf("Error creating %s request: %v to replica %s", c.Request.Method, endpoint, err)
-				errResponse := common.NoteErrorResponse{
-					Msg:    err.Error(),
-					Method: c.Request.Method,
-					Uri:    c.Request.RequestURI,
-					Body:   fmt.Sprintf("%v", reqNote),
-				}
-
-				// Return bad request, user sent us bad thing!
-				c.JSON(http.StatusInternalServerError, errResponse)
-				log.Printf("%s [REPLY][%s] %s %v",
-					misc.ColoredReplica, c.Request.Method, c.Request.RequestURI, errResponse)
-				return
-			}
-
-			request.Header.Set("Content-Type", "application/json")
-			// Perform the request
-			client := http.Client{}
-			response, err = client.Do(request)
-			if err != nil {
-				log.Printf("Error making %s request to replica %s: %v\n", c.Request.Method, endpoint, err)
-				errResponse := common.NoteErrorResponse{
-					Msg:    err.Error(),
-					Method: c.Request.Method,
-					Uri:    c.Request.RequestURI,
-					Body:   fmt.Sprintf("%v", reqNote),
-				}
-
-				// Return bad request, user sent us bad thing!
-				c.JSON(http.StatusInternalServerError, errResponse)
-				log.Printf("%s [REPLY][%s] %s %v",
-					misc.ColoredReplica, c.Request.Method, c.Request.RequestURI, errResponse)
-				return
-			}
-		} // I sincerely will ignore the exceptional cases
-
-		// Check status code from the /backup API
-		if response.StatusCode == http.StatusOK {
-			continue
-		} else {
-			log.Printf("Error updating POST request to replica %s: %v\n", endpoint, err)
-			errResponse := common.NoteErrorResponse{
-				Msg:    fmt.Sprintf("replica %s failed to update", replica),
-				Method: c.Request.Method,
-				Uri:    c.Request.RequestURI,
-				Body:   fmt.Sprintf("%v", reqNote),
-			}
-
-			// Return bad request, user sent us bad thing!
-			c.JSON(http.StatusInternalServerError, errResponse)
-			log.Printf("%s [REPLY][%s] %s %v",
-				misc.ColoredReplica, c.Request.Method, c.Request.RequestURI, errResponse)
-			return
-		}
-
-		response.Body.Close()
-	}
-
 	// Everything went on correct
-	log.Printf("%s [REPLY] Forward request to primary", misc.ColoredReplica)
+	logger.Info("Reply", requestFields(c, misc.SourceReplica))
+	setNoteHeaders(c, newNote)
 	c.JSON(http.StatusOK, newNote)
 }
 
 // remoteDeletePrimary is for [DELETE] /primary/{0-9} API
+// Only the leader accepts this, the other replicas reply 421 along with the current leader
 func (h *Handler) remoteDeletePrimary(c *gin.Context) {
-	log.Printf("%s [REQUEST][%s] %s {} ",
-		misc.ColoredReplica, c.Request.Method, c.Request.RequestURI)
+	logger.Info("Request", requestFields(c, misc.SourceReplica))
+	if h.rejectNotLeader(c) {
+		return
+	}
 
 	// Read ID param from API
 	noteID := c.Param("id")
@@ -195,86 +91,47 @@ func (h *Handler) remoteDeletePrimary(c *gin.Context) {
 		}
 
 		c.JSON(http.StatusBadRequest, errResponse)
-		log.Printf("%s [REPLY][%s] %s %v",
-			misc.ColoredReplica, c.Request.Method, c.Request.RequestURI, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceReplica).With("reply", errResponse))
 		return
 	}
 
-	// Perform note delete
-	err = h.performRemoteDelete(id)
+	// Perform note delete, and tell all replicas to delete
+	err = h.leaderDelete(id, c.GetHeader("If-Match"), idempotencyOf(c))
 	response := struct {
 		Msg string `json:"msg"`
 	}{}
 	if err != nil {
 		response.Msg = "FAILED"
-		c.JSON(http.StatusInternalServerError, response)
+		c.JSON(writeErrorStatus(err), response)
+		logger.Info("Reply", requestFields(c, misc.SourceReplica).With("reply", response))
 		return
 	}
 
-	// Till here, only primary knows that a note was deleted
-	// Now primary shall tell all replicas to update
-	// For all replicas, delete
-	for i, replica := range h.replicas {
-		if i == 0 { // Skip current replica
-			continue
-		}
-
-		// Perform request accordingly
-		endpoint := fmt.Sprintf("http://%s/backup/%d", replica, id)
-
-		// Create a new request accordingly
-		request, err := http.NewRequest("DELETE", endpoint, nil)
-		if err != nil {
-			log.Printf("Error creating %s request: %v to replica %s", c.Request.Method, endpoint, err)
-
-			response.Msg = "FAILED"
-			c.JSON(http.StatusInternalServerError, response)
-			log.Printf("%s [REPLY][%s] %s %v",
-				misc.ColoredReplica, c.Request.Method, c.Request.RequestURI, response)
-			return
-		}
-		request.Header.Set("Content-Type", "application/json")
-
-		// Perform the request
-		client := http.Client{}
-		res, err := client.Do(request)
-		if err != nil {
-			log.Printf("Error making %s request to replica %s: %v\n", c.Request.Method, endpoint, err)
-
-			response.Msg = "FAILED"
-			c.JSON(http.StatusInternalServerError, response)
-			log.Printf("%s [REPLY][%s] %s %v",
-				misc.ColoredReplica, c.Request.Method, c.Request.RequestURI, response)
-			return
-		}
-
-		// Check status code from the /backup API
-		if res.StatusCode == http.StatusOK {
-			continue
-		} else {
-			log.Printf("Error upating DELETE request to replica %s: %v\n", endpoint, err)
-
-			response.Msg = "FAILED"
-			c.JSON(http.StatusInternalServerError, response)
-			log.Printf("%s [REPLY][%s] %s %v",
-				misc.ColoredReplica, c.Request.Method, c.Request.RequestURI, response)
-			return
-		}
-
-		res.Body.Close()
-	}
-
 	// Yes, the update went on correctly!
 	response.Msg = "OK"
 	c.JSON(http.StatusOK, response)
-	log.Printf("%s [REPLY] Forward request to primary", misc.ColoredReplica)
+	logger.Info("Reply", requestFields(c, misc.SourceReplica))
 	return
 }
 
 // remoteUpdateBackup is for [POST/PUT/PATCH] /backup API
+// Writes from leaders of older terms are rejected with 421
 func (h *Handler) remoteUpdateBackup(c *gin.Context) {
-	// Try parsing body JSON
-	err, reqNote := clientRequest(c)
+	if h.rejectStaleLeader(c) {
+		return
+	}
+
+	// Try parsing body JSON, or the patch along with the ID of the note for PATCH
+	var err error
+	var reqNote common.Note
+	if strings.Contains(c.Request.Method, "PATCH") {
+		reqNote.Id, err = strconv.Atoi(c.Param("id"))
+		if err == nil {
+			err, _ = bindPatch(c)
+		}
+	} else {
+		err, reqNote = clientRequest(c)
+	}
 	if err != nil {
 		errResponse := common.NoteErrorResponse{
 			Msg:    err.Error(),
@@ -285,13 +142,12 @@ func (h *Handler) remoteUpdateBackup(c *gin.Context) {
 
 		// Return bad request, user sent us bad thing!
 		c.JSON(http.StatusBadRequest, errResponse)
-		log.Printf("%s [REPLY][%s] %s %v",
-			misc.ColoredReplica, c.Request.Method, c.Request.RequestURI, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceReplica).With("reply", errResponse))
 		return
 	}
 
-	// Perform remote write
-	err, newNote := h.performRemoteWrite(c, reqNote)
+	// Apply the note as the primary wrote it, unless this replica already has a newer version
+	err, _ = h.dsh.WriteNoteIfNewer(reqNote)
 	if err != nil {
 		errResponse := common.NoteErrorResponse{
 			Msg:    err.Error(),
@@ -300,20 +156,28 @@ func (h *Handler) remoteUpdateBackup(c *gin.Context) {
 			Body:   fmt.Sprintf("%v", reqNote),
 		}
 
-		// Return bad request, user sent us bad thing!
-		c.JSON(http.StatusInternalServerError, errResponse)
-		log.Printf("%s [REPLY][%s] %s %v",
-			misc.ColoredReplica, c.Request.Method, c.Request.RequestURI, errResponse)
+		// Stale versions are conflicts, anything else is our fault
+		status := http.StatusInternalServerError
+		if errors.Is(err, ds.ErrStaleVersion) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceReplica).With("reply", errResponse))
 		return
 	}
 
 	// This replica's update was successful
-	c.JSON(http.StatusOK, newNote)
+	c.JSON(http.StatusOK, reqNote)
 	return
 }
 
 // remoteDeleteBackup is for [DELETE] /backup/{0-9} API
+// Deletes from leaders of older terms are rejected with 421
 func (h *Handler) remoteDeleteBackup(c *gin.Context) {
+	if h.rejectStaleLeader(c) {
+		return
+	}
+
 	// Read ID param from API
 	noteID := c.Param("id")
 
@@ -328,13 +192,12 @@ func (h *Handler) remoteDeleteBackup(c *gin.Context) {
 		}
 
 		c.JSON(http.StatusBadRequest, errResponse)
-		log.Printf("%s [REPLY][%s] %s %v",
-			misc.ColoredReplica, c.Request.Method, c.Request.RequestURI, errResponse)
+		logger.Warn("Reply", requestFields(c, misc.SourceReplica).With("reply", errResponse))
 		return
 	}
 
 	// Perform note delete
-	err = h.performRemoteDelete(id)
+	err = h.performRemoteDelete(id, "")
 	response := struct {
 		Msg string `json:"msg"`
 	}{}
@@ -350,94 +213,215 @@ func (h *Handler) remoteDeleteBackup(c *gin.Context) {
 }
 
 // handleRemoteWrite handles remote write
+// The leader writes by itself, the other replicas forward the request to the leader
 func (h *Handler) handleRemoteWrite(c *gin.Context, note common.Note) (error, common.Note) {
-	// If this was replica 0, skip forward
-	if misc.IsReplica0() {
-		// Assign new ID for the new note
-		err, newID := h.dsh.AssignNewID()
+	// If this was the leader, skip forward
+	if h.election.isLeader() {
+		return h.leaderWrite(c, note)
+	} else { // If not, forward this request to the primary
+		// Serialize the payload to JSON, patches are forwarded as they are along with the ID of the note
+		err, payloadBytes, contentType := forwardedWrite(c, note)
 		if err != nil {
-			log.Printf("Unable to assign new ID for note: %v", err)
+			logger.Error("Error marshaling JSON payload", logger.Fields{"err": err})
 			return err, common.Note{}
 		}
 
-		// Update note and try creating the note
-		note.Id = newID
-		log.Println("NEWID: ", note.Id)
-		return h.performRemoteWrite(c, note)
-	} else { // If not, forward this request to the primary
-		log.Printf("%s [REQUEST] Forward request to primary", misc.ColoredReplica)
-
-		// Serialize the payload to JSON
-		payloadBytes, err := json.Marshal(note)
+		uri := "/primary"
+		if strings.Contains(c.Request.Method, "PATCH") {
+			uri = fmt.Sprintf("/primary/%d", note.Id)
+		}
+		err, status, body := h.forwardToLeader(c.Request.Method, uri, payloadBytes, contentType, c.GetHeader("If-Match"), idempotencyOf(c))
 		if err != nil {
-			log.Println("Error marshaling JSON payload:", err)
 			return err, common.Note{}
 		}
 
-		// Perform request accordingly
-		var response *http.Response
-		endpoint := fmt.Sprintf("http://%s/primary", h.replicas[0])
-		if strings.Contains(c.Request.Method, "POST") { // Forward POST
-			// Create POST Request
-			response, err = http.Post(endpoint, "application/json", bytes.NewBuffer(payloadBytes))
-			if err != nil {
-				log.Printf("Error making POST request to %s: %v\n", endpoint, err)
-				return err, common.Note{}
-			}
-		} else if strings.Contains(c.Request.Method, "PUT") ||
-			strings.Contains(c.Request.Method, "PATCH") { // Forward PUT or PATCH
-
-			// Create a new request accordingly
-			request, err := http.NewRequest(c.Request.Method, endpoint, bytes.NewBuffer(payloadBytes))
-			if err != nil {
-				log.Printf("Error creating %s request: %v to primary\n", c.Request.Method, err)
-				return err, common.Note{}
-			}
-			request.Header.Set("Content-Type", "application/json")
-
-			// Perform the request
-			client := http.Client{}
-			response, err = client.Do(request)
-			if err != nil {
-				log.Printf("Error making %s request to primary %s: %v\n", c.Request.Method, endpoint, err)
-				return err, common.Note{}
-			}
-		} // I sincerely will ignore the exceptional cases
-
-		defer response.Body.Close()
-
 		// Check if the response status code is OK (200)
-		if response.StatusCode == http.StatusOK {
-			// Read the response body
-			body, err := io.ReadAll(response.Body)
-			if err != nil {
-				log.Println("Error reading response body from primary:", err)
-				return err, common.Note{}
-			}
-
+		if status == http.StatusOK {
 			// Try unmarshalling the body into our note format
 			var newNote common.Note
 			err = json.Unmarshal(body, &newNote)
 			if err != nil {
-				log.Println("Error unmarshalling response from primary:", err)
+				logger.Error("Error unmarshalling response from primary", logger.Fields{"err": err})
 				return err, common.Note{}
 			}
 
 			// Yes this worked
 			return nil, newNote
+		} else if status == http.StatusPreconditionFailed {
+			return errPreconditionFailed, common.Note{}
+		} else if status == http.StatusUnprocessableEntity {
+			return forwardedPatchError(body), common.Note{}
 		} else {
-			log.Println("Error: Non-OK response status code from primary:", response.Status)
+			logger.Error("Non-OK response from primary", logger.Fields{"status": status})
 			return errors.New("non-ok response code"), common.Note{}
 		}
 	}
 }
 
+// leaderWrite writes the note on the leader, and propagates it to the backups
+// Only one write to a note at a time checks If-Match and bumps the version, the backups are waited for once the note
+// was unlocked, so that slow backups hold up neither the writes to other notes nor the next write to the same note
+func (h *Handler) leaderWrite(c *gin.Context, note common.Note) (error, common.Note) {
+	// Assign new ID for the new note, updates keep their IDs
+	if strings.Contains(c.Request.Method, "POST") {
+		err, newID := h.dsh.AssignNewID()
+		if err != nil {
+			logger.Error("Unable to assign new ID for note", logger.Fields{"err": err})
+			return err, common.Note{}
+		}
+
+		note.Id = newID
+		logger.Debug("Assigned new ID for note", logger.Fields{"note_id": note.Id})
+	}
+
+	unlock := h.lockNote(note.Id)
+	err, newNote := h.performRemoteWrite(c, note)
+	if err != nil {
+		unlock()
+		return err, common.Note{}
+	}
+
+	// Till here, only the primary knows that a note was written
+	// Now primary shall tell all replicas to update, in the order of the versions of the note
+	wait := h.propagateRemote(c.Request.Method, newNote, idempotencyOf(c).write(c.Request.Method, newNote))
+	unlock()
+	return wait(), newNote
+}
+
+// leaderDelete deletes the note on the leader, and propagates the delete to the backups
+// If ifMatch was not empty, the note is deleted only if its current version matches
+func (h *Handler) leaderDelete(id int, ifMatch string, idempotency *idempotencyRequest) error {
+	unlock := h.lockNote(id)
+	err := h.performRemoteDelete(id, ifMatch)
+	if err != nil {
+		unlock()
+		return err
+	}
+
+	// Till here, only primary knows that a note was deleted
+	// Now primary shall tell all replicas to delete
+	wait := h.propagateRemote(http.MethodDelete, common.Note{Id: id}, idempotency.write(http.MethodDelete, common.Note{Id: id}))
+	unlock()
+	return wait()
+}
+
+// noteLock serializes the writes of the leader to a single note
+type noteLock struct {
+	lock    sync.Mutex
+	waiters int // Writes holding or waiting for the lock, the lock is dropped once there are none
+}
+
+// lockNote locks the note for a write of the leader, and returns the function which unlocks it
+func (h *Handler) lockNote(id int) func() {
+	h.notesLock.Lock()
+	l, ok := h.notesInFlight[id]
+	if !ok {
+		l = &noteLock{}
+		h.notesInFlight[id] = l
+	}
+	l.waiters++
+	h.notesLock.Unlock()
+
+	l.lock.Lock()
+	return func() {
+		l.lock.Unlock()
+
+		h.notesLock.Lock()
+		l.waiters--
+		if l.waiters == 0 {
+			delete(h.notesInFlight, id)
+		}
+		h.notesLock.Unlock()
+	}
+}
+
+// propagateRemote queues the note the leader just wrote for all backups, or the delete of it for DELETE
+// The note must still be locked, so that each backup gets the writes to the note in the order of their versions
+// The returned function waits until every backup applied the write or got a hint for it,
+// so the client's write is as durable as before
+// Writes to unreachable and down backups are kept as hints, and replayed once the backups are back
+// The idempotency key of the write is remembered by the leader and sent along, if the client made the write with one
+func (h *Handler) propagateRemote(method string, note common.Note, write *common.IdempotentWrite) func() error {
+	h.rememberWrite(write)
+
+	answers := make(map[string]<-chan replication.Ack)
+	for _, replica := range h.peers() {
+		if h.shouldHint(replica) {
+			h.hintWrite(replica, method, note, nil, write)
+			continue
+		}
+
+		logger.Debug("Propagating to replica", logger.Fields{"replica": replica})
+		answers[replica] = h.queueReplicate(replica, replication.Mutation{Method: method, Note: note, Idempotency: write})
+	}
+
+	return func() error {
+		var failed error
+		for replica, answer := range answers {
+			err := (<-answer).Err
+			if replication.IsUnreachable(err) {
+				logger.Warn("Replica is unreachable, keeping hint", logger.Fields{"method": method, "replica": replica, "err": err})
+				h.hintWrite(replica, method, note, nil, write)
+			} else if err != nil {
+				logger.Error("Replica failed to update", logger.Fields{"method": method, "replica": replica, "err": err})
+				failed = err
+			}
+		}
+		return failed
+	}
+}
+
+// forwardToLeader sends the request to the leader, and returns the status code and the body of the reply
+// The idempotency key of the client is sent along, so that the leader remembers the write with it
+// If the replica was not the leader anymore, this retries once against the leader it told
+func (h *Handler) forwardToLeader(method string, uri string, payload []byte, contentType string, ifMatch string, idempotency *idempotencyRequest) (error, int, []byte) {
+	err, leader := h.currentLeader()
+	if err != nil {
+		return err, 0, nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		logger.Info("Forward request to primary", logger.Fields{"source": misc.SourceReplica, "primary": leader})
+
+		header := http.Header{}
+		if len(contentType) != 0 {
+			header.Set("Content-Type", contentType)
+		}
+		header.Set("If-Match", ifMatch)
+		header.Set(headerForwarded, h.self())
+		idempotency.setHeaders(header)
+		err, reply := h.client.Forward(leader, method, uri, payload, header)
+		if err != nil {
+			logger.Error("Error making request", logger.Fields{"method": method, "primary": leader, "err": err})
+			return err, 0, nil
+		}
+
+		// The replica was not the leader anymore, so learn the new leader and try again
+		if reply.Status == http.StatusMisdirectedRequest && attempt == 0 {
+			err, newLeader := h.observeMisdirected(reply.Leader())
+			if err != nil || newLeader == leader || isSelf(newLeader) {
+				return errNoLeader, 0, nil
+			}
+
+			logger.Info("Primary was stale, redirecting to new leader", logger.Fields{"stale": leader, "primary": newLeader})
+			leader = newLeader
+			continue
+		} else if reply.Status == http.StatusMisdirectedRequest {
+			return errNoLeader, 0, nil
+		}
+
+		return nil, reply.Status, reply.Body
+	}
+}
+
 // performRemoteWrite actually performs the remote write, this will create the file as well
+// The note must be locked until the write was queued for the backups
 func (h *Handler) performRemoteWrite(c *gin.Context, note common.Note) (error, common.Note) {
 	if strings.Contains(c.Request.Method, "POST") { // If this was POST, create new one
+		h.touchNote(&note, 1)
 		err := h.dsh.CreateNote(note)
 		if err != nil {
-			log.Printf("Unable to create new note: %v", err)
+			logger.Error("Unable to create new note", logger.Fields{"note_id": note.Id, "err": err})
 			return err, common.Note{}
 		}
 
@@ -447,23 +431,27 @@ func (h *Handler) performRemoteWrite(c *gin.Context, note common.Note) (error, c
 		// First, find original note
 		err, original := h.dsh.ReadSpecific(note.Id)
 		if err != nil {
-			log.Printf("Unable to find existing note with ID  %d: %v", note.Id)
+			logger.Warn("Unable to find existing note", logger.Fields{"note_id": note.Id, "err": err})
 			return err, common.Note{}
 		}
 
-		// Patch will only modify if the field was not empty
-		if len(note.Body) != 0 {
-			original.Body = note.Body
+		// The client might only want to write if nobody else modified the note
+		err = checkIfMatch(c.GetHeader("If-Match"), original)
+		if err != nil {
+			return err, common.Note{}
 		}
 
-		if len(note.Title) != 0 {
-			original.Title = note.Title
+		// Apply the patch to the newest version of the note
+		err, original = patchNote(c, original)
+		if err != nil {
+			return err, common.Note{}
 		}
 
-		// Try updating the note
+		// Try updating the note as the next version
+		h.touchNote(&original, original.Version+1)
 		err = h.dsh.UpdateNote(original)
 		if err != nil {
-			log.Printf("Unable to update existing note with ID %d: %v", note.Id, err)
+			logger.Error("Unable to update existing note", logger.Fields{"note_id": note.Id, "err": err})
 			return err, common.Note{}
 		}
 
@@ -472,7 +460,13 @@ func (h *Handler) performRemoteWrite(c *gin.Context, note common.Note) (error, c
 		// First, find original note
 		err, original := h.dsh.ReadSpecific(note.Id)
 		if err != nil {
-			log.Printf("Unable to find existing note with ID  %d: %v", note.Id)
+			logger.Warn("Unable to find existing note", logger.Fields{"note_id": note.Id, "err": err})
+			return err, common.Note{}
+		}
+
+		// The client might only want to write if nobody else modified the note
+		err = checkIfMatch(c.GetHeader("If-Match"), original)
+		if err != nil {
 			return err, common.Note{}
 		}
 
@@ -480,10 +474,11 @@ func (h *Handler) performRemoteWrite(c *gin.Context, note common.Note) (error, c
 		original.Body = note.Body
 		original.Title = note.Title
 
-		// Try updating the note
+		// Try updating the note as the next version
+		h.touchNote(&original, original.Version+1)
 		err = h.dsh.UpdateNote(original)
 		if err != nil {
-			log.Printf("Unable to update existing note with ID %d: %v", note.Id, err)
+			logger.Error("Unable to update existing note", logger.Fields{"note_id": note.Id, "err": err})
 			return err, common.Note{}
 		}
 
@@ -494,43 +489,45 @@ func (h *Handler) performRemoteWrite(c *gin.Context, note common.Note) (error, c
 }
 
 // handleRemoteDelete handles the delete operation
-func (h *Handler) handleRemoteDelete(id int) error {
-	// If this was replica 0, skip forward
-	if misc.IsReplica0() {
-		return h.performRemoteDelete(id)
+// If ifMatch was not empty, the note is deleted only if its current version matches
+func (h *Handler) handleRemoteDelete(id int, ifMatch string, idempotency *idempotencyRequest) error {
+	// If this was the leader, skip forward
+	if h.election.isLeader() {
+		return h.leaderDelete(id, ifMatch, idempotency)
 	} else { // If not, forward this request to the primary
-		log.Printf("%s [REQUEST] Forward request to primary", misc.ColoredReplica)
-
-		// Perform delete request
-		var response *http.Response
-		endpoint := fmt.Sprintf("http://%s/primary/%d", h.replicas[0], id)
-
-		// Create a new request accordingly
-		request, err := http.NewRequest("DELETE", endpoint, nil)
-		if err != nil {
-			log.Printf("Error creating DELETE request to primary: %v\n", err)
-			return err
-		}
-		request.Header.Set("Content-Type", "application/json")
-
-		// Perform the request
-		client := http.Client{}
-		response, err = client.Do(request)
+		err, status, _ := h.forwardToLeader(http.MethodDelete, fmt.Sprintf("/primary/%d", id), nil, "", ifMatch, idempotency)
 		if err != nil {
-			log.Printf("Error making DELETE request to primary %s: %v\n", endpoint, err)
+			logger.Error("Error making DELETE request to primary", logger.Fields{"note_id": id, "err": err})
 			return err
 		}
 
 		// Just check if code was OK
-		if response.StatusCode == http.StatusOK {
+		if status == http.StatusOK {
 			return nil
+		} else if status == http.StatusPreconditionFailed {
+			return errPreconditionFailed
 		} else {
-			return err
+			msg := fmt.Sprintf("non-ok response code %d from primary", status)
+			return errors.New(msg)
 		}
 	}
 }
 
 // performRemoteDelete removes the file from current local storage
-func (h *Handler) performRemoteDelete(id int) error {
+// If ifMatch was not empty, the note is deleted only if its current version matches
+// The note must be locked until the delete was queued for the backups
+func (h *Handler) performRemoteDelete(id int, ifMatch string) error {
+	if len(ifMatch) != 0 {
+		err, current := h.dsh.ReadSpecific(id)
+		if err != nil {
+			return err
+		}
+
+		err = checkIfMatch(ifMatch, current)
+		if err != nil {
+			return err
+		}
+	}
+
 	return h.dsh.DeleteNote(id)
 }
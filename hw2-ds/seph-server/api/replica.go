@@ -0,0 +1,78 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"seph/misc"
+	"seph/replication"
+	"time"
+)
+
+// replicaBackoff is how long the first retry of a request to another replica waits, further retries wait longer
+const replicaBackoff = 100 * time.Millisecond
+
+// backup sends the write to the replica on its own, or deletes the note from it for DELETE
+func (h *Handler) backup(replica string, mutation replication.Mutation) replication.Ack {
+	err, acks := h.backupBatch(replica, []replication.Mutation{mutation})
+	if err != nil {
+		return replication.Ack{Err: err}
+	}
+	return acks[0]
+}
+
+// backupBatch sends the writes to the replica in a single request, which applies them in order
+// In remote-write mode the request is marked as sent by the leader, and a 421 teaches this replica the newer leader
+func (h *Handler) backupBatch(replica string, mutations []replication.Mutation) (error, []replication.Ack) {
+	if h.syncMode != misc.SyncRemoteWrite {
+		return h.client.BackupBatch(replica, mutations, replication.Leader{})
+	}
+
+	err, acks := h.client.BackupBatch(replica, mutations, h.asLeader())
+
+	// The replica knows a newer leader, so this replica is not the leader anymore
+	errs := []error{err}
+	for _, ack := range acks {
+		errs = append(errs, ack.Err)
+	}
+	for _, e := range errs {
+		if newLeader, ok := replication.LeaderOf(e); ok && replication.StatusOf(e) == http.StatusMisdirectedRequest {
+			_, _ = h.observeMisdirected(newLeader)
+			break
+		}
+	}
+	return err, acks
+}
+
+// isSelf returns if the replica address points to this replica, using $REPLICA_ID
+// $REPLICA_ID can be either the whole address or only the host, ex) replica-1:5000 or replica-1
+func isSelf(replica string) bool {
+	replicaID := os.Getenv("REPLICA_ID")
+	host, _, err := net.SplitHostPort(replica)
+	if err != nil {
+		host = replica
+	}
+	return len(replicaID) != 0 && (replica == replicaID || host == replicaID)
+}
+
+// peers returns all replicas other than this replica
+func (h *Handler) peers() []string {
+	replicas := h.replicas()
+	peers := make([]string, 0, len(replicas))
+	for _, replica := range replicas {
+		if !isSelf(replica) {
+			peers = append(peers, replica)
+		}
+	}
+	return peers
+}
+
+// self returns the address of this replica in the replicas, which other replicas know this replica by
+// Replicas which are not members yet use the address they are going to join with
+func (h *Handler) self() string {
+	member, ok := h.selfMember()
+	if ok {
+		return member.Address
+	}
+	return h.selfAddress()
+}
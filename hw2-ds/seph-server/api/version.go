@@ -0,0 +1,65 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"seph/common"
+	"strings"
+	"time"
+)
+
+// errPreconditionFailed is returned when the If-Match header did not match the current version of the note
+var errPreconditionFailed = errors.New("precondition failed, note was modified")
+
+// noteETag returns the ETag of the note, which is the quoted version number
+func noteETag(note common.Note) string {
+	return fmt.Sprintf("\"%d\"", note.Version)
+}
+
+// setNoteHeaders sets ETag and Last-Modified headers of the response describing the note
+// The session token of the client is updated with the version of the note as well
+func setNoteHeaders(c *gin.Context, note common.Note) {
+	c.Header("ETag", noteETag(note))
+	setSessionHeader(c, note.Id, note.Version)
+	if !note.LastModified.IsZero() {
+		c.Header("Last-Modified", note.LastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// checkIfMatch checks if the If-Match header matches the current version of the note
+// Empty If-Match always matches, "*" matches any existing note
+func checkIfMatch(ifMatch string, note common.Note) error {
+	if len(ifMatch) == 0 {
+		return nil
+	}
+
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == noteETag(note) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: If-Match %s, current ETag %s", errPreconditionFailed, ifMatch, noteETag(note))
+}
+
+// touchNote marks the note as written now by this replica with the given version
+func (h *Handler) touchNote(note *common.Note, version int64) {
+	note.Version = version
+	note.LastModified = time.Now().UTC()
+	note.Writer = h.self()
+}
+
+// writeErrorStatus returns the status code of the reply for the failed write
+func writeErrorStatus(err error) int {
+	if errors.Is(err, errPreconditionFailed) {
+		return http.StatusPreconditionFailed
+	} else if errors.Is(err, errPatchFailed) {
+		return http.StatusUnprocessableEntity
+	} else if errors.Is(err, errNoLeader) || errors.Is(err, errPrimaryContended) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -1,10 +1,19 @@
 package common
 
+import "time"
+
 // Note represents a single note
+// Version starts from 1 and increases on every write, LastModified is the time of the last write
+// Writer is the replica which wrote the version, which breaks the tie between versions written concurrently
+// Deleted notes are kept as tombstones for a while, so that replicas can tell deletions from missing notes
 type Note struct {
-	Id    int    `json:"id"`
-	Title string `json:"title"`
-	Body  string `json:"body"`
+	Id           int       `json:"id"`
+	Title        string    `json:"title"`
+	Body         string    `json:"body"`
+	Version      int64     `json:"version"`
+	LastModified time.Time `json:"lastModified"`
+	Writer       string    `json:"writer,omitempty"`
+	Deleted      bool      `json:"deleted,omitempty"`
 }
 
 // NoteWithPrimary stores a single Note struct with Primary
@@ -13,6 +22,14 @@ type NoteWithPrimary struct {
 	Primary string `json:"primary"`
 }
 
+// Ownership represents the primary of a single note in local-write mode
+// Epoch increases whenever the note moves to another primary
+type Ownership struct {
+	Id      int    `json:"id"`
+	Primary string `json:"primary"`
+	Epoch   uint64 `json:"epoch"`
+}
+
 // NoteErrorResponse is for returning error responses
 type NoteErrorResponse struct {
 	Msg    string `json:"msg"`
@@ -20,3 +37,108 @@ type NoteErrorResponse struct {
 	Uri    string `json:"uri"`
 	Body   string `json:"body"`
 }
+
+// NoteDigest represents the version of a single note, used for comparing replicas
+// Hash covers the whole version, so that versions of the same number written concurrently differ as well
+type NoteDigest struct {
+	Id      int    `json:"id"`
+	Version int64  `json:"version"`
+	Deleted bool   `json:"deleted,omitempty"`
+	Hash    string `json:"hash"`
+}
+
+// Change represents a single write in the replication log of a replica
+// Seq increases by one on every write, deletions are logged as writes of tombstones
+type Change struct {
+	Seq  uint64 `json:"seq"`
+	Note Note   `json:"note"`
+}
+
+// Hint represents a write meant for a replica which was not available, kept by the replica which coordinated it
+// Hints are replayed in the order of Seq once the replica is back, Owner is only set in local-write mode
+// Idempotency is only set when the client made the write with an idempotency key
+type Hint struct {
+	Seq     uint64     `json:"seq"`
+	Replica string     `json:"replica"`
+	Method  string     `json:"method"`
+	Note    Note       `json:"note"`
+	Owner   *Ownership `json:"owner,omitempty"`
+	Created time.Time  `json:"created"`
+
+	Idempotency *IdempotentWrite `json:"idempotency,omitempty"`
+}
+
+// IdempotentWrite represents a write made with an idempotency key, kept until Expires
+// Retries with the same key are answered with the result of the write instead of writing again
+// Fingerprint identifies the request, so that a key reused for another request is told apart from a retry
+type IdempotentWrite struct {
+	Key         string    `json:"key"`
+	Fingerprint string    `json:"fingerprint"`
+	Method      string    `json:"method"`
+	Note        Note      `json:"note"`
+	Expires     time.Time `json:"expires"`
+}
+
+// ChangesResponse is the reply for the changes after a sequence number of the replication log
+// Epoch identifies the log, sequence numbers of different epochs cannot be compared
+type ChangesResponse struct {
+	Epoch   string   `json:"epoch"`
+	Last    uint64   `json:"last"`
+	Changes []Change `json:"changes"`
+	More    bool     `json:"more"`
+}
+
+// ElectionMessage is exchanged between replicas electing the leader
+// Replica is the candidate in elections, and the leader in heartbeats and their replies
+type ElectionMessage struct {
+	Term    uint64 `json:"term"`
+	Replica string `json:"replica"`
+}
+
+// Member represents a single replica of the cluster
+// Slot decides which IDs the replica allocates for new notes, it never changes while the replica is a member
+type Member struct {
+	Id      string `json:"id"`
+	Address string `json:"address"`
+	Slot    int    `json:"slot"`
+}
+
+// Membership represents all replicas of the cluster, Version increases on every join and leave
+type Membership struct {
+	Version uint64   `json:"version"`
+	Members []Member `json:"members"`
+}
+
+// PeerHealth represents a single peer as the failure detector of a replica sees it
+// State is "up", "suspect" or "down", Phi is how unlikely it is that the peer is still alive
+type PeerHealth struct {
+	Replica   string    `json:"replica"`
+	State     string    `json:"state"`
+	Phi       float64   `json:"phi"`
+	LastHeard time.Time `json:"lastHeard"`
+}
+
+// HealthResponse is the reply of the health probe, along with the peers as the replica sees them
+type HealthResponse struct {
+	Replica string       `json:"replica"`
+	State   string       `json:"state"`
+	Peers   []PeerHealth `json:"peers"`
+}
+
+// RaftMember represents a single server of the Raft cluster
+// Id is the address of the seph API, Address is the address Raft listens on
+type RaftMember struct {
+	Id       string `json:"id"`
+	Address  string `json:"address,omitempty"`
+	Suffrage string `json:"suffrage,omitempty"`
+}
+
+// RaftStatus represents the state of the Raft cluster as this replica sees it
+type RaftStatus struct {
+	State        string       `json:"state"`
+	Leader       string       `json:"leader"`
+	Term         string       `json:"term"`
+	LastIndex    uint64       `json:"lastIndex"`
+	AppliedIndex uint64       `json:"appliedIndex"`
+	Members      []RaftMember `json:"members"`
+}
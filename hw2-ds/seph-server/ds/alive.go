@@ -0,0 +1,38 @@
+package ds
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// aliveFileName is the name of the file keeping the time this replica was seen alive last in the target directory
+const aliveFileName = "seph.alive"
+
+// LoadLastAlive loads the time this replica was seen alive last, from the target directory
+// This returns false if the time was never saved, such as on the first start
+func LoadLastAlive(targetDir string) (error, time.Time, bool) {
+	fileName := path.Join(targetDir, aliveFileName)
+	data, err := os.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil, time.Time{}, false
+	} else if err != nil {
+		return err, time.Time{}, false
+	}
+
+	lastAlive, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+	if err != nil {
+		msg := fmt.Sprintf("error parsing time in %s: %v", fileName, err)
+		return errors.New(msg), time.Time{}, false
+	}
+
+	return nil, lastAlive, true
+}
+
+// SaveLastAlive saves the time this replica was seen alive last to the target directory
+func SaveLastAlive(targetDir string, lastAlive time.Time) error {
+	return writeFileAtomic(path.Join(targetDir, aliveFileName), []byte(lastAlive.UTC().Format(time.RFC3339Nano)))
+}
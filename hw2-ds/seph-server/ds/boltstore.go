@@ -0,0 +1,193 @@
+package ds
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"path"
+	"seph/common"
+	"time"
+)
+
+// boltFileName is the name of the bolt database file in the target directory
+const boltFileName = "seph.db"
+
+// boltNotesBucket is the bucket which stores all notes, keyed by big endian IDs so that they are sorted
+var boltNotesBucket = []byte("notes")
+
+// boltMetaBucket is the bucket which stores everything else, such as the last allocated ID
+var boltMetaBucket = []byte("meta")
+
+// boltLastIDKey is the key of the largest allocated or stored ID in the meta bucket
+var boltLastIDKey = []byte("lastID")
+
+// boltStore keeps all notes in a single embedded bolt database
+// Every write is a transaction which is fsynced on commit, so it survives crashes
+type boltStore struct {
+	db *bolt.DB
+}
+
+// openBoltStore opens (or creates) the bolt database in the target directory
+func openBoltStore(targetDir string) (error, *boltStore) {
+	dbPath := path.Join(targetDir, boltFileName)
+	db, err := bolt.Open(dbPath, 0644, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		msg := fmt.Sprintf("could not open bolt database %s: %v", dbPath, err)
+		return errors.New(msg), nil
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltNotesBucket)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists(boltMetaBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		msg := fmt.Sprintf("could not create bucket in %s: %v", dbPath, err)
+		return errors.New(msg), nil
+	}
+
+	return nil, &boltStore{db: db}
+}
+
+// boltKey converts the note ID into the key in the bucket
+func boltKey(id int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+// boltLastID returns the largest allocated or stored ID, or -1 if there was none
+func boltLastID(tx *bolt.Tx) int {
+	value := tx.Bucket(boltMetaBucket).Get(boltLastIDKey)
+	if value == nil {
+		return -1
+	}
+	return int(binary.BigEndian.Uint64(value))
+}
+
+// Get returns the note of given ID, or ErrNotFound
+func (s *boltStore) Get(id int) (error, common.Note) {
+	var note common.Note
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(boltNotesBucket).Get(boltKey(id))
+		if value == nil {
+			return notFound(id)
+		}
+		return json.Unmarshal(value, &note)
+	})
+
+	return err, note
+}
+
+// List returns all notes in the order of their IDs
+func (s *boltStore) List() (error, []common.Note) {
+	notes := make([]common.Note, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltNotesBucket).ForEach(func(_, value []byte) error {
+			var note common.Note
+			err := json.Unmarshal(value, &note)
+			if err != nil {
+				return err
+			}
+
+			notes = append(notes, note)
+			return nil
+		})
+	})
+	if err != nil {
+		return err, nil
+	}
+
+	return nil, notes
+}
+
+// Scan calls fn with each note of ID larger than after in the order of their IDs, until fn returns false
+// The cursor seeks right to the first ID, and notes are decoded one by one
+func (s *boltStore) Scan(after int, fn func(note common.Note) bool) error {
+	if after < -1 {
+		after = -1
+	}
+
+	return s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(boltNotesBucket).Cursor()
+		for key, value := cursor.Seek(boltKey(after + 1)); key != nil; key, value = cursor.Next() {
+			var note common.Note
+			err := json.Unmarshal(value, &note)
+			if err != nil {
+				return err
+			}
+
+			if !fn(note) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// Put creates or overwrites the note, and raises the largest ID in the same transaction
+// This way the ID is never allocated again even after the note is deleted
+func (s *boltStore) Put(note common.Note) error {
+	noteJSON, err := json.Marshal(note)
+	if err != nil {
+		msg := fmt.Sprintf("error marshalling note %d to JSON: %v", note.Id, err)
+		return errors.New(msg)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if note.Id > boltLastID(tx) {
+			err := tx.Bucket(boltMetaBucket).Put(boltLastIDKey, boltKey(note.Id))
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(boltNotesBucket).Put(boltKey(note.Id), noteJSON)
+	})
+}
+
+// Delete removes the note of given ID, or returns ErrNotFound
+func (s *boltStore) Delete(id int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltNotesBucket)
+		if bucket.Get(boltKey(id)) == nil {
+			return notFound(id)
+		}
+		return bucket.Delete(boltKey(id))
+	})
+}
+
+// NextID returns the next ID in the stripe, which is larger than any ID allocated or stored before
+// The allocation is persisted in the same transaction
+func (s *boltStore) NextID(stride int, offset int) (error, int) {
+	nextID := -1
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		nextID = nextStripedID(boltLastID(tx), stride, offset)
+		return tx.Bucket(boltMetaBucket).Put(boltLastIDKey, boltKey(nextID))
+	})
+	if err != nil {
+		return err, -1
+	}
+
+	return nil, nextID
+}
+
+// Snapshot writes a consistent copy of all notes to w, everything is read in a single transaction
+func (s *boltStore) Snapshot(w io.Writer) error {
+	err, notes := s.List()
+	if err != nil {
+		return err
+	}
+	return writeSnapshot(w, notes)
+}
+
+// Close closes the bolt database
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
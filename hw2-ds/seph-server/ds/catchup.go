@@ -0,0 +1,140 @@
+package ds
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"seph/common"
+	"seph/logger"
+	"seph/replication"
+)
+
+// ChangesPageSize is the maximum number of changes returned at once
+const ChangesPageSize = 1000
+
+// Headers which tell the position in the replication log a snapshot was taken at
+const (
+	HeaderEpoch = replication.HeaderEpoch
+	HeaderSeq   = replication.HeaderSeq
+)
+
+// Changes returns the changes after the sequence number of the replication log with the given epoch
+// If the epoch was different or the changes were compacted, this returns ErrChangesUnavailable
+func (h *Handler) Changes(epoch string, since uint64) (error, common.ChangesResponse) {
+	currentEpoch, last := h.log.position()
+	if epoch != currentEpoch {
+		msg := fmt.Sprintf("asked for epoch %s, log has epoch %s", epoch, currentEpoch)
+		return fmt.Errorf("%w: %s", ErrChangesUnavailable, msg), common.ChangesResponse{}
+	}
+
+	err, changes, more := h.log.since(since, ChangesPageSize)
+	if err != nil {
+		return err, common.ChangesResponse{}
+	}
+
+	return nil, common.ChangesResponse{Epoch: currentEpoch, Last: last, Changes: changes, More: more}
+}
+
+// LogPosition returns the epoch and the last sequence number of the replication log
+func (h *Handler) LogPosition() (string, uint64) {
+	return h.log.position()
+}
+
+// WriteSnapshot writes all notes including the tombstones to w, as a JSON array
+// Take the LogPosition before the snapshot, the changes after it might be in the snapshot already
+// which is fine since applying them twice changes nothing
+func (h *Handler) WriteSnapshot(w io.Writer) error {
+	return h.store.Snapshot(w)
+}
+
+// catchUp pulls the changes this replica missed from the peer, and returns the number of applied notes
+func (h *Handler) catchUp(peer string) (error, int) {
+	c, ok := h.log.cursor(peer)
+	if !ok {
+		return h.pullSnapshot(peer)
+	}
+
+	err, applied := h.pullChanges(peer, c)
+	if errors.Is(err, ErrChangesUnavailable) {
+		logger.Info("Peer no longer has the changes, pulling a snapshot instead",
+			logger.Fields{"peer": peer, "epoch": c.Epoch, "seq": c.Seq})
+		return h.pullSnapshot(peer)
+	}
+
+	return err, applied
+}
+
+// pullChanges pulls the changes after the cursor page by page, moving the cursor after every page
+func (h *Handler) pullChanges(peer string, c cursor) (error, int) {
+	applied := 0
+	for {
+		err, changes := h.client.FetchChanges(peer, c.Epoch, c.Seq)
+		if replication.StatusOf(err) == http.StatusGone {
+			return fmt.Errorf("%w: %v", ErrChangesUnavailable, err), applied
+		} else if err != nil {
+			return err, applied
+		}
+
+		for _, change := range changes.Changes {
+			err, written := h.applyRemote(change.Note)
+			if err != nil {
+				return err, applied
+			}
+			if written {
+				applied++
+			}
+			c.Seq = change.Seq
+		}
+
+		err = h.log.setCursor(peer, c)
+		if err != nil {
+			return err, applied
+		}
+
+		if !changes.More {
+			return nil, applied
+		}
+	}
+}
+
+// pullSnapshot pulls all notes from the peer, then moves the cursor to where the snapshot was taken
+// Notes missing in the snapshot are deleted, since the peer might have purged their tombstones already
+func (h *Handler) pullSnapshot(peer string) (error, int) {
+	err, snapshot := h.client.FetchSnapshot(peer)
+	if err != nil {
+		return err, 0
+	}
+	c := cursor{Epoch: snapshot.Epoch, Seq: snapshot.Seq}
+
+	h.lock.Lock()
+	err = h.deleteMissing(snapshot.Notes)
+	h.lock.Unlock()
+	if err != nil {
+		return err, 0
+	}
+
+	applied := 0
+	for _, note := range snapshot.Notes {
+		err, written := h.applyRemote(note)
+		if err != nil {
+			return err, applied
+		}
+		if written {
+			applied++
+		}
+	}
+
+	logger.Info("Pulled snapshot from peer", logger.Fields{"peer": peer, "notes": len(snapshot.Notes), "epoch": c.Epoch, "seq": c.Seq})
+	return h.log.setCursor(peer, c), applied
+}
+
+// applyRemote applies the note from a peer, notes this replica already has newer versions of are skipped
+func (h *Handler) applyRemote(note common.Note) (error, bool) {
+	err, written := h.WriteNoteIfNewer(note)
+	if errors.Is(err, ErrStaleVersion) {
+		return nil, false
+	}
+
+	return err, written
+}
@@ -0,0 +1,82 @@
+package ds
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"seph/common"
+	"seph/replication"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPullSnapshot checks the notes of a snapshot replace the notes of this replica unless they are older,
+// and a snapshot which could not be pulled leaves the notes and the cursor as they were
+func TestPullSnapshot(t *testing.T) {
+	now := time.Now().UTC()
+	local := []common.Note{
+		{Id: 1, Title: "newer here", Version: 3, LastModified: now},
+		{Id: 2, Title: "purged by peer", Version: 1, LastModified: now},
+	}
+	snapshot := []common.Note{
+		{Id: 1, Title: "older", Version: 2, LastModified: now},
+		{Id: 3, Title: "missed", Version: 1, LastModified: now},
+	}
+
+	serve := func(seq string, notes interface{}) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(HeaderEpoch, "e1")
+			w.Header().Set(HeaderSeq, seq)
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(notes)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		peer   http.HandlerFunc
+		failed bool
+		want   string // Titles of the notes after pulling
+	}{
+		{"snapshot", serve("7", snapshot), false, "newer here,missed"},
+		{"peer fails", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) }, true, "newer here,purged by peer"},
+		{"no position", serve("", snapshot), true, "newer here,purged by peer"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(test.peer)
+			defer server.Close()
+			peer := strings.TrimPrefix(server.URL, "http://")
+
+			h := newTestHandler(t)
+			h.SetClient(replication.New(time.Second, 0, time.Millisecond, 0))
+			for _, note := range local {
+				if err := h.WriteNote(note); err != nil {
+					t.Fatalf("could not write note: %v", err)
+				}
+			}
+
+			err, _ := h.pullSnapshot(peer)
+			if failed := err != nil; failed != test.failed {
+				t.Fatalf("err = %v, want failed %v", err, test.failed)
+			}
+
+			titles := make([]string, 0)
+			for _, note := range h.ReadAllRaw() {
+				titles = append(titles, note.Title)
+			}
+			if got := strings.Join(titles, ","); got != test.want {
+				t.Errorf("notes = %s, want %s", got, test.want)
+			}
+
+			c, ok := h.log.cursor(peer)
+			if test.failed && ok {
+				t.Errorf("cursor = %+v, want none", c)
+			} else if !test.failed && c != (cursor{Epoch: "e1", Seq: 7}) {
+				t.Errorf("cursor = %+v, want e1 7", c)
+			}
+		})
+	}
+}
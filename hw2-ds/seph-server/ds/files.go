@@ -0,0 +1,103 @@
+package ds
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"seph/logger"
+	"strconv"
+	"strings"
+)
+
+// readChecksummedLines reads all complete lines of "CRC32 JSON" from the start of the file
+// Reading stops at the first torn or corrupted line, the name of the file is only for logging
+func readChecksummedLines(file *os.File, name string) (error, [][]byte) {
+	_, err := file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err, nil
+	}
+
+	lines := make([][]byte, 0)
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			if len(line) != 0 {
+				logger.Warn("Discarding torn "+name+" record", logger.Fields{"after": len(lines)})
+			}
+			return nil, lines
+		} else if err != nil {
+			return err, nil
+		}
+
+		// Each line is "CRC32 JSON\n"
+		fields := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 2)
+		if len(fields) != 2 {
+			logger.Warn("Discarding malformed "+name+" record", logger.Fields{"after": len(lines)})
+			return nil, lines
+		}
+
+		checksum, err := strconv.ParseUint(fields[0], 16, 32)
+		if err != nil || uint32(checksum) != crc32.ChecksumIEEE([]byte(fields[1])) {
+			logger.Warn("Discarding corrupted "+name+" record", logger.Fields{"after": len(lines)})
+			return nil, lines
+		}
+
+		lines = append(lines, []byte(fields[1]))
+	}
+}
+
+// checksummedLine returns the line of "CRC32 JSON\n" for the JSON data
+func checksummedLine(data []byte) string {
+	return fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(data), data)
+}
+
+// writeFileAtomic writes data to a temporary file, fsyncs it and renames it to fileName
+// Readers will see either the old contents or the new contents, never a truncated file
+func writeFileAtomic(fileName string, data []byte) error {
+	tmpName := fileName + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		msg := fmt.Sprintf("error creating temporary file %s: %v", tmpName, err)
+		return errors.New(msg)
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpName)
+		msg := fmt.Sprintf("error writing temporary file %s: %v", tmpName, err)
+		return errors.New(msg)
+	}
+
+	err = os.Rename(tmpName, fileName)
+	if err != nil {
+		_ = os.Remove(tmpName)
+		msg := fmt.Sprintf("error renaming %s to %s: %v", tmpName, fileName, err)
+		return errors.New(msg)
+	}
+
+	// The rename itself must reach the disk as well
+	return syncDir(filepath.Dir(fileName))
+}
+
+// syncDir fsyncs the directory, so that the renames and removals in it are durable
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return file.Sync()
+}
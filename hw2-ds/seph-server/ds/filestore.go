@@ -0,0 +1,261 @@
+package ds
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"seph/common"
+	"seph/logger"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fileStore keeps every note as a single JSON file in the target directory, ex) 1.json
+// Only the IDs are kept in memory, notes are read from their files, so that scanning a page never holds every note
+// Note files are replaced atomically, the replication log of the handler is what makes writes survive crashes
+type fileStore struct {
+	lock    sync.RWMutex
+	dir     string
+	ids     map[int]bool
+	lastID  int
+	savedID int // Largest ID persisted in the last ID file
+}
+
+// lastIDFileName is the name of the file which keeps the largest ID this replica allocated or stored
+const lastIDFileName = "seph.id"
+
+// openFileStore finds the IDs of all note files in the target directory
+// Temporary files left by crashed writes are removed, the note files they were replacing are still whole
+func openFileStore(targetDir string) (error, *fileStore) {
+	s := &fileStore{
+		lock:    sync.RWMutex{},
+		dir:     targetDir,
+		ids:     make(map[int]bool),
+		lastID:  -1,
+		savedID: -1,
+	}
+
+	// Read all .json files in the target directory
+	files, err := os.ReadDir(targetDir)
+	if err != nil {
+		return err, nil
+	}
+
+	for _, file := range files {
+		if filepath.Ext(file.Name()) == ".tmp" {
+			_ = os.Remove(filepath.Join(targetDir, file.Name()))
+		} else if filepath.Ext(file.Name()) == ".json" {
+			id, err := strconv.Atoi(strings.TrimSuffix(file.Name(), ".json"))
+			if err != nil {
+				logger.Warn("Ignoring file which is not a note", logger.Fields{"file": file.Name()})
+				continue
+			}
+
+			s.ids[id] = true
+			if id > s.lastID {
+				s.lastID = id
+			}
+		}
+	}
+
+	// Read the largest allocated or deleted ID, so that IDs of deleted notes are never reused
+	data, err := os.ReadFile(filepath.Join(targetDir, lastIDFileName))
+	if err == nil {
+		s.savedID, err = strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			msg := fmt.Sprintf("could not parse %s: %v", lastIDFileName, err)
+			return errors.New(msg), nil
+		}
+		if s.savedID > s.lastID {
+			s.lastID = s.savedID
+		}
+	} else if !os.IsNotExist(err) {
+		return err, nil
+	}
+
+	logger.Info("Loaded notes from directory", logger.Fields{"dir": targetDir, "notes": len(s.ids)})
+	return nil, s
+}
+
+// Get returns the note of given ID, or ErrNotFound
+func (s *fileStore) Get(id int) (error, common.Note) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if !s.ids[id] {
+		return notFound(id), common.Note{}
+	}
+	return s.read(id)
+}
+
+// List returns all notes in the order of their IDs
+func (s *fileStore) List() (error, []common.Note) {
+	notes := make([]common.Note, 0)
+	err := s.Scan(-1, func(note common.Note) bool {
+		notes = append(notes, note)
+		return true
+	})
+	if err != nil {
+		return err, nil
+	}
+
+	return nil, notes
+}
+
+// Scan calls fn with each note of ID larger than after in the order of their IDs, until fn returns false
+// Notes are read from their files one by one, writes wait until the scan finished
+func (s *fileStore) Scan(after int, fn func(note common.Note) bool) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	ids := make([]int, 0, len(s.ids))
+	for id := range s.ids {
+		if id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		err, note := s.read(id)
+		if err != nil {
+			return err
+		}
+		if !fn(note) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// read reads the note from its file, the lock must be held
+func (s *fileStore) read(id int) (error, common.Note) {
+	fileName := filepath.Join(s.dir, fmt.Sprintf("%d.json", id))
+	err, note := readNoteFromFile(fileName)
+	if err != nil {
+		msg := fmt.Sprintf("could not read note %s: %v", fileName, err)
+		return errors.New(msg), common.Note{}
+	}
+	return nil, note
+}
+
+// Put replaces the note file atomically
+// The ID is only persisted as the largest one once the note is deleted, until then its note file tells the ID on open
+func (s *fileStore) Put(note common.Note) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	fileName := filepath.Join(s.dir, fmt.Sprintf("%d.json", note.Id))
+	noteJSON, err := json.MarshalIndent(note, "", "  ")
+	if err != nil {
+		msg := fmt.Sprintf("error marshalling note %s to JSON: %v", fileName, err)
+		return errors.New(msg)
+	}
+
+	err = writeFileAtomic(fileName, noteJSON)
+	if err != nil {
+		return err
+	}
+
+	s.ids[note.Id] = true
+	if note.Id > s.lastID {
+		s.lastID = note.Id
+	}
+	return nil
+}
+
+// Delete removes the note file
+// The largest ID is persisted first, so that the ID is never allocated again once its note file is gone
+func (s *fileStore) Delete(id int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.ids[id] {
+		return notFound(id)
+	}
+
+	err := s.saveLastID()
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filepath.Join(s.dir, fmt.Sprintf("%d.json", id)))
+	if err == nil {
+		err = syncDir(s.dir)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("could not delete note %d: %v", id, err)
+		return errors.New(msg)
+	}
+
+	delete(s.ids, id)
+	return nil
+}
+
+// NextID returns the next ID in the stripe, which is larger than any ID allocated or stored before
+// For example, if the largest note ever stored was 3.json, this will return 4 with stride 1
+// The allocated ID is persisted before it is returned
+func (s *fileStore) NextID(stride int, offset int) (error, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	newID := nextStripedID(s.lastID, stride, offset)
+	s.lastID = newID
+	err := s.saveLastID()
+	if err != nil {
+		return err, -1
+	}
+	return nil, newID
+}
+
+// saveLastID persists the largest ID unless it was already, the lock must be held
+func (s *fileStore) saveLastID() error {
+	if s.lastID <= s.savedID {
+		return nil
+	}
+
+	err := writeFileAtomic(filepath.Join(s.dir, lastIDFileName), []byte(strconv.Itoa(s.lastID)))
+	if err != nil {
+		return err
+	}
+
+	s.savedID = s.lastID
+	return nil
+}
+
+// Snapshot writes a consistent copy of all notes to w
+func (s *fileStore) Snapshot(w io.Writer) error {
+	err, notes := s.List()
+	if err != nil {
+		return err
+	}
+	return writeSnapshot(w, notes)
+}
+
+// Close does nothing, every note file is closed once it was written
+func (s *fileStore) Close() error {
+	return nil
+}
+
+// readNoteFromFile reads a specific file as note format
+func readNoteFromFile(filePath string) (error, common.Note) {
+	// Read the designated file
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err, common.Note{}
+	}
+
+	// Try unmarshalling into target file
+	var content common.Note
+	err = json.Unmarshal(data, &content)
+	if err != nil {
+		return err, content
+	}
+
+	return nil, content
+}
@@ -1,73 +1,136 @@
 package ds
 
 import (
-	"encoding/json"
 	"errors"
-	"fmt"
-	"log"
-	"net/http"
-	"seph/common"
+	"seph/logger"
 	"seph/misc"
+	"seph/replication"
 	"sync"
 )
 
 // Handler represents a single distributed storage handler
 type Handler struct {
-	lock       sync.Mutex
-	targetDir  string
-	primaryMap map[int]string
-	replicas   []string
+	lock     sync.Mutex
+	store    Store
+	log      *replLog
+	owners   *ownerTable
+	hints    *hintLog
+	replicas []string
+	idStride int
+	idOffset int
+	writer   string
+	client   *replication.Client
+	purged   *purgeWatermark
+
+	idempotency *idempotencyTable
 }
 
-// New creates a new Handler
-func New(targetDir string, replicas []string) *Handler {
-	return &Handler{
-		lock:       sync.Mutex{},
-		targetDir:  targetDir,
-		primaryMap: make(map[int]string),
-		replicas:   replicas,
+// New creates a new Handler, storing notes in the given storage backend
+// The backend keeps its data in the target directory, see OpenStore for the supported backends
+func New(backend string, targetDir string, replicas []string) (error, *Handler) {
+	err, store := OpenStore(backend, targetDir)
+	if err != nil {
+		return err, nil
+	}
+
+	// Notes in memory are gone on restart, so the replication log must start over as well
+	err, log := openReplLog(targetDir, backend != misc.StorageMemory)
+	if err != nil {
+		_ = store.Close()
+		return err, nil
+	}
+
+	// The store might have missed the last write logged before a crash
+	err = redoLog(store, log)
+	if err != nil {
+		_ = log.close()
+		_ = store.Close()
+		return err, nil
+	}
+
+	// The primaries are kept in memory and written as a whole file, so there is nothing to close on errors
+	err, owners := openOwnerTable(targetDir, backend != misc.StorageMemory)
+	if err != nil {
+		_ = log.close()
+		_ = store.Close()
+		return err, nil
 	}
+
+	err, hints := openHintLog(targetDir, backend != misc.StorageMemory)
+	if err != nil {
+		_ = log.close()
+		_ = store.Close()
+		return err, nil
+	}
+
+	err, idempotency := openIdempotencyTable(targetDir, backend != misc.StorageMemory)
+	if err != nil {
+		_ = hints.close()
+		_ = log.close()
+		_ = store.Close()
+		return err, nil
+	}
+
+	err, purged := openPurgeWatermark(targetDir, backend != misc.StorageMemory)
+	if err != nil {
+		_ = idempotency.close()
+		_ = hints.close()
+		_ = log.close()
+		_ = store.Close()
+		return err, nil
+	}
+
+	return nil, &Handler{
+		lock:     sync.Mutex{},
+		store:    store,
+		log:      log,
+		owners:   owners,
+		hints:    hints,
+		replicas: replicas,
+		idStride: 1,
+		idOffset: 0,
+		purged:   purged,
+
+		idempotency: idempotency,
+	}
+}
+
+// SetClient sets the client for catching up with peers, which must be set before Init
+func (h *Handler) SetClient(client *replication.Client) {
+	h.client = client
 }
 
-// Init will try retrieving all data from replicas[0]
-// This is one of the requirements in the homework!
-// One of the problem is that, if this seph-server was the replicas[0],
-// this will literally just do a GET request to the self.
-// But, I am too lazy to cover this :b
-func (h *Handler) Init() error {
-	// If this was replicas[0], skip
-	if misc.IsReplica0() {
-		log.Printf("[Seph] Initialization skipped, this was replicas[0]")
+// SetWriter sets the replica stamped on the versions this handler writes by itself, such as tombstones
+func (h *Handler) SetWriter(replica string) {
+	h.writer = replica
+}
+
+// SetIDStripe makes this handler allocate only IDs where ID % stride == offset
+// When several replicas allocate IDs at once, each replica must have its own offset so that IDs never collide
+func (h *Handler) SetIDStripe(stride int, offset int) {
+	h.idStride = stride
+	h.idOffset = offset
+}
+
+// Init catches up with the first healthy peer, so that the writes this replica missed while it was down are applied
+// Only the changes after the last sequence number seen from the peer are pulled, deletions included
+// When the peer was never seen before or its log no longer has the changes, a full snapshot is pulled instead
+func (h *Handler) Init(peers []string) error {
+	if len(peers) == 0 {
+		logger.Info("Initialization skipped, there were no peers", nil)
 		return nil
-	} else { // If this was not replicas[0], then get data from replicas[0]
-		endpoint := fmt.Sprintf("http://%s/note", h.replicas[0])
-		response, err := http.Get(endpoint)
+	}
+
+	for _, peer := range peers {
+		err, applied := h.catchUp(peer)
 		if err != nil {
-			log.Printf("[Seph] Error making GET request to %s: %v", endpoint, err)
-			return err
+			logger.Warn("Could not catch up with peer", logger.Fields{"peer": peer, "err": err})
+			continue
 		}
-		defer response.Body.Close()
-
-		// Slice of common.Note which comes from replica[0]/note
-		var notes []common.Note
-
-		// Check if the response status code is OK (200)
-		if response.StatusCode == http.StatusOK {
-			// Decode the response body into a slice of common.Note
-			err := json.NewDecoder(response.Body).Decode(&notes)
-			if err != nil {
-				log.Println("Error decoding response body:", err)
-				return err
-			}
-		} else {
-			log.Println("Error: Non-OK response status code:", response.Status)
-			return errors.New("non-ok response status code")
-		}
-
-		// Dump all notes into the local storage
-		h.DumpNotes(notes)
 
-		log.Printf("[Seph] Initialization finished %d entries synced", len(notes))
+		logger.Info("Initialization finished", logger.Fields{"peer": peer, "synced": applied})
 		return nil
 	}
+
+	return errors.New("could not catch up with any of the peers")
 }
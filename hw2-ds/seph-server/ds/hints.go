@@ -0,0 +1,326 @@
+package ds
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"seph/common"
+	"seph/logger"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hintsFileName is the name of the file keeping the hints for unavailable replicas in the target directory
+const hintsFileName = "seph.hints"
+
+// ErrHintsFull is returned when a hint does not fit in the size limit of the hints
+var ErrHintsFull = errors.New("hints are full")
+
+// hintEntry is a single hint along with the size it takes in the hints file
+type hintEntry struct {
+	hint common.Hint
+	size int
+}
+
+// hintLog keeps the writes meant for unavailable replicas, so that they are replayed once the replicas are back
+// Hints are appended to a single file for all replicas, the file is rewritten whenever hints are dropped
+// Hints older than maxAge are dropped without replaying, and new hints are refused beyond maxSize bytes in total
+type hintLog struct {
+	lock    sync.Mutex
+	dir     string // Empty when the hints are kept in memory only
+	file    *os.File
+	last    uint64
+	size    int
+	maxAge  time.Duration
+	maxSize int
+	hints   map[string][]hintEntry
+}
+
+// close closes the hint log file
+func (l *hintLog) close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// openHintLog opens the hints of the target directory
+// When persistent was false, the hints are kept in memory only and start empty
+func openHintLog(targetDir string, persistent bool) (error, *hintLog) {
+	l := &hintLog{lock: sync.Mutex{}, hints: make(map[string][]hintEntry)}
+	if !persistent {
+		return nil, l
+	}
+	l.dir = targetDir
+
+	hintsPath := path.Join(targetDir, hintsFileName)
+	var err error
+	l.file, err = os.OpenFile(hintsPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		msg := fmt.Sprintf("could not open hints %s: %v", hintsPath, err)
+		return errors.New(msg), nil
+	}
+
+	err, lines := readChecksummedLines(l.file, "hints")
+	if err != nil {
+		_ = l.file.Close()
+		msg := fmt.Sprintf("could not read hints %s: %v", hintsPath, err)
+		return errors.New(msg), nil
+	}
+	for _, line := range lines {
+		var hint common.Hint
+		err = json.Unmarshal(line, &hint)
+		if err != nil {
+			logger.Warn("Discarding malformed hint", logger.Fields{"after": l.last, "err": err})
+			break
+		}
+
+		size := len(checksummedLine(line))
+		l.hints[hint.Replica] = append(l.hints[hint.Replica], hintEntry{hint: hint, size: size})
+		l.size += size
+		l.last = hint.Seq
+	}
+
+	// Torn records at the end were never acknowledged, so drop them before appending anything
+	err = l.rewrite()
+	if err != nil {
+		_ = l.file.Close()
+		return err, nil
+	}
+
+	if l.last != 0 {
+		logger.Info("Loaded hints", logger.Fields{"replicas": len(l.hints), "bytes": l.size})
+	}
+	return nil, l
+}
+
+// add stores the hint as the next one for its replica
+func (l *hintLog) add(hint common.Hint) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	hint.Seq = l.last + 1
+	if hint.Created.IsZero() {
+		hint.Created = time.Now().UTC()
+	}
+
+	hintJSON, err := json.Marshal(hint)
+	if err != nil {
+		msg := fmt.Sprintf("error marshalling hint: %v", err)
+		return errors.New(msg)
+	}
+	line := checksummedLine(hintJSON)
+	if l.maxSize > 0 && l.size+len(line) > l.maxSize {
+		msg := fmt.Sprintf("%d bytes of hints are kept, limit is %d", l.size, l.maxSize)
+		return fmt.Errorf("%w: %s", ErrHintsFull, msg)
+	}
+
+	if l.file != nil {
+		_, err = l.file.Write([]byte(line))
+		if err == nil {
+			err = l.file.Sync()
+		}
+		if err != nil {
+			msg := fmt.Sprintf("error appending hint: %v", err)
+			return errors.New(msg)
+		}
+	}
+
+	l.hints[hint.Replica] = append(l.hints[hint.Replica], hintEntry{hint: hint, size: len(line)})
+	l.size += len(line)
+	l.last = hint.Seq
+	return nil
+}
+
+// pending returns the hints for the replica in order
+func (l *hintLog) pending(replica string) []common.Hint {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	hints := make([]common.Hint, 0, len(l.hints[replica]))
+	for _, entry := range l.hints[replica] {
+		hints = append(hints, entry.hint)
+	}
+	return hints
+}
+
+// has returns if there are hints for the replica
+func (l *hintLog) has(replica string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return len(l.hints[replica]) != 0
+}
+
+// replicas returns the replicas having hints, sorted by their addresses
+func (l *hintLog) replicas() []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	replicas := make([]string, 0, len(l.hints))
+	for replica := range l.hints {
+		replicas = append(replicas, replica)
+	}
+	sort.Strings(replicas)
+	return replicas
+}
+
+// drop removes the hints for the replica up to the sequence number
+func (l *hintLog) drop(replica string, seq uint64) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.filter(func(hint common.Hint) bool {
+		return hint.Replica != replica || hint.Seq > seq
+	})
+}
+
+// expire removes the hints older than the age limit, and returns how many were removed
+func (l *hintLog) expire(now time.Time) (error, int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.maxAge <= 0 {
+		return nil, 0
+	}
+
+	before := l.count()
+	err := l.filter(func(hint common.Hint) bool {
+		return now.Sub(hint.Created) < l.maxAge
+	})
+	return err, before - l.count()
+}
+
+// filter keeps only the hints for which keep returns true, and rewrites the file if any was removed
+// The lock must be held
+func (l *hintLog) filter(keep func(hint common.Hint) bool) error {
+	removed := false
+	for replica, entries := range l.hints {
+		kept := make([]hintEntry, 0, len(entries))
+		for _, entry := range entries {
+			if keep(entry.hint) {
+				kept = append(kept, entry)
+				continue
+			}
+			l.size -= entry.size
+			removed = true
+		}
+
+		if len(kept) == 0 {
+			delete(l.hints, replica)
+		} else {
+			l.hints[replica] = kept
+		}
+	}
+
+	if !removed {
+		return nil
+	}
+	return l.rewrite()
+}
+
+// count returns the number of hints for all replicas, the lock must be held
+func (l *hintLog) count() int {
+	count := 0
+	for _, entries := range l.hints {
+		count += len(entries)
+	}
+	return count
+}
+
+// rewrite replaces the hints file with the hints in memory, the lock must be held
+// Hints are written in the order of their sequence numbers, so that each replica keeps its order on reload
+func (l *hintLog) rewrite() error {
+	if l.file == nil {
+		return nil
+	}
+
+	entries := make([]hintEntry, 0, l.count())
+	for _, replicaEntries := range l.hints {
+		entries = append(entries, replicaEntries...)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].hint.Seq < entries[j].hint.Seq })
+
+	var builder strings.Builder
+	for _, entry := range entries {
+		hintJSON, err := json.Marshal(entry.hint)
+		if err != nil {
+			return err
+		}
+		builder.WriteString(checksummedLine(hintJSON))
+	}
+
+	hintsPath := path.Join(l.dir, hintsFileName)
+	err := writeFileAtomic(hintsPath, []byte(builder.String()))
+	if err != nil {
+		return err
+	}
+
+	// The old file was replaced, so keep appending to the new one
+	file, err := os.OpenFile(hintsPath, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_ = l.file.Close()
+	l.file = file
+	return nil
+}
+
+// stats returns the number of hints and their size in bytes
+func (l *hintLog) stats() (int, int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.count(), l.size
+}
+
+// SetHintLimits makes this handler drop hints older than maxAge, and refuse hints beyond maxSize bytes in total
+func (h *Handler) SetHintLimits(maxAge time.Duration, maxSize int) {
+	h.hints.lock.Lock()
+	defer h.hints.lock.Unlock()
+
+	h.hints.maxAge = maxAge
+	h.hints.maxSize = maxSize
+}
+
+// AddHint stores the write meant for the replica of the hint, this returns ErrHintsFull beyond the size limit
+func (h *Handler) AddHint(hint common.Hint) error {
+	return h.hints.add(hint)
+}
+
+// Hints returns the hints for the replica in the order they were added
+func (h *Handler) Hints(replica string) []common.Hint {
+	return h.hints.pending(replica)
+}
+
+// HasHints returns if there are hints for the replica, writes to it must be hinted as well to keep their order
+func (h *Handler) HasHints(replica string) bool {
+	return h.hints.has(replica)
+}
+
+// HintedReplicas returns the replicas having hints
+func (h *Handler) HintedReplicas() []string {
+	return h.hints.replicas()
+}
+
+// DropHints removes the hints for the replica up to the sequence number, once they were replayed
+func (h *Handler) DropHints(replica string, seq uint64) error {
+	return h.hints.drop(replica, seq)
+}
+
+// ExpireHints removes the hints older than the age limit, and returns how many were removed
+func (h *Handler) ExpireHints() (error, int) {
+	return h.hints.expire(time.Now())
+}
+
+// HintStats returns the number of hints and their size in bytes
+func (h *Handler) HintStats() (int, int) {
+	return h.hints.stats()
+}
@@ -0,0 +1,213 @@
+package ds
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"seph/common"
+	"seph/logger"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// idempotencyFileName is the name of the file keeping the writes made with idempotency keys in the target directory
+const idempotencyFileName = "seph.idempotency"
+
+// idempotencyExpireInterval is how often expired writes are dropped, expired writes are never recalled meanwhile
+const idempotencyExpireInterval = time.Minute
+
+// idempotencyTable keeps the writes made with idempotency keys until they expire
+// Writes are appended to the file, the file is rewritten whenever expired writes are dropped
+type idempotencyTable struct {
+	lock       sync.Mutex
+	dir        string // Empty when the writes are kept in memory only
+	file       *os.File
+	writes     map[string]common.IdempotentWrite
+	lastExpire time.Time
+}
+
+// close closes the file of the idempotency table
+func (t *idempotencyTable) close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.file == nil {
+		return nil
+	}
+	return t.file.Close()
+}
+
+// openIdempotencyTable loads the writes made with idempotency keys of the target directory
+// When persistent was false, the writes are kept in memory only and start empty
+func openIdempotencyTable(targetDir string, persistent bool) (error, *idempotencyTable) {
+	t := &idempotencyTable{lock: sync.Mutex{}, writes: make(map[string]common.IdempotentWrite), lastExpire: time.Now()}
+	if !persistent {
+		return nil, t
+	}
+	t.dir = targetDir
+
+	tablePath := path.Join(targetDir, idempotencyFileName)
+	var err error
+	t.file, err = os.OpenFile(tablePath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		msg := fmt.Sprintf("could not open idempotency keys %s: %v", tablePath, err)
+		return errors.New(msg), nil
+	}
+
+	err, lines := readChecksummedLines(t.file, "idempotency keys")
+	if err != nil {
+		_ = t.file.Close()
+		msg := fmt.Sprintf("could not read idempotency keys %s: %v", tablePath, err)
+		return errors.New(msg), nil
+	}
+	for _, line := range lines {
+		var write common.IdempotentWrite
+		err = json.Unmarshal(line, &write)
+		if err != nil {
+			logger.Warn("Discarding malformed idempotency key", logger.Fields{"keys": len(t.writes), "err": err})
+			break
+		}
+		t.writes[write.Key] = write
+	}
+
+	// Expired writes are dropped along with torn records at the end, before appending anything
+	now := time.Now()
+	for key, write := range t.writes {
+		if !now.Before(write.Expires) {
+			delete(t.writes, key)
+		}
+	}
+	err = t.rewrite()
+	if err != nil {
+		_ = t.file.Close()
+		return err, nil
+	}
+
+	if len(t.writes) != 0 {
+		logger.Info("Loaded idempotency keys", logger.Fields{"keys": len(t.writes)})
+	}
+	return nil, t
+}
+
+// get returns the write made with the key, false if there was none or it expired
+func (t *idempotencyTable) get(key string, now time.Time) (common.IdempotentWrite, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	write, ok := t.writes[key]
+	if !ok || !now.Before(write.Expires) {
+		return common.IdempotentWrite{}, false
+	}
+	return write, true
+}
+
+// set stores the write made with its key, the write stored first for a key is kept
+// Replicas might receive the same write twice, from its coordinator and again from hints or retries
+func (t *idempotencyTable) set(write common.IdempotentWrite, now time.Time) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if now.Sub(t.lastExpire) >= idempotencyExpireInterval {
+		t.lastExpire = now
+		err := t.expire(now)
+		if err != nil {
+			return err
+		}
+	}
+
+	if current, ok := t.writes[write.Key]; ok && now.Before(current.Expires) {
+		return nil
+	} else if !now.Before(write.Expires) {
+		return nil
+	}
+
+	if t.file != nil {
+		writeJSON, err := json.Marshal(write)
+		if err != nil {
+			msg := fmt.Sprintf("error marshalling idempotency key: %v", err)
+			return errors.New(msg)
+		}
+
+		_, err = t.file.Write([]byte(checksummedLine(writeJSON)))
+		if err == nil {
+			err = t.file.Sync()
+		}
+		if err != nil {
+			msg := fmt.Sprintf("error appending idempotency key: %v", err)
+			return errors.New(msg)
+		}
+	}
+
+	t.writes[write.Key] = write
+	return nil
+}
+
+// expire drops the expired writes, and rewrites the file if any was dropped, the lock must be held
+func (t *idempotencyTable) expire(now time.Time) error {
+	removed := 0
+	for key, write := range t.writes {
+		if !now.Before(write.Expires) {
+			delete(t.writes, key)
+			removed++
+		}
+	}
+
+	if removed == 0 {
+		return nil
+	}
+	logger.Debug("Expired idempotency keys", logger.Fields{"removed": removed, "kept": len(t.writes)})
+	return t.rewrite()
+}
+
+// rewrite replaces the file with the writes in memory, the lock must be held
+// Writes are written in the order they expire
+func (t *idempotencyTable) rewrite() error {
+	if t.file == nil {
+		return nil
+	}
+
+	writes := make([]common.IdempotentWrite, 0, len(t.writes))
+	for _, write := range t.writes {
+		writes = append(writes, write)
+	}
+	sort.Slice(writes, func(i, j int) bool { return writes[i].Expires.Before(writes[j].Expires) })
+
+	var builder strings.Builder
+	for _, write := range writes {
+		writeJSON, err := json.Marshal(write)
+		if err != nil {
+			return err
+		}
+		builder.WriteString(checksummedLine(writeJSON))
+	}
+
+	tablePath := path.Join(t.dir, idempotencyFileName)
+	err := writeFileAtomic(tablePath, []byte(builder.String()))
+	if err != nil {
+		return err
+	}
+
+	// The old file was replaced, so keep appending to the new one
+	file, err := os.OpenFile(tablePath, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_ = t.file.Close()
+	t.file = file
+	return nil
+}
+
+// RecallWrite returns the write made with the idempotency key, false if there was none or it expired
+func (h *Handler) RecallWrite(key string) (common.IdempotentWrite, bool) {
+	return h.idempotency.get(key, time.Now())
+}
+
+// RememberWrite stores the write made with its idempotency key until it expires
+// If a write was already stored for the key, that one is kept
+func (h *Handler) RememberWrite(write common.IdempotentWrite) error {
+	return h.idempotency.set(write, time.Now())
+}
@@ -0,0 +1,44 @@
+package ds
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"seph/common"
+)
+
+// membershipFileName is the name of the file keeping the members of the cluster in the target directory
+const membershipFileName = "seph.members"
+
+// LoadMembership loads the members of the cluster this replica saw last, from the target directory
+// This returns false if the members were never saved, then the replicas in the config are the members
+func LoadMembership(targetDir string) (error, common.Membership, bool) {
+	fileName := path.Join(targetDir, membershipFileName)
+	data, err := os.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil, common.Membership{}, false
+	} else if err != nil {
+		return err, common.Membership{}, false
+	}
+
+	var membership common.Membership
+	err = json.Unmarshal(data, &membership)
+	if err != nil {
+		msg := fmt.Sprintf("error unmarshalling members in %s: %v", fileName, err)
+		return errors.New(msg), common.Membership{}, false
+	}
+
+	return nil, membership, true
+}
+
+// SaveMembership saves the members of the cluster to the target directory
+func SaveMembership(targetDir string, membership common.Membership) error {
+	data, err := json.Marshal(membership)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path.Join(targetDir, membershipFileName), data)
+}
@@ -0,0 +1,107 @@
+package ds
+
+import (
+	"io"
+	"seph/common"
+	"sync"
+)
+
+// memoryStore keeps all notes in memory only, everything is gone once the process exits
+// This is meant for tests and throwaway replicas
+type memoryStore struct {
+	lock   sync.RWMutex
+	notes  map[int]common.Note
+	lastID int // Largest ID returned or stored, so that IDs of deleted notes are never reused
+}
+
+// newMemoryStore creates an empty memoryStore
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		lock:   sync.RWMutex{},
+		notes:  make(map[int]common.Note),
+		lastID: -1,
+	}
+}
+
+// Get returns the note of given ID, or ErrNotFound
+func (s *memoryStore) Get(id int) (error, common.Note) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	note, ok := s.notes[id]
+	if !ok {
+		return notFound(id), common.Note{}
+	}
+	return nil, note
+}
+
+// List returns all notes in the order of their IDs
+func (s *memoryStore) List() (error, []common.Note) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	notes := make([]common.Note, 0, len(s.notes))
+	for _, note := range s.notes {
+		notes = append(notes, note)
+	}
+	sortNotes(notes)
+
+	return nil, notes
+}
+
+// Scan calls fn with each note of ID larger than after in the order of their IDs, until fn returns false
+func (s *memoryStore) Scan(after int, fn func(note common.Note) bool) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	scanNotes(s.notes, after, fn)
+	return nil
+}
+
+// Put creates or overwrites the note
+func (s *memoryStore) Put(note common.Note) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.notes[note.Id] = note
+	if note.Id > s.lastID {
+		s.lastID = note.Id
+	}
+	return nil
+}
+
+// Delete removes the note of given ID, or returns ErrNotFound
+func (s *memoryStore) Delete(id int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.notes[id]; !ok {
+		return notFound(id)
+	}
+
+	delete(s.notes, id)
+	return nil
+}
+
+// NextID returns the next ID in the stripe, which is larger than any ID returned or stored before
+func (s *memoryStore) NextID(stride int, offset int) (error, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.lastID = nextStripedID(s.lastID, stride, offset)
+	return nil, s.lastID
+}
+
+// Snapshot writes a consistent copy of all notes to w
+func (s *memoryStore) Snapshot(w io.Writer) error {
+	err, notes := s.List()
+	if err != nil {
+		return err
+	}
+	return writeSnapshot(w, notes)
+}
+
+// Close does nothing, there is nothing to release
+func (s *memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,132 @@
+package ds
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"seph/common"
+	"sort"
+	"sync"
+)
+
+// ownersFileName is the name of the file keeping the primary of every note in the target directory
+const ownersFileName = "seph.owners"
+
+// ownerTable keeps the primary of every note in local-write mode
+// Every move of a note to another primary increases its epoch, so that replicas always keep the latest primary
+type ownerTable struct {
+	lock   sync.Mutex
+	dir    string // Empty when the table is kept in memory only
+	owners map[int]common.Ownership
+}
+
+// openOwnerTable loads the primaries of the target directory
+// When persistent was false, the table is kept in memory only and starts empty
+func openOwnerTable(targetDir string, persistent bool) (error, *ownerTable) {
+	t := &ownerTable{lock: sync.Mutex{}, owners: make(map[int]common.Ownership)}
+	if !persistent {
+		return nil, t
+	}
+	t.dir = targetDir
+
+	data, err := os.ReadFile(path.Join(targetDir, ownersFileName))
+	if os.IsNotExist(err) {
+		return nil, t
+	} else if err != nil {
+		return err, nil
+	}
+
+	var owners []common.Ownership
+	err = json.Unmarshal(data, &owners)
+	if err != nil {
+		return err, nil
+	}
+	for _, owner := range owners {
+		t.owners[owner.Id] = owner
+	}
+	return nil, t
+}
+
+// supersedes returns if the ownership a should replace the ownership b of the same note
+// Higher epochs win, and the lower primary wins ties so that all replicas agree on the same primary
+func supersedes(a common.Ownership, b common.Ownership) bool {
+	if a.Epoch != b.Epoch {
+		return a.Epoch > b.Epoch
+	}
+	return a.Primary < b.Primary
+}
+
+// get returns the primary of the note, the epoch is 0 if the note never had one
+func (t *ownerTable) get(id int) common.Ownership {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	owner, ok := t.owners[id]
+	if !ok {
+		return common.Ownership{Id: id}
+	}
+	return owner
+}
+
+// set stores the ownership if it supersedes the current one, and returns whether it did along with the current one
+func (t *ownerTable) set(owner common.Ownership) (error, bool, common.Ownership) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	current, ok := t.owners[owner.Id]
+	if ok && current == owner {
+		return nil, true, current
+	} else if ok && !supersedes(owner, current) {
+		return nil, false, current
+	}
+
+	t.owners[owner.Id] = owner
+	return t.save(), true, owner
+}
+
+// all returns the primaries of all notes sorted by ID
+func (t *ownerTable) all() []common.Ownership {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.sorted()
+}
+
+// sorted returns the primaries of all notes sorted by ID, the lock must be held
+func (t *ownerTable) sorted() []common.Ownership {
+	owners := make([]common.Ownership, 0, len(t.owners))
+	for _, owner := range t.owners {
+		owners = append(owners, owner)
+	}
+	sort.Slice(owners, func(i, j int) bool { return owners[i].Id < owners[j].Id })
+	return owners
+}
+
+// save writes the whole table to the owners file, the lock must be held
+func (t *ownerTable) save() error {
+	if len(t.dir) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(t.sorted())
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(t.dir, ownersFileName), data)
+}
+
+// Owner returns the primary of the note, the epoch is 0 if the note never had one
+func (h *Handler) Owner(id int) common.Ownership {
+	return h.owners.get(id)
+}
+
+// SetOwner stores the primary of the note unless this replica already knows a newer one
+// This returns whether the ownership was stored, and the ownership this replica keeps now
+func (h *Handler) SetOwner(owner common.Ownership) (error, bool, common.Ownership) {
+	return h.owners.set(owner)
+}
+
+// Owners returns the primaries of all notes sorted by ID
+func (h *Handler) Owners() []common.Ownership {
+	return h.owners.all()
+}
@@ -0,0 +1,195 @@
+package ds
+
+import (
+	"container/heap"
+	"seph/common"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Orders notes can be sorted in, notes of the same title or modification time are sorted by their IDs
+// Titles are sorted case-insensitively
+const (
+	SortByID      = "id"
+	SortByTitle   = "title"
+	SortByUpdated = "updated"
+)
+
+// NoteQuery selects a single page of notes, deleted notes are never selected
+// Pages start right after the cursor if there was one, otherwise after skipping offset notes
+type NoteQuery struct {
+	Sort       string
+	Descending bool
+
+	// Titles are matched case-insensitively, empty ones match every note
+	TitlePrefix   string
+	TitleContains string
+
+	After  *NoteCursor
+	Offset int
+	Limit  int
+}
+
+// NoteCursor is the position of the last note of a page in the sort order, the next page starts right after it
+type NoteCursor struct {
+	Id           int
+	Title        string
+	LastModified time.Time
+}
+
+// NotePage is a single page of notes, More is true when there were more notes after the page
+type NotePage struct {
+	Notes []common.Note
+	More  bool
+}
+
+// CursorOf returns the position of the note in the sort order
+func CursorOf(note common.Note) NoteCursor {
+	return NoteCursor{Id: note.Id, Title: note.Title, LastModified: note.LastModified}
+}
+
+// ReadPage reads a page of notes from the store
+// Only the notes of the page are kept in memory while scanning, notes sorted by ascending IDs stop the scan once the page is full
+func (h *Handler) ReadPage(query NoteQuery) (error, NotePage) {
+	after := -1
+	if query.After != nil && query.Sort == SortByID && !query.Descending {
+		after = query.After.Id
+	}
+
+	c := newPageCollector(query)
+	err := h.store.Scan(after, c.add)
+	if err != nil {
+		return err, NotePage{}
+	}
+	return nil, c.page()
+}
+
+// PageNotes selects a page of notes from the notes already read, such as the ones merged from other replicas
+func PageNotes(notes []common.Note, query NoteQuery) NotePage {
+	c := newPageCollector(query)
+	for _, note := range notes {
+		c.add(note)
+	}
+	return c.page()
+}
+
+// matches returns if the note belongs to the query, regardless of the page
+func (q NoteQuery) matches(note common.Note) bool {
+	if note.Deleted {
+		return false
+	}
+
+	title := strings.ToLower(note.Title)
+	return strings.HasPrefix(title, strings.ToLower(q.TitlePrefix)) &&
+		strings.Contains(title, strings.ToLower(q.TitleContains))
+}
+
+// before returns if the position a comes before the position b in the sort order of the query
+func (q NoteQuery) before(a NoteCursor, b NoteCursor) bool {
+	if q.Descending {
+		a, b = b, a
+	}
+
+	switch q.Sort {
+	case SortByTitle:
+		if titleA, titleB := strings.ToLower(a.Title), strings.ToLower(b.Title); titleA != titleB {
+			return titleA < titleB
+		} else if a.Title != b.Title {
+			return a.Title < b.Title
+		}
+	case SortByUpdated:
+		if !a.LastModified.Equal(b.LastModified) {
+			return a.LastModified.Before(b.LastModified)
+		}
+	}
+	return a.Id < b.Id
+}
+
+// pageCollector keeps the first notes of the query in the sort order, as many as the page and the notes before it
+// The kept notes are a heap with the last note on top, so it is dropped first once a note before it comes
+type pageCollector struct {
+	query    NoteQuery
+	capacity int
+	notes    []common.Note
+	sorted   bool // Notes come in the sort order, so the scan stops once the page is full
+}
+
+// newPageCollector creates a pageCollector, which keeps one more note than the page to tell if there were more
+func newPageCollector(query NoteQuery) *pageCollector {
+	capacity := query.Limit + 1
+	if query.After == nil {
+		capacity += query.Offset
+	}
+
+	return &pageCollector{
+		query:    query,
+		capacity: capacity,
+		notes:    make([]common.Note, 0),
+		sorted:   query.Sort == SortByID && !query.Descending,
+	}
+}
+
+// add takes the note if it belongs to the page, and returns false once no more notes could belong to it
+func (c *pageCollector) add(note common.Note) bool {
+	if !c.query.matches(note) {
+		return true
+	} else if c.query.After != nil && !c.query.before(*c.query.After, CursorOf(note)) {
+		return true
+	}
+
+	if len(c.notes) < c.capacity {
+		heap.Push(c, note)
+	} else if c.query.before(CursorOf(note), CursorOf(c.notes[0])) {
+		c.notes[0] = note
+		heap.Fix(c, 0)
+	}
+	return !c.sorted || len(c.notes) < c.capacity
+}
+
+// page returns the notes of the page in the sort order
+func (c *pageCollector) page() NotePage {
+	notes := c.notes
+	sort.Slice(notes, func(i, j int) bool { return c.query.before(CursorOf(notes[i]), CursorOf(notes[j])) })
+
+	if c.query.After == nil {
+		if c.query.Offset >= len(notes) {
+			notes = notes[:0]
+		} else {
+			notes = notes[c.query.Offset:]
+		}
+	}
+
+	page := NotePage{Notes: notes, More: len(notes) > c.query.Limit}
+	if page.More {
+		page.Notes = notes[:c.query.Limit]
+	}
+	return page
+}
+
+// Len implements heap.Interface
+func (c *pageCollector) Len() int {
+	return len(c.notes)
+}
+
+// Less implements heap.Interface, the note last in the sort order is on top
+func (c *pageCollector) Less(i, j int) bool {
+	return c.query.before(CursorOf(c.notes[j]), CursorOf(c.notes[i]))
+}
+
+// Swap implements heap.Interface
+func (c *pageCollector) Swap(i, j int) {
+	c.notes[i], c.notes[j] = c.notes[j], c.notes[i]
+}
+
+// Push implements heap.Interface
+func (c *pageCollector) Push(x interface{}) {
+	c.notes = append(c.notes, x.(common.Note))
+}
+
+// Pop implements heap.Interface
+func (c *pageCollector) Pop() interface{} {
+	last := c.notes[len(c.notes)-1]
+	c.notes = c.notes[:len(c.notes)-1]
+	return last
+}
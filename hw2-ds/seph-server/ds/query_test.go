@@ -0,0 +1,96 @@
+package ds
+
+import (
+	"fmt"
+	"seph/common"
+	"testing"
+	"time"
+)
+
+// TestReadPage reads the pages from every backend, which must be the same pages as the ones selected from all notes
+func TestReadPage(t *testing.T) {
+	now := time.Now().UTC()
+	notes := []common.Note{
+		{Id: 1, Title: "banana", Version: 1, LastModified: now.Add(3 * time.Minute)},
+		{Id: 2, Title: "Apple", Version: 1, LastModified: now.Add(1 * time.Minute)},
+		{Id: 3, Title: "apple pie", Version: 1, LastModified: now.Add(1 * time.Minute)},
+		{Id: 4, Title: "cherry", Version: 2, LastModified: now, Deleted: true},
+		{Id: 5, Title: "apple", Version: 1, LastModified: now.Add(2 * time.Minute)},
+		{Id: 6, Title: "date", Version: 1, LastModified: now.Add(4 * time.Minute)},
+	}
+	cursor := func(id int) *NoteCursor {
+		for _, note := range notes {
+			if note.Id == id {
+				position := CursorOf(note)
+				return &position
+			}
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name  string
+		query NoteQuery
+		ids   []int
+		more  bool
+	}{
+		{"first page", NoteQuery{Sort: SortByID, Limit: 2}, []int{1, 2}, true},
+		{"all notes", NoteQuery{Sort: SortByID, Limit: 10}, []int{1, 2, 3, 5, 6}, false},
+		{"exactly all notes", NoteQuery{Sort: SortByID, Limit: 5}, []int{1, 2, 3, 5, 6}, false},
+		{"offset", NoteQuery{Sort: SortByID, Offset: 2, Limit: 2}, []int{3, 5}, true},
+		{"offset past the end", NoteQuery{Sort: SortByID, Offset: 9, Limit: 2}, []int{}, false},
+		{"cursor", NoteQuery{Sort: SortByID, After: cursor(3), Limit: 2}, []int{5, 6}, false},
+		{"cursor of deleted note", NoteQuery{Sort: SortByID, After: cursor(4), Limit: 1}, []int{5}, true},
+		{"descending", NoteQuery{Sort: SortByID, Descending: true, Limit: 2}, []int{6, 5}, true},
+		{"descending cursor", NoteQuery{Sort: SortByID, Descending: true, After: cursor(3), Limit: 5}, []int{2, 1}, false},
+		{"title ignores case", NoteQuery{Sort: SortByTitle, Limit: 10}, []int{2, 5, 3, 1, 6}, false},
+		{"title cursor", NoteQuery{Sort: SortByTitle, After: cursor(5), Limit: 2}, []int{3, 1}, true},
+		{"updated ties by ID", NoteQuery{Sort: SortByUpdated, Limit: 3}, []int{2, 3, 5}, true},
+		{"updated descending", NoteQuery{Sort: SortByUpdated, Descending: true, Offset: 1, Limit: 2}, []int{1, 5}, true},
+		{"title prefix", NoteQuery{Sort: SortByID, TitlePrefix: "APP", Limit: 10}, []int{2, 3, 5}, false},
+		{"title contains", NoteQuery{Sort: SortByID, TitleContains: "pie", Limit: 10}, []int{3}, false},
+		{"deleted never match", NoteQuery{Sort: SortByID, TitlePrefix: "cherry", Limit: 10}, []int{}, false},
+	}
+
+	for _, backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			err, h := New(backend, t.TempDir(), nil)
+			if err != nil {
+				t.Fatalf("could not open handler: %v", err)
+			}
+			t.Cleanup(func() { _ = h.store.Close() })
+
+			for _, note := range notes {
+				if err, _ := h.WriteNoteIfNewer(note); err != nil {
+					t.Fatalf("could not write note %d: %v", note.Id, err)
+				}
+			}
+
+			for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+					err, page := h.ReadPage(test.query)
+					if err != nil {
+						t.Fatalf("could not read page: %v", err)
+					}
+					if got, want := pageIDs(page), fmt.Sprint(test.ids); got != want || page.More != test.more {
+						t.Errorf("ReadPage = %s more %v, want %s more %v", got, page.More, want, test.more)
+					}
+
+					selected := PageNotes(notes, test.query)
+					if got, want := pageIDs(selected), fmt.Sprint(test.ids); got != want || selected.More != test.more {
+						t.Errorf("PageNotes = %s more %v, want %s more %v", got, selected.More, want, test.more)
+					}
+				})
+			}
+		})
+	}
+}
+
+// pageIDs returns the IDs of the notes of the page in order
+func pageIDs(page NotePage) string {
+	ids := make([]int, 0, len(page.Notes))
+	for _, note := range page.Notes {
+		ids = append(ids, note.Id)
+	}
+	return fmt.Sprint(ids)
+}
@@ -0,0 +1,310 @@
+package ds
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"path"
+	"seph/common"
+	"seph/logger"
+	"time"
+)
+
+// raftFileName is the name of the bolt database keeping the Raft log and its metadata in the target directory
+const raftFileName = "raft.db"
+
+// Buckets of the Raft database, the log entries are keyed by big endian indexes so that they are sorted
+var (
+	raftLogsBucket   = []byte("logs")
+	raftStableBucket = []byte("stable")
+)
+
+// errRaftKeyNotFound is returned when the key was not in the stable store, Raft compares the message itself
+var errRaftKeyNotFound = errors.New("not found")
+
+// RaftStore keeps the Raft log and the Raft metadata such as the current term in a bolt database
+// It implements both raft.LogStore and raft.StableStore
+type RaftStore struct {
+	db *bolt.DB
+}
+
+// OpenRaftStore opens (or creates) the Raft database in the target directory
+func OpenRaftStore(targetDir string) (error, *RaftStore) {
+	dbPath := path.Join(targetDir, raftFileName)
+	db, err := bolt.Open(dbPath, 0644, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		msg := fmt.Sprintf("could not open raft database %s: %v", dbPath, err)
+		return errors.New(msg), nil
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(raftLogsBucket)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists(raftStableBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		msg := fmt.Sprintf("could not create bucket in %s: %v", dbPath, err)
+		return errors.New(msg), nil
+	}
+
+	return nil, &RaftStore{db: db}
+}
+
+// raftKey converts the index into the key in the bucket
+func raftKey(index uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, index)
+	return key
+}
+
+// FirstIndex returns the first index written, 0 for no entries
+func (s *RaftStore) FirstIndex() (uint64, error) {
+	var index uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		key, _ := tx.Bucket(raftLogsBucket).Cursor().First()
+		if key != nil {
+			index = binary.BigEndian.Uint64(key)
+		}
+		return nil
+	})
+
+	return index, err
+}
+
+// LastIndex returns the last index written, 0 for no entries
+func (s *RaftStore) LastIndex() (uint64, error) {
+	var index uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		key, _ := tx.Bucket(raftLogsBucket).Cursor().Last()
+		if key != nil {
+			index = binary.BigEndian.Uint64(key)
+		}
+		return nil
+	})
+
+	return index, err
+}
+
+// GetLog gets the log entry at the index, or raft.ErrLogNotFound
+func (s *RaftStore) GetLog(index uint64, log *raft.Log) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(raftLogsBucket).Get(raftKey(index))
+		if value == nil {
+			return raft.ErrLogNotFound
+		}
+		return json.Unmarshal(value, log)
+	})
+}
+
+// StoreLog stores a single log entry
+func (s *RaftStore) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs stores the log entries in a single transaction
+func (s *RaftStore) StoreLogs(logs []*raft.Log) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(raftLogsBucket)
+		for _, log := range logs {
+			value, err := json.Marshal(log)
+			if err != nil {
+				return err
+			}
+
+			err = bucket.Put(raftKey(log.Index), value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteRange deletes the log entries from min to max, both inclusive
+func (s *RaftStore) DeleteRange(min uint64, max uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(raftLogsBucket).Cursor()
+		for key, _ := cursor.Seek(raftKey(min)); key != nil; key, _ = cursor.Next() {
+			if binary.BigEndian.Uint64(key) > max {
+				break
+			}
+
+			err := cursor.Delete()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Set stores the value of the key
+func (s *RaftStore) Set(key []byte, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(raftStableBucket).Put(key, value)
+	})
+}
+
+// Get returns the value of the key, or an error saying "not found"
+func (s *RaftStore) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		stored := tx.Bucket(raftStableBucket).Get(key)
+		if stored == nil {
+			return errRaftKeyNotFound
+		}
+
+		// Values are only valid during the transaction, so copy them
+		value = append([]byte(nil), stored...)
+		return nil
+	})
+
+	return value, err
+}
+
+// SetUint64 stores the number as the value of the key
+func (s *RaftStore) SetUint64(key []byte, value uint64) error {
+	return s.Set(key, raftKey(value))
+}
+
+// GetUint64 returns the number stored as the value of the key, or 0 if it was not found
+func (s *RaftStore) GetUint64(key []byte) (uint64, error) {
+	value, err := s.Get(key)
+	if errors.Is(err, errRaftKeyNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint64(value), nil
+}
+
+// Close closes the Raft database
+func (s *RaftStore) Close() error {
+	return s.db.Close()
+}
+
+// raftCommand is a single command of the Raft log, the note is kept at the top level so that older commands still decode
+// Idempotency is only set when the client made the write with an idempotency key
+// Commands with PurgeBefore purge the tombstones deleted before it instead of writing the note
+type raftCommand struct {
+	common.Note
+	Idempotency *common.IdempotentWrite `json:"idempotency,omitempty"`
+	PurgeBefore *time.Time              `json:"purgeBefore,omitempty"`
+}
+
+// RaftCommand encodes the note as a command of the Raft log, along with the idempotency key it was written with
+// Every command writes a whole note, deletions write tombstones, so applying a command twice changes nothing
+func RaftCommand(note common.Note, write *common.IdempotentWrite) (error, []byte) {
+	data, err := json.Marshal(raftCommand{Note: note, Idempotency: write})
+	if err != nil {
+		msg := fmt.Sprintf("error marshalling note %d to JSON: %v", note.Id, err)
+		return errors.New(msg), nil
+	}
+
+	return nil, data
+}
+
+// RaftPurgeCommand encodes purging the tombstones deleted before the given time as a command of the Raft log
+// Every replica purges the same tombstones once applied, since the time is part of the command
+func RaftPurgeCommand(before time.Time) (error, []byte) {
+	data, err := json.Marshal(raftCommand{PurgeBefore: &before})
+	if err != nil {
+		msg := fmt.Sprintf("error marshalling purge command to JSON: %v", err)
+		return errors.New(msg), nil
+	}
+
+	return nil, data
+}
+
+// raftFSM applies the committed commands of the Raft log to the notes of the handler
+type raftFSM struct {
+	h *Handler
+}
+
+// RaftFSM returns the state machine of the Raft log, which is the notes of this handler
+func (h *Handler) RaftFSM() raft.FSM {
+	return &raftFSM{h: h}
+}
+
+// Apply writes the note of the committed command, the reply is nil or the error
+// The idempotency key of the command is remembered on every replica, so that any later leader answers retries
+// Purge commands reply the number of purged tombstones instead
+func (f *raftFSM) Apply(log *raft.Log) interface{} {
+	var command raftCommand
+	err := json.Unmarshal(log.Data, &command)
+	if err != nil {
+		logger.Error("Error decoding raft command", logger.Fields{"index": log.Index, "err": err})
+		return err
+	}
+	if command.PurgeBefore != nil {
+		return f.h.PurgeTombstones(*command.PurgeBefore)
+	}
+	note := command.Note
+
+	// Commands are replayed after restarts, the notes might have the version already
+	err, _ = f.h.WriteNoteIfNewer(note)
+	if err != nil && !errors.Is(err, ErrStaleVersion) {
+		logger.Error("Error applying raft command", logger.Fields{"index": log.Index, "note_id": note.Id, "err": err})
+		return err
+	}
+
+	if command.Idempotency != nil {
+		err = f.h.RememberWrite(*command.Idempotency)
+		if err != nil {
+			logger.Warn("Could not remember idempotency key", logger.Fields{"index": log.Index, "note_id": note.Id, "err": err})
+		}
+	}
+	return nil
+}
+
+// Snapshot captures all notes including the tombstones, Apply is never called at the same time
+func (f *raftFSM) Snapshot() (raft.FSMSnapshot, error) {
+	return &raftSnapshot{notes: f.h.ReadAllRaw()}, nil
+}
+
+// Restore discards all notes and replaces them with the notes of the snapshot
+func (f *raftFSM) Restore(snapshot io.ReadCloser) error {
+	defer snapshot.Close()
+
+	var notes []common.Note
+	err := json.NewDecoder(snapshot).Decode(&notes)
+	if err != nil {
+		return err
+	}
+
+	err = f.h.replaceAll(notes)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Restored notes from raft snapshot", logger.Fields{"notes": len(notes)})
+	return nil
+}
+
+// raftSnapshot is the notes captured at a point of the Raft log
+type raftSnapshot struct {
+	notes []common.Note
+}
+
+// Persist writes the notes to the sink as a JSON array
+func (s *raftSnapshot) Persist(sink raft.SnapshotSink) error {
+	err := writeSnapshot(sink, s.notes)
+	if err != nil {
+		_ = sink.Cancel()
+		return err
+	}
+
+	return sink.Close()
+}
+
+// Release does nothing, the captured notes are garbage collected
+func (s *raftSnapshot) Release() {}
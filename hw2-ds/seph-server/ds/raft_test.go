@@ -0,0 +1,54 @@
+package ds
+
+import (
+	"errors"
+	"github.com/hashicorp/raft"
+	"seph/common"
+	"testing"
+	"time"
+)
+
+// TestRaftFSMApply applies the commands of a Raft log in order, replaying the log must change nothing
+func TestRaftFSMApply(t *testing.T) {
+	now := time.Now().UTC()
+	command := func(data []byte, err error) []byte {
+		if err != nil {
+			t.Fatalf("could not encode command: %v", err)
+		}
+		return data
+	}
+	note := func(note common.Note) []byte {
+		err, data := RaftCommand(note, nil)
+		return command(data, err)
+	}
+	purge := func(before time.Time) []byte {
+		err, data := RaftPurgeCommand(before)
+		return command(data, err)
+	}
+
+	logs := [][]byte{
+		note(common.Note{Id: 1, Title: "a", Version: 1, LastModified: now.Add(-time.Hour)}),
+		note(common.Note{Id: 2, Title: "b", Version: 1, LastModified: now.Add(-time.Hour)}),
+		note(common.Note{Id: 1, Version: 2, LastModified: now.Add(-time.Minute), Deleted: true}),
+		note(common.Note{Id: 2, Version: 2, LastModified: now, Deleted: true}),
+		purge(now.Add(-time.Second)),
+	}
+
+	h := newTestHandler(t)
+	fsm := h.RaftFSM()
+	for replay := 0; replay < 2; replay++ {
+		for i, data := range logs {
+			reply := fsm.Apply(&raft.Log{Index: uint64(i + 1), Data: data})
+			if err, ok := reply.(error); ok {
+				t.Fatalf("could not apply command %d: %v", i+1, err)
+			}
+		}
+
+		if err, _ := h.ReadRaw(1); !errors.Is(err, ErrNotFound) {
+			t.Errorf("tombstone of expired note = %v, want purged", err)
+		}
+		if err, tombstone := h.ReadRaw(2); err != nil || !tombstone.Deleted {
+			t.Errorf("tombstone of recent note = %v, %v, want kept", err, tombstone)
+		}
+	}
+}
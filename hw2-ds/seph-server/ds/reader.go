@@ -1,102 +1,85 @@
 package ds
 
 import (
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"log"
-	"os"
-	"path"
-	"path/filepath"
 	"seph/common"
-	"strconv"
-	"strings"
+	"seph/logger"
 )
 
-// ReadAll reads all notes in the target directory
+// ReadAll reads all notes in the store, deleted notes are left out
 func (h *Handler) ReadAll() []common.Note {
-	// Open the target directory which stores all notes
-	files, err := os.ReadDir(h.targetDir)
-	if err != nil {
-		log.Printf("[Seph] Error reading directory %s: %v\n", h.targetDir, err)
-		return nil
-	}
-
-	// Create an empty note for storing every note in the directory
 	allNotes := make([]common.Note, 0)
-
-	// Read all .json files in the target directory
-	for _, file := range files {
-		if filepath.Ext(file.Name()) == ".json" {
-			filePath := filepath.Join(h.targetDir, file.Name())
-			err, content := h.readNoteFromFile(filePath)
-			if err != nil {
-				log.Printf("[Seph] Error reading note from file %s: %v\n", filePath, err)
-				continue
-			}
-
-			// Append the note
-			allNotes = append(allNotes, content)
+	for _, note := range h.ReadAllRaw() {
+		if !note.Deleted {
+			allNotes = append(allNotes, note)
 		}
 	}
 
 	return allNotes
 }
 
-// ReadSpecific reads specific designated note file
-// The convention for a note is NOTE_ID.json, ex 1.json
-func (h *Handler) ReadSpecific(id int) (error, common.Note) {
-	// Construct target file name
-	fileName := fmt.Sprintf("%d.json", id)
-	fileName = path.Join(h.targetDir, fileName)
+// ReadAllRaw reads all notes in the store, including the tombstones of deleted notes
+func (h *Handler) ReadAllRaw() []common.Note {
+	err, allNotes := h.store.List()
+	if err != nil {
+		logger.Error("Error listing notes", logger.Fields{"err": err})
+		return nil
+	}
 
-	return h.readNoteFromFile(fileName)
+	return allNotes
 }
 
-// AssignNewID assigns a new ID for new note
-// For example, if last file was 3.json, this will return 4
-func (h *Handler) AssignNewID() (error, int) {
-	// Open the target directory which stores all notes
-	files, err := os.ReadDir(h.targetDir)
-	if err != nil {
-		log.Printf("[Seph] Error reading directory %s: %v", h.targetDir, err)
-		return nil, -1
+// ReadSpecific reads specific designated note
+// If the note did not exist or was deleted, this returns ErrNotFound
+func (h *Handler) ReadSpecific(id int) (error, common.Note) {
+	err, note := h.store.Get(id)
+	if err == nil && note.Deleted {
+		return notFound(id), common.Note{}
 	}
+	return err, note
+}
 
-	lastIndex := -1
-
-	// Read all .json files in the target directory
-	for _, file := range files {
-		if filepath.Ext(file.Name()) == ".json" {
-			fileNameWithoutExtension := strings.Split(file.Name(), ".")[0]
-			val, err := strconv.Atoi(fileNameWithoutExtension)
-			if err != nil {
-				log.Printf("[Seph] Error converting file name %s to integer: %v", file.Name(), err)
-			}
+// ReadRaw reads specific designated note, including the tombstone of a deleted note
+func (h *Handler) ReadRaw(id int) (error, common.Note) {
+	return h.store.Get(id)
+}
 
-			// If this value was larger than the last index, set this as the last index
-			if lastIndex < val {
-				lastIndex = val
-			}
-		}
+// Digest returns the versions of all notes in the store, including the tombstones
+func (h *Handler) Digest() []common.NoteDigest {
+	notes := h.ReadAllRaw()
+	digest := make([]common.NoteDigest, 0, len(notes))
+	for _, note := range notes {
+		digest = append(digest, DigestOf(note))
 	}
 
-	return nil, lastIndex + 1
+	return digest
 }
 
-// readNoteFromFile reads a specific file as note format
-func (h *Handler) readNoteFromFile(filePath string) (error, common.Note) {
-	// Read the designated file
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return err, common.Note{}
+// DigestOf returns the digest of the note, the hash covers every field Newer compares
+// Times are hashed as Unix nanoseconds, so that notes decoded in other time zones hash the same
+func DigestOf(note common.Note) common.NoteDigest {
+	hash := sha256.New()
+	_, _ = fmt.Fprintf(hash, "%d\x00%d\x00%s\x00%t\x00%s\x00%s",
+		note.Version, note.LastModified.UnixNano(), note.Writer, note.Deleted, note.Title, note.Body)
+
+	return common.NoteDigest{
+		Id:      note.Id,
+		Version: note.Version,
+		Deleted: note.Deleted,
+		Hash:    hex.EncodeToString(hash.Sum(nil)[:16]),
 	}
+}
 
-	// Try unmarshalling into target file
-	var content common.Note
-	err = json.Unmarshal(data, &content)
+// AssignNewID assigns a new ID for new note, IDs of deleted notes are never reused
+// For example, if last note was 3, this will return 4 when there is no ID stripe
+func (h *Handler) AssignNewID() (error, int) {
+	err, newID := h.store.NextID(h.idStride, h.idOffset)
 	if err != nil {
-		return err, content
+		logger.Error("Error assigning new ID", logger.Fields{"err": err})
+		return err, -1
 	}
 
-	return nil, content
+	return nil, newID
 }
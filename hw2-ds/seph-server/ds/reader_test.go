@@ -0,0 +1,60 @@
+package ds
+
+import (
+	"seph/common"
+	"testing"
+	"time"
+)
+
+func TestDigestOf(t *testing.T) {
+	now := time.Now().UTC()
+	base := common.Note{Id: 1, Title: "t", Body: "b", Version: 2, LastModified: now, Writer: "127.0.0.1:8001"}
+
+	with := func(change func(note *common.Note)) common.Note {
+		note := base
+		change(&note)
+		return note
+	}
+
+	tests := []struct {
+		name string
+		note common.Note
+		same bool
+	}{
+		{"same note", base, true},
+		{"other time zone", with(func(n *common.Note) { n.LastModified = now.In(time.FixedZone("KST", 9*60*60)) }), true},
+		{"other writer", with(func(n *common.Note) { n.Writer = "127.0.0.1:8002" }), false},
+		{"other time", with(func(n *common.Note) { n.LastModified = now.Add(time.Nanosecond) }), false},
+		{"other title", with(func(n *common.Note) { n.Title = "u" }), false},
+		{"other body", with(func(n *common.Note) { n.Body = "c" }), false},
+		{"tombstone", with(func(n *common.Note) { n.Deleted = true }), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, b := DigestOf(base), DigestOf(test.note)
+			if a.Version != b.Version || a.Id != b.Id {
+				t.Fatalf("digests of version %d and %d", a.Version, b.Version)
+			}
+			if (a.Hash == b.Hash) != test.same {
+				t.Errorf("hash %s and %s, want same %v", a.Hash, b.Hash, test.same)
+			}
+		})
+	}
+}
+
+func TestLastAlive(t *testing.T) {
+	dir := t.TempDir()
+	if err, _, ok := LoadLastAlive(dir); err != nil || ok {
+		t.Fatalf("LoadLastAlive before saving = %v, %v, want nothing", err, ok)
+	}
+
+	now := time.Now()
+	if err := SaveLastAlive(dir, now); err != nil {
+		t.Fatalf("could not save time: %v", err)
+	}
+	err, lastAlive, ok := LoadLastAlive(dir)
+	if err != nil || !ok || !lastAlive.Equal(now) {
+		t.Errorf("LoadLastAlive = %v, %v, %v, want %v", err, lastAlive, ok, now)
+	}
+}
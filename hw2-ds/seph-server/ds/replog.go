@@ -0,0 +1,358 @@
+package ds
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"seph/common"
+	"seph/logger"
+	"strings"
+	"sync"
+)
+
+// Names of the replication log files in the target directory
+const (
+	replLogFileName  = "seph.replog"
+	epochFileName    = "seph.epoch"
+	cursorsFileName  = "seph.cursors"
+	restoredFileName = "seph.restored"
+)
+
+// replLogMaxChanges is the number of changes which triggers a compaction of the replication log
+// Half of the changes are dropped on compaction, peers which are further behind need a snapshot
+const replLogMaxChanges = 10000
+
+// ErrChangesUnavailable is returned when the changes asked for are not in the replication log anymore
+var ErrChangesUnavailable = errors.New("changes are not available in the replication log")
+
+// cursor is the position in the replication log of a peer, up to which this replica has applied changes
+type cursor struct {
+	Epoch string `json:"epoch"`
+	Seq   uint64 `json:"seq"`
+}
+
+// replLog represents the replication log of this replica, every write to the store is logged with a sequence number
+// Peers ask for the changes after the last sequence number they saw, so that they can catch up without a full copy
+// The epoch changes whenever the log starts over, so that peers never compare sequence numbers of different logs
+type replLog struct {
+	lock      sync.Mutex
+	dir       string // Empty when the log is kept in memory only
+	file      *os.File
+	epoch     string
+	compacted uint64 // All changes up to this sequence number were dropped
+	restored  uint64 // All changes up to this sequence number were superseded by restoring the store
+	last      uint64
+	changes   []common.Change
+	cursors   map[string]cursor
+}
+
+// openReplLog opens the replication log of the target directory
+// When persistent was false, the log is kept in memory only and starts over with a new epoch
+func openReplLog(targetDir string, persistent bool) (error, *replLog) {
+	l := &replLog{
+		lock:    sync.Mutex{},
+		changes: make([]common.Change, 0),
+		cursors: make(map[string]cursor),
+	}
+
+	if !persistent {
+		l.epoch = newEpoch()
+		return nil, l
+	}
+	l.dir = targetDir
+
+	// The epoch is created along with the log, a missing epoch means the log must start over
+	data, err := os.ReadFile(path.Join(targetDir, epochFileName))
+	if err == nil {
+		l.epoch = strings.TrimSpace(string(data))
+	} else if !os.IsNotExist(err) {
+		return err, nil
+	}
+
+	flags := os.O_CREATE | os.O_RDWR
+	if len(l.epoch) == 0 {
+		l.epoch = newEpoch()
+		flags |= os.O_TRUNC
+		err = writeFileAtomic(path.Join(targetDir, epochFileName), []byte(l.epoch))
+		if err != nil {
+			return err, nil
+		}
+	}
+
+	logPath := path.Join(targetDir, replLogFileName)
+	l.file, err = os.OpenFile(logPath, flags, 0644)
+	if err != nil {
+		msg := fmt.Sprintf("could not open replication log %s: %v", logPath, err)
+		return errors.New(msg), nil
+	}
+
+	err, lines := readChecksummedLines(l.file, "replication log")
+	if err != nil {
+		_ = l.file.Close()
+		msg := fmt.Sprintf("could not read replication log %s: %v", logPath, err)
+		return errors.New(msg), nil
+	}
+	for _, line := range lines {
+		var change common.Change
+		err = json.Unmarshal(line, &change)
+		if err != nil {
+			logger.Warn("Discarding malformed replication log record", logger.Fields{"after": len(l.changes), "err": err})
+			break
+		}
+
+		l.changes = append(l.changes, change)
+	}
+	if len(l.changes) != 0 {
+		l.compacted = l.changes[0].Seq - 1
+		l.last = l.changes[len(l.changes)-1].Seq
+	}
+
+	// Torn records at the end were never acknowledged, so drop them before appending anything
+	err = l.rewrite()
+	if err != nil {
+		_ = l.file.Close()
+		return err, nil
+	}
+
+	// Read the positions in the logs of the peers, they are only valid along with this log
+	data, err = os.ReadFile(path.Join(targetDir, cursorsFileName))
+	if err == nil {
+		err = json.Unmarshal(data, &l.cursors)
+		if err != nil {
+			logger.Warn("Discarding malformed cursors of peers", logger.Fields{"err": err})
+			l.cursors = make(map[string]cursor)
+		}
+	} else if !os.IsNotExist(err) {
+		_ = l.file.Close()
+		return err, nil
+	}
+
+	// The restore is kept as a position in the log, so it is only valid along with this log as well
+	data, err = os.ReadFile(path.Join(targetDir, restoredFileName))
+	if err == nil {
+		var restored cursor
+		err = json.Unmarshal(data, &restored)
+		if err != nil {
+			logger.Warn("Discarding malformed position of the last restore", logger.Fields{"err": err})
+		} else if restored.Epoch == l.epoch {
+			l.restored = restored.Seq
+		}
+	} else if !os.IsNotExist(err) {
+		_ = l.file.Close()
+		return err, nil
+	}
+
+	logger.Info("Loaded replication log",
+		logger.Fields{"epoch": l.epoch, "first": l.compacted + 1, "last": l.last, "restored": l.restored})
+	return nil, l
+}
+
+// newEpoch returns a new random epoch
+func newEpoch() string {
+	epoch := make([]byte, 8)
+	_, _ = rand.Read(epoch)
+	return hex.EncodeToString(epoch)
+}
+
+// append logs the note as the next change
+func (l *replLog) append(note common.Note) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	change := common.Change{Seq: l.last + 1, Note: note}
+	if l.file != nil {
+		changeJSON, err := json.Marshal(change)
+		if err != nil {
+			msg := fmt.Sprintf("error marshalling replication log record: %v", err)
+			return errors.New(msg)
+		}
+
+		_, err = l.file.Write([]byte(checksummedLine(changeJSON)))
+		if err == nil {
+			err = l.file.Sync()
+		}
+		if err != nil {
+			msg := fmt.Sprintf("error appending replication log: %v", err)
+			return errors.New(msg)
+		}
+	}
+
+	l.changes = append(l.changes, change)
+	l.last = change.Seq
+
+	// Keep the log small, peers which are far behind will get a snapshot instead
+	if len(l.changes) > replLogMaxChanges {
+		dropped := len(l.changes) / 2
+		l.changes = append([]common.Change(nil), l.changes[dropped:]...)
+		l.compacted = l.changes[0].Seq - 1
+
+		err := l.rewrite()
+		if err != nil {
+			logger.Warn("Could not compact replication log", logger.Fields{"err": err})
+		}
+	}
+
+	return nil
+}
+
+// rewrite replaces the log file with the changes in memory
+func (l *replLog) rewrite() error {
+	if l.file == nil {
+		return nil
+	}
+
+	var builder strings.Builder
+	for _, change := range l.changes {
+		changeJSON, err := json.Marshal(change)
+		if err != nil {
+			return err
+		}
+		builder.WriteString(checksummedLine(changeJSON))
+	}
+
+	logPath := path.Join(l.dir, replLogFileName)
+	err := writeFileAtomic(logPath, []byte(builder.String()))
+	if err != nil {
+		return err
+	}
+
+	// The old file was replaced, so keep appending to the new one
+	file, err := os.OpenFile(logPath, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_ = l.file.Close()
+	l.file = file
+	return nil
+}
+
+// since returns at most limit changes after the sequence number, and whether there were more
+// If some of the changes were already compacted, this returns ErrChangesUnavailable
+func (l *replLog) since(seq uint64, limit int) (error, []common.Change, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if seq < l.compacted || seq > l.last {
+		msg := fmt.Sprintf("asked for changes after %d, log has %d-%d", seq, l.compacted+1, l.last)
+		return fmt.Errorf("%w: %s", ErrChangesUnavailable, msg), nil, false
+	}
+
+	start := int(seq - l.compacted)
+	end := len(l.changes)
+	if end-start > limit {
+		end = start + limit
+	}
+
+	changes := append([]common.Change(nil), l.changes[start:end]...)
+	return nil, changes, end < len(l.changes)
+}
+
+// position returns the epoch and the last sequence number of the log
+func (l *replLog) position() (string, uint64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.epoch, l.last
+}
+
+// cursor returns the position in the log of the peer, up to which this replica has applied changes
+func (l *replLog) cursor(peer string) (cursor, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	c, ok := l.cursors[peer]
+	return c, ok
+}
+
+// setCursor stores the position in the log of the peer
+func (l *replLog) setCursor(peer string, c cursor) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.cursors[peer] = c
+	if len(l.dir) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(l.cursors)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(l.dir, cursorsFileName), data)
+}
+
+// markRestored marks every change logged so far as superseded, since the store was replaced as a whole
+// The notes the restore deleted were not logged, so redoLog must not bring them back from older changes
+func (l *replLog) markRestored() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.restored = l.last
+	if len(l.dir) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(cursor{Epoch: l.epoch, Seq: l.last})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(l.dir, restoredFileName), data)
+}
+
+// redoLog writes the changes of the replication log which the store missed to the store
+// Writes are logged before they are stored, so the store misses the last write when the replica crashed in between
+// Only the last change of every note is redone, and changes up to the last restore are skipped, so that notes
+// deleted without being logged stay deleted
+// Tombstones of notes missing in the store are skipped, since those were purged
+func redoLog(store Store, l *replLog) error {
+	l.lock.Lock()
+	changes := l.changes
+	restored := l.restored
+	l.lock.Unlock()
+
+	redone := 0
+	seen := make(map[int]bool)
+	for i := len(changes) - 1; i >= 0 && changes[i].Seq > restored; i-- {
+		change := changes[i]
+		if seen[change.Note.Id] {
+			continue
+		}
+		seen[change.Note.Id] = true
+
+		err, stored := store.Get(change.Note.Id)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		} else if err == nil && !Newer(change.Note, stored) {
+			continue
+		} else if err != nil && change.Note.Deleted {
+			continue
+		}
+
+		err = store.Put(change.Note)
+		if err != nil {
+			msg := fmt.Sprintf("could not redo change %d of replication log: %v", change.Seq, err)
+			return errors.New(msg)
+		}
+		redone++
+	}
+
+	if redone != 0 {
+		logger.Info("Redone changes the store missed", logger.Fields{"redone": redone})
+	}
+	return nil
+}
+
+// close closes the log file
+func (l *replLog) close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,191 @@
+package ds
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"seph/common"
+	"seph/misc"
+	"testing"
+	"time"
+)
+
+// TestRedoLog logs changes without storing them, as if the replica crashed in between, and reopens the handler
+func TestRedoLog(t *testing.T) {
+	now := time.Now().UTC()
+	stored := common.Note{Id: 1, Title: "stored", Version: 1, LastModified: now}
+
+	tests := []struct {
+		name   string
+		logged common.Note
+		want   *common.Note
+	}{
+		{"missed write", common.Note{Id: 2, Title: "missed", Version: 1, LastModified: now}, &common.Note{Id: 2, Title: "missed", Version: 1}},
+		{"missed update", common.Note{Id: 1, Title: "updated", Version: 2, LastModified: now}, &common.Note{Id: 1, Title: "updated", Version: 2}},
+		{"older write", common.Note{Id: 1, Title: "older", Version: 0, LastModified: now}, &common.Note{Id: 1, Title: "stored", Version: 1}},
+		{"purged tombstone", common.Note{Id: 3, Version: 2, LastModified: now, Deleted: true}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			err, h := New(misc.StorageFile, dir, nil)
+			if err != nil {
+				t.Fatalf("could not open handler: %v", err)
+			}
+			if err := h.putNote(stored); err != nil {
+				t.Fatalf("could not write note: %v", err)
+			}
+			if err := h.log.append(test.logged); err != nil {
+				t.Fatalf("could not log note: %v", err)
+			}
+			_ = h.hints.close()
+			_ = h.log.close()
+			_ = h.store.Close()
+
+			err, h = New(misc.StorageFile, dir, nil)
+			if err != nil {
+				t.Fatalf("could not reopen handler: %v", err)
+			}
+			defer h.store.Close()
+
+			err, note := h.store.Get(test.logged.Id)
+			if test.want == nil {
+				if !errors.Is(err, ErrNotFound) {
+					t.Errorf("err = %v, want ErrNotFound", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("could not read note: %v", err)
+			}
+			if note.Title != test.want.Title || note.Version != test.want.Version {
+				t.Errorf("note = %q version %d, want %q version %d", note.Title, note.Version, test.want.Title, test.want.Version)
+			}
+		})
+	}
+}
+
+// TestRedoLogAfterDeletes checks notes deleted without being logged stay deleted once the handler is reopened
+func TestRedoLogAfterDeletes(t *testing.T) {
+	now := time.Now().UTC()
+	kept := common.Note{Id: 1, Title: "kept", Version: 1, LastModified: now}
+	note := common.Note{Id: 3, Title: "note", Version: 1, LastModified: now.Add(-time.Hour)}
+	tombstone := common.Note{Id: 3, Version: 2, LastModified: now.Add(-time.Hour), Deleted: true}
+
+	tests := []struct {
+		name   string
+		change func(h *Handler) error
+		want   []int // IDs of the notes stored after reopening
+	}{
+		{"purged tombstone", func(h *Handler) error {
+			if err := h.putNote(tombstone); err != nil {
+				return err
+			}
+			h.PurgeTombstones(now)
+			return nil
+		}, []int{1}},
+		{"deleted by restore", func(h *Handler) error {
+			return h.replaceAll([]common.Note{kept})
+		}, []int{1}},
+		{"missed write after restore", func(h *Handler) error {
+			if err := h.replaceAll([]common.Note{kept}); err != nil {
+				return err
+			}
+			return h.log.append(common.Note{Id: 4, Title: "missed", Version: 1, LastModified: now})
+		}, []int{1, 4}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			err, h := New(misc.StorageFile, dir, nil)
+			if err != nil {
+				t.Fatalf("could not open handler: %v", err)
+			}
+			for _, n := range []common.Note{kept, note} {
+				if err := h.putNote(n); err != nil {
+					t.Fatalf("could not write note: %v", err)
+				}
+			}
+			if err := test.change(h); err != nil {
+				t.Fatalf("could not change notes: %v", err)
+			}
+			_ = h.hints.close()
+			_ = h.log.close()
+			_ = h.store.Close()
+
+			err, h = New(misc.StorageFile, dir, nil)
+			if err != nil {
+				t.Fatalf("could not reopen handler: %v", err)
+			}
+			defer h.store.Close()
+
+			got := make([]int, 0)
+			for _, n := range h.ReadAllRaw() {
+				got = append(got, n.Id)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("stored notes %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// TestCrashBeforeStore kills a replica right after it logged writes which its store did not take yet,
+// and checks the writes are in the store once the directory is opened again
+func TestCrashBeforeStore(t *testing.T) {
+	stored := common.Note{Id: 1, Title: "stored", Version: 1, LastModified: time.Now().UTC()}
+	logged := []common.Note{
+		{Id: 1, Title: "updated", Version: 2, LastModified: stored.LastModified.Add(time.Second)},
+		{Id: 2, Title: "created", Version: 1, LastModified: stored.LastModified},
+	}
+
+	// The killed replica runs this test again in another process
+	if dir := os.Getenv("SEPH_CRASH_DIR"); len(dir) != 0 {
+		err, h := New(os.Getenv("SEPH_CRASH_BACKEND"), dir, nil)
+		if err != nil {
+			t.Fatalf("could not open handler: %v", err)
+		}
+		if err := h.putNote(stored); err != nil {
+			t.Fatalf("could not write note: %v", err)
+		}
+		for _, note := range logged {
+			if err := h.log.append(note); err != nil {
+				t.Fatalf("could not log note: %v", err)
+			}
+		}
+
+		process, _ := os.FindProcess(os.Getpid())
+		_ = process.Kill()
+		select {}
+	}
+
+	for _, backend := range []string{misc.StorageFile, misc.StorageBolt} {
+		t.Run(backend, func(t *testing.T) {
+			dir := t.TempDir()
+			cmd := exec.Command(os.Args[0], "-test.run=^TestCrashBeforeStore$")
+			cmd.Env = append(os.Environ(), "SEPH_CRASH_DIR="+dir, "SEPH_CRASH_BACKEND="+backend)
+			output, err := cmd.CombinedOutput()
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) || exitErr.Exited() {
+				t.Fatalf("replica was not killed: %v\n%s", err, output)
+			}
+
+			err, h := New(backend, dir, nil)
+			if err != nil {
+				t.Fatalf("could not reopen handler: %v", err)
+			}
+			defer h.store.Close()
+
+			for _, want := range logged {
+				err, note := h.store.Get(want.Id)
+				if err != nil || note.Title != want.Title || note.Version != want.Version {
+					t.Errorf("note %d = %q version %d (%v), want %q version %d",
+						want.Id, note.Title, note.Version, err, want.Title, want.Version)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package ds
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"seph/common"
+	"seph/misc"
+	"sort"
+)
+
+// ErrNotFound is returned when the note does not exist in the store
+var ErrNotFound = errors.New("note not found")
+
+// Store represents a storage backend of notes
+// All implementations must be safe to use from multiple goroutines
+type Store interface {
+	// Get returns the note of given ID, or ErrNotFound
+	Get(id int) (error, common.Note)
+
+	// List returns all notes in the order of their IDs
+	List() (error, []common.Note)
+
+	// Scan calls fn with each note of ID larger than after in the order of their IDs, until fn returns false
+	// Notes are read one by one, so fn must not keep every note it was called with, nor use the store itself
+	Scan(after int, fn func(note common.Note) bool) error
+
+	// Put creates or overwrites the note, the note must be durable once this returns
+	// The ID is kept as the largest stored one if it is, also for notes allocated by other replicas
+	Put(note common.Note) error
+
+	// Delete removes the note of given ID, or returns ErrNotFound
+	Delete(id int) error
+
+	// NextID returns the ID for a new note, which was never returned nor stored before even across restarts
+	// It never looks at the notes stored now, since deleted notes might have had larger IDs
+	// Only IDs where ID % stride == offset are returned, so that replicas can allocate IDs without collisions
+	NextID(stride int, offset int) (error, int)
+
+	// Snapshot writes a consistent copy of all notes to w, as a JSON array
+	Snapshot(w io.Writer) error
+
+	// Close releases everything the store holds
+	Close() error
+}
+
+// OpenStore opens the storage backend, which keeps its data in the target directory
+// Supported backends are "file", "bolt" and "memory", empty backend defaults to "file"
+func OpenStore(backend string, targetDir string) (error, Store) {
+	switch backend {
+	case "", misc.StorageFile:
+		return openFileStore(targetDir)
+	case misc.StorageBolt:
+		return openBoltStore(targetDir)
+	case misc.StorageMemory:
+		return nil, newMemoryStore()
+	default:
+		msg := fmt.Sprintf("unknown storage backend %s", backend)
+		return errors.New(msg), nil
+	}
+}
+
+// notFound returns ErrNotFound describing the note of given ID
+func notFound(id int) error {
+	return fmt.Errorf("%w: %d", ErrNotFound, id)
+}
+
+// sortNotes sorts the notes in the order of their IDs
+func sortNotes(notes []common.Note) {
+	sort.Slice(notes, func(i, j int) bool { return notes[i].Id < notes[j].Id })
+}
+
+// scanNotes calls fn with each note of ID larger than after in the order of their IDs, until fn returns false
+// Only the IDs are sorted, the notes themselves are not copied
+func scanNotes(notes map[int]common.Note, after int, fn func(note common.Note) bool) {
+	ids := make([]int, 0, len(notes))
+	for id := range notes {
+		if id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		if !fn(notes[id]) {
+			return
+		}
+	}
+}
+
+// nextStripedID returns the smallest ID larger than last, where ID % stride == offset
+func nextStripedID(last int, stride int, offset int) int {
+	if stride < 1 {
+		stride, offset = 1, 0
+	}
+
+	id := last + 1
+	return id + ((offset-id%stride)%stride+stride)%stride
+}
+
+// writeSnapshot writes the notes to w as a JSON array
+func writeSnapshot(w io.Writer, notes []common.Note) error {
+	return json.NewEncoder(w).Encode(notes)
+}
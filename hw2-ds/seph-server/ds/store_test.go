@@ -0,0 +1,169 @@
+package ds
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"seph/common"
+	"seph/misc"
+	"testing"
+)
+
+var backends = []string{misc.StorageMemory, misc.StorageFile, misc.StorageBolt}
+
+// openTestStore opens the store of the backend in the directory, and closes it once the test is done
+func openTestStore(t *testing.T, backend string, dir string) Store {
+	t.Helper()
+
+	err, store := OpenStore(backend, dir)
+	if err != nil {
+		t.Fatalf("could not open %s store: %v", backend, err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func TestNextStripedID(t *testing.T) {
+	tests := []struct {
+		last   int
+		stride int
+		offset int
+		want   int
+	}{
+		{-1, 1, 0, 0},
+		{3, 1, 0, 4},
+		{-1, 3, 1, 1},
+		{1, 3, 1, 4},
+		{2, 3, 1, 4},
+		{4, 3, 0, 6},
+		{4, 0, 5, 5},
+	}
+
+	for _, test := range tests {
+		if got := nextStripedID(test.last, test.stride, test.offset); got != test.want {
+			t.Errorf("nextStripedID(%d, %d, %d) = %d, want %d", test.last, test.stride, test.offset, got, test.want)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	for _, backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			store := openTestStore(t, backend, t.TempDir())
+
+			for _, id := range []int{3, 1, 2} {
+				if err := store.Put(common.Note{Id: id, Title: "note", Version: 1}); err != nil {
+					t.Fatalf("could not put note %d: %v", id, err)
+				}
+			}
+			if err := store.Put(common.Note{Id: 2, Title: "updated", Version: 2}); err != nil {
+				t.Fatalf("could not overwrite note: %v", err)
+			}
+
+			err, note := store.Get(2)
+			if err != nil || note.Title != "updated" {
+				t.Errorf("Get(2) = %v, %q, want updated", err, note.Title)
+			}
+			if err, _ := store.Get(4); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get(4) = %v, want ErrNotFound", err)
+			}
+
+			if err := store.Delete(1); err != nil {
+				t.Errorf("could not delete note: %v", err)
+			}
+			if err := store.Delete(1); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Delete(1) again = %v, want ErrNotFound", err)
+			}
+
+			err, notes := store.List()
+			if err != nil {
+				t.Fatalf("could not list notes: %v", err)
+			}
+			if len(notes) != 2 || notes[0].Id != 2 || notes[1].Id != 3 {
+				t.Errorf("List = %v, want notes 2 and 3", notes)
+			}
+
+			scanned := make([]int, 0)
+			err = store.Scan(2, func(note common.Note) bool {
+				scanned = append(scanned, note.Id)
+				return true
+			})
+			if err != nil || len(scanned) != 1 || scanned[0] != 3 {
+				t.Errorf("Scan(2) = %v, %v, want note 3", err, scanned)
+			}
+		})
+	}
+}
+
+// TestSnapshotUnreadableNote checks a snapshot fails rather than leaving out a note file which cannot be read
+func TestSnapshotUnreadableNote(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, misc.StorageFile, dir)
+	for _, id := range []int{1, 2} {
+		if err := store.Put(common.Note{Id: id, Title: "note", Version: 1}); err != nil {
+			t.Fatalf("could not put note %d: %v", id, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "2.json"), []byte("{"), 0644); err != nil {
+		t.Fatalf("could not corrupt note: %v", err)
+	}
+
+	var snapshot bytes.Buffer
+	if err := store.Snapshot(&snapshot); err == nil {
+		t.Errorf("Snapshot = %q, want error", snapshot.String())
+	}
+}
+
+// TestNextID checks IDs are never reused, also when the largest note was written by another replica and deleted since
+func TestNextID(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored []int
+		delete []int
+		stride int
+		offset int
+		want   int
+	}{
+		{"empty", nil, nil, 1, 0, 0},
+		{"after stored", []int{0, 1, 2}, nil, 1, 0, 3},
+		{"after deleted", []int{0, 1, 2}, []int{2}, 1, 0, 3},
+		{"after replicated and deleted", []int{10}, []int{10}, 3, 1, 13},
+		{"own stripe", []int{0, 3}, nil, 3, 2, 5},
+	}
+
+	for _, backend := range backends {
+		for _, test := range tests {
+			t.Run(backend+"/"+test.name, func(t *testing.T) {
+				dir := t.TempDir()
+				store := openTestStore(t, backend, dir)
+				for _, id := range test.stored {
+					if err := store.Put(common.Note{Id: id}); err != nil {
+						t.Fatalf("could not put note %d: %v", id, err)
+					}
+				}
+				for _, id := range test.delete {
+					if err := store.Delete(id); err != nil {
+						t.Fatalf("could not delete note %d: %v", id, err)
+					}
+				}
+
+				err, id := store.NextID(test.stride, test.offset)
+				if err != nil || id != test.want {
+					t.Fatalf("NextID = %v, %d, want %d", err, id, test.want)
+				}
+				if backend == misc.StorageMemory {
+					return
+				}
+
+				// The allocated ID must not be returned again after a restart
+				_ = store.Close()
+				store = openTestStore(t, backend, dir)
+				err, id = store.NextID(test.stride, test.offset)
+				if err != nil || id != test.want+test.stride {
+					t.Errorf("NextID after reopening = %v, %d, want %d", err, id, test.want+test.stride)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package ds
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// watermarkFileName is the name of the file keeping the largest ID of a purged tombstone in the target directory
+const watermarkFileName = "seph.purged"
+
+// purgeWatermark is the largest ID of the notes whose tombstones were purged, -1 if none was purged yet
+// New IDs are larger than any ID seen before, so notes of IDs at or below it which are missing were deleted long ago,
+// unless they were never replicated for as long as the tombstone TTL
+type purgeWatermark struct {
+	lock sync.Mutex
+	dir  string // Empty if the watermark is kept in memory only
+	id   int
+}
+
+// openPurgeWatermark loads the watermark from the target directory, if it is persistent
+func openPurgeWatermark(targetDir string, persistent bool) (error, *purgeWatermark) {
+	w := &purgeWatermark{lock: sync.Mutex{}, id: -1}
+	if !persistent {
+		return nil, w
+	}
+	w.dir = targetDir
+
+	fileName := path.Join(targetDir, watermarkFileName)
+	data, err := os.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil, w
+	} else if err != nil {
+		return err, nil
+	}
+
+	w.id, err = strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		msg := fmt.Sprintf("could not parse %s: %v", fileName, err)
+		return errors.New(msg), nil
+	}
+	return nil, w
+}
+
+// raise moves the watermark up to the ID, lower IDs change nothing
+func (w *purgeWatermark) raise(id int) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if id <= w.id {
+		return nil
+	}
+	if len(w.dir) != 0 {
+		err := writeFileAtomic(path.Join(w.dir, watermarkFileName), []byte(strconv.Itoa(id)))
+		if err != nil {
+			return err
+		}
+	}
+
+	w.id = id
+	return nil
+}
+
+// covers returns if the tombstone of the note might have been purged
+func (w *purgeWatermark) covers(id int) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return id <= w.id
+}
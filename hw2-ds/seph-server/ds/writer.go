@@ -1,116 +1,257 @@
 package ds
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"os"
-	"path"
 	"seph/common"
+	"seph/logger"
+	"time"
 )
 
-// CreateNote creates a new note file
-// The convention for a note is NOTE_ID.json, ex 1.json
-func (h *Handler) CreateNote(note common.Note) error {
-	// Construct target file name
-	fileName := fmt.Sprintf("%d.json", note.Id)
-	fileName = path.Join(h.targetDir, fileName)
+// ErrStaleVersion is returned when a note older than the stored one is written
+var ErrStaleVersion = errors.New("stale version")
 
-	// Marshal note into JSON
-	noteJSON, err := json.MarshalIndent(note, "", "  ")
-	if err != nil {
-		msg := fmt.Sprintf("error marshalling note %s to JSON: %v", fileName, err)
-		return errors.New(msg)
+// ErrVersionConflict is returned when a note is written on top of a version, but another write got there first
+var ErrVersionConflict = errors.New("version conflict")
+
+// Newer returns if the note a is newer than the note b
+// Two coordinators might write the same version at once, so versions are ordered by the time and the replica
+// which wrote them next, and by their contents last, so that every replica picks the same one
+func Newer(a common.Note, b common.Note) bool {
+	if a.Version != b.Version {
+		return a.Version > b.Version
+	} else if !a.LastModified.Equal(b.LastModified) {
+		return a.LastModified.After(b.LastModified)
+	} else if a.Writer != b.Writer {
+		return a.Writer > b.Writer
+	} else if a.Deleted != b.Deleted {
+		return a.Deleted
+	} else if a.Title != b.Title {
+		return a.Title > b.Title
 	}
+	return a.Body > b.Body
+}
 
-	// Write JSON to file
-	err = os.WriteFile(fileName, noteJSON, 0644)
+// CreateNote creates a new note
+func (h *Handler) CreateNote(note common.Note) error {
+	err := h.putNote(note)
 	if err != nil {
-		msg := fmt.Sprintf("error writing note %s: %v", fileName, err)
+		msg := fmt.Sprintf("error writing note %d: %v", note.Id, err)
 		return errors.New(msg)
 	}
 
 	return nil
 }
 
-// UpdateNote updates an existing note file
-// The note file did not exist, this will return error
+// UpdateNote updates an existing note
+// The note did not exist, this will return error
 func (h *Handler) UpdateNote(note common.Note) error {
-	// Construct target file name
-	fileName := fmt.Sprintf("%d.json", note.Id)
-	fileName = path.Join(h.targetDir, fileName)
-
-	// Check if file exists
-	_, err := os.Stat(fileName)
+	// Check if note exists
+	err, _ := h.store.Get(note.Id)
 	if err != nil {
-		msg := fmt.Sprintf("could not stat note file %s: %v", fileName, err)
+		msg := fmt.Sprintf("could not find note %d: %v", note.Id, err)
 		return errors.New(msg)
 	}
 
-	// This Means that the file exists, so just overwrite
-	// Marshal note into JSON
-	noteJSON, err := json.MarshalIndent(note, "", "  ")
+	// This Means that the note exists, so just overwrite
+	err = h.putNote(note)
 	if err != nil {
-		msg := fmt.Sprintf("error marshalling note %s to JSON: %v", fileName, err)
+		msg := fmt.Sprintf("error writing note %d: %v", note.Id, err)
 		return errors.New(msg)
 	}
 
-	// Write JSON to file
-	err = os.WriteFile(fileName, noteJSON, 0644)
+	return nil
+}
+
+// DeleteNote deletes a specific note
+// If the note did not exist, this returns ErrNotFound
+// The note is replaced with a tombstone of the next version, so that other replicas learn about the deletion
+func (h *Handler) DeleteNote(id int) error {
+	// Reading and writing must be done at once, so lock with mutex
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	err, note := h.ReadSpecific(id)
 	if err != nil {
-		msg := fmt.Sprintf("error writing note %s: %v", fileName, err)
-		return errors.New(msg)
+		return err
 	}
 
-	return nil
+	tombstone := common.Note{
+		Id:           id,
+		Version:      note.Version + 1,
+		LastModified: time.Now().UTC(),
+		Writer:       h.writer,
+		Deleted:      true,
+	}
+	return h.putNote(tombstone)
 }
 
-// DumpNotes dump all notes into the target directory
-// This is meant for initialization process, not intended to be called afterwards
-// This function will not consider any cases which partially failed
-func (h *Handler) DumpNotes(notes []common.Note) {
-	// For all notes, iterate and create
-	for _, note := range notes {
-		err := h.WriteNote(note)
+// ExpiredTombstones returns the number of tombstones of notes deleted before the given time
+func (h *Handler) ExpiredTombstones(before time.Time) int {
+	expired := 0
+	for _, note := range h.ReadAllRaw() {
+		if note.Deleted && note.LastModified.Before(before) {
+			expired++
+		}
+	}
+
+	return expired
+}
+
+// PurgeTombstones removes the tombstones of notes deleted before the given time
+// The purge watermark is raised before the tombstones are removed, see Purged
+// This returns the number of removed tombstones
+func (h *Handler) PurgeTombstones(before time.Time) int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	expired := make([]int, 0)
+	watermark := -1
+	for _, note := range h.ReadAllRaw() {
+		if note.Deleted && note.LastModified.Before(before) {
+			expired = append(expired, note.Id)
+			if note.Id > watermark {
+				watermark = note.Id
+			}
+		}
+	}
+
+	err := h.purged.raise(watermark)
+	if err != nil {
+		logger.Warn("Error raising purge watermark, tombstones are kept", logger.Fields{"watermark": watermark, "err": err})
+		return 0
+	}
+
+	purged := 0
+	for _, id := range expired {
+		err := h.store.Delete(id)
 		if err != nil {
-			log.Printf("[Seph] Error dumping note %d: %v", note.Id, err)
+			logger.Warn("Error purging tombstone", logger.Fields{"note_id": id, "err": err})
 			continue
 		}
+		purged++
 	}
+
+	return purged
 }
 
-// DeleteNote deletes a specific note
-// Yes this will make a fragmentation, but whatsoever
-func (h *Handler) DeleteNote(id int) error {
-	// Construct target file name
-	fileName := fmt.Sprintf("%d.json", id)
-	fileName = path.Join(h.targetDir, fileName)
+// Purged returns if the note might be missing because its tombstone was purged
+// This is the case for IDs at or below the largest ID of a purged tombstone
+func (h *Handler) Purged(id int) bool {
+	return h.purged.covers(id)
+}
 
-	// Try removing file
-	return os.Remove(fileName)
+// putNote logs the note in the replication log so that peers can catch up, then writes it to the store
+// The note is logged first, so a crash before the store took it is redone from the log on open, see redoLog
+func (h *Handler) putNote(note common.Note) error {
+	err := h.log.append(note)
+	if err != nil {
+		return err
+	}
+
+	return h.store.Put(note)
 }
 
-// WriteNote will just force writing note to a file
+// WriteNote will just force writing note
 func (h *Handler) WriteNote(note common.Note) error {
-	// Construct target file name
-	fileName := fmt.Sprintf("%d.json", note.Id)
-	fileName = path.Join(h.targetDir, fileName)
-
-	// This Means that the file exists, so just overwrite
-	// Marshal note into JSON
-	noteJSON, err := json.MarshalIndent(note, "", "  ")
+	err := h.putNote(note)
 	if err != nil {
-		msg := fmt.Sprintf("error marshalling note %s to JSON: %v", fileName, err)
+		msg := fmt.Sprintf("error writing note %d: %v", note.Id, err)
 		return errors.New(msg)
 	}
 
-	// Write JSON to file
-	err = os.WriteFile(fileName, noteJSON, 0644)
+	return nil
+}
+
+// WriteNoteIfNewer writes the note only if it is newer than the stored one, see Newer
+// This returns true when the note was written, and false when the stored note was already the same note
+// If the stored note was even newer than the given note, this returns ErrStaleVersion
+func (h *Handler) WriteNoteIfNewer(note common.Note) (error, bool) {
+	// Comparing and writing must be done at once, so lock with mutex
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	err, stored := h.ReadRaw(note.Id)
+	if err == nil && Newer(stored, note) {
+		msg := fmt.Sprintf("note %d has version %d by %s, got version %d by %s",
+			note.Id, stored.Version, stored.Writer, note.Version, note.Writer)
+		return fmt.Errorf("%w: %s", ErrStaleVersion, msg), false
+	} else if err == nil && !Newer(note, stored) {
+		return nil, false
+	}
+
+	err = h.WriteNote(note)
 	if err != nil {
-		msg := fmt.Sprintf("error writing note %s: %v", fileName, err)
-		return errors.New(msg)
+		return err, false
+	}
+
+	return nil, true
+}
+
+// WriteNoteOnBase writes the note made from the base version, only if no version above the base was stored yet
+// Missing notes count as version 0, and replicas which fell behind the base take the note as well,
+// since it is the whole note and not a change to the base
+// If another write already stored a version above the base, this returns ErrVersionConflict
+// Writing the same note again succeeds, so that retries of a write which was stored are not conflicts
+func (h *Handler) WriteNoteOnBase(note common.Note, base int64) error {
+	// Comparing and writing must be done at once, so lock with mutex
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	err, stored := h.ReadRaw(note.Id)
+	if err == nil && stored.Version > base {
+		if !Newer(stored, note) && !Newer(note, stored) {
+			return nil
+		}
+
+		msg := fmt.Sprintf("note %d has version %d by %s, got version %d by %s on top of version %d",
+			note.Id, stored.Version, stored.Writer, note.Version, note.Writer, base)
+		return fmt.Errorf("%w: %s", ErrVersionConflict, msg)
+	}
+
+	return h.WriteNote(note)
+}
+
+// replaceAll discards every note in the store, then writes the given notes
+func (h *Handler) replaceAll(notes []common.Note) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	err := h.deleteMissing(notes)
+	if err != nil {
+		return err
+	}
+
+	for _, note := range notes {
+		err := h.putNote(note)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteMissing deletes the notes of the store which are not among the given notes, the lock must be held
+// The deletes are not logged, so the replication log is marked as restored first for redoLog to skip the older changes
+func (h *Handler) deleteMissing(notes []common.Note) error {
+	err := h.log.markRestored()
+	if err != nil {
+		return err
 	}
 
+	keep := make(map[int]bool, len(notes))
+	for _, note := range notes {
+		keep[note.Id] = true
+	}
+
+	for _, note := range h.ReadAllRaw() {
+		if !keep[note.Id] {
+			err := h.store.Delete(note.Id)
+			if err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
@@ -0,0 +1,209 @@
+package ds
+
+import (
+	"errors"
+	"seph/common"
+	"seph/misc"
+	"testing"
+	"time"
+)
+
+// newTestHandler opens a handler keeping its notes in memory
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+
+	err, h := New(misc.StorageMemory, t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("could not open handler: %v", err)
+	}
+	return h
+}
+
+func TestNewer(t *testing.T) {
+	now := time.Now().UTC()
+	base := common.Note{Id: 1, Title: "t", Body: "b", Version: 2, LastModified: now, Writer: "127.0.0.1:8001"}
+
+	with := func(change func(note *common.Note)) common.Note {
+		note := base
+		change(&note)
+		return note
+	}
+
+	tests := []struct {
+		name  string
+		a     common.Note
+		b     common.Note
+		newer bool
+	}{
+		{"same note", base, base, false},
+		{"higher version", with(func(n *common.Note) { n.Version = 3; n.LastModified = now.Add(-time.Hour) }), base, true},
+		{"lower version", with(func(n *common.Note) { n.Version = 1; n.LastModified = now.Add(time.Hour) }), base, false},
+		{"later write of the same version", with(func(n *common.Note) { n.LastModified = now.Add(time.Millisecond) }), base, true},
+		{"same time by larger writer", with(func(n *common.Note) { n.Writer = "127.0.0.1:8002" }), base, true},
+		{"same time by smaller writer", with(func(n *common.Note) { n.Writer = "127.0.0.1:8000" }), base, false},
+		{"tombstone of the same version", with(func(n *common.Note) { n.Deleted = true }), base, true},
+		{"other title of the same version", with(func(n *common.Note) { n.Title = "u" }), base, true},
+		{"other body of the same version", with(func(n *common.Note) { n.Body = "a" }), base, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Newer(test.a, test.b); got != test.newer {
+				t.Errorf("Newer = %v, want %v", got, test.newer)
+			}
+			if test.newer && Newer(test.b, test.a) {
+				t.Error("both notes were newer than each other")
+			}
+		})
+	}
+}
+
+// TestWriteNoteIfNewerConverges writes two concurrent writes of the same version in both orders,
+// both replicas must end up with the same note
+func TestWriteNoteIfNewerConverges(t *testing.T) {
+	now := time.Now().UTC()
+	first := common.Note{Id: 1, Title: "first", Version: 2, LastModified: now, Writer: "127.0.0.1:8001"}
+	second := common.Note{Id: 1, Title: "second", Version: 2, LastModified: now, Writer: "127.0.0.1:8002"}
+
+	replicas := []*Handler{newTestHandler(t), newTestHandler(t)}
+	orders := [][]common.Note{{first, second}, {second, first}}
+	for i, h := range replicas {
+		for _, note := range orders[i] {
+			err, _ := h.WriteNoteIfNewer(note)
+			if err != nil && !errors.Is(err, ErrStaleVersion) {
+				t.Fatalf("could not write note: %v", err)
+			}
+		}
+	}
+
+	for i, h := range replicas {
+		err, stored := h.ReadRaw(1)
+		if err != nil {
+			t.Fatalf("replica %d could not read note: %v", i, err)
+		}
+		if stored.Title != second.Title {
+			t.Errorf("replica %d kept %q, want %q", i, stored.Title, second.Title)
+		}
+	}
+}
+
+func TestWriteNoteIfNewer(t *testing.T) {
+	h := newTestHandler(t)
+	now := time.Now().UTC()
+	stored := common.Note{Id: 1, Title: "t", Version: 2, LastModified: now, Writer: "a"}
+	if err, _ := h.WriteNoteIfNewer(stored); err != nil {
+		t.Fatalf("could not write note: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		note    common.Note
+		written bool
+		stale   bool
+	}{
+		{"same note", stored, false, false},
+		{"older version", common.Note{Id: 1, Title: "old", Version: 1, LastModified: now}, false, true},
+		{"losing concurrent write", common.Note{Id: 1, Title: "lost", Version: 2, LastModified: now.Add(-time.Second), Writer: "b"}, false, true},
+		{"newer version", common.Note{Id: 1, Title: "new", Version: 3, LastModified: now, Writer: "a"}, true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err, written := h.WriteNoteIfNewer(test.note)
+			if errors.Is(err, ErrStaleVersion) != test.stale {
+				t.Errorf("err = %v, want stale %v", err, test.stale)
+			} else if !test.stale && err != nil {
+				t.Errorf("could not write note: %v", err)
+			}
+			if written != test.written {
+				t.Errorf("written = %v, want %v", written, test.written)
+			}
+		})
+	}
+}
+
+// TestWriteNoteOnBase checks a note is only written on top of the version it was made from, or a version before it
+func TestWriteNoteOnBase(t *testing.T) {
+	now := time.Now().UTC()
+	stored := common.Note{Id: 1, Title: "t", Version: 3, LastModified: now, Writer: "a"}
+
+	tests := []struct {
+		name     string
+		note     common.Note
+		base     int64
+		conflict bool
+	}{
+		{"new note", common.Note{Id: 2, Title: "new", Version: 1, LastModified: now, Writer: "b"}, 0, false},
+		{"on the stored version", common.Note{Id: 1, Title: "next", Version: 4, LastModified: now, Writer: "b"}, 3, false},
+		{"on a version the replica missed", common.Note{Id: 1, Title: "ahead", Version: 6, LastModified: now, Writer: "b"}, 5, false},
+		{"on an older version", common.Note{Id: 1, Title: "lost", Version: 3, LastModified: now.Add(time.Second), Writer: "b"}, 2, true},
+		{"same note again", stored, 2, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newTestHandler(t)
+			if err := h.WriteNote(stored); err != nil {
+				t.Fatalf("could not write note: %v", err)
+			}
+
+			err := h.WriteNoteOnBase(test.note, test.base)
+			if errors.Is(err, ErrVersionConflict) != test.conflict {
+				t.Fatalf("err = %v, want conflict %v", err, test.conflict)
+			} else if !test.conflict && err != nil {
+				t.Fatalf("could not write note: %v", err)
+			}
+
+			want := test.note
+			if test.conflict {
+				want = stored
+			}
+			err, got := h.ReadRaw(test.note.Id)
+			if err != nil || got.Title != want.Title || got.Version != want.Version {
+				t.Errorf("stored %+v (%v), want %+v", got, err, want)
+			}
+		})
+	}
+}
+
+// TestPurgeWatermark checks the largest ID of the purged tombstones is kept across restarts
+func TestPurgeWatermark(t *testing.T) {
+	now := time.Now().UTC()
+	dir := t.TempDir()
+
+	err, h := New(misc.StorageFile, dir, nil)
+	if err != nil {
+		t.Fatalf("could not open handler: %v", err)
+	}
+	for _, note := range []common.Note{
+		{Id: 3, Version: 2, LastModified: now.Add(-time.Hour), Deleted: true},
+		{Id: 5, Version: 2, LastModified: now.Add(-time.Hour), Deleted: true},
+		{Id: 8, Version: 2, LastModified: now, Deleted: true},
+	} {
+		if err := h.putNote(note); err != nil {
+			t.Fatalf("could not write note: %v", err)
+		}
+	}
+	if h.Purged(0) {
+		t.Error("Purged before purging anything")
+	}
+	if purged := h.PurgeTombstones(now.Add(-time.Minute)); purged != 2 {
+		t.Errorf("purged %d tombstones, want 2", purged)
+	}
+	_ = h.idempotency.close()
+	_ = h.hints.close()
+	_ = h.log.close()
+	_ = h.store.Close()
+
+	err, h = New(misc.StorageFile, dir, nil)
+	if err != nil {
+		t.Fatalf("could not reopen handler: %v", err)
+	}
+	defer h.store.Close()
+
+	for id, purged := range map[int]bool{0: true, 3: true, 5: true, 6: false, 8: false} {
+		if h.Purged(id) != purged {
+			t.Errorf("Purged(%d) = %v, want %v", id, !purged, purged)
+		}
+	}
+}
@@ -1,9 +1,10 @@
 package main
 
 import (
-	"log"
+	"flag"
 	"os"
 	"seph/api"
+	"seph/logger"
 	"seph/misc"
 )
 
@@ -11,15 +12,26 @@ var Config misc.Config
 
 // main is the entry point of this program
 func main() {
+	// Parse log level and format, flags take precedence over $LOG_LEVEL and $LOG_FORMAT
+	logLevel := flag.String("log-level", os.Getenv("LOG_LEVEL"), "log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", os.Getenv("LOG_FORMAT"), "log format: text, json or logfmt")
+	flag.Parse()
+
+	// Initialize logger
+	err := logger.Init(*logLevel, *logFormat)
+	if err != nil {
+		logger.Fatal("Could not initialize logger", logger.Fields{"err": err})
+		return
+	}
+
 	// Check if a command-line argument is provided
-	if len(os.Args) < 2 {
-		log.Printf("Usage: ./%s <config.json>", os.Args[0])
+	if flag.NArg() < 1 {
+		logger.Error("Usage: ./seph [-log-level LEVEL] [-log-format FORMAT] <config.json>", nil)
 		return
 	}
 
 	// Get the YAML file from the command-line argument
-	configFile := os.Args[1]
-	var err error
+	configFile := flag.Arg(0)
 
 	// Parse config file
 	err, Config = misc.Parse(configFile)
@@ -27,15 +39,12 @@ func main() {
 		return
 	}
 
-	// Print logo and initialize colors!
+	// Print logo
 	misc.PrintLogo()
-	misc.InitColoredLogs()
-
-	log.Printf("Loaded config file successfully: ")
 	Config.PrintConfig()
 
 	// Start up the API server
-	h := api.New("0.0.0.0", Config.ServicePort, Config.Sync, Config.Replicas)
+	h := api.New("0.0.0.0", Config)
 	err = h.Run()
 	if err != nil {
 		return
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Predefined metric types, same as the ones of Prometheus text format
+const (
+	TypeCounter = "counter"
+	TypeGauge   = "gauge"
+)
+
+// metric represents a single metric
+type metric struct {
+	kind  string
+	help  string
+	value float64
+}
+
+var (
+	lock    sync.Mutex
+	metrics = map[string]*metric{}
+)
+
+// Register registers a metric with its type and help text, registering twice is a no-op
+func Register(name string, kind string, help string) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, ok := metrics[name]; !ok {
+		metrics[name] = &metric{kind: kind, help: help}
+	}
+}
+
+// Add adds delta to the metric, unregistered metrics are registered as counters
+func Add(name string, delta float64) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	m, ok := metrics[name]
+	if !ok {
+		m = &metric{kind: TypeCounter}
+		metrics[name] = m
+	}
+	m.value += delta
+}
+
+// Set sets the value of the metric, unregistered metrics are registered as gauges
+func Set(name string, value float64) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	m, ok := metrics[name]
+	if !ok {
+		m = &metric{kind: TypeGauge}
+		metrics[name] = m
+	}
+	m.value = value
+}
+
+// Get returns the current value of the metric
+func Get(name string) float64 {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if m, ok := metrics[name]; ok {
+		return m.value
+	}
+	return 0
+}
+
+// Write writes all metrics to w in Prometheus text format, in the order of their names
+func Write(w io.Writer) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		m := metrics[name]
+		if len(m.help) != 0 {
+			if _, err := fmt.Fprintf(w, "# HELP %s %s\n", name, m.help); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "# TYPE %s %s\n%s %v\n", name, m.kind, name, m.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -4,8 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"os"
+	"seph/logger"
 	"strings"
 )
 
@@ -14,6 +14,70 @@ type Config struct {
 	ServicePort int      `json:"servicePort"`
 	Sync        string   `json:"sync"`
 	Replicas    []string `json:"replicas"`
+	WriteQuorum int      `json:"writeQuorum"` // 0 means the majority of the current members
+	ReadQuorum  int      `json:"readQuorum"`  // 0 means the majority of the current members
+	Storage     string   `json:"storage"`
+
+	// MaxReplicas is the number of members the cluster can grow to, the replicas in the config are the initial members
+	// Replicas allocating IDs at once take every MaxReplicas-th ID, so this must not change once notes were written
+	MaxReplicas int `json:"maxReplicas"`
+
+	// ReplicaTimeout is the milliseconds a request to another replica may take
+	ReplicaTimeout int `json:"replicaTimeout"`
+
+	// ReplicaRetries is how many times requests to unreachable replicas are retried, negative disables retrying
+	ReplicaRetries int `json:"replicaRetries"`
+
+	// InternalPortOffset is added to the service port of each replica to get the port replicas talk to each other on
+	InternalPortOffset int `json:"internalPortOffset"`
+
+	// ReplicationBatchSize is the most writes sent to a backup in a single request, negative sends every write on its own
+	ReplicationBatchSize int `json:"replicationBatchSize"`
+
+	// ReplicationPipeline is the number of batches which may be in flight to a backup at once
+	ReplicationPipeline int `json:"replicationPipeline"`
+
+	// AntiEntropyInterval is the seconds between anti-entropy rounds, negative disables anti-entropy
+	AntiEntropyInterval int `json:"antiEntropyInterval"`
+
+	// TombstoneTTL is the seconds to keep the tombstones of deleted notes before purging them
+	TombstoneTTL int `json:"tombstoneTTL"`
+
+	// HeartbeatInterval is the milliseconds between heartbeats of the leader in remote-write mode
+	HeartbeatInterval int `json:"heartbeatInterval"`
+
+	// ElectionTimeout is the milliseconds without heartbeats after which replicas elect a new leader
+	ElectionTimeout int `json:"electionTimeout"`
+
+	// RaftPortOffset is added to the service port of each replica to get the port Raft listens on
+	RaftPortOffset int `json:"raftPortOffset"`
+
+	// RaftSnapshotThreshold is the number of Raft log entries which triggers a snapshot and a compaction of the log
+	RaftSnapshotThreshold int `json:"raftSnapshotThreshold"`
+
+	// HealthInterval is the milliseconds between health probes of the peers
+	HealthInterval int `json:"healthInterval"`
+
+	// PhiThreshold is the suspicion level at which peers are regarded as down, they are suspected at half of it
+	PhiThreshold float64 `json:"phiThreshold"`
+
+	// HintMaxAge is the seconds to keep the writes for unavailable replicas before dropping them without replaying
+	HintMaxAge int `json:"hintMaxAge"`
+
+	// HintMaxSize is the bytes the writes for unavailable replicas may take in total, further writes are not kept
+	HintMaxSize int `json:"hintMaxSize"`
+
+	// PrimaryPolicy decides what a replica does with a write to a note another replica is the primary of
+	// in local-write mode, either moving the note to itself or forwarding the write to the primary
+	PrimaryPolicy string `json:"primaryPolicy"`
+
+	// IdempotencyTTL is the seconds to answer retries of a write with the same idempotency key by its first result
+	IdempotencyTTL int `json:"idempotencyTTL"`
+
+	// PageLimit is the number of notes a page of GET /note has when the client did not ask for a limit
+	// PageMaxLimit is the most notes a page may have, larger limits are lowered to it
+	PageLimit    int `json:"pageLimit"`
+	PageMaxLimit int `json:"pageMaxLimit"`
 }
 
 // Parse parses the designated config file and returns the Config struct
@@ -21,7 +85,7 @@ func Parse(filePath string) (error, Config) {
 	// Read YAML file
 	yamlFile, err := os.ReadFile(filePath)
 	if err != nil {
-		log.Fatalf("[ERROR] Unable to read the config file %s: %v", filePath, err)
+		logger.Fatal("Unable to read the config file", logger.Fields{"file": filePath, "err": err})
 		return err, Config{}
 	}
 
@@ -29,14 +93,103 @@ func Parse(filePath string) (error, Config) {
 	var config Config
 	err = json.Unmarshal(yamlFile, &config)
 	if err != nil {
-		log.Fatalf("[ERROR] Unable to unmarshall the config file %s: %v", filePath, err)
+		logger.Fatal("Unable to unmarshall the config file", logger.Fields{"file": filePath, "err": err})
 		return err, Config{}
 	}
 
+	// The cluster does not grow beyond the replicas in the config by default
+	if config.MaxReplicas == 0 {
+		config.MaxReplicas = len(config.Replicas)
+	}
+
+	// Requests to other replicas time out after 5 seconds, and are retried twice by default
+	if config.ReplicaTimeout == 0 {
+		config.ReplicaTimeout = 5000
+	}
+	if config.ReplicaRetries == 0 {
+		config.ReplicaRetries = 2
+	}
+
+	// Replicas talk to each other 2000 ports above the service port by default
+	if config.InternalPortOffset == 0 {
+		config.InternalPortOffset = 2000
+	}
+
+	// Writes are sent to backups in batches of up to 64, with up to 4 batches in flight to each backup by default
+	if config.ReplicationBatchSize == 0 {
+		config.ReplicationBatchSize = 64
+	}
+	if config.ReplicationPipeline == 0 {
+		config.ReplicationPipeline = 4
+	}
+
+	// Notes are stored as JSON files by default
+	if len(config.Storage) == 0 {
+		config.Storage = StorageFile
+	}
+
+	// Replicas compare their notes every 30 seconds, and keep tombstones for a week by default
+	if config.AntiEntropyInterval == 0 {
+		config.AntiEntropyInterval = 30
+	}
+	if config.TombstoneTTL == 0 {
+		config.TombstoneTTL = 7 * 24 * 60 * 60
+	}
+
+	// Leaders send heartbeats every 500ms, and are replaced after 2 seconds of silence by default
+	if config.HeartbeatInterval == 0 {
+		config.HeartbeatInterval = 500
+	}
+	if config.ElectionTimeout == 0 {
+		config.ElectionTimeout = 2000
+	}
+
+	// Raft listens 1000 ports above the service port, and compacts its log every 8192 entries by default
+	if config.RaftPortOffset == 0 {
+		config.RaftPortOffset = 1000
+	}
+	if config.RaftSnapshotThreshold == 0 {
+		config.RaftSnapshotThreshold = 8192
+	}
+
+	// Peers are probed every second, and regarded as down once phi reached 8 by default
+	if config.HealthInterval == 0 {
+		config.HealthInterval = 1000
+	}
+	if config.PhiThreshold == 0 {
+		config.PhiThreshold = 8
+	}
+
+	// Writes for unavailable replicas are kept for 3 hours, up to 64MiB by default
+	if config.HintMaxAge == 0 {
+		config.HintMaxAge = 3 * 60 * 60
+	}
+	if config.HintMaxSize == 0 {
+		config.HintMaxSize = 64 * 1024 * 1024
+	}
+
+	// Writes move notes to the replica which received them by default, as the local-write protocol does
+	if len(config.PrimaryPolicy) == 0 {
+		config.PrimaryPolicy = PrimaryMigrate
+	}
+
+	// Retries with the same idempotency key are answered by the first result for a day by default
+	if config.IdempotencyTTL == 0 {
+		config.IdempotencyTTL = 24 * 60 * 60
+	}
+
+	// Pages have 100 notes by default, and at most 1000 notes
+	if config.PageLimit == 0 {
+		config.PageLimit = 100
+	}
+	if config.PageMaxLimit == 0 {
+		config.PageMaxLimit = 1000
+	}
+
 	// Now try validating the config file
 	err = config.isValid()
 	if err != nil {
-		log.Fatalf("[ERROR] Unable to load config file properly: %v", err)
+		logger.Fatal("Unable to load config file properly", logger.Fields{"file": filePath, "err": err})
 		return err, Config{}
 	}
 
@@ -47,13 +200,140 @@ func Parse(filePath string) (error, Config) {
 // isValid returns if this config is valid or not
 func (c Config) isValid() error {
 	// First check if sync method was correct or not
-	// Sync method only supports "local-write" or "remote-write"
-	if !strings.Contains(c.Sync, "local-write") && !strings.Contains(c.Sync, "remote-write") {
-		msg := fmt.Sprintf("invalid sync type: %s, supported sync types: \"local-write\" or \"remote-write\"",
+	// Sync method only supports "local-write", "remote-write", "quorum" or "raft"
+	if !strings.Contains(c.Sync, "local-write") && !strings.Contains(c.Sync, "remote-write") &&
+		!strings.Contains(c.Sync, "quorum") && !strings.Contains(c.Sync, "raft") {
+		msg := fmt.Sprintf("invalid sync type: %s, supported sync types: \"local-write\", \"remote-write\", \"quorum\" or \"raft\"",
 			c.Sync)
 		return errors.New(msg)
 	}
 
+	// Members must fit in the cluster
+	if len(c.Replicas) == 0 || c.MaxReplicas < len(c.Replicas) {
+		msg := fmt.Sprintf("invalid max replicas %d, must be at least the %d replicas", c.MaxReplicas, len(c.Replicas))
+		return errors.New(msg)
+	}
+
+	// Quorums must be within the number of replicas, 0 follows the members
+	if c.WriteQuorum < 0 || c.WriteQuorum > c.MaxReplicas {
+		msg := fmt.Sprintf("invalid write quorum %d, range must be 0-%d", c.WriteQuorum, c.MaxReplicas)
+		return errors.New(msg)
+	}
+	if c.ReadQuorum < 0 || c.ReadQuorum > c.MaxReplicas {
+		msg := fmt.Sprintf("invalid read quorum %d, range must be 0-%d", c.ReadQuorum, c.MaxReplicas)
+		return errors.New(msg)
+	}
+
+	// Reads might miss the latest write when the quorums do not overlap, this is allowed but worth telling
+	if strings.Contains(c.Sync, "quorum") && c.WriteQuorum != 0 && c.ReadQuorum != 0 &&
+		c.WriteQuorum+c.ReadQuorum <= len(c.Replicas) {
+		logger.Warn("Write and read quorums do not overlap, reads might return stale notes",
+			logger.Fields{"writeQuorum": c.WriteQuorum, "readQuorum": c.ReadQuorum, "replicas": len(c.Replicas)})
+	}
+
+	// Requests to other replicas must be allowed some time
+	if c.ReplicaTimeout <= 0 {
+		msg := fmt.Sprintf("invalid replica timeout %d, must be positive", c.ReplicaTimeout)
+		return errors.New(msg)
+	}
+
+	// Internal ports must be valid for every replica, and must not be the Raft port
+	if c.InternalPortOffset < 0 || c.ServicePort+c.InternalPortOffset > 65535 {
+		msg := fmt.Sprintf("invalid internal port offset %d, internal port %d must be within 0-65535",
+			c.InternalPortOffset, c.ServicePort+c.InternalPortOffset)
+		return errors.New(msg)
+	}
+	if c.InternalPortOffset == c.RaftPortOffset {
+		msg := fmt.Sprintf("invalid internal port offset %d, must differ from the raft port offset", c.InternalPortOffset)
+		return errors.New(msg)
+	}
+
+	// At least one batch must be allowed in flight to each backup
+	if c.ReplicationPipeline < 1 {
+		msg := fmt.Sprintf("invalid replication pipeline %d, must be positive", c.ReplicationPipeline)
+		return errors.New(msg)
+	}
+
+	// Storage backend only supports "file", "bolt" or "memory"
+	if c.Storage != StorageFile && c.Storage != StorageBolt && c.Storage != StorageMemory {
+		msg := fmt.Sprintf("invalid storage: %s, supported storages: \"file\", \"bolt\" or \"memory\"", c.Storage)
+		return errors.New(msg)
+	}
+
+	// Tombstones must outlive a few anti-entropy rounds, otherwise replicas which missed the delete resurrect the note
+	if c.TombstoneTTL < 0 {
+		msg := fmt.Sprintf("invalid tombstone TTL %d, must be positive", c.TombstoneTTL)
+		return errors.New(msg)
+	}
+	if c.AntiEntropyInterval > 0 && c.TombstoneTTL < 2*c.AntiEntropyInterval {
+		logger.Warn("Tombstone TTL is shorter than two anti-entropy rounds, deleted notes might come back",
+			logger.Fields{"antiEntropyInterval": c.AntiEntropyInterval, "tombstoneTTL": c.TombstoneTTL})
+	}
+
+	// A single lost heartbeat must not start an election
+	if c.HeartbeatInterval <= 0 {
+		msg := fmt.Sprintf("invalid heartbeat interval %d, must be positive", c.HeartbeatInterval)
+		return errors.New(msg)
+	}
+	if c.ElectionTimeout < 2*c.HeartbeatInterval {
+		msg := fmt.Sprintf("invalid election timeout %d, must be at least twice the heartbeat interval %d",
+			c.ElectionTimeout, c.HeartbeatInterval)
+		return errors.New(msg)
+	}
+
+	// Raft ports must be valid for every replica as well
+	if c.RaftPortOffset < 0 || c.ServicePort+c.RaftPortOffset > 65535 {
+		msg := fmt.Sprintf("invalid raft port offset %d, raft port %d must be within 0-65535",
+			c.RaftPortOffset, c.ServicePort+c.RaftPortOffset)
+		return errors.New(msg)
+	}
+	if c.RaftSnapshotThreshold < 1 {
+		msg := fmt.Sprintf("invalid raft snapshot threshold %d, must be positive", c.RaftSnapshotThreshold)
+		return errors.New(msg)
+	}
+
+	// Failure detector must probe and suspect at all
+	if c.HealthInterval <= 0 {
+		msg := fmt.Sprintf("invalid health interval %d, must be positive", c.HealthInterval)
+		return errors.New(msg)
+	}
+	if c.PhiThreshold <= 0 {
+		msg := fmt.Sprintf("invalid phi threshold %v, must be positive", c.PhiThreshold)
+		return errors.New(msg)
+	}
+
+	// Replicas catch up by anti-entropy once their writes were dropped, so the limits only need to be positive
+	if c.HintMaxAge < 0 {
+		msg := fmt.Sprintf("invalid hint max age %d, must be positive", c.HintMaxAge)
+		return errors.New(msg)
+	}
+	if c.HintMaxSize < 0 {
+		msg := fmt.Sprintf("invalid hint max size %d, must be positive", c.HintMaxSize)
+		return errors.New(msg)
+	}
+
+	// Primary policy only supports "migrate" or "forward"
+	if c.PrimaryPolicy != PrimaryMigrate && c.PrimaryPolicy != PrimaryForward {
+		msg := fmt.Sprintf("invalid primary policy: %s, supported primary policies: \"migrate\" or \"forward\"",
+			c.PrimaryPolicy)
+		return errors.New(msg)
+	}
+
+	if c.IdempotencyTTL < 0 {
+		msg := fmt.Sprintf("invalid idempotency TTL %d, must be positive", c.IdempotencyTTL)
+		return errors.New(msg)
+	}
+
+	// The default page must fit in the largest page
+	if c.PageMaxLimit < 0 {
+		msg := fmt.Sprintf("invalid page max limit %d, must be positive", c.PageMaxLimit)
+		return errors.New(msg)
+	}
+	if c.PageLimit < 0 || c.PageLimit > c.PageMaxLimit {
+		msg := fmt.Sprintf("invalid page limit %d, range must be 0-%d", c.PageLimit, c.PageMaxLimit)
+		return errors.New(msg)
+	}
+
 	// Then check if service port is valid or not
 	if c.ServicePort <= 0 || c.ServicePort > 65535 {
 		msg := fmt.Sprintf("invalid service port %d, range must be 0-65535", c.ServicePort)
@@ -66,19 +346,43 @@ func (c Config) isValid() error {
 
 // PrintConfig prints out the contents of the config
 func (c Config) PrintConfig() {
-	log.Printf("- ServicePort: %d", c.ServicePort)
-	log.Printf("- SyncType: %s", c.Sync)
+	logger.Info("Loaded config file successfully", logger.Fields{
+		"servicePort": c.ServicePort,
+		"sync":        c.Sync,
+		"replicas":    strings.Join(c.Replicas, ","),
+		"writeQuorum": c.WriteQuorum,
+		"readQuorum":  c.ReadQuorum,
+		"storage":     c.Storage,
+		"maxReplicas": c.MaxReplicas,
 
-	// Construct replicas and print this out
-	replicaMsg := ""
-	for i, rep := range c.Replicas {
-		msg := fmt.Sprintf("%d) %s ", i, rep)
-		if i != len(c.Replicas)-1 {
-			msg = msg + ", "
-		}
+		"replicaTimeout": c.ReplicaTimeout,
+		"replicaRetries": c.ReplicaRetries,
 
-		replicaMsg = replicaMsg + msg
-	}
+		"internalPortOffset": c.InternalPortOffset,
+
+		"replicationBatchSize": c.ReplicationBatchSize,
+		"replicationPipeline":  c.ReplicationPipeline,
+
+		"antiEntropyInterval": c.AntiEntropyInterval,
+		"tombstoneTTL":        c.TombstoneTTL,
+
+		"heartbeatInterval": c.HeartbeatInterval,
+		"electionTimeout":   c.ElectionTimeout,
+
+		"raftPortOffset":        c.RaftPortOffset,
+		"raftSnapshotThreshold": c.RaftSnapshotThreshold,
+
+		"healthInterval": c.HealthInterval,
+		"phiThreshold":   c.PhiThreshold,
+
+		"hintMaxAge":  c.HintMaxAge,
+		"hintMaxSize": c.HintMaxSize,
+
+		"primaryPolicy": c.PrimaryPolicy,
+
+		"idempotencyTTL": c.IdempotencyTTL,
 
-	log.Printf("- Replicas: %s", replicaMsg)
+		"pageLimit":    c.PageLimit,
+		"pageMaxLimit": c.PageMaxLimit,
+	})
 }
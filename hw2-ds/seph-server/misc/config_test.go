@@ -0,0 +1,113 @@
+package misc
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// parseTestConfig parses the config file of the contents, which must be valid
+func parseTestConfig(t *testing.T, contents string) Config {
+	t.Helper()
+
+	fileName := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(fileName, []byte(contents), 0644); err != nil {
+		t.Fatalf("could not write config: %v", err)
+	}
+
+	err, config := Parse(fileName)
+	if err != nil {
+		t.Fatalf("could not parse config: %v", err)
+	}
+	return config
+}
+
+func TestParseDefaults(t *testing.T) {
+	config := parseTestConfig(t, `{"servicePort": 8001, "sync": "quorum", "replicas": ["127.0.0.1:8001", "127.0.0.1:8002"]}`)
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"maxReplicas", config.MaxReplicas, 2},
+		{"replicaTimeout", config.ReplicaTimeout, 5000},
+		{"replicaRetries", config.ReplicaRetries, 2},
+		{"internalPortOffset", config.InternalPortOffset, 2000},
+		{"replicationBatchSize", config.ReplicationBatchSize, 64},
+		{"replicationPipeline", config.ReplicationPipeline, 4},
+		{"storage", config.Storage, StorageFile},
+		{"antiEntropyInterval", config.AntiEntropyInterval, 30},
+		{"tombstoneTTL", config.TombstoneTTL, 7 * 24 * 60 * 60},
+		{"heartbeatInterval", config.HeartbeatInterval, 500},
+		{"electionTimeout", config.ElectionTimeout, 2000},
+		{"raftPortOffset", config.RaftPortOffset, 1000},
+		{"raftSnapshotThreshold", config.RaftSnapshotThreshold, 8192},
+		{"healthInterval", config.HealthInterval, 1000},
+		{"phiThreshold", config.PhiThreshold, 8.0},
+		{"hintMaxAge", config.HintMaxAge, 3 * 60 * 60},
+		{"hintMaxSize", config.HintMaxSize, 64 * 1024 * 1024},
+		{"primaryPolicy", config.PrimaryPolicy, PrimaryMigrate},
+		{"idempotencyTTL", config.IdempotencyTTL, 24 * 60 * 60},
+		{"pageLimit", config.PageLimit, 100},
+		{"pageMaxLimit", config.PageMaxLimit, 1000},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.got != test.want {
+				t.Errorf("%s = %v (%T), want %v (%T)", test.name, test.got, test.got, test.want, test.want)
+			}
+		})
+	}
+}
+
+func TestIsValid(t *testing.T) {
+	valid := parseTestConfig(t, `{"servicePort": 8001, "sync": "quorum", "replicas": ["127.0.0.1:8001", "127.0.0.1:8002", "127.0.0.1:8003"]}`)
+
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		err    string // Empty if the config stays valid
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"sync", func(c *Config) { c.Sync = "gossip" }, "invalid sync type"},
+		{"no replicas", func(c *Config) { c.Replicas = nil }, "invalid max replicas"},
+		{"max replicas below replicas", func(c *Config) { c.MaxReplicas = 2 }, "invalid max replicas"},
+		{"write quorum above max replicas", func(c *Config) { c.WriteQuorum = 4 }, "invalid write quorum"},
+		{"negative read quorum", func(c *Config) { c.ReadQuorum = -1 }, "invalid read quorum"},
+		{"quorums which do not overlap", func(c *Config) { c.WriteQuorum, c.ReadQuorum = 1, 1 }, ""},
+		{"replica timeout", func(c *Config) { c.ReplicaTimeout = -1 }, "replica timeout"},
+		{"internal port out of range", func(c *Config) { c.InternalPortOffset = 60000 }, "internal port"},
+		{"internal port same as raft", func(c *Config) { c.RaftPortOffset = c.InternalPortOffset }, "must differ from the raft port"},
+		{"pipeline", func(c *Config) { c.ReplicationPipeline = -1 }, "pipeline"},
+		{"storage", func(c *Config) { c.Storage = "tape" }, "storage"},
+		{"negative tombstone TTL", func(c *Config) { c.TombstoneTTL = -1 }, "tombstone TTL"},
+		{"tombstone TTL within two anti-entropy rounds is only warned", func(c *Config) { c.TombstoneTTL = 59 }, ""},
+		{"election timeout within two heartbeats", func(c *Config) { c.ElectionTimeout = 999 }, "election timeout"},
+		{"raft port out of range", func(c *Config) { c.RaftPortOffset = 60000 }, "raft port"},
+		{"phi threshold", func(c *Config) { c.PhiThreshold = -1 }, "phi threshold"},
+		{"hint max age", func(c *Config) { c.HintMaxAge = -1 }, "hint max age"},
+		{"primary policy", func(c *Config) { c.PrimaryPolicy = "steal" }, "primary policy"},
+		{"idempotency TTL", func(c *Config) { c.IdempotencyTTL = -1 }, "idempotency TTL"},
+		{"page max limit", func(c *Config) { c.PageMaxLimit = -1 }, "page max limit"},
+		{"page limit above max", func(c *Config) { c.PageLimit = 1001 }, "invalid page limit 1001, range must be 0-1000"},
+		{"page limit of max", func(c *Config) { c.PageLimit = 1000 }, ""},
+		{"service port", func(c *Config) { c.ServicePort = 0 }, "service port"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := valid
+			test.change(&config)
+
+			err := config.isValid()
+			if len(test.err) == 0 && err != nil {
+				t.Errorf("isValid = %v, want valid", err)
+			} else if len(test.err) != 0 && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("isValid = %v, want %s", err, test.err)
+			}
+		})
+	}
+}
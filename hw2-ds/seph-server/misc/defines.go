@@ -1,12 +1,27 @@
 package misc
 
-// Defne colored outputs
-var (
-	ColoredClient  string
-	ColoredReplica string
+// Predefined sources of requests, used for logging
+const (
+	SourceClient  = "client"
+	SourceReplica = "replica"
 )
 
 const (
 	SyncLocalWrite  = 1
 	SyncRemoteWrite = 2
+	SyncQuorum      = 3
+	SyncRaft        = 4
+)
+
+// Predefined storage backends
+const (
+	StorageFile   = "file"
+	StorageBolt   = "bolt"
+	StorageMemory = "memory"
+)
+
+// Predefined policies for writes to notes of other primaries in local-write mode
+const (
+	PrimaryMigrate = "migrate"
+	PrimaryForward = "forward"
 )
@@ -2,9 +2,6 @@ package misc
 
 import (
 	"fmt"
-	"github.com/fatih/color"
-	"strings"
-	"os"
 )
 
 func PrintLogo() {
@@ -17,14 +14,3 @@ func PrintLogo() {
 	fmt.Println("Simple Distributed Storage")
 	fmt.Println("        32190984 - Isu Kim")
 }
-
-// InitColoredLogs initializes colored log messages, WARN, ERROR, INFO
-func InitColoredLogs() {
-	ColoredClient = color.New(color.FgHiGreen).Sprint("CLIENT")
-	ColoredReplica = color.New(color.FgHiYellow).Sprint("REPLICA")
-}
-
-// IsReplica0 represents if this replica instance was replicas[0]
-func IsReplica0() bool {
-	return strings.Contains(os.Getenv("IS_REPLICA_0"),"TRUE")
-}
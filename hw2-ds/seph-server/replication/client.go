@@ -0,0 +1,639 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc"
+	"io"
+	"net/http"
+	"net/url"
+	"seph/common"
+	"seph/replication/replicationpb"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers replicas use for telling each other about leaders and forwarded requests
+const (
+	HeaderLeader    = "Seph-Leader"
+	HeaderTerm      = "Seph-Term"
+	HeaderForwarded = "Seph-Forwarded"
+)
+
+// Headers which tell the position in the replication log a snapshot was taken at
+const (
+	HeaderEpoch = "Seph-Epoch"
+	HeaderSeq   = "Seph-Seq"
+)
+
+// electionTimeout is how long elections and heartbeats may take
+// Dead replicas must be noticed quickly, so this gives up much earlier than the other requests between replicas
+const electionTimeout = 1 * time.Second
+
+// catchUpTimeout is how long catching up with a replica may take for a single page or snapshot
+// Snapshots can be large, so this waits longer than the other requests between replicas
+const catchUpTimeout = 30 * time.Second
+
+// maxIdleConnsPerHost is the number of idle connections kept open to each replica
+// Every write is sent to every replica, so the default of 2 would open new connections under load
+const maxIdleConnsPerHost = 32
+
+// Client sends the requests replicas make to each other
+// Replication goes over the internal protocol, membership and forwarded client requests go over the public HTTP API
+// Requests which are safe to send twice are retried with exponential backoff while the replica is unreachable
+// or unavailable, timeouts are not retried since the replica might still be working on the request
+type Client struct {
+	http               *http.Client
+	timeout            time.Duration
+	retries            int
+	backoff            time.Duration
+	internalPortOffset int
+
+	connsLock sync.Mutex
+	conns     map[string]*grpc.ClientConn
+}
+
+// Reply is the reply of a forwarded request, which is relayed to the client as it is
+type Reply struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Leader returns the leader the replica told along with the reply, Term is 0 if it told none
+func (r Reply) Leader() Leader {
+	return leaderOf(r.Header)
+}
+
+// request is a single request to a replica
+type request struct {
+	replica  string
+	op       string
+	method   string
+	uri      string
+	body     interface{} // Sent as JSON unless nil
+	header   http.Header
+	retry    bool          // Only requests which are safe to send twice are retried
+	expected []int         // Status codes which are not errors
+	timeout  time.Duration // Overrides the timeout of the client unless 0
+}
+
+// New creates a new client, requests time out after timeout and are retried up to retries times
+// The first retry waits for backoff, and every further retry waits twice as long as the previous one
+// Replicas serve the internal protocol on their service port plus internalPortOffset
+func New(timeout time.Duration, retries int, backoff time.Duration, internalPortOffset int) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 0 // No limit across replicas, the limit per replica applies
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+
+	if retries < 0 {
+		retries = 0
+	}
+	// Requests time out by their own contexts, since some of them wait longer or shorter than the others
+	return &Client{
+		http:               &http.Client{Transport: transport},
+		timeout:            timeout,
+		retries:            retries,
+		backoff:            backoff,
+		internalPortOffset: internalPortOffset,
+		conns:              make(map[string]*grpc.ClientConn),
+	}
+}
+
+// do sends the request, and returns the reply if its status code was one of the expected ones
+func (c *Client) do(r request) (error, Reply) {
+	var payload []byte
+	if r.body != nil {
+		var err error
+		payload, err = json.Marshal(r.body)
+		if err != nil {
+			return &Error{Replica: r.replica, Op: r.op, Err: err}, Reply{}
+		}
+	}
+
+	var reply Reply
+	err := c.retry(r.retry, func() error {
+		var err error
+		err, reply = c.send(r, payload)
+		if err != nil {
+			return err
+		}
+
+		for _, status := range r.expected {
+			if reply.Status == status {
+				return nil
+			}
+		}
+		return &Error{Replica: r.replica, Op: r.op, Status: reply.Status, Leader: reply.Leader(), Err: ErrStatus}
+	})
+	return err, reply
+}
+
+// retry runs the attempt, and runs it again while it failed with a retryable error and retries are left
+func (c *Client) retry(retry bool, attempt func() error) error {
+	backoff := c.backoff
+	for n := 0; ; n++ {
+		err := attempt()
+		if err == nil || !retry || n >= c.retries || !retryable(err) {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// send sends the request once, and reads the whole reply before it times out
+func (c *Client) send(r request, payload []byte) (error, Reply) {
+	timeout := c.timeout
+	if r.timeout != 0 {
+		timeout = r.timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	endpoint := fmt.Sprintf("http://%s%s", r.replica, r.uri)
+	httpRequest, err := http.NewRequestWithContext(ctx, r.method, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return &Error{Replica: r.replica, Op: r.op, Err: err}, Reply{}
+	}
+	for key, values := range r.header {
+		for _, value := range values {
+			httpRequest.Header.Add(key, value)
+		}
+	}
+	if payload != nil && len(httpRequest.Header.Get("Content-Type")) == 0 {
+		httpRequest.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := c.http.Do(httpRequest)
+	if err != nil {
+		return unreachable(r.replica, r.op, err), Reply{}
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return unreachable(r.replica, r.op, err), Reply{}
+	}
+	return nil, Reply{Status: response.StatusCode, Header: response.Header, Body: body}
+}
+
+// retryable returns if the request might succeed when sent again
+func retryable(err error) bool {
+	var replicaErr *Error
+	if errors.As(err, &replicaErr) && replicaErr.Timeout {
+		return false
+	}
+	if IsUnreachable(err) {
+		return true
+	}
+
+	status := StatusOf(err)
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// decode unmarshals the body of the reply, failures are reported as errors of the request
+func decode(r request, reply Reply, v interface{}) error {
+	err := json.Unmarshal(reply.Body, v)
+	if err != nil {
+		return &Error{Replica: r.replica, Op: r.op, Status: reply.Status, Err: err}
+	}
+	return nil
+}
+
+// leaderHeader returns the headers marking the request as sent by the leader, none if the leader was empty
+func leaderHeader(leader Leader) http.Header {
+	header := http.Header{}
+	if len(leader.Replica) != 0 {
+		header.Set(HeaderLeader, leader.Replica)
+		header.Set(HeaderTerm, fmt.Sprintf("%d", leader.Term))
+	}
+	return header
+}
+
+// Mutation is a single write sent to a backup, DELETE deletes the note and any other method writes it
+// Owner is the primary of the note in local-write mode, which the backup stores once it applied the write
+// Idempotency is the idempotency key the client made the write with, which the backup remembers as well
+type Mutation struct {
+	Method      string
+	Note        common.Note
+	Owner       *common.Ownership
+	Idempotency *common.IdempotentWrite
+}
+
+// Ack is the answer of a backup to a single mutation
+// Primary is the primary the backup keeps now, it differs from the owner of the mutation if the backup knew a newer one
+type Ack struct {
+	Err     error
+	Primary common.Ownership
+}
+
+// Backup sends the note to the replica, which applies it unless it already has a newer version
+// In remote-write mode the leader marks the request as its own, the replica rejects leaders of older terms with 421
+func (c *Client) Backup(replica string, note common.Note, leader Leader) error {
+	err, acks := c.BackupBatch(replica, []Mutation{{Method: http.MethodPut, Note: note}}, leader)
+	if err != nil {
+		return err
+	}
+	return acks[0].Err
+}
+
+// DeleteBackup deletes the note from the replica, deleting a note the replica does not have is a success
+func (c *Client) DeleteBackup(replica string, id int, leader Leader) error {
+	err, acks := c.BackupBatch(replica, []Mutation{{Method: http.MethodDelete, Note: common.Note{Id: id}}}, leader)
+	if err != nil {
+		return err
+	}
+	return acks[0].Err
+}
+
+// BackupBatch sends the mutations to the replica in a single request, which applies them in order
+// This returns an error if the request failed as a whole, otherwise the answer to each mutation in the same order
+// Deleting a note the replica does not have is a success
+func (c *Client) BackupBatch(replica string, mutations []Mutation, leader Leader) (error, []Ack) {
+	op := "backup"
+	request := &replicationpb.BackupRequest{
+		Leader:    EncodeLeader(leader),
+		Mutations: make([]*replicationpb.Mutation, 0, len(mutations)),
+	}
+	for _, mutation := range mutations {
+		encoded := &replicationpb.Mutation{Kind: replicationpb.Mutation_WRITE, Note: EncodeNote(mutation.Note)}
+		if mutation.Method == http.MethodDelete {
+			encoded = &replicationpb.Mutation{Kind: replicationpb.Mutation_DELETE, Note: &replicationpb.Note{Id: int64(mutation.Note.Id)}}
+		}
+		if mutation.Owner != nil {
+			encoded.Owner = EncodeOwnership(*mutation.Owner)
+		}
+		if mutation.Idempotency != nil {
+			encoded.Idempotency = EncodeIdempotentWrite(*mutation.Idempotency)
+		}
+		request.Mutations = append(request.Mutations, encoded)
+	}
+
+	var response *replicationpb.BackupResponse
+	err := c.call(replica, op, true, func(ctx context.Context, rpc replicationpb.ReplicationClient) error {
+		var err error
+		response, err = rpc.Backup(ctx, request)
+		if err == nil && (len(response.Results) != len(mutations) || len(response.Owners) != len(mutations)) {
+			return malformed(replica, op)
+		}
+		return err
+	})
+	if err != nil {
+		return err, nil
+	}
+
+	acks := make([]Ack, len(mutations))
+	for i, result := range response.Results {
+		acks[i].Primary = DecodeOwnership(response.Owners[i])
+		if result == replicationpb.Result_NOT_FOUND && mutations[i].Method == http.MethodDelete {
+			continue
+		}
+		acks[i].Err = resultError(replica, op, result, response.Leader)
+	}
+	return nil, acks
+}
+
+// SetPrimary tells the replica about the primary of the note in local-write mode
+// This returns whether the replica accepted the primary, and the primary the replica keeps now
+func (c *Client) SetPrimary(replica string, owner common.Ownership) (error, bool, common.Ownership) {
+	var response *replicationpb.SetPrimaryResponse
+	err := c.call(replica, "set primary", true, func(ctx context.Context, rpc replicationpb.ReplicationClient) error {
+		var err error
+		response, err = rpc.SetPrimary(ctx, &replicationpb.SetPrimaryRequest{Owner: EncodeOwnership(owner)})
+		return err
+	})
+	if err != nil {
+		return err, false, common.Ownership{}
+	}
+	return nil, response.Accepted, DecodeOwnership(response.Current)
+}
+
+// FetchPrimaries fetches the primaries of all notes the replica keeps in local-write mode
+func (c *Client) FetchPrimaries(replica string) (error, []common.Ownership) {
+	var response *replicationpb.FetchPrimariesResponse
+	err := c.call(replica, "fetch primaries", true, func(ctx context.Context, rpc replicationpb.ReplicationClient) error {
+		var err error
+		response, err = rpc.FetchPrimaries(ctx, &replicationpb.FetchPrimariesRequest{})
+		return err
+	})
+	if err != nil {
+		return err, nil
+	}
+
+	owners := make([]common.Ownership, 0, len(response.Owners))
+	for _, owner := range response.Owners {
+		owners = append(owners, DecodeOwnership(owner))
+	}
+	return nil, owners
+}
+
+// WriteNote writes the note to the replica in quorum mode, unless the replica already has a newer version
+// The replica remembers the idempotency key of the write as well, if the client made the write with one
+func (c *Client) WriteNote(replica string, note common.Note, write *common.IdempotentWrite) error {
+	request := &replicationpb.WriteNotesRequest{Notes: []*replicationpb.Note{EncodeNote(note)}}
+	if write != nil {
+		request.Idempotency = []*replicationpb.IdempotentWrite{EncodeIdempotentWrite(*write)}
+	}
+	return c.writeNotes(replica, "write note", request)
+}
+
+// WriteNoteOnBase writes the note made from the base version to the replica in quorum mode,
+// unless the replica already has a version above the base, which fails with 412
+func (c *Client) WriteNoteOnBase(replica string, note common.Note, base int64, write *common.IdempotentWrite) error {
+	request := &replicationpb.WriteNotesRequest{Notes: []*replicationpb.Note{EncodeNote(note)}, BaseVersions: []int64{base}}
+	if write != nil {
+		request.Idempotency = []*replicationpb.IdempotentWrite{EncodeIdempotentWrite(*write)}
+	}
+	return c.writeNotes(replica, "write note on base", request)
+}
+
+// writeNotes sends the request of a single note to the replica, and returns the error of its result
+func (c *Client) writeNotes(replica string, op string, request *replicationpb.WriteNotesRequest) error {
+	return c.call(replica, op, true, func(ctx context.Context, rpc replicationpb.ReplicationClient) error {
+		response, err := rpc.WriteNotes(ctx, request)
+		if err != nil {
+			return err
+		}
+		if len(response.Results) != 1 {
+			return malformed(replica, op)
+		}
+		return resultError(replica, op, response.Results[0], nil)
+	})
+}
+
+// FetchNote fetches the note or its tombstone from the replica, and returns false if the replica had neither
+func (c *Client) FetchNote(replica string, id int) (error, common.Note, bool) {
+	var response *replicationpb.FetchNoteResponse
+	err := c.call(replica, "fetch note", true, func(ctx context.Context, rpc replicationpb.ReplicationClient) error {
+		var err error
+		response, err = rpc.FetchNote(ctx, &replicationpb.FetchNoteRequest{Id: int64(id)})
+		return err
+	})
+	if err != nil || !response.Found {
+		return err, common.Note{}, false
+	}
+	return nil, DecodeNote(response.Note), true
+}
+
+// FetchAll fetches all notes the replica has, tombstones of deleted notes included
+// The replica streams the notes in batches, so that large replicas need not fit into a single message
+func (c *Client) FetchAll(replica string) (error, []common.Note) {
+	var notes []common.Note
+	err := c.call(replica, "fetch all", true, func(ctx context.Context, rpc replicationpb.ReplicationClient) error {
+		stream, err := rpc.Snapshot(ctx, &replicationpb.SnapshotRequest{})
+		if err != nil {
+			return err
+		}
+
+		notes = nil
+		for {
+			batch, err := stream.Recv()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			for _, note := range batch.Notes {
+				notes = append(notes, DecodeNote(note))
+			}
+		}
+	})
+	if err != nil {
+		return err, nil
+	}
+	return nil, notes
+}
+
+// PageQuery selects a single page of notes the way the replica pages its own notes, deleted notes are never selected
+// Pages start right after the cursor if there was one, otherwise after skipping offset notes
+type PageQuery struct {
+	Sort          string
+	Descending    bool
+	TitlePrefix   string
+	TitleContains string
+	After         *common.Note // Only the ID, title and modification time of the cursor are used
+	Offset        int
+	Limit         int
+}
+
+// FetchPage fetches a single page of the notes the replica has, and returns if there were more notes after it
+func (c *Client) FetchPage(replica string, query PageQuery) (error, []common.Note, bool) {
+	request := &replicationpb.FetchPageRequest{
+		Sort:          query.Sort,
+		Descending:    query.Descending,
+		TitlePrefix:   query.TitlePrefix,
+		TitleContains: query.TitleContains,
+		Offset:        int32(query.Offset),
+		Limit:         int32(query.Limit),
+	}
+	if query.After != nil {
+		request.After = EncodeNote(*query.After)
+	}
+
+	var response *replicationpb.FetchPageResponse
+	err := c.call(replica, "fetch page", true, func(ctx context.Context, rpc replicationpb.ReplicationClient) error {
+		var err error
+		response, err = rpc.FetchPage(ctx, request)
+		return err
+	})
+	if err != nil {
+		return err, nil, false
+	}
+
+	notes := make([]common.Note, 0, len(response.Notes))
+	for _, note := range response.Notes {
+		notes = append(notes, DecodeNote(note))
+	}
+	return nil, notes, response.More
+}
+
+// FetchNotes fetches the notes or their tombstones from the replica, notes the replica had neither of are left out
+func (c *Client) FetchNotes(replica string, ids []int) (error, []common.Note) {
+	request := &replicationpb.FetchNotesRequest{Ids: make([]int64, 0, len(ids))}
+	for _, id := range ids {
+		request.Ids = append(request.Ids, int64(id))
+	}
+
+	var response *replicationpb.FetchNotesResponse
+	err := c.call(replica, "fetch notes", true, func(ctx context.Context, rpc replicationpb.ReplicationClient) error {
+		var err error
+		response, err = rpc.FetchNotes(ctx, request)
+		return err
+	})
+	if err != nil {
+		return err, nil
+	}
+
+	notes := make([]common.Note, 0, len(response.Notes))
+	for _, note := range response.Notes {
+		notes = append(notes, DecodeNote(note))
+	}
+	return nil, notes
+}
+
+// FetchDigest fetches the digest of all notes the replica has, for comparing them against the local ones
+// The replica streams the digest in batches, so that large replicas need not fit into a single message
+func (c *Client) FetchDigest(replica string) (error, []common.NoteDigest) {
+	var digest []common.NoteDigest
+	err := c.call(replica, "fetch digest", true, func(ctx context.Context, rpc replicationpb.ReplicationClient) error {
+		stream, err := rpc.FetchDigest(ctx, &replicationpb.FetchDigestRequest{})
+		if err != nil {
+			return err
+		}
+
+		digest = nil
+		for {
+			batch, err := stream.Recv()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			for _, entry := range batch.Digests {
+				digest = append(digest, DecodeDigest(entry))
+			}
+		}
+	})
+	if err != nil {
+		return err, nil
+	}
+	return nil, digest
+}
+
+// SetMembers tells the replica about the members of the cluster
+// This returns whether the replica accepted the members, and the members the replica keeps now
+func (c *Client) SetMembers(replica string, membership common.Membership) (error, bool, common.Membership) {
+	r := request{
+		replica: replica, op: "set members", method: http.MethodPut, uri: "/members", body: membership,
+		retry: true, expected: []int{http.StatusOK, http.StatusConflict},
+	}
+	err, reply := c.do(r)
+	if err != nil {
+		return err, false, common.Membership{}
+	}
+
+	var current common.Membership
+	err = decode(r, reply, &current)
+	if err != nil {
+		return err, false, common.Membership{}
+	}
+	return nil, reply.Status == http.StatusOK, current
+}
+
+// FetchMembers fetches the members of the cluster the replica knows
+func (c *Client) FetchMembers(replica string) (error, common.Membership) {
+	r := request{
+		replica: replica, op: "fetch members", method: http.MethodGet, uri: "/members",
+		retry: true, expected: []int{http.StatusOK},
+	}
+	err, reply := c.do(r)
+	if err != nil {
+		return err, common.Membership{}
+	}
+
+	var membership common.Membership
+	return decode(r, reply, &membership), membership
+}
+
+// Join asks the replica to add the member to the cluster, and returns the members after it was added
+func (c *Client) Join(replica string, member common.Member) (error, common.Membership) {
+	r := request{
+		replica: replica, op: "join", method: http.MethodPost, uri: "/members", body: member,
+		expected: []int{http.StatusOK},
+	}
+	err, reply := c.do(r)
+	if err != nil {
+		return err, common.Membership{}
+	}
+
+	var membership common.Membership
+	return decode(r, reply, &membership), membership
+}
+
+// SendElection sends the message to /election/{kind} of the replica and returns the reply
+// 409 replies carry the current term and leader as well, so they are returned without an error
+// Election messages are never retried, a replica which did not answer in time is regarded as dead
+func (c *Client) SendElection(replica string, kind string, message common.ElectionMessage) (error, common.ElectionMessage) {
+	r := request{
+		replica: replica, op: "election " + kind, method: http.MethodPost, uri: "/election/" + kind, body: message,
+		expected: []int{http.StatusOK, http.StatusConflict}, timeout: electionTimeout,
+	}
+	err, reply := c.do(r)
+	if err != nil {
+		return err, common.ElectionMessage{}
+	}
+
+	var current common.ElectionMessage
+	return decode(r, reply, &current), current
+}
+
+// Probe sends a single health probe to the replica, which fails unless the replica answered 200 within the timeout
+// Probes are never retried, since missed probes are what the failure detector looks for
+func (c *Client) Probe(replica string, timeout time.Duration) error {
+	r := request{
+		replica: replica, op: "probe", method: http.MethodGet, uri: "/health",
+		expected: []int{http.StatusOK}, timeout: timeout,
+	}
+	err, _ := c.do(r)
+	return err
+}
+
+// FetchChanges fetches a page of the changes after the sequence number of the replication log with the given epoch
+// The replica replies 410 once it no longer has the changes, then a snapshot must be fetched instead, see StatusOf
+func (c *Client) FetchChanges(replica string, epoch string, since uint64) (error, common.ChangesResponse) {
+	r := request{
+		replica: replica, op: "fetch changes", method: http.MethodGet,
+		uri:   fmt.Sprintf("/sync/changes?epoch=%s&since=%d", url.QueryEscape(epoch), since),
+		retry: true, expected: []int{http.StatusOK}, timeout: catchUpTimeout,
+	}
+	err, reply := c.do(r)
+	if err != nil {
+		return err, common.ChangesResponse{}
+	}
+
+	var changes common.ChangesResponse
+	return decode(r, reply, &changes), changes
+}
+
+// Snapshot is all notes of a replica, tombstones included, along with the position of its replication log
+// The changes after the position might be in the snapshot already, which is fine since applying them twice changes nothing
+type Snapshot struct {
+	Epoch string
+	Seq   uint64
+	Notes []common.Note
+}
+
+// FetchSnapshot fetches all notes of the replica along with the position of its replication log they were taken at
+func (c *Client) FetchSnapshot(replica string) (error, Snapshot) {
+	r := request{
+		replica: replica, op: "fetch snapshot", method: http.MethodGet, uri: "/sync/snapshot",
+		retry: true, expected: []int{http.StatusOK}, timeout: catchUpTimeout,
+	}
+	err, reply := c.do(r)
+	if err != nil {
+		return err, Snapshot{}
+	}
+
+	seq, err := strconv.ParseUint(reply.Header.Get(HeaderSeq), 10, 64)
+	if err != nil {
+		return &Error{Replica: replica, Op: r.op, Status: reply.Status, Err: err}, Snapshot{}
+	}
+
+	snapshot := Snapshot{Epoch: reply.Header.Get(HeaderEpoch), Seq: seq}
+	return decode(r, reply, &snapshot.Notes), snapshot
+}
+
+// Forward sends a client request to the replica, and returns the reply whatever its status code was
+// Forwarded requests are never retried, since the replica might have applied the write already
+func (c *Client) Forward(replica string, method string, uri string, body []byte, header http.Header) (error, Reply) {
+	r := request{replica: replica, op: "forward", method: method, uri: uri, header: header}
+	err, reply := c.send(r, body)
+	return err, reply
+}
@@ -0,0 +1,274 @@
+package replication
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"seph/common"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testPeer is a replica which answers with the replies in order, and keeps answering with the last one
+// The times of the attempts are kept, so that the backoff between them can be checked
+type testPeer struct {
+	lock     sync.Mutex
+	replies  []func(w http.ResponseWriter)
+	attempts []time.Time
+}
+
+// newTestPeer starts the peer, and returns its address as replicas are written in the config
+func newTestPeer(t *testing.T, replies ...func(w http.ResponseWriter)) (*testPeer, string) {
+	t.Helper()
+
+	p := &testPeer{replies: replies}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.lock.Lock()
+		n := len(p.attempts)
+		p.attempts = append(p.attempts, time.Now())
+		p.lock.Unlock()
+
+		if n >= len(p.replies) {
+			n = len(p.replies) - 1
+		}
+		p.replies[n](w)
+	}))
+	t.Cleanup(server.Close)
+
+	return p, strings.TrimPrefix(server.URL, "http://")
+}
+
+// count returns the number of requests the peer got
+func (p *testPeer) count() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return len(p.attempts)
+}
+
+// replyStatus replies the status code with an empty JSON object
+func replyStatus(code int) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_, _ = w.Write([]byte("{}"))
+	}
+}
+
+// reply replies 200 with the value as JSON
+func reply(v interface{}) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(v)
+	}
+}
+
+// stall replies only after the delay
+func stall(delay time.Duration) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		time.Sleep(delay)
+		replyStatus(http.StatusOK)(w)
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	members := common.Membership{Version: 3}
+
+	tests := []struct {
+		name     string
+		retries  int
+		replies  []func(w http.ResponseWriter)
+		status   int // 0 if the request succeeds
+		attempts int
+	}{
+		{"ok", 2, []func(w http.ResponseWriter){reply(members)}, 0, 1},
+		{"unavailable then ok", 2, []func(w http.ResponseWriter){replyStatus(503), replyStatus(502), reply(members)}, 0, 3},
+		{"unavailable until retries run out", 2, []func(w http.ResponseWriter){replyStatus(503)}, 503, 3},
+		{"no retries", 0, []func(w http.ResponseWriter){replyStatus(503), reply(members)}, 503, 1},
+		{"client errors are not retried", 2, []func(w http.ResponseWriter){replyStatus(400), reply(members)}, 400, 1},
+		{"internal errors are not retried", 2, []func(w http.ResponseWriter){replyStatus(500), reply(members)}, 500, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			peer, replica := newTestPeer(t, test.replies...)
+			c := New(time.Second, test.retries, time.Millisecond, 0)
+
+			err, fetched := c.FetchMembers(replica)
+			if StatusOf(err) != test.status {
+				t.Errorf("err = %v, want status %d", err, test.status)
+			}
+			if test.status == 0 && fetched.Version != members.Version {
+				t.Errorf("members = %v, want %v", fetched, members)
+			}
+			if peer.count() != test.attempts {
+				t.Errorf("attempts = %d, want %d", peer.count(), test.attempts)
+			}
+		})
+	}
+}
+
+// TestClientBackoff checks every retry waits twice as long as the previous one
+func TestClientBackoff(t *testing.T) {
+	backoff := 20 * time.Millisecond
+	peer, replica := newTestPeer(t, replyStatus(http.StatusServiceUnavailable))
+	c := New(time.Second, 3, backoff, 0)
+
+	err, _ := c.FetchMembers(replica)
+	if StatusOf(err) != http.StatusServiceUnavailable {
+		t.Fatalf("err = %v, want status 503", err)
+	}
+	if peer.count() != 4 {
+		t.Fatalf("attempts = %d, want 4", peer.count())
+	}
+
+	for i := 1; i < len(peer.attempts); i++ {
+		gap := peer.attempts[i].Sub(peer.attempts[i-1])
+		want := backoff << (i - 1)
+		if gap < want {
+			t.Errorf("retry %d waited %v, want at least %v", i, gap, want)
+		}
+	}
+}
+
+// TestClientUnreachable checks requests are retried while nobody listens, but never once the replica timed out
+func TestClientUnreachable(t *testing.T) {
+	peer, replica := newTestPeer(t, stall(200*time.Millisecond))
+	c := New(50*time.Millisecond, 2, time.Millisecond, 0)
+
+	err, _ := c.FetchMembers(replica)
+	var replicaErr *Error
+	if !errors.As(err, &replicaErr) || !replicaErr.Timeout || !IsUnreachable(err) {
+		t.Errorf("err = %v, want a timeout", err)
+	}
+	if peer.count() != 1 {
+		t.Errorf("attempts = %d, want 1", peer.count())
+	}
+
+	// Nobody listens on a closed server
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	err, _ = c.FetchMembers(strings.TrimPrefix(closed.URL, "http://"))
+	if !IsUnreachable(err) || errors.As(err, &replicaErr) && replicaErr.Timeout {
+		t.Errorf("err = %v, want unreachable", err)
+	}
+}
+
+// TestClientNeverRetried checks requests which might have been applied already are sent only once
+func TestClientNeverRetried(t *testing.T) {
+	tests := []struct {
+		name string
+		send func(c *Client, replica string) error
+	}{
+		{"forward", func(c *Client, replica string) error {
+			err, reply := c.Forward(replica, http.MethodPost, "/note", []byte(`{"title":"a"}`), http.Header{})
+			if err == nil && reply.Status != http.StatusServiceUnavailable {
+				t.Errorf("status = %d, want 503 relayed as it is", reply.Status)
+			}
+			return err
+		}},
+		{"join", func(c *Client, replica string) error {
+			err, _ := c.Join(replica, common.Member{Id: "127.0.0.1:8004"})
+			if StatusOf(err) != http.StatusServiceUnavailable {
+				t.Errorf("err = %v, want status 503", err)
+			}
+			return nil
+		}},
+		{"election", func(c *Client, replica string) error {
+			err, _ := c.SendElection(replica, "elect", common.ElectionMessage{Term: 1})
+			if StatusOf(err) != http.StatusServiceUnavailable {
+				t.Errorf("err = %v, want status 503", err)
+			}
+			return nil
+		}},
+		{"probe", func(c *Client, replica string) error {
+			if err := c.Probe(replica, time.Second); StatusOf(err) != http.StatusServiceUnavailable {
+				t.Errorf("err = %v, want status 503", err)
+			}
+			return nil
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			peer, replica := newTestPeer(t, replyStatus(http.StatusServiceUnavailable), replyStatus(http.StatusOK))
+			c := New(time.Second, 3, time.Millisecond, 0)
+
+			if err := test.send(c, replica); err != nil {
+				t.Errorf("could not send: %v", err)
+			}
+			if peer.count() != 1 {
+				t.Errorf("attempts = %d, want 1", peer.count())
+			}
+		})
+	}
+}
+
+// TestClientLeaderRedirect checks a replica which is not the leader answers 421 along with the leader to retry against
+func TestClientLeaderRedirect(t *testing.T) {
+	redirect := func(w http.ResponseWriter) {
+		w.Header().Set(HeaderLeader, "127.0.0.1:8001")
+		w.Header().Set(HeaderTerm, "7")
+		replyStatus(http.StatusMisdirectedRequest)(w)
+	}
+	want := Leader{Term: 7, Replica: "127.0.0.1:8001"}
+
+	peer, replica := newTestPeer(t, redirect, replyStatus(http.StatusOK))
+	c := New(time.Second, 3, time.Millisecond, 0)
+
+	err, _, _ := c.SetMembers(replica, common.Membership{Version: 1})
+	if StatusOf(err) != http.StatusMisdirectedRequest {
+		t.Fatalf("err = %v, want status 421", err)
+	}
+	if leader, ok := LeaderOf(err); !ok || leader != want {
+		t.Errorf("leader = %v, want %v", leader, want)
+	}
+	if peer.count() != 1 {
+		t.Errorf("attempts = %d, want 1", peer.count())
+	}
+
+	// Forwarded requests relay the 421 to the client, along with the leader
+	err, forwarded := c.Forward(replica, http.MethodGet, "/note", nil, http.Header{})
+	if err != nil || forwarded.Status != http.StatusOK {
+		t.Fatalf("Forward = %v, %d, want 200", err, forwarded.Status)
+	}
+	peer, replica = newTestPeer(t, redirect)
+	_, forwarded = c.Forward(replica, http.MethodGet, "/note", nil, http.Header{})
+	if forwarded.Leader() != want {
+		t.Errorf("leader = %v, want %v", forwarded.Leader(), want)
+	}
+	if peer.count() != 1 {
+		t.Errorf("attempts = %d, want 1", peer.count())
+	}
+}
+
+func TestClientCatchUp(t *testing.T) {
+	notes := []common.Note{{Id: 1, Version: 2}, {Id: 2, Version: 1, Deleted: true}}
+	snapshot := func(w http.ResponseWriter) {
+		w.Header().Set(HeaderEpoch, "e1")
+		w.Header().Set(HeaderSeq, "42")
+		reply(notes)(w)
+	}
+
+	_, replica := newTestPeer(t, snapshot)
+	c := New(time.Second, 0, time.Millisecond, 0)
+	err, fetched := c.FetchSnapshot(replica)
+	if err != nil || fetched.Epoch != "e1" || fetched.Seq != 42 || len(fetched.Notes) != len(notes) {
+		t.Errorf("FetchSnapshot = %v, %+v, want epoch e1 at 42 with %d notes", err, fetched, len(notes))
+	}
+
+	_, replica = newTestPeer(t, replyStatus(http.StatusGone))
+	if err, _ := c.FetchChanges(replica, "e1", 40); StatusOf(err) != http.StatusGone {
+		t.Errorf("err = %v, want status 410", err)
+	}
+
+	// Catching up may take longer than the other requests
+	_, replica = newTestPeer(t, stall(100*time.Millisecond))
+	c = New(50*time.Millisecond, 0, time.Millisecond, 0)
+	if err, _ := c.FetchChanges(replica, "e1", 40); err != nil {
+		t.Errorf("err = %v, want the reply after the timeout of other requests", err)
+	}
+}
@@ -0,0 +1,112 @@
+package replication
+
+import (
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"seph/common"
+	"seph/replication/replicationpb"
+)
+
+// EncodeNote converts the note into its protobuf message
+func EncodeNote(note common.Note) *replicationpb.Note {
+	return &replicationpb.Note{
+		Id:           int64(note.Id),
+		Title:        note.Title,
+		Body:         note.Body,
+		Version:      note.Version,
+		LastModified: timestamppb.New(note.LastModified),
+		Writer:       note.Writer,
+		Deleted:      note.Deleted,
+	}
+}
+
+// DecodeNote converts the protobuf message into a note, a missing message is an empty note
+func DecodeNote(note *replicationpb.Note) common.Note {
+	if note == nil {
+		return common.Note{}
+	}
+
+	decoded := common.Note{
+		Id:      int(note.Id),
+		Title:   note.Title,
+		Body:    note.Body,
+		Version: note.Version,
+		Writer:  note.Writer,
+		Deleted: note.Deleted,
+	}
+	if note.LastModified != nil {
+		decoded.LastModified = note.LastModified.AsTime().Local()
+	}
+	return decoded
+}
+
+// EncodeDigest converts the digest of a note into its protobuf message
+func EncodeDigest(digest common.NoteDigest) *replicationpb.Digest {
+	return &replicationpb.Digest{Id: int64(digest.Id), Version: digest.Version, Deleted: digest.Deleted, Hash: digest.Hash}
+}
+
+// DecodeDigest converts the protobuf message into the digest of a note
+func DecodeDigest(digest *replicationpb.Digest) common.NoteDigest {
+	if digest == nil {
+		return common.NoteDigest{}
+	}
+	return common.NoteDigest{Id: int(digest.Id), Version: digest.Version, Deleted: digest.Deleted, Hash: digest.Hash}
+}
+
+// EncodeOwnership converts the primary of a note into its protobuf message
+func EncodeOwnership(owner common.Ownership) *replicationpb.Ownership {
+	return &replicationpb.Ownership{Id: int64(owner.Id), Primary: owner.Primary, Epoch: owner.Epoch}
+}
+
+// DecodeOwnership converts the protobuf message into the primary of a note
+func DecodeOwnership(owner *replicationpb.Ownership) common.Ownership {
+	if owner == nil {
+		return common.Ownership{}
+	}
+	return common.Ownership{Id: int(owner.Id), Primary: owner.Primary, Epoch: owner.Epoch}
+}
+
+// EncodeLeader converts the leader into its protobuf message, an empty leader has no message
+func EncodeLeader(leader Leader) *replicationpb.Leader {
+	if len(leader.Replica) == 0 {
+		return nil
+	}
+	return &replicationpb.Leader{Term: leader.Term, Replica: leader.Replica}
+}
+
+// DecodeLeader converts the protobuf message into the leader, a missing message is an empty leader
+func DecodeLeader(leader *replicationpb.Leader) Leader {
+	if leader == nil || len(leader.Replica) == 0 {
+		return Leader{}
+	}
+	return Leader{Term: leader.Term, Replica: leader.Replica}
+}
+
+// EncodeIdempotentWrite converts the write made with an idempotency key into its protobuf message
+func EncodeIdempotentWrite(write common.IdempotentWrite) *replicationpb.IdempotentWrite {
+	return &replicationpb.IdempotentWrite{
+		Key:         write.Key,
+		Fingerprint: write.Fingerprint,
+		Method:      write.Method,
+		Note:        EncodeNote(write.Note),
+		Expires:     timestamppb.New(write.Expires),
+	}
+}
+
+// DecodeIdempotentWrite converts the protobuf message into the write made with an idempotency key
+// A missing message is nil, since most writes are made without idempotency keys
+func DecodeIdempotentWrite(write *replicationpb.IdempotentWrite) *common.IdempotentWrite {
+	if write == nil {
+		return nil
+	}
+
+	decoded := &common.IdempotentWrite{
+		Key:         write.Key,
+		Fingerprint: write.Fingerprint,
+		Method:      write.Method,
+		Note:        DecodeNote(write.Note),
+	}
+	if write.Expires != nil {
+		decoded.Expires = write.Expires.AsTime().Local()
+	}
+	return decoded
+}
@@ -0,0 +1,85 @@
+package replication
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// ErrUnreachable is wrapped by errors of requests which the replica never answered
+var ErrUnreachable = errors.New("replica is unreachable")
+
+// ErrStatus is wrapped by errors of requests which the replica answered with an unexpected status code
+var ErrStatus = errors.New("unexpected status code")
+
+// Leader is the leader of remote-write mode a replica told along with its reply, Term is 0 if it told none
+type Leader struct {
+	Term    uint64
+	Replica string
+}
+
+// Error represents a failed request to a replica
+// Status is 0 when the replica never answered, Leader is set when the replica told a newer leader with 421
+type Error struct {
+	Replica string
+	Op      string
+	Status  int
+	Timeout bool // The replica did not answer in time, it might still be working on the request
+	Leader  Leader
+	Err     error
+}
+
+// Error returns the message of the error
+func (e *Error) Error() string {
+	if e.Status == 0 {
+		return fmt.Sprintf("%s to %s: %v", e.Op, e.Replica, e.Err)
+	}
+	return fmt.Sprintf("%s to %s: %v %d", e.Op, e.Replica, e.Err, e.Status)
+}
+
+// Unwrap returns the cause of the error, either ErrUnreachable or ErrStatus
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// IsUnreachable returns if the error was because the replica never answered
+func IsUnreachable(err error) bool {
+	return errors.Is(err, ErrUnreachable)
+}
+
+// StatusOf returns the status code the replica answered with, or 0 if the replica never answered
+func StatusOf(err error) int {
+	var replicaErr *Error
+	if errors.As(err, &replicaErr) {
+		return replicaErr.Status
+	}
+	return 0
+}
+
+// LeaderOf returns the leader the replica told along with the error, if it told one
+func LeaderOf(err error) (Leader, bool) {
+	var replicaErr *Error
+	if errors.As(err, &replicaErr) && len(replicaErr.Leader.Replica) != 0 {
+		return replicaErr.Leader, true
+	}
+	return Leader{}, false
+}
+
+// leaderOf reads the leader from the headers of the reply
+func leaderOf(header http.Header) Leader {
+	term, err := strconv.ParseUint(header.Get(HeaderTerm), 10, 64)
+	replica := header.Get(HeaderLeader)
+	if err != nil || len(replica) == 0 {
+		return Leader{}
+	}
+	return Leader{Term: term, Replica: replica}
+}
+
+// unreachable returns the error of a request which the replica never answered
+func unreachable(replica string, op string, err error) *Error {
+	var netErr net.Error
+	timeout := errors.As(err, &netErr) && netErr.Timeout()
+	return &Error{Replica: replica, Op: op, Timeout: timeout, Err: fmt.Errorf("%w: %v", ErrUnreachable, err)}
+}
@@ -0,0 +1,485 @@
+// Internal protocol replicas use for replicating notes to each other
+// This is served on its own port, the public REST API of seph is not affected by it
+//
+// Regenerate the Go code with make proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v3.21.12
+// source: replication/replicationpb/replication.proto
+
+package replicationpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Replication_Backup_FullMethodName         = "/seph.replication.Replication/Backup"
+	Replication_SetPrimary_FullMethodName     = "/seph.replication.Replication/SetPrimary"
+	Replication_FetchPrimaries_FullMethodName = "/seph.replication.Replication/FetchPrimaries"
+	Replication_WriteNotes_FullMethodName     = "/seph.replication.Replication/WriteNotes"
+	Replication_FetchNote_FullMethodName      = "/seph.replication.Replication/FetchNote"
+	Replication_FetchPage_FullMethodName      = "/seph.replication.Replication/FetchPage"
+	Replication_FetchNotes_FullMethodName     = "/seph.replication.Replication/FetchNotes"
+	Replication_FetchDigest_FullMethodName    = "/seph.replication.Replication/FetchDigest"
+	Replication_Snapshot_FullMethodName       = "/seph.replication.Replication/Snapshot"
+)
+
+// ReplicationClient is the client API for Replication service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ReplicationClient interface {
+	// Backup applies the writes of the primary, unless the replica already has newer versions
+	Backup(ctx context.Context, in *BackupRequest, opts ...grpc.CallOption) (*BackupResponse, error)
+	// SetPrimary stores the primary of the note unless the replica already knows a newer one
+	SetPrimary(ctx context.Context, in *SetPrimaryRequest, opts ...grpc.CallOption) (*SetPrimaryResponse, error)
+	// FetchPrimaries returns the primaries of all notes the replica keeps
+	FetchPrimaries(ctx context.Context, in *FetchPrimariesRequest, opts ...grpc.CallOption) (*FetchPrimariesResponse, error)
+	// WriteNotes writes the notes in quorum mode, unless the replica already has newer versions
+	// Conditional writes are rejected once the replica has a version above their base
+	WriteNotes(ctx context.Context, in *WriteNotesRequest, opts ...grpc.CallOption) (*WriteNotesResponse, error)
+	// FetchNote returns the note or its tombstone
+	FetchNote(ctx context.Context, in *FetchNoteRequest, opts ...grpc.CallOption) (*FetchNoteResponse, error)
+	// FetchPage returns a single page of notes
+	FetchPage(ctx context.Context, in *FetchPageRequest, opts ...grpc.CallOption) (*FetchPageResponse, error)
+	// FetchNotes returns the notes or their tombstones
+	FetchNotes(ctx context.Context, in *FetchNotesRequest, opts ...grpc.CallOption) (*FetchNotesResponse, error)
+	// FetchDigest streams the versions of all notes in batches
+	FetchDigest(ctx context.Context, in *FetchDigestRequest, opts ...grpc.CallOption) (Replication_FetchDigestClient, error)
+	// Snapshot streams all notes in batches, tombstones included
+	Snapshot(ctx context.Context, in *SnapshotRequest, opts ...grpc.CallOption) (Replication_SnapshotClient, error)
+}
+
+type replicationClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewReplicationClient(cc grpc.ClientConnInterface) ReplicationClient {
+	return &replicationClient{cc}
+}
+
+func (c *replicationClient) Backup(ctx context.Context, in *BackupRequest, opts ...grpc.CallOption) (*BackupResponse, error) {
+	out := new(BackupResponse)
+	err := c.cc.Invoke(ctx, Replication_Backup_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationClient) SetPrimary(ctx context.Context, in *SetPrimaryRequest, opts ...grpc.CallOption) (*SetPrimaryResponse, error) {
+	out := new(SetPrimaryResponse)
+	err := c.cc.Invoke(ctx, Replication_SetPrimary_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationClient) FetchPrimaries(ctx context.Context, in *FetchPrimariesRequest, opts ...grpc.CallOption) (*FetchPrimariesResponse, error) {
+	out := new(FetchPrimariesResponse)
+	err := c.cc.Invoke(ctx, Replication_FetchPrimaries_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationClient) WriteNotes(ctx context.Context, in *WriteNotesRequest, opts ...grpc.CallOption) (*WriteNotesResponse, error) {
+	out := new(WriteNotesResponse)
+	err := c.cc.Invoke(ctx, Replication_WriteNotes_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationClient) FetchNote(ctx context.Context, in *FetchNoteRequest, opts ...grpc.CallOption) (*FetchNoteResponse, error) {
+	out := new(FetchNoteResponse)
+	err := c.cc.Invoke(ctx, Replication_FetchNote_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationClient) FetchPage(ctx context.Context, in *FetchPageRequest, opts ...grpc.CallOption) (*FetchPageResponse, error) {
+	out := new(FetchPageResponse)
+	err := c.cc.Invoke(ctx, Replication_FetchPage_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationClient) FetchNotes(ctx context.Context, in *FetchNotesRequest, opts ...grpc.CallOption) (*FetchNotesResponse, error) {
+	out := new(FetchNotesResponse)
+	err := c.cc.Invoke(ctx, Replication_FetchNotes_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationClient) FetchDigest(ctx context.Context, in *FetchDigestRequest, opts ...grpc.CallOption) (Replication_FetchDigestClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Replication_ServiceDesc.Streams[0], Replication_FetchDigest_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &replicationFetchDigestClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Replication_FetchDigestClient interface {
+	Recv() (*DigestBatch, error)
+	grpc.ClientStream
+}
+
+type replicationFetchDigestClient struct {
+	grpc.ClientStream
+}
+
+func (x *replicationFetchDigestClient) Recv() (*DigestBatch, error) {
+	m := new(DigestBatch)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *replicationClient) Snapshot(ctx context.Context, in *SnapshotRequest, opts ...grpc.CallOption) (Replication_SnapshotClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Replication_ServiceDesc.Streams[1], Replication_Snapshot_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &replicationSnapshotClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Replication_SnapshotClient interface {
+	Recv() (*NoteBatch, error)
+	grpc.ClientStream
+}
+
+type replicationSnapshotClient struct {
+	grpc.ClientStream
+}
+
+func (x *replicationSnapshotClient) Recv() (*NoteBatch, error) {
+	m := new(NoteBatch)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ReplicationServer is the server API for Replication service.
+// All implementations must embed UnimplementedReplicationServer
+// for forward compatibility
+type ReplicationServer interface {
+	// Backup applies the writes of the primary, unless the replica already has newer versions
+	Backup(context.Context, *BackupRequest) (*BackupResponse, error)
+	// SetPrimary stores the primary of the note unless the replica already knows a newer one
+	SetPrimary(context.Context, *SetPrimaryRequest) (*SetPrimaryResponse, error)
+	// FetchPrimaries returns the primaries of all notes the replica keeps
+	FetchPrimaries(context.Context, *FetchPrimariesRequest) (*FetchPrimariesResponse, error)
+	// WriteNotes writes the notes in quorum mode, unless the replica already has newer versions
+	// Conditional writes are rejected once the replica has a version above their base
+	WriteNotes(context.Context, *WriteNotesRequest) (*WriteNotesResponse, error)
+	// FetchNote returns the note or its tombstone
+	FetchNote(context.Context, *FetchNoteRequest) (*FetchNoteResponse, error)
+	// FetchPage returns a single page of notes
+	FetchPage(context.Context, *FetchPageRequest) (*FetchPageResponse, error)
+	// FetchNotes returns the notes or their tombstones
+	FetchNotes(context.Context, *FetchNotesRequest) (*FetchNotesResponse, error)
+	// FetchDigest streams the versions of all notes in batches
+	FetchDigest(*FetchDigestRequest, Replication_FetchDigestServer) error
+	// Snapshot streams all notes in batches, tombstones included
+	Snapshot(*SnapshotRequest, Replication_SnapshotServer) error
+	mustEmbedUnimplementedReplicationServer()
+}
+
+// UnimplementedReplicationServer must be embedded to have forward compatible implementations.
+type UnimplementedReplicationServer struct {
+}
+
+func (UnimplementedReplicationServer) Backup(context.Context, *BackupRequest) (*BackupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Backup not implemented")
+}
+func (UnimplementedReplicationServer) SetPrimary(context.Context, *SetPrimaryRequest) (*SetPrimaryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetPrimary not implemented")
+}
+func (UnimplementedReplicationServer) FetchPrimaries(context.Context, *FetchPrimariesRequest) (*FetchPrimariesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FetchPrimaries not implemented")
+}
+func (UnimplementedReplicationServer) WriteNotes(context.Context, *WriteNotesRequest) (*WriteNotesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WriteNotes not implemented")
+}
+func (UnimplementedReplicationServer) FetchNote(context.Context, *FetchNoteRequest) (*FetchNoteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FetchNote not implemented")
+}
+func (UnimplementedReplicationServer) FetchPage(context.Context, *FetchPageRequest) (*FetchPageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FetchPage not implemented")
+}
+func (UnimplementedReplicationServer) FetchNotes(context.Context, *FetchNotesRequest) (*FetchNotesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FetchNotes not implemented")
+}
+func (UnimplementedReplicationServer) FetchDigest(*FetchDigestRequest, Replication_FetchDigestServer) error {
+	return status.Errorf(codes.Unimplemented, "method FetchDigest not implemented")
+}
+func (UnimplementedReplicationServer) Snapshot(*SnapshotRequest, Replication_SnapshotServer) error {
+	return status.Errorf(codes.Unimplemented, "method Snapshot not implemented")
+}
+func (UnimplementedReplicationServer) mustEmbedUnimplementedReplicationServer() {}
+
+// UnsafeReplicationServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ReplicationServer will
+// result in compilation errors.
+type UnsafeReplicationServer interface {
+	mustEmbedUnimplementedReplicationServer()
+}
+
+func RegisterReplicationServer(s grpc.ServiceRegistrar, srv ReplicationServer) {
+	s.RegisterService(&Replication_ServiceDesc, srv)
+}
+
+func _Replication_Backup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BackupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).Backup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Replication_Backup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).Backup(ctx, req.(*BackupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Replication_SetPrimary_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetPrimaryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).SetPrimary(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Replication_SetPrimary_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).SetPrimary(ctx, req.(*SetPrimaryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Replication_FetchPrimaries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FetchPrimariesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).FetchPrimaries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Replication_FetchPrimaries_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).FetchPrimaries(ctx, req.(*FetchPrimariesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Replication_WriteNotes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WriteNotesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).WriteNotes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Replication_WriteNotes_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).WriteNotes(ctx, req.(*WriteNotesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Replication_FetchNote_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FetchNoteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).FetchNote(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Replication_FetchNote_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).FetchNote(ctx, req.(*FetchNoteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Replication_FetchPage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FetchPageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).FetchPage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Replication_FetchPage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).FetchPage(ctx, req.(*FetchPageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Replication_FetchNotes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FetchNotesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).FetchNotes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Replication_FetchNotes_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).FetchNotes(ctx, req.(*FetchNotesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Replication_FetchDigest_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(FetchDigestRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ReplicationServer).FetchDigest(m, &replicationFetchDigestServer{stream})
+}
+
+type Replication_FetchDigestServer interface {
+	Send(*DigestBatch) error
+	grpc.ServerStream
+}
+
+type replicationFetchDigestServer struct {
+	grpc.ServerStream
+}
+
+func (x *replicationFetchDigestServer) Send(m *DigestBatch) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Replication_Snapshot_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SnapshotRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ReplicationServer).Snapshot(m, &replicationSnapshotServer{stream})
+}
+
+type Replication_SnapshotServer interface {
+	Send(*NoteBatch) error
+	grpc.ServerStream
+}
+
+type replicationSnapshotServer struct {
+	grpc.ServerStream
+}
+
+func (x *replicationSnapshotServer) Send(m *NoteBatch) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Replication_ServiceDesc is the grpc.ServiceDesc for Replication service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Replication_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "seph.replication.Replication",
+	HandlerType: (*ReplicationServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Backup",
+			Handler:    _Replication_Backup_Handler,
+		},
+		{
+			MethodName: "SetPrimary",
+			Handler:    _Replication_SetPrimary_Handler,
+		},
+		{
+			MethodName: "FetchPrimaries",
+			Handler:    _Replication_FetchPrimaries_Handler,
+		},
+		{
+			MethodName: "WriteNotes",
+			Handler:    _Replication_WriteNotes_Handler,
+		},
+		{
+			MethodName: "FetchNote",
+			Handler:    _Replication_FetchNote_Handler,
+		},
+		{
+			MethodName: "FetchPage",
+			Handler:    _Replication_FetchPage_Handler,
+		},
+		{
+			MethodName: "FetchNotes",
+			Handler:    _Replication_FetchNotes_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "FetchDigest",
+			Handler:       _Replication_FetchDigest_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Snapshot",
+			Handler:       _Replication_Snapshot_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "replication/replicationpb/replication.proto",
+}
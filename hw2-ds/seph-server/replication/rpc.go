@@ -0,0 +1,126 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"seph/replication/replicationpb"
+	"strconv"
+)
+
+// MaxMessageSize is the largest message replicas send to each other over the internal protocol
+// Batches of large notes would not fit into the default of 4MiB
+const MaxMessageSize = 64 << 20
+
+// errMalformedReply is returned when the reply of the replica did not match the request
+var errMalformedReply = errors.New("malformed reply")
+
+// InternalAddr returns the address the replica serves the internal protocol on, which is its service port plus the offset
+func InternalAddr(replica string, offset int) string {
+	host, port, err := net.SplitHostPort(replica)
+	if err != nil {
+		return replica
+	}
+
+	servicePort, err := strconv.Atoi(port)
+	if err != nil {
+		return replica
+	}
+	return net.JoinHostPort(host, strconv.Itoa(servicePort+offset))
+}
+
+// conn returns the client for the internal protocol of the replica
+// Connections are opened lazily and shared by all calls to the same replica
+func (c *Client) conn(replica string) (error, replicationpb.ReplicationClient) {
+	c.connsLock.Lock()
+	defer c.connsLock.Unlock()
+
+	conn, ok := c.conns[replica]
+	if !ok {
+		var err error
+		conn, err = grpc.Dial(InternalAddr(replica, c.internalPortOffset),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(MaxMessageSize), grpc.MaxCallSendMsgSize(MaxMessageSize)))
+		if err != nil {
+			return unreachable(replica, "connect", err), nil
+		}
+		c.conns[replica] = conn
+	}
+	return nil, replicationpb.NewReplicationClient(conn)
+}
+
+// call runs the call against the internal protocol of the replica, each attempt times out on its own
+// The call either returns an error of the internal protocol, or an *Error for results it did not expect
+func (c *Client) call(replica string, op string, retry bool, fn func(ctx context.Context, rpc replicationpb.ReplicationClient) error) error {
+	err, rpc := c.conn(replica)
+	if err != nil {
+		return err
+	}
+
+	return c.retry(retry, func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		defer cancel()
+
+		err := fn(ctx, rpc)
+		var replicaErr *Error
+		if err == nil || errors.As(err, &replicaErr) {
+			return err
+		}
+		return rpcError(replica, op, err)
+	})
+}
+
+// rpcError converts the error of the internal protocol into an *Error
+// Replicas which could not be reached are unreachable, other failures get the closest HTTP status code
+func rpcError(replica string, op string, err error) *Error {
+	code := status.Code(err)
+	switch code {
+	case codes.Unavailable:
+		return &Error{Replica: replica, Op: op, Err: fmt.Errorf("%w: %v", ErrUnreachable, err)}
+	case codes.DeadlineExceeded:
+		return &Error{Replica: replica, Op: op, Timeout: true, Err: fmt.Errorf("%w: %v", ErrUnreachable, err)}
+	}
+
+	httpStatus := http.StatusInternalServerError
+	switch code {
+	case codes.InvalidArgument:
+		httpStatus = http.StatusBadRequest
+	case codes.NotFound:
+		httpStatus = http.StatusNotFound
+	case codes.Unimplemented:
+		httpStatus = http.StatusNotImplemented
+	}
+	return &Error{Replica: replica, Op: op, Status: httpStatus, Err: fmt.Errorf("%w: %v", ErrStatus, err)}
+}
+
+// resultError converts the result of a single note into an error, the status code is the one HTTP would reply with
+// The leader is only kept for stale leaders, so that the sender learns the newer leader
+func resultError(replica string, op string, result replicationpb.Result, leader *replicationpb.Leader) error {
+	var httpStatus int
+	switch result {
+	case replicationpb.Result_OK:
+		return nil
+	case replicationpb.Result_STALE_VERSION:
+		httpStatus = http.StatusConflict
+	case replicationpb.Result_NOT_FOUND:
+		httpStatus = http.StatusNotFound
+	case replicationpb.Result_VERSION_CONFLICT:
+		httpStatus = http.StatusPreconditionFailed
+	case replicationpb.Result_STALE_LEADER:
+		return &Error{Replica: replica, Op: op, Status: http.StatusMisdirectedRequest, Leader: DecodeLeader(leader), Err: ErrStatus}
+	default:
+		httpStatus = http.StatusInternalServerError
+	}
+	return &Error{Replica: replica, Op: op, Status: httpStatus, Err: ErrStatus}
+}
+
+// malformed returns the error of a reply which did not match the request
+func malformed(replica string, op string) *Error {
+	return &Error{Replica: replica, Op: op, Err: errMalformedReply}
+}
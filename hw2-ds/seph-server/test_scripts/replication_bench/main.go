@@ -0,0 +1,271 @@
+// Benchmark of replicating writes to backups, sending every write on its own against batching and pipelining them
+// This starts a local cluster of seph for every cluster size and replication setting, then measures its write throughput
+//
+// Build seph first, then run from the seph-server directory:
+//
+//	go build -o seph . && go run ./test_scripts/replication_bench -seph ./seph -replicas 3,5,7
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// setup is a replication setting the cluster is benchmarked with
+type setup struct {
+	name      string
+	batchSize int // Negative sends every write on its own
+	depth     int
+}
+
+// result is the outcome of benchmarking a single cluster
+type result struct {
+	replicas   int
+	setup      string
+	writes     int
+	failures   int
+	throughput float64
+	p50        time.Duration
+	p99        time.Duration
+}
+
+func main() {
+	sephPath := flag.String("seph", "./seph", "path to the seph binary")
+	replicaCounts := flag.String("replicas", "3,5,7", "comma separated cluster sizes to benchmark")
+	syncMode := flag.String("sync", "local-write", "sync mode of the cluster, local-write or remote-write")
+	storage := flag.String("storage", "memory", "storage of the replicas, memory keeps disks out of the measurement")
+	clients := flag.Int("clients", 32, "number of concurrent clients writing notes")
+	duration := flag.Duration("duration", 10*time.Second, "how long to write notes to each cluster")
+	basePort := flag.Int("port", 17000, "service port of the first replica, the others take the following ports")
+	batchSize := flag.Int("batch", 64, "replication batch size of the batched setup")
+	depth := flag.Int("pipeline", 4, "batches in flight to each backup in the batched setup")
+	flag.Parse()
+
+	binary, err := filepath.Abs(*sephPath)
+	if err != nil {
+		log.Fatalf("Invalid seph path %s: %s\n", *sephPath, err)
+	}
+
+	setups := []setup{
+		{name: "per-request", batchSize: -1, depth: 1},
+		{name: "batched", batchSize: *batchSize, depth: *depth},
+	}
+
+	var results []result
+	for _, field := range strings.Split(*replicaCounts, ",") {
+		replicas, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || replicas < 1 {
+			log.Fatalf("Invalid cluster size %s\n", field)
+		}
+
+		for _, s := range setups {
+			log.Printf("Benchmarking %d replicas, %s", replicas, s.name)
+			r, err := benchmark(binary, *syncMode, *storage, replicas, s, *basePort, *clients, *duration)
+			if err != nil {
+				log.Fatalf("Benchmark of %d replicas, %s failed: %s\n", replicas, s.name, err)
+			}
+			results = append(results, r)
+		}
+	}
+
+	report(results)
+}
+
+// benchmark starts the cluster, writes notes to it from all clients for the duration, then stops the cluster
+func benchmark(binary string, syncMode string, storage string, replicas int, s setup, basePort int, clients int, duration time.Duration) (result, error) {
+	dir, err := os.MkdirTemp("", "seph-bench-")
+	if err != nil {
+		return result{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	addrs := make([]string, 0, replicas)
+	for i := 0; i < replicas; i++ {
+		addrs = append(addrs, fmt.Sprintf("127.0.0.1:%d", basePort+i))
+	}
+
+	var processes []*exec.Cmd
+	defer func() {
+		for _, process := range processes {
+			_ = process.Process.Kill()
+			_ = process.Wait()
+		}
+	}()
+
+	for i, addr := range addrs {
+		process, err := start(binary, dir, i, addr, addrs, syncMode, storage, s, basePort)
+		if err != nil {
+			return result{}, err
+		}
+		processes = append(processes, process)
+	}
+
+	err = waitReady(addrs, 30*time.Second)
+	if err != nil {
+		return result{}, err
+	}
+
+	return load(addrs, replicas, s, clients, duration), nil
+}
+
+// start writes the config of a single replica and starts it, the replica logs into its data directory
+func start(binary string, dir string, i int, addr string, addrs []string, syncMode string, storage string, s setup, basePort int) (*exec.Cmd, error) {
+	data := filepath.Join(dir, fmt.Sprintf("data%d", i))
+	err := os.MkdirAll(data, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	config := map[string]interface{}{
+		"servicePort":          basePort + i,
+		"sync":                 syncMode,
+		"storage":              storage,
+		"replicas":             addrs,
+		"replicationBatchSize": s.batchSize,
+		"replicationPipeline":  s.depth,
+	}
+	payload, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	configPath := filepath.Join(dir, fmt.Sprintf("config%d.json", i))
+	err = os.WriteFile(configPath, payload, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	logFile, err := os.Create(filepath.Join(data, "seph.log"))
+	if err != nil {
+		return nil, err
+	}
+
+	process := exec.Command(binary, "-log-level", "warn", configPath)
+	process.Env = append(os.Environ(), "SEPH_DATA="+data, "REPLICA_ID="+addr)
+	process.Stdout = logFile
+	process.Stderr = logFile
+	return process, process.Start()
+}
+
+// waitReady waits until every replica accepted a write, in remote-write mode this waits for the leader as well
+func waitReady(addrs []string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for _, addr := range addrs {
+		for {
+			err := write(addr)
+			if err == nil {
+				break
+			} else if time.Now().After(deadline) {
+				return fmt.Errorf("replica %s was not ready: %w", addr, err)
+			}
+			time.Sleep(200 * time.Millisecond)
+		}
+	}
+	return nil
+}
+
+// client is shared by all writes, so that connections are reused
+var client = &http.Client{
+	Timeout:   10 * time.Second,
+	Transport: &http.Transport{MaxIdleConnsPerHost: 256},
+}
+
+// write creates a single note through the replica
+func write(addr string) error {
+	body := bytes.NewBufferString(`{"title":"bench","body":"replication benchmark"}`)
+	response, err := client.Post(fmt.Sprintf("http://%s/note", addr), "application/json", body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	_, _ = io.Copy(io.Discard, response.Body)
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code %d", response.StatusCode)
+	}
+	return nil
+}
+
+// load writes notes from all clients at once for the duration, each client spreads its writes across the replicas
+func load(addrs []string, replicas int, s setup, clients int, duration time.Duration) result {
+	var lock sync.Mutex
+	var latencies []time.Duration
+	failures := 0
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+
+			var own []time.Duration
+			failed := 0
+			for n := c; time.Since(start) < duration; n++ {
+				begin := time.Now()
+				err := write(addrs[n%len(addrs)])
+				if err != nil {
+					failed++
+					continue
+				}
+				own = append(own, time.Since(begin))
+			}
+
+			lock.Lock()
+			latencies = append(latencies, own...)
+			failures += failed
+			lock.Unlock()
+		}(c)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return result{
+		replicas:   replicas,
+		setup:      s.name,
+		writes:     len(latencies),
+		failures:   failures,
+		throughput: float64(len(latencies)) / elapsed.Seconds(),
+		p50:        percentile(latencies, 0.50),
+		p99:        percentile(latencies, 0.99),
+	}
+}
+
+// percentile returns the latency at the percentile, the latencies must be sorted
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	return latencies[int(float64(len(latencies)-1)*p)]
+}
+
+// report prints the results as a table, along with the speedup of batching for each cluster size
+func report(results []result) {
+	fmt.Printf("\n%-9s %-12s %10s %9s %10s %10s %8s\n", "replicas", "setup", "writes/s", "failures", "p50", "p99", "speedup")
+
+	baseline := make(map[int]float64)
+	for _, r := range results {
+		speedup := "-"
+		if base, ok := baseline[r.replicas]; ok && base > 0 {
+			speedup = fmt.Sprintf("%.2fx", r.throughput/base)
+		} else {
+			baseline[r.replicas] = r.throughput
+		}
+
+		fmt.Printf("%-9d %-12s %10.1f %9d %10s %10s %8s\n", r.replicas, r.setup, r.throughput, r.failures,
+			r.p50.Round(10*time.Microsecond), r.p99.Round(10*time.Microsecond), speedup)
+	}
+}